@@ -104,11 +104,13 @@ slamMapType.releaseTile = function(tile) {
 	delete this.tiles[tile.tileID];
 }
 
-slamMapType.updateTile = function(tile) {
+slamMapType.updateTile = function(tile, version) {
 	var tileUrl = getTileUrl(tile.coord, tile.zoom);
 	if (!tileUrl) {
 		return
 	}
+	// A new URL makes the browser load the tile again
+	tileUrl += "&version=" + version;
 	
 	$(tile).find("img").attr("src", tileUrl);
 
@@ -123,10 +125,45 @@ slamMapType.updateTile = function(tile) {
 	// img.src = tileUrl;
 };
 
+// Map id and update index of the map, for each zoom level, as of the last update
+slamMapType.mapId = null;
+slamMapType.updateIndices = {};
+
+// Update the tiles which have changed since the last update
 slamMapType.update = function() {
+	var self = this;
+	var zooms = {};
 	for (var tileID in this.tiles) {
-		this.updateTile(this.tiles[tileID]);
+		zooms[this.tiles[tileID].zoom] = true;
 	}
+
+	$.each(zooms, function(zoom) {
+		var params = {zoomLevel: zoom};
+		if (self.mapId !== null && zoom in self.updateIndices) {
+			params.mapId = self.mapId;
+			params.since = self.updateIndices[zoom];
+		}
+
+		$.getJSON("/api/get/slam/image/changed-tiles/", params, function(changes) {
+			if (changes.mapId !== self.mapId) {
+				self.mapId = changes.mapId;
+				self.updateIndices = {};
+			}
+			self.updateIndices[zoom] = changes.updateIndex;
+
+			var changed = {};
+			$.each(changes.tiles, function(i, t) {
+				changed["x" + t.x + "y" + t.y + "z" + t.zoom] = true;
+			});
+
+			for (var tileID in self.tiles) {
+				var tile = self.tiles[tileID];
+				if (tile.zoom == zoom && (changes.all || changed[tileID])) {
+					self.updateTile(tile, changes.updateIndex);
+				}
+			}
+		});
+	});
 }
 
 function createSlamMap(mapSizeMeters) {
@@ -80,7 +80,12 @@
 
 			// Open-link
 			plugin.detailsModal.find("[data-role=open-stored-map]").click(function() {
-				plugin.StartFromStored(filename);
+				plugin.StartFromStored(filename, "hectorslam");
+			});
+
+			// Localize-link, uses the map without updating it
+			plugin.detailsModal.find("[data-role=localize-in-stored-map]").click(function() {
+				plugin.StartFromStored(filename, "mcl");
 			});
 
 			// Save map meta data button
@@ -139,7 +144,7 @@
 		};
 
 		// Start map from saved map
-		plugin.StartFromStored = function(filename) {
+		plugin.StartFromStored = function(filename, algorithm) {
 			
 			// Close details modal
 			plugin.detailsModal.modal("hide");
@@ -149,7 +154,7 @@
 
 			$.ajax("/api/set/slam/initialize-from-stored-map", {
 				data: {
-					algorithm: 'hectorslam',
+					algorithm: algorithm,
 					filename: filename,
 				},
 				error: errorFunc,
@@ -0,0 +1,76 @@
+;(function($) {
+
+    // Controls for a log replay, with status updates from a web socket
+    $.replay = function(el, options) {
+
+        var defaults = {
+            apiUrl: "/api/set/sensorlogs/",
+            streamUrl: "/api/streaming/sensorlogs/"
+        }
+
+        var plugin = this;
+
+        plugin.settings = {}
+
+        var init = function() {
+            plugin.settings = $.extend({}, defaults, options);
+            plugin.el = el;
+
+            plugin.socket = false;
+
+            el.find("[data-role=replay-play]").click(function() {
+                call("play", {});
+            });
+            el.find("[data-role=replay-pause]").click(function() {
+                call("pause", {});
+            });
+            el.find("[data-role=replay-step]").click(function() {
+                call("step", { sensor: $(this).attr("data-sensor") || "" });
+            });
+            el.find("[data-role=replay-rate]").submit(function(e) {
+                e.preventDefault();
+                call("rate", { rate: $(this).find("[name=rate]").val() });
+            });
+            el.find("[data-role=replay-seek]").submit(function(e) {
+                e.preventDefault();
+                call("seek", { record: $(this).find("[name=record]").val() });
+            });
+        }
+
+        var call = function(action, data) {
+            $.ajax({
+                url: plugin.settings.apiUrl + action,
+                dataType: "json",
+                data: data,
+                error: AjaxErrorFunc
+            });
+        }
+
+        var update = function(status) {
+            el.find("[data-role=replay-state]").text(status.state + " at " + status.rate + "x");
+            el.find("[data-role=replay-position]").text(status.position);
+            el.find("[data-role=replay-timestamp]").text(status.timestamp);
+        }
+
+        plugin.Start = function() {
+            plugin.socket = new WebSocket("ws://" + location.host + plugin.settings.streamUrl);
+            plugin.socket.onmessage = function(e) {
+                var message = JSON.parse(e.data);
+                if (message.type == "replayStatus") {
+                    update(message.data);
+                }
+            };
+        }
+
+        plugin.Stop = function() {
+            if (plugin.socket) {
+                plugin.socket.close();
+                plugin.socket = false;
+            }
+        }
+
+        init();
+
+    }
+
+})(jQuery);
@@ -81,6 +81,23 @@ func (hsp *HectorSlamProcessor) Reset() {
 	hsp.mapRep.Reset()
 }
 
+// Rebuild the map from scratch from scans taken at the given poses, e.g. after
+// the poses have been corrected by loop closure. The last pose becomes the last
+// scan match and map update pose.
+func (hsp *HectorSlamProcessor) RebuildMap(dataContainers []*datacontainer.DataContainer, poses [][3]float64) {
+	hsp.mapRep.Reset()
+
+	for i := range dataContainers {
+		hsp.mapRep.UpdateByScan(dataContainers[i], poses[i])
+	}
+	hsp.mapRep.OnMapUpdated()
+
+	if len(poses) > 0 {
+		hsp.lastScanMatchPose = poses[len(poses)-1]
+		hsp.lastMapUpdatePose = poses[len(poses)-1]
+	}
+}
+
 func (hsp *HectorSlamProcessor) GetLastScanMatchPose() [3]float64 {
 	return hsp.lastScanMatchPose
 }
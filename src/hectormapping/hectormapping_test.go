@@ -1,148 +1,134 @@
-package hectormapping
+package hectormapping_test
 
 import (
-    "testing"
-    "os"
-    "image"
-    "image/color"
-    "image/png"
-    "fmt"
-    
-    "robot/sensors/logreader"
-    "robot/sensors/lidar"
-    "robot/config"
-    "robot/logging"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"robot/model"
+	"robot/simulator"
+	"robot/slam/benchmark"
 )
 
-func saveImage(im image.Image, name string, t *testing.T) {
-	// Save image
-	file, err := os.Create("testoutput/" + name + ".png")
-	if err != nil {
-		t.Error(err)
-	}
-	err = png.Encode(file, im)
-	if err != nil {
-		t.Error(err)
+// Make an 8 x 6 m room with a table in the middle and some pillars, so the
+// scans have features along every wall
+func makeTestRoom() image.Image {
+	resolution := 0.05
+	img := image.NewGray(image.Rect(0, 0, 160, 120))
+
+	// Boxes in world coordinates, x0, y0, x1, y1
+	walls := [][4]float64{
+		{-4, -3, 4, -2.9}, {-4, 2.9, 4, 3}, {-4, -3, -3.9, 3}, {3.9, -3, 4, 3},
+		{-0.75, -0.5, 0.75, 0.5},
+		{-3.9, 0, -3.6, 0.3}, {3.6, -1, 3.9, -0.6}, {-1, 2.6, -0.6, 2.9}, {1.5, -2.9, 1.8, -2.6},
 	}
-}
 
-func saveImageFromGridMap(gridMap OccGridMap, name string, t *testing.T) {
-	im := image.NewGray(image.Rect(0, 0, gridMap.GetSizeX(), gridMap.GetSizeY()))
-	
-	// Declare colors
-	unknownColor := color.Gray{127}
-	freeColor := color.Gray{255}
-	occupiedColor := color.Gray{0}
-	
-	// Fill image
-	for x := 0; x < gridMap.GetSizeX(); x++ {
-		for y := 0; y < gridMap.GetSizeY(); y++ {
-			
-			cell := gridMap.GetCell(x, y)
-			
-			if cell.IsFree() {
-				im.Set(x, y, freeColor)
-			} else if cell.IsOccupied() {
-				im.Set(x, y, occupiedColor)
-			} else {
-				im.Set(x, y, unknownColor)
+	for j := 0; j < 120; j++ {
+		for i := 0; i < 160; i++ {
+			x := (float64(i)+0.5)*resolution - 4
+			y := 3 - (float64(j)+0.5)*resolution
+
+			c := color.Gray{255}
+			for _, w := range walls {
+				if x >= w[0] && x <= w[2] && y >= w[1] && y <= w[3] {
+					c = color.Gray{0}
+				}
 			}
-			
+			img.SetGray(i, j, c)
 		}
 	}
-	
-	saveImage(im, name, t)
+
+	return img
 }
 
-// Make images such as Fig. 2 in the article, showing the partial derivatives
-// of the map, i.e. ∂M/∂x and ∂M/∂Y. 
-func getDerivativeImages(hsp *HectorSlamProcessor, t *testing.T) {
-	// Get MapProcContainer and gridmaputil
-	mapProcContainer := hsp.GetMapRepresentation().GetMapProcContainer()
-	gridMapUtil := mapProcContainer.GetGridMapUtil()
-	gridMap := mapProcContainer.GetGridMap()
-	
-	logger.Printf("Obstacle threshold: %f", gridMap.GetObstacleThreshold())
-	
-	zoomPx := 10
-	
-	var xMax, yMax, xMin, yMin int
-	gridMap.GetMapExtends(&xMax, &yMax, &xMin, &yMin)
-	t.Logf("xMax = %d, yMax = %d, xMin = %d, yMin = %d", xMax, yMax, xMin, yMin)
-	
-	imInter := image.NewGray(image.Rect(xMin * zoomPx, yMin * zoomPx, xMax * zoomPx, yMax * zoomPx))
-	imdMdx := image.NewGray(image.Rect(xMin * zoomPx, yMin * zoomPx, xMax * zoomPx, yMax * zoomPx))
-	imdMdy := image.NewGray(image.Rect(xMin * zoomPx, yMin * zoomPx, xMax * zoomPx, yMax * zoomPx))
-	
-	for x := xMin; x < xMax; x++ {
-		for y := yMin; y < yMax; y++ {
-			
-			for xi := 0; xi < zoomPx; xi++ {
-				for yi := 0; yi < zoomPx; yi++ {
-					floatMapCoords := [2]float64{float64(x) + float64(xi)/float64(zoomPx), float64(y) + float64(yi)/float64(zoomPx)}
-					r := gridMapUtil.InterpMapValueWithDerivatives(floatMapCoords)
-			
-					imInter.Set(x*zoomPx + xi, y*zoomPx + yi, color.Gray{uint8(r[0] * 255)})
-					imdMdx.Set(x*zoomPx + xi, y*zoomPx + yi, color.Gray{uint8(128 + r[1] * 128)})
-					imdMdy.Set(x*zoomPx + xi, y*zoomPx + yi, color.Gray{uint8(128 + r[2] * 128)})
-					
-				}
-			}
-			
-		}
+// Simulate a run around the table in the test room, with the noise seeded so
+// every run gives the same dataset
+func makeTestDataset(t *testing.T, dir string) *benchmark.Dataset {
+	robot := model.MakeDefaultDifferentialWheeledRobot()
+	world := simulator.MakeWorld(simulator.MakeTruthMapFromImage(makeTestRoom(), 0.05), robot,
+		model.Position{X: -2, Y: -1.5, Theta: 0})
+
+	steps := make([]benchmark.Step, 0)
+	for _, length := range []float64{4, 3, 4, 3} {
+		steps = append(steps, benchmark.Straight(length, 0.05)...)
+		steps = append(steps, benchmark.Turn(robot, math.Pi/2, 5*math.Pi/180)...)
 	}
-	
-	
-	saveImage(imInter, "interpolated", t)
-	saveImage(imdMdx, "dMdx", t)
-	saveImage(imdMdy, "dMdy", t)
+
+	d, err := benchmark.Simulate(dir, world, robot, steps, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
 }
 
-func TestHectorSlamProcessor(t *testing.T) {
-	logging.AddWriter(os.Stdout)
-	
-//	hsp := MakeHectorSlamProcessor(0.0125, 4096, 4096, [2]float64{0.1, 0.1}, 6)	// Andre etasje gamle elektro.log
-//	hsp := MakeHectorSlamProcessor(0.025, 2048, 2048, [2]float64{0.1, 0.4}, 3) // EL5
-//	hsp := MakeHectorSlamProcessor(0.025, 4096, 4096, [2]float64{0.1, 0.4}, 3) // Glassgarden
-	hsp := MakeHectorSlamProcessor(0.025, 4096, 4096, [2]float64{0.1, 0.4}, 3) // Infohjornerunde
-//	hsp := MakeHectorSlamProcessor(0.025, 4096, 4096, [2]float64{0.1, 0.4}, 3) // Runde gamle blokker
-	hsp.SetUpdateFactorFree(0.4)
-	hsp.SetUpdateFactorOccupied(0.9)
-	
-	sensorLog, err := logreader.MakeLogReaderFromLogName("Infohjornerunde.log")
+// Run HectorSLAM on a simulated run, and check that the trajectory and the
+// map are as accurate as they used to be
+func TestRegression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hectormapping")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
-	dataContainer := MakeDataContainer(config.LIDAR_NUM_DISTANCES)
-	
-	n := 10000
-	for i := 0; i < n; i++ {
-		sensorReading, _ := sensorLog.ReadSensorReading()
-		
-		if sensorReading == nil {
-			break
-		}
-		
-		lidarReading, ok := sensorReading.(*lidar.LidarReading)
-		if !ok {
-			t.Errorf("Could not convert sensorReading to lidarReading")
-		}
-		
-		LidarReadingToDataContainer(lidarReading, dataContainer, hsp.GetScaleToMap())
-		hsp.Update(dataContainer, hsp.GetLastScanMatchPose())
-		
-		estimate := hsp.GetLastScanMatchPose()
-		logger.Printf("%d: Estimate: %f, %f, %f", i, estimate[0], estimate[1], estimate[2])
+	defer os.RemoveAll(dir)
+
+	d := makeTestDataset(t, dir)
+	d.MapSize = 512
+
+	b := benchmark.MakeBenchmark()
+	b.UseOdometry = false
+	b.LoopClosure = false
+
+	r, err := b.Run(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("\n%s", r)
+
+	// The run is the same every time, so the limits are just above its
+	// results
+	limits := benchmark.Limits{
+		ATE:              0.012,
+		RPETranslation:   0.011,
+		RPERotation:      0.19,
+		ObstacleDistance: 0.011,
+		MinPrecision:     0.99,
+		MinRecall:        0.78,
+	}
+	for _, failure := range r.Check(limits) {
+		t.Error(failure)
 	}
-	
-	// Save image
-	saveImageFromGridMap(hsp.GetGridMap(), "TestHectorSlamProcessor", t)
-	
-	for i := 1; i < hsp.GetMapLevels(); i++ {
-		saveImageFromGridMap(hsp.GetGridMapByLevel(i), fmt.Sprintf("TestHectorSlamProcessor-%d", i), t)
+	if r.Matched != r.Poses {
+		t.Errorf("Only %d of %d poses matched the ground truth", r.Matched, r.Poses)
 	}
-	
-//	getDerivativeImages(hsp, t)
 }
 
+// Run HectorSLAM on the recorded datasets, checking the limits given with
+// each of them
+func TestDatasets(t *testing.T) {
+	names, err := benchmark.GetDatasets()
+	if err != nil || len(names) == 0 {
+		t.Skip("No datasets found")
+	}
+
+	b := benchmark.MakeBenchmark()
+	for _, name := range names {
+		d, err := benchmark.LoadDataset(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		r, err := b.Run(d)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		t.Logf("\n%s", r)
+
+		for _, failure := range r.Check(d.Limits) {
+			t.Errorf("%s: %s", name, failure)
+		}
+	}
+}
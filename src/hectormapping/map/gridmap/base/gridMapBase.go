@@ -5,6 +5,8 @@ import (
 	"encoding/gob"
 	"reflect"
 
+	"robot/tools/intmath"
+
 	"github.com/skelterjohn/go.matrix"
 
 	"hectormapping/map/gridmap"
@@ -34,6 +36,11 @@ type GridMapBase struct {
 	sizeX                  int
 	lastUpdateIndex        int
 	cellExample            gridmap.Cell
+
+	// The update index of the map when each block of BLOCK_SIZE x BLOCK_SIZE
+	// cells was last changed
+	blockUpdateIndices []int
+	blocksX            int
 }
 
 // Constructor, creates grid representation and transformations
@@ -84,11 +91,17 @@ func (gmb *GridMapBase) Reset() {
 	gmb.Clear()
 }
 
-// Resets the grid cell values by using the resetGridCell() function.
+// Resets the grid cell values by using the resetGridCell() function. The
+// blocks with cells updated by scans are marked as changed by the next update.
 func (gmb *GridMapBase) Clear() {
-	size := gmb.GetSizeX() * gmb.GetSizeY()
+	sizeX := gmb.GetSizeX()
+	size := sizeX * gmb.GetSizeY()
 
 	for i := 0; i < size; i++ {
+		if gmb.mapArray[i].GetUpdateIndex() >= 0 {
+			x, y := i%sizeX, i/sizeX
+			gmb.blockUpdateIndices[(y/gridmap.BLOCK_SIZE)*gmb.blocksX+x/gridmap.BLOCK_SIZE] = gmb.lastUpdateIndex + 1
+		}
 		gmb.mapArray[i].ResetGridCell()
 	}
 }
@@ -107,10 +120,19 @@ func (gmb *GridMapBase) AllocateArray(newMapDims [2]int) {
 	gmb.mapArray = make([]gridmap.Cell, sizeX*sizeY)
 	gmb.mapDimensionProperties.SetMapCellDims(newMapDims)
 
-	// Fill array with pointers to actual cells
+	gmb.blocksX = (sizeX + gridmap.BLOCK_SIZE - 1) / gridmap.BLOCK_SIZE
+	blocksY := (sizeY + gridmap.BLOCK_SIZE - 1) / gridmap.BLOCK_SIZE
+	gmb.blockUpdateIndices = make([]int, gmb.blocksX*blocksY)
+	for i := range gmb.blockUpdateIndices {
+		gmb.blockUpdateIndices[i] = gmb.lastUpdateIndex
+	}
+
+	// Fill array with pointers to actual cells, reset so they aren't taken
+	// for cells updated by scans
 	cellType := reflect.TypeOf(gmb.cellExample).Elem()
 	for i := range gmb.mapArray {
 		gmb.mapArray[i] = reflect.New(cellType).Interface().(gridmap.Cell)
+		gmb.mapArray[i].ResetGridCell()
 	}
 }
 
@@ -120,6 +142,8 @@ func (gmb *GridMapBase) DeleteArray() {
 	if gmb.mapArray != nil {
 		gmb.mapArray = nil
 	}
+	gmb.blockUpdateIndices = nil
+	gmb.blocksX = 0
 
 	gmb.mapDimensionProperties.SetMapCellDims([2]int{-1, -1})
 }
@@ -310,6 +334,29 @@ func (gmb *GridMapBase) GetUpdateIndex() int {
 	return gmb.lastUpdateIndex
 }
 
+// Mark the blocks with cells from min to max (inclusive, in map integer
+// coordinates) as changed by the next update. Cells outside the map are
+// ignored.
+func (gmb *GridMapBase) SetChanged(min, max [2]int) {
+	x0, x1 := intmath.Max(min[0], 0), intmath.Min(max[0], gmb.GetSizeX()-1)
+	y0, y1 := intmath.Max(min[1], 0), intmath.Min(max[1], gmb.GetSizeY()-1)
+	if x0 > x1 || y0 > y1 {
+		return
+	}
+
+	for by := y0 / gridmap.BLOCK_SIZE; by <= y1/gridmap.BLOCK_SIZE; by++ {
+		for bx := x0 / gridmap.BLOCK_SIZE; bx <= x1/gridmap.BLOCK_SIZE; bx++ {
+			gmb.blockUpdateIndices[by*gmb.blocksX+bx] = gmb.lastUpdateIndex + 1
+		}
+	}
+}
+
+// Get the update index of the map when the block of BLOCK_SIZE x BLOCK_SIZE
+// cells starting at cell (blockX, blockY) * BLOCK_SIZE was last changed
+func (gmb *GridMapBase) GetBlockUpdateIndex(blockX, blockY int) int {
+	return gmb.blockUpdateIndices[blockY*gmb.blocksX+blockX]
+}
+
 // Returns the rectangle ([xMin,yMin],[xMax,yMax]) containing non-default cell
 // values.
 func (gmb *GridMapBase) GetMapExtends(xMax, yMax, xMin, yMin *int) bool {
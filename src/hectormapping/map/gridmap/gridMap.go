@@ -8,6 +8,9 @@ import (
 	mdp "hectormapping/map/gridmap/mapdimensionproperties"
 )
 
+// Length in cells of the square blocks a map tracks changes in
+const BLOCK_SIZE = 16
+
 type GridMap interface {
 	HasGridValue(x, y int) bool
 	GetMapDimensions() [2]int
@@ -31,6 +34,8 @@ type GridMap interface {
 	GetMapTworld() *matrix.DenseMatrix
 	SetUpdated()
 	GetUpdateIndex() int
+	SetChanged(min, max [2]int)
+	GetBlockUpdateIndex(blockX, blockY int) int
 	GetMapExtends(xMax, yMax, xMin, yMin *int) bool
 
 	gob.GobEncoder
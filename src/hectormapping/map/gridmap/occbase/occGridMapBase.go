@@ -96,9 +96,9 @@ func (ogmb *OccGridMapBase) UpdateByScan(dataContainer *datacontainer.DataContai
 	poseTransform := utils.TransformationMatrix2D(mapPose)
 
 	// Get start point of all laser beams in map coordinates (same for all
-	// beams, store in robot coords in dataContainer).
+	// beams, store in robot coords scaled to this map like the endpoints in
+	// dataContainer).
 	origo := dataContainer.GetOrigo()
-	origo[0], origo[1] = origo[0]/ogmb.GetCellLength(), origo[1]/ogmb.GetCellLength()
 	temp, err := poseTransform.TimesDense(matrix.MakeDenseMatrix(append(origo[:], 1), 3, 1))
 	if err != nil {
 		panic(err)
@@ -108,6 +108,9 @@ func (ogmb *OccGridMapBase) UpdateByScan(dataContainer *datacontainer.DataContai
 	// get the integer vector of laser beams start point
 	scanBeginMapi := [2]int{int(scanBeginMapf[0] + 0.5), int(scanBeginMapf[1] + 0.5)}
 
+	// The bounding box of the beams, where the map is changed
+	changedMin, changedMax := scanBeginMapi, scanBeginMapi
+
 	// Get the number of valid beams in current scan
 	numValidElems := dataContainer.GetSize()
 
@@ -130,9 +133,13 @@ func (ogmb *OccGridMapBase) UpdateByScan(dataContainer *datacontainer.DataContai
 		if scanBeginMapi != scanEndMapi {
 			ogmb.UpdateLineBresenhami(scanBeginMapi, scanEndMapi, 0)
 		}
+
+		changedMin = [2]int{intmath.Min(changedMin[0], scanEndMapi[0]), intmath.Min(changedMin[1], scanEndMapi[1])}
+		changedMax = [2]int{intmath.Max(changedMax[0], scanEndMapi[0]), intmath.Max(changedMax[1], scanEndMapi[1])}
 	}
 
-	// Tell the map that it has been updated
+	// Tell the map where and that it has been updated
+	ogmb.SetChanged(changedMin, changedMax)
 	ogmb.SetUpdated()
 
 	// Increase update index (used for updating grid cells only once per
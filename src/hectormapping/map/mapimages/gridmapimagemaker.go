@@ -64,28 +64,8 @@ func GetMapTile(mapRep maprep.MapRepresentation, zoomLevel uint,
 	// Create the image
 	im := image.NewGray(image.Rect(0, 0, TILE_SIZE, TILE_SIZE))
 
-	// Get the number of tiles in each direction. This is determined by the
-	// zoomLevel alone. zoomLevel 0 means there is 1 tile, 1 means 2 tiles in
-	// each direction, 2 means 4 tiles in each direction, and so on.
-	numTiles := (1 << zoomLevel)
-
-	// Choose the map to use for this zoomLevel
-	var gridMap gridmap.OccGridMap
-	totalPixels := numTiles * TILE_SIZE
-	for i := mapRep.GetMapLevels() - 1; i >= 0; i-- {
-		if intmath.Max(mapRep.GetGridMap(i).GetSizeX(), mapRep.GetGridMap(i).GetSizeY()) >= totalPixels || i == 0 {
-			gridMap = mapRep.GetGridMap(i)
-			break
-		}
-	}
-	gridMapMaxSize := intmath.Max(gridMap.GetSizeX(), gridMap.GetSizeY())
-
-	// Determine the number of cells in the map per tile
-	cellsPerTile := gridMapMaxSize / numTiles
-
-	// Get the start and end positions of the tile in the map
-	startX := tileX * cellsPerTile
-	startY := (numTiles - 1 - tileY) * cellsPerTile
+	level, startX, startY, cellsPerTile := getTileRegion(mapRep, zoomLevel, tileX, tileY)
+	gridMap := mapRep.GetGridMap(level)
 	endX := startX + cellsPerTile
 	endY := startY + cellsPerTile
 
@@ -117,6 +97,35 @@ func GetMapTile(mapRep maprep.MapRepresentation, zoomLevel uint,
 	return im, nil
 }
 
+// Get the map level a tile is made from, and the cells it covers in that map:
+// cellsPerTile cells in each direction from startX, startY.
+func getTileRegion(mapRep maprep.MapRepresentation, zoomLevel uint, tileX, tileY int) (level, startX, startY, cellsPerTile int) {
+
+	// Get the number of tiles in each direction. This is determined by the
+	// zoomLevel alone. zoomLevel 0 means there is 1 tile, 1 means 2 tiles in
+	// each direction, 2 means 4 tiles in each direction, and so on.
+	numTiles := (1 << zoomLevel)
+
+	// Choose the map to use for this zoomLevel
+	totalPixels := numTiles * TILE_SIZE
+	for level = mapRep.GetMapLevels() - 1; level > 0; level-- {
+		if intmath.Max(mapRep.GetGridMap(level).GetSizeX(), mapRep.GetGridMap(level).GetSizeY()) >= totalPixels {
+			break
+		}
+	}
+	gridMap := mapRep.GetGridMap(level)
+	gridMapMaxSize := intmath.Max(gridMap.GetSizeX(), gridMap.GetSizeY())
+
+	// Determine the number of cells in the map per tile
+	cellsPerTile = gridMapMaxSize / numTiles
+
+	// Get the start position of the tile in the map
+	startX = tileX * cellsPerTile
+	startY = (numTiles - 1 - tileY) * cellsPerTile
+
+	return
+}
+
 // Fill in a sub-image im, based on gridMap, with start coordinates startX and
 // startY (integer map coordinates), with stepSize determining how many cells
 // are in a pixel.
@@ -0,0 +1,286 @@
+package mapimages
+
+import (
+	"image"
+	"sort"
+	"sync"
+	"time"
+
+	"robot/tools/intmath"
+
+	"hectormapping/map/gridmap"
+	"hectormapping/map/maprep"
+)
+
+// Length in cells of the square blocks changes to the map are tracked in
+const BLOCK_SIZE = gridmap.BLOCK_SIZE
+
+// Max number of tiles kept in a cache
+const MAX_CACHED_TILES = 512
+
+// Identifies a tile by zoom level and tile coordinates
+type TileKey struct {
+	Zoom uint `json:"zoom"`
+	X    int  `json:"x"`
+	Y    int  `json:"y"`
+}
+
+// A rendered tile, and the update index of the map when it was rendered. The
+// update index only changes when the tile is rendered again, so it can be
+// used as the version of the tile.
+type Tile struct {
+	Image       image.Image
+	UpdateIndex int
+
+	lastUsed int
+}
+
+// TileCache keeps the tiles rendered from a map representation, and renders
+// them again only when the map has changed where they are.
+//
+// The update index of level 0 of the map tells when the map has changed. The
+// cache then finds the changed parts of each map level by the update indices
+// the map keeps for its blocks of BLOCK_SIZE x BLOCK_SIZE cells.
+type TileCache struct {
+	lock   sync.Mutex
+	mapRep maprep.MapRepresentation
+
+	// Identifies the cache, so tile versions of different maps differ
+	id int64
+
+	tiles  map[TileKey]*Tile
+	levels []*blockTracker
+
+	// Update index of the map when it was last checked for changes, and when
+	// it was first checked
+	updateIndex int
+	firstIndex  int
+	checked     bool
+
+	// Set when the map has been changed without updating the cells' update
+	// indices, e.g. by editing
+	invalid bool
+
+	// Counts tile requests, for dropping the least recently used tiles
+	uses int
+}
+
+// Tracks the blocks of a map level
+type blockTracker struct {
+	gridMap          gridmap.OccGridMap
+	blocksX, blocksY int
+
+	// Update index of the map level when it was last checked
+	levelIndex int
+
+	// The map update index when each block was last found changed
+	changed []int
+}
+
+// Make a cache for tiles of a map representation
+func MakeTileCache(mapRep maprep.MapRepresentation) *TileCache {
+	c := &TileCache{
+		mapRep: mapRep,
+		id:     time.Now().UnixNano(),
+		tiles:  make(map[TileKey]*Tile),
+		levels: make([]*blockTracker, mapRep.GetMapLevels()),
+	}
+
+	for i := range c.levels {
+		gridMap := mapRep.GetGridMap(i)
+		blocksX := (gridMap.GetSizeX() + BLOCK_SIZE - 1) / BLOCK_SIZE
+		blocksY := (gridMap.GetSizeY() + BLOCK_SIZE - 1) / BLOCK_SIZE
+		c.levels[i] = &blockTracker{
+			gridMap: gridMap,
+			blocksX: blocksX,
+			blocksY: blocksY,
+			changed: make([]int, blocksX*blocksY),
+		}
+	}
+
+	return c
+}
+
+// Get the id of the cache, which differs between caches of different maps
+func (c *TileCache) GetID() int64 {
+	return c.id
+}
+
+// Get the update index of the map, as of the last check for changes
+func (c *TileCache) GetUpdateIndex() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.checkForChanges()
+	return c.updateIndex
+}
+
+// Have all tiles rendered again, for changes to the map which the update
+// indices of the cells don't tell about. The map's update index must be
+// increased for clients to notice.
+func (c *TileCache) Invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.invalid = true
+}
+
+// Get a tile, rendering it if the map has changed since it was rendered
+func (c *TileCache) GetTile(zoomLevel uint, tileX, tileY int) (*Tile, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.checkForChanges()
+	c.uses++
+
+	key := TileKey{zoomLevel, tileX, tileY}
+	if tile, ok := c.tiles[key]; ok && !c.tileChangedSince(key, tile.UpdateIndex) {
+		tile.lastUsed = c.uses
+		return tile, nil
+	}
+
+	im, err := GetMapTile(c.mapRep, zoomLevel, tileX, tileY)
+	if err != nil {
+		return nil, err
+	}
+
+	tile := &Tile{
+		Image:       im,
+		UpdateIndex: c.updateIndex,
+		lastUsed:    c.uses,
+	}
+	if _, ok := c.tiles[key]; !ok && len(c.tiles) >= MAX_CACHED_TILES {
+		c.dropLeastRecentlyUsed()
+	}
+	c.tiles[key] = tile
+
+	return tile, nil
+}
+
+// Get the tiles of a zoom level which have changed since the map had the
+// given update index, and the current update index. If the changes since then
+// aren't known, e.g. because the index is of another map, all is set and all
+// tiles should be considered changed.
+func (c *TileCache) GetChangedTiles(zoomLevel uint, since int) (updateIndex int, tiles []TileKey, all bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.checkForChanges()
+
+	tiles = make([]TileKey, 0)
+	if since < c.firstIndex || since > c.updateIndex {
+		return c.updateIndex, tiles, true
+	}
+
+	level, _, _, cellsPerTile := getTileRegion(c.mapRep, zoomLevel, 0, 0)
+	if cellsPerTile < 1 {
+		return c.updateIndex, tiles, true
+	}
+	bt := c.levels[level]
+	numTiles := 1 << zoomLevel
+
+	found := make(map[TileKey]bool)
+	for by := 0; by < bt.blocksY; by++ {
+		for bx := 0; bx < bt.blocksX; bx++ {
+			if bt.changed[by*bt.blocksX+bx] <= since {
+				continue
+			}
+
+			// The tiles the block overlaps
+			x0, x1 := bx*BLOCK_SIZE/cellsPerTile, ((bx+1)*BLOCK_SIZE-1)/cellsPerTile
+			y0, y1 := by*BLOCK_SIZE/cellsPerTile, ((by+1)*BLOCK_SIZE-1)/cellsPerTile
+			for ty := y0; ty <= y1 && ty < numTiles; ty++ {
+				for tx := x0; tx <= x1 && tx < numTiles; tx++ {
+					found[TileKey{zoomLevel, tx, numTiles - 1 - ty}] = true
+				}
+			}
+		}
+	}
+
+	for key := range found {
+		tiles = append(tiles, key)
+	}
+	sort.Sort(byTileCoordinates(tiles))
+
+	return c.updateIndex, tiles, false
+}
+
+// Look for changed blocks, if the map has been updated since the last check
+func (c *TileCache) checkForChanges() {
+	updateIndex := c.mapRep.GetGridMap(0).GetUpdateIndex()
+	if c.checked && !c.invalid && updateIndex == c.updateIndex {
+		return
+	}
+
+	for _, bt := range c.levels {
+		bt.update(updateIndex, !c.checked || c.invalid)
+	}
+	c.invalid = false
+
+	if !c.checked {
+		c.firstIndex = updateIndex
+		c.checked = true
+	}
+	c.updateIndex = updateIndex
+}
+
+// Determine if any block of the tile has changed after the given index
+func (c *TileCache) tileChangedSince(key TileKey, since int) bool {
+	if since < c.firstIndex || since > c.updateIndex {
+		return true
+	}
+
+	level, startX, startY, cellsPerTile := getTileRegion(c.mapRep, key.Zoom, key.X, key.Y)
+	bt := c.levels[level]
+	if cellsPerTile < 1 {
+		return true
+	}
+
+	bx0, bx1 := intmath.Max(startX/BLOCK_SIZE, 0), intmath.Min((startX+cellsPerTile-1)/BLOCK_SIZE, bt.blocksX-1)
+	by0, by1 := intmath.Max(startY/BLOCK_SIZE, 0), intmath.Min((startY+cellsPerTile-1)/BLOCK_SIZE, bt.blocksY-1)
+	for by := by0; by <= by1; by++ {
+		for bx := bx0; bx <= bx1; bx++ {
+			if bt.changed[by*bt.blocksX+bx] > since {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Drop the tile which was used the longest time ago
+func (c *TileCache) dropLeastRecentlyUsed() {
+	var oldest TileKey
+	oldestUse := -1
+	for key, tile := range c.tiles {
+		if oldestUse < 0 || tile.lastUsed < oldestUse {
+			oldest, oldestUse = key, tile.lastUsed
+		}
+	}
+	delete(c.tiles, oldest)
+}
+
+// Mark the blocks which the map level has changed since the last check with
+// the update index. If all is set, all blocks are marked.
+func (bt *blockTracker) update(updateIndex int, all bool) {
+	for by := 0; by < bt.blocksY; by++ {
+		for bx := 0; bx < bt.blocksX; bx++ {
+			if all || bt.gridMap.GetBlockUpdateIndex(bx, by) > bt.levelIndex {
+				bt.changed[by*bt.blocksX+bx] = updateIndex
+			}
+		}
+	}
+	bt.levelIndex = bt.gridMap.GetUpdateIndex()
+}
+
+// Sorts tiles by y, then x
+type byTileCoordinates []TileKey
+
+func (t byTileCoordinates) Len() int      { return len(t) }
+func (t byTileCoordinates) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t byTileCoordinates) Less(i, j int) bool {
+	if t[i].Y != t[j].Y {
+		return t[i].Y < t[j].Y
+	}
+	return t[i].X < t[j].X
+}
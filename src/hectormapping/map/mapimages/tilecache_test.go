@@ -0,0 +1,99 @@
+package mapimages
+
+import (
+	"testing"
+
+	"hectormapping/datacontainer"
+	"hectormapping/map/maprep"
+)
+
+// Mark a cell of level 0 as occupied, as a scan would
+func setOccupied(mapRep maprep.MapRepresentation, x, y, updateIndex int) {
+	gridMap := mapRep.GetGridMap(0)
+	index := y*gridMap.GetSizeX() + x
+	gridMap.GetCellByIndex(index).SetUpdateIndex(updateIndex)
+	gridMap.UpdateSetOccupied(index)
+	gridMap.SetChanged([2]int{x, y}, [2]int{x, y})
+	gridMap.SetUpdated()
+}
+
+func TestTileCache(t *testing.T) {
+	mapRep := maprep.MakeMapRepSingleMap(0.05, 256, 256, [2]float64{0.5, 0.5})
+	c := MakeTileCache(mapRep)
+
+	tile, err := c.GetTile(1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tile isn't rendered again while the map is unchanged
+	if again, _ := c.GetTile(1, 0, 1); again != tile {
+		t.Error("Unchanged tile was rendered again")
+	}
+
+	since := c.GetUpdateIndex()
+	if _, tiles, all := c.GetChangedTiles(1, since); all || len(tiles) != 0 {
+		t.Errorf("Got changed tiles %v, all %v, before changing the map", tiles, all)
+	}
+
+	// Tile y coordinates go downwards, map y coordinates upwards
+	setOccupied(mapRep, 10, 10, 5)
+
+	updateIndex, tiles, all := c.GetChangedTiles(1, since)
+	if updateIndex <= since {
+		t.Errorf("Update index %d didn't increase from %d", updateIndex, since)
+	}
+	if all || len(tiles) != 1 || tiles[0] != (TileKey{1, 0, 1}) {
+		t.Errorf("Got changed tiles %v, all %v, want only the lower left", tiles, all)
+	}
+	if changed, _ := c.GetTile(1, 0, 1); changed == tile || changed.UpdateIndex != updateIndex {
+		t.Error("Changed tile wasn't rendered again")
+	}
+
+	// An unknown index means all tiles may have changed
+	if _, _, all := c.GetChangedTiles(1, updateIndex+1); !all {
+		t.Error("Future update index didn't give all tiles")
+	}
+
+	// Resetting the map changes the tiles with updated cells
+	mapRep.Reset()
+	mapRep.GetGridMap(0).SetUpdated()
+	if _, tiles, _ := c.GetChangedTiles(1, updateIndex); len(tiles) != 1 {
+		t.Errorf("Got changed tiles %v after reset, want 1", tiles)
+	}
+
+	// Edits which don't change the update indices of the cells
+	since = c.GetUpdateIndex()
+	mapRep.GetGridMap(0).GetCell(200, 200).Set(1)
+	mapRep.GetGridMap(0).SetUpdated()
+	c.Invalidate()
+	if _, tiles, _ := c.GetChangedTiles(1, since); len(tiles) != 4 {
+		t.Errorf("Got changed tiles %v after invalidating, want all 4", tiles)
+	}
+}
+
+func TestTileCacheScan(t *testing.T) {
+	mapRep := maprep.MakeMapRepSingleMap(0.05, 256, 256, [2]float64{0.5, 0.5})
+	c := MakeTileCache(mapRep)
+	since := c.GetUpdateIndex()
+
+	// A scan from the middle of the map, seeing up and to the right within
+	// 64 cells
+	scan := datacontainer.MakeDataContainer(0)
+	scan.Add([2]float64{20, 5})
+	scan.Add([2]float64{10, 20})
+	mapRep.UpdateByScan(scan, [3]float64{0, 0, 0})
+
+	// Only the tile the scan is in has changed, of 4x4 tiles of 64x64 cells
+	_, tiles, all := c.GetChangedTiles(2, since)
+	if all || len(tiles) != 1 || tiles[0] != (TileKey{2, 2, 1}) {
+		t.Errorf("Got changed tiles %v, all %v, want only the one up and to the right of the middle", tiles, all)
+	}
+
+	// Scans which don't reach into the map change nothing
+	since = c.GetUpdateIndex()
+	mapRep.UpdateByScan(scan, [3]float64{-100, -100, 0})
+	if _, tiles, all := c.GetChangedTiles(2, since); all || len(tiles) != 0 {
+		t.Errorf("Got changed tiles %v, all %v, for a scan outside the map", tiles, all)
+	}
+}
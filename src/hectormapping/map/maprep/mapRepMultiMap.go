@@ -148,14 +148,15 @@ func (mrmm *MapRepMultiMap) MatchData(beginEstimateWorld [3]float64,
 	//	return tmp
 }
 
-// Update each map. This function assumes that MatchData has already been
-// executed for this dataContainer, and so the maps with index > 0 (the smaller
-// ones) uses their cached dataContainers.
+// Update each map. The maps with index > 0 (the smaller ones) are updated with
+// scaled versions of the dataContainer, so scans which have not been matched
+// first, e.g. when rebuilding the map, can be used as well.
 func (mrmm *MapRepMultiMap) UpdateByScan(dataContainer *datacontainer.DataContainer, robotPoseWorld [3]float64) {
 	for i := range mrmm.mapContainer {
 		if i == 0 {
 			mrmm.mapContainer[i].UpdateByScan(dataContainer, robotPoseWorld)
 		} else {
+			mrmm.dataContainers[i-1].SetFrom(dataContainer, 1.0/math.Pow(2.0, float64(i)))
 			mrmm.mapContainer[i].UpdateByScan(mrmm.dataContainers[i-1], robotPoseWorld)
 		}
 	}
@@ -0,0 +1,269 @@
+// Package posegraph adds loop closure to Hector Mapping. Hector Mapping only
+// matches each scan against the map built so far, so the pose error grows
+// along the trajectory, and closing a large loop gives doubled walls.
+//
+// The scans used to update the map are stored as keyframes in a pose graph,
+// connected by edges from the scan matching. When the robot comes back to a
+// place it has been before, the current scan is matched against the old
+// keyframes there, and the result is added as a loop closure edge. The graph
+// is then optimised with sparse least squares, and the map is rebuilt from the
+// keyframes at their corrected poses.
+package posegraph
+
+import (
+	"errors"
+	"math"
+
+	"github.com/skelterjohn/go.matrix"
+
+	"hectormapping/datacontainer"
+)
+
+// A Keyframe is a scan which has been used to update the map, with the pose
+// and covariance from the scan matcher. Like in HectorSlamProcessor, the
+// covariance is the Hessian of the match in map cells, see
+// ScanMatchInformation.
+type Keyframe struct {
+	Index      int
+	Pose       [3]float64
+	Covariance *matrix.DenseMatrix
+	Scan       *datacontainer.DataContainer
+}
+
+// An Edge is a constraint between two keyframes. The measurement is the pose
+// of keyframe To in the coordinate system of keyframe From.
+type Edge struct {
+	From, To    int
+	Measurement [3]float64
+	Information [3][3]float64
+	LoopClosure bool
+}
+
+// A Graph holds the keyframes and the edges between them. The first keyframe
+// is fixed when optimising.
+type Graph struct {
+	Keyframes []*Keyframe
+	Edges     []*Edge
+}
+
+func MakeGraph() *Graph {
+	return &Graph{
+		Keyframes: make([]*Keyframe, 0),
+		Edges:     make([]*Edge, 0),
+	}
+}
+
+// Add a keyframe. The scan is copied, so the data container may be reused.
+func (g *Graph) AddKeyframe(pose [3]float64, covariance *matrix.DenseMatrix,
+	scan *datacontainer.DataContainer) *Keyframe {
+
+	scanCopy := datacontainer.MakeDataContainer(0)
+	scanCopy.SetFrom(scan, 1.0)
+
+	kf := &Keyframe{
+		Index:      len(g.Keyframes),
+		Pose:       pose,
+		Covariance: covariance.Copy(),
+		Scan:       scanCopy,
+	}
+	g.Keyframes = append(g.Keyframes, kf)
+
+	return kf
+}
+
+// Add an edge between two keyframes
+func (g *Graph) AddEdge(from, to int, measurement [3]float64, information [3][3]float64, loopClosure bool) *Edge {
+	e := &Edge{
+		From:        from,
+		To:          to,
+		Measurement: measurement,
+		Information: information,
+		LoopClosure: loopClosure,
+	}
+	g.Edges = append(g.Edges, e)
+
+	return e
+}
+
+// Get the poses of all keyframes
+func (g *Graph) GetPoses() [][3]float64 {
+	poses := make([][3]float64, len(g.Keyframes))
+	for i, kf := range g.Keyframes {
+		poses[i] = kf.Pose
+	}
+	return poses
+}
+
+// Get the scans of all keyframes
+func (g *Graph) GetScans() []*datacontainer.DataContainer {
+	scans := make([]*datacontainer.DataContainer, len(g.Keyframes))
+	for i, kf := range g.Keyframes {
+		scans[i] = kf.Scan
+	}
+	return scans
+}
+
+// The sum of the squared errors of all edges, weighted by their information
+func (g *Graph) Error() float64 {
+	sum := 0.0
+	for _, e := range g.Edges {
+		err, _, _ := e.linearize(g.Keyframes[e.From].Pose, g.Keyframes[e.To].Pose)
+		sum += quadraticForm(err, e.Information)
+	}
+	return sum
+}
+
+// Optimise the keyframe poses with Gauss-Newton. Each iteration solves the
+// sparse normal equations with conjugate gradients. Stops when the pose
+// changes are negligible, or after the given number of iterations.
+func (g *Graph) Optimize(iterations int) error {
+	n := len(g.Keyframes) - 1
+	if n < 1 {
+		return nil
+	}
+
+	for it := 0; it < iterations; it++ {
+		H := makeBlockMatrix(n)
+		b := make([]float64, 3*n)
+
+		for _, e := range g.Edges {
+			err, A, B := e.linearize(g.Keyframes[e.From].Pose, g.Keyframes[e.To].Pose)
+
+			// Keyframe 0 is fixed, so everything is indexed from keyframe 1
+			i, j := e.From-1, e.To-1
+			blocks := []struct {
+				index int
+				J     mat3
+			}{{i, A}, {j, B}}
+
+			for _, r := range blocks {
+				if r.index < 0 {
+					continue
+				}
+				JtO := r.J.transpose().mul(e.Information)
+				JtOe := JtO.mulVec(err)
+				for k := 0; k < 3; k++ {
+					b[3*r.index+k] -= JtOe[k]
+				}
+
+				for _, c := range blocks {
+					if c.index < 0 {
+						continue
+					}
+					H.add(r.index, c.index, JtO.mul(c.J))
+				}
+			}
+		}
+
+		dx, err := H.solve(b)
+		if err != nil {
+			return err
+		}
+
+		maxStep := 0.0
+		for k := 0; k < n; k++ {
+			pose := &g.Keyframes[k+1].Pose
+			for l := 0; l < 3; l++ {
+				pose[l] += dx[3*k+l]
+				maxStep = math.Max(maxStep, math.Abs(dx[3*k+l]))
+			}
+			pose[2] = normalizeAngle(pose[2])
+		}
+
+		if maxStep < 1e-6 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Compute the error of the edge for the given poses of its keyframes, and the
+// Jacobians of the error with respect to the two poses.
+func (e *Edge) linearize(from, to [3]float64) (err [3]float64, A, B mat3) {
+	Rz := rotation(e.Measurement[2])
+	Ri := rotation(from[2])
+	dRi := rotationDerivative(from[2])
+
+	dt := [3]float64{to[0] - from[0], to[1] - from[1], 0}
+
+	RzT := Rz.transpose()
+	RzTRiT := RzT.mul(Ri.transpose())
+
+	// Pose of "to" relative to "from", compared to the measurement
+	rel := Ri.transpose().mulVec(dt)
+	diff := [3]float64{rel[0] - e.Measurement[0], rel[1] - e.Measurement[1], 0}
+	exy := RzT.mulVec(diff)
+
+	err = [3]float64{exy[0], exy[1], normalizeAngle(to[2] - from[2] - e.Measurement[2])}
+
+	dTheta := RzT.mulVec(dRi.transpose().mulVec(dt))
+
+	for r := 0; r < 2; r++ {
+		for c := 0; c < 2; c++ {
+			A[r][c] = -RzTRiT[r][c]
+			B[r][c] = RzTRiT[r][c]
+		}
+		A[r][2] = dTheta[r]
+	}
+	A[2][2] = -1
+	B[2][2] = 1
+
+	return err, A, B
+}
+
+// Pose of b in the coordinate system of a
+func RelativePose(a, b [3]float64) [3]float64 {
+	sin, cos := math.Sincos(a[2])
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	return [3]float64{
+		cos*dx + sin*dy,
+		-sin*dx + cos*dy,
+		normalizeAngle(b[2] - a[2]),
+	}
+}
+
+// Pose given relative to a, in the coordinate system a is given in
+func ComposePose(a, relative [3]float64) [3]float64 {
+	sin, cos := math.Sincos(a[2])
+	return [3]float64{
+		a[0] + cos*relative[0] - sin*relative[1],
+		a[1] + sin*relative[0] + cos*relative[1],
+		normalizeAngle(a[2] + relative[2]),
+	}
+}
+
+// Diagonal information matrix from standard deviations in x, y and theta
+func DiagonalInformation(stdDevXY, stdDevTheta float64) [3][3]float64 {
+	return [3][3]float64{
+		{1 / (stdDevXY * stdDevXY), 0, 0},
+		{0, 1 / (stdDevXY * stdDevXY), 0},
+		{0, 0, 1 / (stdDevTheta * stdDevTheta)},
+	}
+}
+
+// Get the information of a scan match, the inverse of its covariance, in
+// meters and radians. The scan matcher gives the Hessian of the match in map
+// cells, which is the information in map cells. It's rotated to the
+// coordinate system of the matched pose, with heading theta, in which the
+// errors of edges to it are measured.
+func ScanMatchInformation(hessian *matrix.DenseMatrix, scaleToMap, theta float64) [3][3]float64 {
+	scale := [3]float64{scaleToMap, scaleToMap, 1}
+
+	var world [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			world[i][j] = hessian.Get(i, j) * scale[i] * scale[j]
+		}
+	}
+
+	R := rotation(theta)
+	return R.transpose().mul(world).mul(R)
+}
+
+// Normalize an angle to [-pi, pi]
+func normalizeAngle(angle float64) float64 {
+	return math.Atan2(math.Sin(angle), math.Cos(angle))
+}
+
+var errNotConverged = errors.New("Pose graph solver did not converge.")
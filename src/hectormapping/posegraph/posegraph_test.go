@@ -0,0 +1,169 @@
+package posegraph
+
+import (
+	"math"
+	"testing"
+
+	"github.com/skelterjohn/go.matrix"
+
+	"hectormapping"
+	"hectormapping/datacontainer"
+)
+
+func TestRelativePose(t *testing.T) {
+	a := [3]float64{1, 2, 0.5}
+	b := [3]float64{-1, 3, 2.5}
+
+	c := ComposePose(a, RelativePose(a, b))
+	for i := range b {
+		if math.Abs(c[i]-b[i]) > 1e-9 {
+			t.Fatalf("Composed pose is %v, want %v", c, b)
+		}
+	}
+}
+
+func TestScanMatchInformation(t *testing.T) {
+
+	// A match in a corridor along the x axis of the map, which doesn't
+	// constrain x
+	hessian := matrix.MakeDenseMatrixStacked([][]float64{{0, 0, 0}, {0, 4, 0}, {0, 0, 9}})
+
+	// With cells of 0.05 m, and the pose heading along the y axis, the
+	// corridor is along the y axis of the pose
+	info := ScanMatchInformation(hessian, 20, math.Pi/2)
+	want := [3][3]float64{{1600, 0, 0}, {0, 0, 0}, {0, 0, 9}}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(info[i][j]-want[i][j]) > 1e-6 {
+				t.Fatalf("Information is %v, want %v", info, want)
+			}
+		}
+	}
+}
+
+// Drive around a square with a small error in the rotation of every step, and
+// close the loop back to the start.
+func TestOptimizeLoop(t *testing.T) {
+	g := MakeGraph()
+	info := DiagonalInformation(0.05, 0.02)
+	scan := datacontainer.MakeDataContainer(0)
+
+	step := [3]float64{0.5, 0, 0}
+	turn := [3]float64{0.5, 0, math.Pi / 2}
+	bias := 0.02
+
+	truth := [3]float64{}
+	estimate := [3]float64{}
+	g.AddKeyframe(estimate, matrix.Eye(3), scan)
+
+	for i := 1; i <= 32; i++ {
+		move := step
+		if i%8 == 0 {
+			move = turn
+		}
+		measured := move
+		measured[2] += bias
+
+		truth = ComposePose(truth, move)
+		estimate = ComposePose(estimate, measured)
+		g.AddKeyframe(estimate, matrix.Eye(3), scan)
+		g.AddEdge(i-1, i, measured, info, false)
+	}
+
+	// The last keyframe is back at the start
+	if math.Hypot(truth[0], truth[1]) > 1e-9 {
+		t.Fatalf("Test trajectory doesn't end at the start, but at %v", truth)
+	}
+	before := math.Hypot(estimate[0], estimate[1])
+
+	g.AddEdge(0, 32, [3]float64{}, info, true)
+
+	errBefore := g.Error()
+	if err := g.Optimize(10); err != nil {
+		t.Fatal(err)
+	}
+	if g.Error() >= errBefore {
+		t.Errorf("Error did not decrease, before %f, after %f", errBefore, g.Error())
+	}
+
+	last := g.Keyframes[32].Pose
+	after := math.Hypot(last[0], last[1])
+	if after > 0.05 || after > before/10 {
+		t.Errorf("Last keyframe is %f m from the start after optimising, %f m before", after, before)
+	}
+
+	// The first keyframe is fixed
+	if g.Keyframes[0].Pose != [3]float64{} {
+		t.Errorf("First keyframe moved to %v", g.Keyframes[0].Pose)
+	}
+}
+
+// Walls of a 6x4 m room with a box in one corner, as line segments
+var room = [][4]float64{
+	{-3, -2, 3, -2}, {3, -2, 3, 2}, {3, 2, -3, 2}, {-3, 2, -3, -2},
+	{1.5, 0.8, 2.2, 0.8}, {2.2, 0.8, 2.2, 1.4}, {2.2, 1.4, 1.5, 1.4}, {1.5, 1.4, 1.5, 0.8},
+}
+
+// Make a scan from the given pose in the room, in map coordinates
+func makeScan(pose [3]float64, scaleToMap float64) *datacontainer.DataContainer {
+	dc := datacontainer.MakeDataContainer(0)
+
+	for i := 0; i < 360; i++ {
+		angle := float64(i) * math.Pi / 180
+		dx, dy := math.Cos(pose[2]+angle), math.Sin(pose[2]+angle)
+
+		dist := math.Inf(1)
+		for _, w := range room {
+			// Solve pose + t*d = w0 + s*(w1 - w0)
+			ex, ey := w[2]-w[0], w[3]-w[1]
+			den := dx*ey - dy*ex
+			if den == 0 {
+				continue
+			}
+			px, py := w[0]-pose[0], w[1]-pose[1]
+			t := (px*ey - py*ex) / den
+			s := (px*dy - py*dx) / den
+			if t > 0 && s >= 0 && s <= 1 && t < dist {
+				dist = t
+			}
+		}
+
+		if !math.IsInf(dist, 1) {
+			dist *= scaleToMap
+			dc.Add([2]float64{math.Cos(angle) * dist, math.Sin(angle) * dist})
+		}
+	}
+
+	return dc
+}
+
+func TestMatchKeyframe(t *testing.T) {
+	hsp := hectormapping.MakeHectorSlamProcessor(0.05, 256, 256, [2]float64{0.5, 0.5}, 3)
+	p := MakeProcessor(hsp)
+	p.LoopMinKeyframeAge = 1
+	p.LoopSubmapSize = 256
+
+	candidatePose := [3]float64{-1, -0.5, 0.2}
+	truePose := [3]float64{-0.6, -0.3, 0.3}
+
+	candidate := p.graph.AddKeyframe(candidatePose, matrix.Eye(3), makeScan(candidatePose, hsp.GetScaleToMap()))
+
+	// The estimated pose of the keyframe has drifted
+	kf := p.graph.AddKeyframe([3]float64{-0.4, -0.4, 0.25}, matrix.Eye(3), makeScan(truePose, hsp.GetScaleToMap()))
+
+	relative, ok := p.matchKeyframe(candidate, kf)
+	if !ok {
+		t.Fatal("Match was not accepted")
+	}
+
+	want := RelativePose(candidatePose, truePose)
+	if math.Hypot(relative[0]-want[0], relative[1]-want[1]) > 0.1 || math.Abs(relative[2]-want[2]) > 0.05 {
+		t.Errorf("Relative pose is %v, want %v", relative, want)
+	}
+
+	// A scan from somewhere else should not match
+	elsewhere := p.graph.AddKeyframe(kf.Pose, matrix.Eye(3), makeScan([3]float64{2, -1, 2}, hsp.GetScaleToMap()))
+	if _, ok := p.matchKeyframe(candidate, elsewhere); ok {
+		t.Error("Match of a scan from elsewhere was accepted")
+	}
+}
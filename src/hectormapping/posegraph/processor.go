@@ -0,0 +1,364 @@
+package posegraph
+
+import (
+	"log"
+	"math"
+
+	"hectormapping"
+	"hectormapping/datacontainer"
+	"hectormapping/map/gridmap"
+	"hectormapping/map/maprep"
+
+	"robot/logging"
+)
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+// A Processor runs a HectorSlamProcessor and keeps a pose graph of the scans
+// used to update its map. Each scan which updates the map becomes a keyframe,
+// so rebuilding the map from the keyframes gives the same map as the slam
+// processor would have made with the corrected poses.
+type Processor struct {
+	hsp   *hectormapping.HectorSlamProcessor
+	graph *Graph
+
+	// Largest standard deviations of the poses between consecutive
+	// keyframes, in the directions the scan match doesn't constrain, e.g.
+	// along a featureless corridor. Elsewhere they are given by the
+	// covariance of the scan match.
+	OdometryStdDevXY, OdometryStdDevTheta float64
+
+	// Factor the covariance of the scan match is multiplied by. The scan
+	// matcher treats the scan endpoints as independent, so the covariance it
+	// gives is much too small.
+	ScanMatchCovarianceScale float64
+
+	// Standard deviations of the poses found by loop closure
+	LoopStdDevXY, LoopStdDevTheta float64
+
+	// Keyframes within this distance in meters of the current pose are
+	// candidates for loop closure, if they are at least LoopMinKeyframeAge
+	// keyframes old
+	LoopSearchRadius   float64
+	LoopMinKeyframeAge int
+
+	// How far in meters and radians from the estimated pose to search for
+	// the pose of the current scan relative to a candidate
+	LoopSearchWindow float64
+	LoopSearchAngle  float64
+
+	// Number of keyframes on each side of the candidate used to make the map
+	// the current scan is matched against
+	LoopSubmapKeyframes int
+
+	// Size in cells of the map the current scan is matched against
+	LoopSubmapSize int
+
+	// Fraction of the scan endpoints which must hit walls in the submap for
+	// the match to be accepted
+	LoopMinScore float64
+
+	// Maximum number of Gauss-Newton iterations
+	OptimizationIterations int
+
+	lastMapUpdatePose [3]float64
+	lastLoopClosure   int
+	loopClosures      int
+}
+
+// Make a processor which keeps a pose graph for the given slam processor
+func MakeProcessor(hsp *hectormapping.HectorSlamProcessor) *Processor {
+	return &Processor{
+		hsp:                      hsp,
+		graph:                    MakeGraph(),
+		OdometryStdDevXY:         0.05,
+		OdometryStdDevTheta:      0.02,
+		ScanMatchCovarianceScale: 100,
+		LoopStdDevXY:             0.05,
+		LoopStdDevTheta:          0.02,
+		LoopSearchRadius:         2.0,
+		LoopMinKeyframeAge:       20,
+		LoopSearchWindow:         1.0,
+		LoopSearchAngle:          0.3,
+		LoopSubmapKeyframes:      2,
+		LoopSubmapSize:           512,
+		LoopMinScore:             0.7,
+		OptimizationIterations:   10,
+		lastMapUpdatePose:        hsp.GetLastMapUpdatePose(),
+		lastLoopClosure:          -1,
+	}
+}
+
+// Update the slam processor with a scan. If the scan updated the map, it is
+// added as a keyframe, and matched against old keyframes close to it. When a
+// loop closure is found, the graph is optimised and the map rebuilt.
+func (p *Processor) Update(dataContainer *datacontainer.DataContainer, poseHintWorld [3]float64) {
+	p.hsp.Update(dataContainer, poseHintWorld)
+
+	if p.hsp.GetLastMapUpdatePose() == p.lastMapUpdatePose {
+		return
+	}
+	p.lastMapUpdatePose = p.hsp.GetLastMapUpdatePose()
+
+	kf := p.graph.AddKeyframe(p.hsp.GetLastScanMatchPose(), p.hsp.GetLastScanMatchCovariance(), dataContainer)
+	if kf.Index > 0 {
+		prev := p.graph.Keyframes[kf.Index-1]
+		p.graph.AddEdge(prev.Index, kf.Index, RelativePose(prev.Pose, kf.Pose), p.odometryInformation(kf), false)
+	}
+
+	if p.lastLoopClosure >= 0 && kf.Index-p.lastLoopClosure < p.LoopMinKeyframeAge/2 {
+		return
+	}
+
+	candidate := p.findCandidate(kf)
+	if candidate == nil {
+		return
+	}
+
+	relative, ok := p.matchKeyframe(candidate, kf)
+	if !ok {
+		return
+	}
+
+	p.graph.AddEdge(candidate.Index, kf.Index, relative,
+		DiagonalInformation(p.LoopStdDevXY, p.LoopStdDevTheta), true)
+	p.lastLoopClosure = kf.Index
+	p.loopClosures++
+
+	logger.Printf("Loop closure between keyframe %d and %d\n", candidate.Index, kf.Index)
+
+	err := p.graph.Optimize(p.OptimizationIterations)
+	if err != nil {
+		logger.Println("Pose graph optimisation failed:", err)
+		return
+	}
+
+	p.hsp.RebuildMap(p.graph.GetScans(), p.graph.GetPoses())
+	p.lastMapUpdatePose = p.hsp.GetLastMapUpdatePose()
+}
+
+// Get the information of the edge from the previous keyframe to kf. It's the
+// information of the scan match of kf, with the covariance scaled by
+// ScanMatchCovarianceScale, plus that of the largest standard deviations,
+// which keeps the graph solvable where the scan match is degenerate.
+func (p *Processor) odometryInformation(kf *Keyframe) [3][3]float64 {
+	information := ScanMatchInformation(kf.Covariance, p.hsp.GetScaleToMap(), kf.Pose[2])
+	least := DiagonalInformation(p.OdometryStdDevXY, p.OdometryStdDevTheta)
+	for i := range information {
+		for j := range information[i] {
+			information[i][j] = information[i][j]/p.ScanMatchCovarianceScale + least[i][j]
+		}
+	}
+	return information
+}
+
+// Find the closest keyframe which is old enough to be a loop closure candidate
+func (p *Processor) findCandidate(kf *Keyframe) *Keyframe {
+	var best *Keyframe
+	bestDist := p.LoopSearchRadius
+
+	for _, old := range p.graph.Keyframes[:max(kf.Index-p.LoopMinKeyframeAge+1, 0)] {
+		dist := math.Hypot(old.Pose[0]-kf.Pose[0], old.Pose[1]-kf.Pose[1])
+		if dist <= bestDist {
+			best, bestDist = old, dist
+		}
+	}
+
+	return best
+}
+
+// Match the scan of a keyframe against a map made from the candidate and the
+// keyframes next to it, in the coordinate system of the candidate. Returns the
+// pose of the keyframe relative to the candidate, and whether the match is
+// good enough.
+//
+// The gradient based scan matcher needs a good initial estimate, which is not
+// available after a long loop, so the match is found by searching the window
+// around the estimate for the pose where the most scan endpoints hit walls.
+func (p *Processor) matchKeyframe(candidate, kf *Keyframe) ([3]float64, bool) {
+	cellLength := 1 / p.hsp.GetScaleToMap()
+	submap := maprep.MakeMapRepMultiMap(cellLength, p.LoopSubmapSize, p.LoopSubmapSize,
+		1, [2]float64{0.5, 0.5})
+
+	first := max(candidate.Index-p.LoopSubmapKeyframes, 0)
+	last := min(candidate.Index+p.LoopSubmapKeyframes, kf.Index-p.LoopMinKeyframeAge)
+	for _, neighbour := range p.graph.Keyframes[first : last+1] {
+		submap.UpdateByScan(neighbour.Scan, RelativePose(candidate.Pose, neighbour.Pose))
+	}
+	gridMap := submap.GetGridMap(0)
+
+	hint := gridMap.GetMapCoordsPose(RelativePose(candidate.Pose, kf.Pose))
+	windowCells := int(p.LoopSearchWindow / cellLength)
+
+	// Coarse search with a tolerance of one cell, then a fine search around
+	// the best coarse match
+	coarse := makeHitGrid(gridMap, 1)
+	best, _ := coarse.search(kf.Scan, hint, windowCells, 2, p.LoopSearchAngle, 0.02)
+
+	fine := makeHitGrid(gridMap, 0)
+	best, _ = fine.search(kf.Scan, best, 2, 1, 0.02, 0.005)
+
+	return gridMap.GetWorldCoordsPose(best), coarse.score(kf.Scan, best) >= p.LoopMinScore
+}
+
+// A hitGrid tells which cells of a map are within a given number of cells of
+// an occupied cell.
+type hitGrid struct {
+	sizeX, sizeY int
+	hit          []bool
+}
+
+func makeHitGrid(gridMap gridmap.OccGridMap, tolerance int) *hitGrid {
+	hg := &hitGrid{
+		sizeX: gridMap.GetSizeX(),
+		sizeY: gridMap.GetSizeY(),
+	}
+	hg.hit = make([]bool, hg.sizeX*hg.sizeY)
+
+	for y := 0; y < hg.sizeY; y++ {
+		for x := 0; x < hg.sizeX; x++ {
+			if !gridMap.IsOccupied(x, y) {
+				continue
+			}
+			for dy := -tolerance; dy <= tolerance; dy++ {
+				for dx := -tolerance; dx <= tolerance; dx++ {
+					if x+dx >= 0 && y+dy >= 0 && x+dx < hg.sizeX && y+dy < hg.sizeY {
+						hg.hit[(y+dy)*hg.sizeX+x+dx] = true
+					}
+				}
+			}
+		}
+	}
+
+	return hg
+}
+
+// The fraction of the scan endpoints which hit, when the scan is taken from
+// the given pose in map coordinates
+func (hg *hitGrid) score(scan *datacontainer.DataContainer, mapPose [3]float64) float64 {
+	if scan.GetSize() == 0 {
+		return 0
+	}
+
+	sin, cos := math.Sincos(mapPose[2])
+
+	hits := 0
+	for i := 0; i < scan.GetSize(); i++ {
+		point := scan.GetVecEntry(i)
+		if hg.isHit(int(mapPose[0]+cos*point[0]-sin*point[1]+0.5), int(mapPose[1]+sin*point[0]+cos*point[1]+0.5)) {
+			hits++
+		}
+	}
+
+	return float64(hits) / float64(scan.GetSize())
+}
+
+func (hg *hitGrid) isHit(x, y int) bool {
+	return x >= 0 && y >= 0 && x < hg.sizeX && y < hg.sizeY && hg.hit[y*hg.sizeX+x]
+}
+
+// Search for the pose with the best score within window cells and angleWindow
+// radians of the start pose, in the given steps. Poses are in map coordinates.
+func (hg *hitGrid) search(scan *datacontainer.DataContainer, start [3]float64,
+	window, step int, angleWindow, angleStep float64) ([3]float64, float64) {
+
+	best, bestHits := start, -1
+	points := make([][2]float64, scan.GetSize())
+
+	for angle := -angleWindow; angle <= angleWindow+1e-9; angle += angleStep {
+		theta := start[2] + angle
+		sin, cos := math.Sincos(theta)
+		for i := range points {
+			point := scan.GetVecEntry(i)
+			points[i] = [2]float64{cos*point[0] - sin*point[1] + 0.5, sin*point[0] + cos*point[1] + 0.5}
+		}
+
+		for dy := -window; dy <= window; dy += step {
+			for dx := -window; dx <= window; dx += step {
+				x, y := start[0]+float64(dx), start[1]+float64(dy)
+
+				hits := 0
+				for _, point := range points {
+					if hg.isHit(int(x+point[0]), int(y+point[1])) {
+						hits++
+					}
+				}
+
+				if hits > bestHits {
+					best, bestHits = [3]float64{x, y, theta}, hits
+				}
+			}
+		}
+	}
+
+	if len(points) == 0 {
+		return best, 0
+	}
+	return best, float64(bestHits) / float64(len(points))
+}
+
+// An Anchor is a pose relative to a keyframe of the pose graph, so it can be
+// moved with the keyframe when the graph is optimised
+type Anchor struct {
+	Keyframe int
+	Relative [3]float64
+}
+
+// Anchor a pose to the last keyframe. The keyframe is -1 if there are none.
+func (p *Processor) MakeAnchor(pose [3]float64) Anchor {
+	keyframes := p.graph.Keyframes
+	if len(keyframes) == 0 {
+		return Anchor{Keyframe: -1}
+	}
+
+	last := keyframes[len(keyframes)-1]
+	return Anchor{
+		Keyframe: last.Index,
+		Relative: RelativePose(last.Pose, pose),
+	}
+}
+
+// Get the pose of an anchor at the current pose of its keyframe. Returns
+// false if it has no keyframe.
+func (p *Processor) AnchoredPose(a Anchor) ([3]float64, bool) {
+	if a.Keyframe < 0 {
+		return [3]float64{}, false
+	}
+	return ComposePose(p.graph.Keyframes[a.Keyframe].Pose, a.Relative), true
+}
+
+// Get the pose of the last scan, corrected by loop closure
+func (p *Processor) GetLastScanMatchPose() [3]float64 {
+	return p.hsp.GetLastScanMatchPose()
+}
+
+func (p *Processor) GetHectorSlamProcessor() *hectormapping.HectorSlamProcessor {
+	return p.hsp
+}
+
+func (p *Processor) GetGraph() *Graph {
+	return p.graph
+}
+
+// Get the number of loop closures found
+func (p *Processor) GetLoopClosures() int {
+	return p.loopClosures
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,167 @@
+package posegraph
+
+import (
+	"math"
+)
+
+type mat3 [3][3]float64
+
+func (m mat3) mul(other [3][3]float64) mat3 {
+	var res mat3
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				res[r][c] += m[r][k] * other[k][c]
+			}
+		}
+	}
+	return res
+}
+
+func (m mat3) mulVec(v [3]float64) [3]float64 {
+	var res [3]float64
+	for r := 0; r < 3; r++ {
+		for k := 0; k < 3; k++ {
+			res[r] += m[r][k] * v[k]
+		}
+	}
+	return res
+}
+
+func (m mat3) transpose() mat3 {
+	var res mat3
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			res[r][c] = m[c][r]
+		}
+	}
+	return res
+}
+
+// 2D rotation as a 3x3 matrix, leaving the third component as it is
+func rotation(theta float64) mat3 {
+	sin, cos := math.Sincos(theta)
+	return mat3{{cos, -sin, 0}, {sin, cos, 0}, {0, 0, 1}}
+}
+
+// Derivative of the 2D rotation with respect to theta
+func rotationDerivative(theta float64) mat3 {
+	sin, cos := math.Sincos(theta)
+	return mat3{{-sin, -cos, 0}, {cos, -sin, 0}, {0, 0, 0}}
+}
+
+func quadraticForm(v [3]float64, m [3][3]float64) float64 {
+	mv := mat3(m).mulVec(v)
+	return v[0]*mv[0] + v[1]*mv[1] + v[2]*mv[2]
+}
+
+// A symmetric matrix made of 3x3 blocks, where only the non-zero blocks are
+// stored. In a pose graph, each row of blocks has one block for the keyframe
+// itself and one for each keyframe it has an edge to.
+type blockMatrix struct {
+	rows []map[int]*mat3
+}
+
+func makeBlockMatrix(n int) *blockMatrix {
+	bm := &blockMatrix{rows: make([]map[int]*mat3, n)}
+	for i := range bm.rows {
+		bm.rows[i] = make(map[int]*mat3)
+	}
+	return bm
+}
+
+func (bm *blockMatrix) add(i, j int, m mat3) {
+	block, ok := bm.rows[i][j]
+	if !ok {
+		block = new(mat3)
+		bm.rows[i][j] = block
+	}
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			block[r][c] += m[r][c]
+		}
+	}
+}
+
+func (bm *blockMatrix) mulVec(x []float64) []float64 {
+	res := make([]float64, len(x))
+	for i, row := range bm.rows {
+		for j, block := range row {
+			for r := 0; r < 3; r++ {
+				for c := 0; c < 3; c++ {
+					res[3*i+r] += block[r][c] * x[3*j+c]
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Solve Hx = b with the conjugate gradient method, preconditioned with the
+// diagonal of H. H must be symmetric positive definite, which it is as long
+// as every keyframe is connected to the fixed first keyframe.
+func (bm *blockMatrix) solve(b []float64) ([]float64, error) {
+	n := len(b)
+
+	invDiag := make([]float64, n)
+	for i, row := range bm.rows {
+		block, ok := row[i]
+		for r := 0; r < 3; r++ {
+			if !ok || block[r][r] <= 0 {
+				return nil, errNotConverged
+			}
+			invDiag[3*i+r] = 1 / block[r][r]
+		}
+	}
+
+	x := make([]float64, n)
+	res := make([]float64, n)
+	copy(res, b)
+	z := make([]float64, n)
+	for i := range z {
+		z[i] = invDiag[i] * res[i]
+	}
+	p := make([]float64, n)
+	copy(p, z)
+
+	rz := dot(res, z)
+	bNorm := math.Sqrt(dot(b, b))
+	if bNorm == 0 {
+		return x, nil
+	}
+
+	for it := 0; it < 10*n; it++ {
+		Hp := bm.mulVec(p)
+		alpha := rz / dot(p, Hp)
+
+		for i := range x {
+			x[i] += alpha * p[i]
+			res[i] -= alpha * Hp[i]
+		}
+
+		if math.Sqrt(dot(res, res)) < 1e-10*bNorm {
+			return x, nil
+		}
+
+		for i := range z {
+			z[i] = invDiag[i] * res[i]
+		}
+		rzNew := dot(res, z)
+		beta := rzNew / rz
+		rz = rzNew
+
+		for i := range p {
+			p[i] = z[i] + beta*p[i]
+		}
+	}
+
+	return nil, errNotConverged
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,76 @@
+// Command convertlog converts sensor logs between the CSV and the binary log
+// format. Either format can be read, the format of the input is detected.
+//
+// Usage:
+//
+//	convertlog -in run.log -out run.bin [-format binary|csv] [-compress]
+//
+// Logs are looked up in and written to the sensor log folder unless a path is
+// given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"robot/config"
+	"robot/sensors/logreader"
+)
+
+var (
+	in       = flag.String("in", "", "sensor log to convert")
+	out      = flag.String("out", "", "file to write the converted log to")
+	format   = flag.String("format", "binary", "format to convert to, binary or csv")
+	compress = flag.Bool("compress", config.SENSORLOGS_COMPRESS, "compress the records of a binary log")
+)
+
+func main() {
+	flag.Parse()
+
+	if *in == "" || *out == "" || (*format != "binary" && *format != "csv") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Use the sensor log folder, unless a path is given
+func logPath(name string) string {
+	if filepath.Base(name) == name {
+		return config.SENSORLOGS_ROOT + name
+	}
+	return name
+}
+
+func run() error {
+	slr, err := logreader.MakeLogReaderFromFileName(logPath(*in))
+	if err != nil {
+		return err
+	}
+	defer slr.Close()
+
+	f, err := os.Create(logPath(*out))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if *format == "binary" {
+		err = slr.ConvertToBinary(f, *compress)
+	} else {
+		err = slr.ConvertToCSV(f)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %s to %s\n", *in, *out)
+	return nil
+}
@@ -0,0 +1,107 @@
+// Command offlineslam builds a map from a sensor log without the web server,
+// processing the log as fast as possible. The map is saved to the map storage,
+// and the trajectory can be written to a file as CSV, or in the TUM or KITTI
+// format for evaluation tools.
+//
+// Usage:
+//
+//	offlineslam -log run.log -map "Second Floor" [-trajectory run.txt -format tum] [-loopclosure]
+//
+// The log is looked up in the sensor log folder unless a path is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"robot/config"
+	"robot/sensors/logreader"
+	"robot/slam/offline"
+	"robot/slam/trajectory"
+)
+
+var (
+	logName        = flag.String("log", "", "sensor log to process")
+	mapName        = flag.String("map", "", "name of the map to save, defaults to the log name")
+	description    = flag.String("description", "", "map description")
+	trajectoryFile = flag.String("trajectory", "", "file to write the trajectory to")
+	format         = flag.String("format", trajectory.FORMAT_CSV, "trajectory format: csv, tum or kitti")
+	useOdometry    = flag.Bool("odometry", config.HECTORSLAM_USE_ODOMETRY, "use odometry readings for the pose hint")
+	loopClosure    = flag.Bool("loopclosure", config.HECTORSLAM_LOOP_CLOSURE, "detect loop closures and correct the map")
+)
+
+func main() {
+	flag.Parse()
+
+	if *logName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	// Use the log from the sensor log folder, unless a path is given
+	var slr *logreader.SensorLogReader
+	var err error
+	if filepath.Base(*logName) == *logName {
+		slr, err = logreader.MakeLogReaderFromLogName(*logName)
+	} else {
+		slr, err = logreader.MakeLogReaderFromFileName(*logName)
+	}
+	if err != nil {
+		return err
+	}
+	defer slr.Close()
+
+	p := offline.MakeDefaultProcessor()
+	p.UseOdometry = *useOdometry
+	if *loopClosure {
+		p.EnableLoopClosure()
+	}
+
+	err = p.ProcessLog(slr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(p.Stats)
+	if pg := p.GetPoseGraph(); pg != nil {
+		fmt.Printf("%d keyframes, %d loop closures\n", len(pg.GetGraph().Keyframes), pg.GetLoopClosures())
+	}
+
+	name := *mapName
+	if name == "" {
+		base := filepath.Base(*logName)
+		name = base[:len(base)-len(filepath.Ext(base))]
+	}
+
+	err = p.SaveMap(name, filepath.Base(name), *description)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved map %q\n", name)
+
+	if *trajectoryFile != "" {
+		f, err := os.Create(*trajectoryFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = trajectory.Write(f, p.Trajectory, *format)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d poses to %s\n", len(p.Trajectory), *trajectoryFile)
+	}
+
+	return nil
+}
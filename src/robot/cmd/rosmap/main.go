@@ -0,0 +1,81 @@
+// Command rosmap converts maps between the map storage format and the format
+// of the ROS map_server, a PGM image and a YAML file.
+//
+// Usage:
+//
+//	rosmap -export -in office.tigermap -out office
+//	rosmap -import -in office.yaml -out office.tigermap [-name Office]
+//
+// Exporting writes office.pgm and office.yaml. Files are looked up in and
+// written to the map storage folder unless a path is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"robot/mapstorage"
+)
+
+var (
+	export = flag.Bool("export", false, "export a stored map to the ROS format")
+	imp    = flag.Bool("import", false, "import a ROS map to a stored map")
+	in     = flag.String("in", "", "map to convert")
+	out    = flag.String("out", "", "file to write the converted map to")
+	name   = flag.String("name", "", "name of an imported map, the name of the YAML file by default")
+)
+
+func main() {
+	flag.Parse()
+
+	if *in == "" || *out == "" || *export == *imp {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if *export {
+		err = exportMap()
+	} else {
+		err = importMap()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func exportMap() error {
+	m, err := mapstorage.Load(*in)
+	if err != nil {
+		return err
+	}
+
+	err = mapstorage.ExportROS(m.MapRep, *out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %s to %s\n", *in, *out)
+	return nil
+}
+
+func importMap() error {
+	m, err := mapstorage.ImportROS(*in)
+	if err != nil {
+		return err
+	}
+
+	if *name != "" {
+		m.Meta.Name = *name
+	}
+
+	err = m.Save(*out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %s to %s\n", *in, *out)
+	return nil
+}
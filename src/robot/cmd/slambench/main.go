@@ -0,0 +1,119 @@
+// Command slambench runs HectorSLAM on the datasets in the dataset folder and
+// reports how well the estimated trajectory and map fit the ground truth. The
+// report can be saved and diffed against a report from another commit.
+//
+// Usage:
+//
+//	slambench [-dataset simroom,office] [-out report.txt] [-check] [-loopclosure]
+//
+// With -check, the command fails if a result is outside the limits in the
+// dataset description.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"robot/config"
+	"robot/slam/benchmark"
+	"robot/slam/trajectory"
+)
+
+var (
+	datasets      = flag.String("dataset", "", "comma separated datasets to run, defaults to all")
+	outFile       = flag.String("out", "", "file to write the report to, defaults to standard output")
+	trajectoryDir = flag.String("trajectories", "", "folder to write the estimated trajectories to, in the TUM format")
+	check         = flag.Bool("check", false, "fail if a result is outside the limits of the dataset")
+	useOdometry   = flag.Bool("odometry", config.HECTORSLAM_USE_ODOMETRY, "use odometry readings for the pose hint")
+	loopClosure   = flag.Bool("loopclosure", config.HECTORSLAM_LOOP_CLOSURE, "detect loop closures and correct the map")
+	timing        = flag.Bool("timing", false, "print processing times to standard error")
+)
+
+func main() {
+	flag.Parse()
+
+	failed, err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func run() (bool, error) {
+	names, err := benchmark.GetDatasets()
+	if *datasets != "" {
+		names, err = strings.Split(*datasets, ","), nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(names) == 0 {
+		return false, fmt.Errorf("No datasets in %s.", config.DATASETS_ROOT)
+	}
+
+	b := benchmark.MakeBenchmark()
+	b.UseOdometry = *useOdometry
+	b.LoopClosure = *loopClosure
+
+	results := make([]*benchmark.Result, 0, len(names))
+	failed := false
+	for _, name := range names {
+		d, err := benchmark.LoadDataset(name)
+		if err != nil {
+			return false, err
+		}
+
+		r, err := b.Run(d)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", name, err)
+		}
+		results = append(results, r)
+
+		if *timing {
+			fmt.Fprintf(os.Stderr, "%s:\n%s\n", d.Name, r.Stats)
+		}
+
+		if *check {
+			for _, failure := range r.Check(d.Limits) {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", d.Name, failure)
+				failed = true
+			}
+		}
+
+		if *trajectoryDir != "" {
+			err = writeTrajectory(filepath.Join(*trajectoryDir, d.Name+".txt"), r.Trajectory)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return failed, benchmark.WriteReport(w, results)
+}
+
+func writeTrajectory(filename string, poses []trajectory.Pose) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return trajectory.WriteTUM(f, poses)
+}
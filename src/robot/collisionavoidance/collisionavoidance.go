@@ -11,6 +11,7 @@ import (
 	"robot/logging"
 	"robot/sensors/lidar"
 	"robot/sensors/sensor"
+	"robot/telemetry"
 )
 
 var logger *log.Logger
@@ -20,12 +21,10 @@ func init() {
 }
 
 type CollisionDetector struct {
+	lidar  sensor.Sensor
 	angle  float64
 	radius float64
 
-	minIndex int
-	maxIndex int
-
 	// Internal flag
 	isStopped bool
 
@@ -40,10 +39,11 @@ type CollisionDetector struct {
 	ResumeChan chan bool
 }
 
-// Create a CollisionDetector with angle and radius specified. The angle is in
-// radians, the radius is in meters.
-func MakeCollisionDetector(angle, radius float64) *CollisionDetector {
+// Create a CollisionDetector watching the given LIDAR with angle and radius
+// specified. The angle is in radians, the radius is in meters.
+func MakeCollisionDetector(lidar sensor.Sensor, angle, radius float64) *CollisionDetector {
 	c := &CollisionDetector{
+		lidar:  lidar,
 		angle:  angle,
 		radius: radius,
 	}
@@ -55,52 +55,46 @@ func MakeCollisionDetector(angle, radius float64) *CollisionDetector {
 }
 
 // Make collision detector from default parameters
-func MakeDefaultCollisionDetector() *CollisionDetector {
-	return MakeCollisionDetector(config.COLLISION_DETECTION_ANGLE,
+func MakeDefaultCollisionDetector(lidar sensor.Sensor) *CollisionDetector {
+	return MakeCollisionDetector(lidar, config.COLLISION_DETECTION_ANGLE,
 		config.COLLISION_DETECTION_RADIUS)
 }
 
 func (c *CollisionDetector) Start() {
 	// Subscribe to LIDAR readings
-	c.lidarChan = lidar.LidarSensor.Subscribe()
-
-	// This was the original code for calculating the starting and stopping indices for a given
-	// collision avoidance radial span using the original lidar laser.
-	//nPerDeg := float64(lidar.LidarSensor.Distances) / lidar.LidarSensor.RadialSpan
-	//
-	// nPerRad := nPerDeg * 180 / math.Pi
-	//
-	// c.minIndex = lidar.LidarSensor.Distances/2 - int(nPerRad*c.angle/2)
-	// c.maxIndex = lidar.LidarSensor.Distances/2 + int(nPerRad*c.angle/2)
-
-	// This is the corrected code for calculating the starting and stopping indices for a given
-	// collision avoidance radial span using an XV Neato Lidar laser.
-	deg := config.COLLISION_DETECTION_ANGLE * 180 / math.Pi
-	c.minIndex = int(127 - deg/2)
-	c.maxIndex = int(127 + deg/2)
-
-	// BJW debugging
-	logger.Printf("Set Min lidar index = %v\n", c.minIndex)
-	logger.Printf("Set Max lidar index = %v\n\n", c.maxIndex)
+	c.lidarChan = c.lidar.Subscribe()
 
 	c.stopRoutineChan = make(chan bool)
 
 	// Run the routine until Stop() is called (return)
 	go c.checkRoutine()
 
+	c.publish(true)
+
 	// logger.Println("Collision avoidance started")
 }
 
 func (c *CollisionDetector) Stop() {
 	// Unsubscribe
-	lidar.LidarSensor.Unsubscribe(c.lidarChan)
+	c.lidar.Unsubscribe(c.lidarChan)
 
 	// Stop routine
 	c.stopRoutineChan <- true
+
+	c.publish(false)
 }
 
 func (c *CollisionDetector) Reset() {
 	c.isStopped = false
+	c.publish(true)
+}
+
+// Publish the state as telemetry
+func (c *CollisionDetector) publish(active bool) {
+	telemetry.Publish(telemetry.COLLISION, telemetry.Collision{
+		Active:   active,
+		Obstacle: active && c.isStopped,
+	})
 }
 
 func (c *CollisionDetector) checkRoutine() {
@@ -124,6 +118,7 @@ func (c *CollisionDetector) checkRoutine() {
 			// the area is now non-occupied.
 			if !c.areaOccupied(lidarReading) {
 				c.isStopped = false
+				c.publish(true)
 
 				c.ResumeChan <- true
 				// select {
@@ -137,6 +132,7 @@ func (c *CollisionDetector) checkRoutine() {
 			// the area is occupied.
 			if c.areaOccupied(lidarReading) {
 				c.isStopped = true
+				c.publish(true)
 
 				c.StopChan <- true
 				// select {
@@ -149,15 +145,25 @@ func (c *CollisionDetector) checkRoutine() {
 	}
 }
 
-// Check if the area is occupied (one or more laser beams are stopping)
+// Check if the area is occupied (one or more laser beams are stopping). The
+// area is the sector within radius of the robot's center and half the angle
+// to each side of the robot's forward, wherever the LIDAR is mounted.
 func (c *CollisionDetector) areaOccupied(lr *lidar.LidarReading) bool {
-	// BJW debugging
-	// logger.Printf("Using Min lidar index = %v\n", c.minIndex)
-	// logger.Printf("Using Max lidar index = %v\n\n", c.maxIndex)
+	origin := lr.Origin()
 
 	// Check if there's anything within that area
-	for i := c.minIndex; i < c.maxIndex; i++ {
-		if lr.Distances[i] > 10 && lr.Distances[i] < c.radius*1000 {
+	for i := range lr.Distances {
+		if lr.Distances[i] <= 10 {
+			continue
+		}
+
+		// The point the beam hit, in the robot's frame
+		dist := lr.Distances[i] / 1000
+		angle := lr.BeamAngle(i)
+		x := origin[0] + dist*math.Cos(angle)
+		y := origin[1] + dist*math.Sin(angle)
+
+		if math.Abs(math.Atan2(y, x)) <= c.angle/2 && math.Hypot(x, y) < c.radius {
 			// logger.Printf("Detected obstacle at index = %v\nDistance = %v\n\n", i, lr.Distances[i])
 			return true
 		}
@@ -2,6 +2,7 @@ package collisionavoidance
 
 import (
 	"fmt"
+	"math"
 	"sync"
 	"testing"
 
@@ -10,19 +11,21 @@ import (
 
 func TestCollissionAvoidance(t *testing.T) {
 
-	err := lidar.LidarSensor.Connect()
+	l := lidar.MakeDefaultLidar()
+
+	err := l.Connect()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = lidar.LidarSensor.Start()
+	err = l.Start()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer lidar.LidarSensor.Stop()
-	defer lidar.LidarSensor.Disconnect()
+	defer l.Stop()
+	defer l.Disconnect()
 
-	cd := MakeDefaultCollisionDetector()
+	cd := MakeDefaultCollisionDetector(l)
 
 	n := 10
 	wg := new(sync.WaitGroup)
@@ -49,3 +52,74 @@ func TestCollissionAvoidance(t *testing.T) {
 	cd.Stop()
 
 }
+
+func TestAreaOccupied(t *testing.T) {
+	l := lidar.MakeLidar(lidar.NAME, nil, 240, 4000, 241)
+	cd := MakeCollisionDetector(l, math.Pi/3, 0.35)
+
+	// An obstacle straight ahead of the LIDAR, beam 120
+	reading := lidar.MakeLidarReading(l)
+	reading.Distances[120] = 200
+	if !cd.areaOccupied(reading) {
+		t.Error("Obstacle ahead not detected")
+	}
+
+	// Too far away, or outside the angle
+	reading.Distances[120] = 500
+	reading.Distances[80] = 200
+	if cd.areaOccupied(reading) {
+		t.Error("Obstacle outside the area detected")
+	}
+
+	// With the LIDAR turned to the left, ahead of the robot is 90 degrees to
+	// the right of the LIDAR, beam 30
+	reading = lidar.MakeLidarReading(l)
+	reading.Mount.Yaw = 90
+	reading.Distances[120] = 200
+	if cd.areaOccupied(reading) {
+		t.Error("Obstacle to the left of the robot detected")
+	}
+	reading.Distances[30] = 200
+	if !cd.areaOccupied(reading) {
+		t.Error("Obstacle ahead of the turned LIDAR not detected")
+	}
+}
+
+func TestAreaOccupiedMountOffCenter(t *testing.T) {
+	l := lidar.MakeLidar(lidar.NAME, nil, 240, 4000, 241)
+	cd := MakeCollisionDetector(l, math.Pi/3, 0.35)
+
+	// With the LIDAR 0.3 m behind the robot's center, an obstacle 0.5 m ahead
+	// of it is 0.2 m ahead of the robot
+	reading := lidar.MakeLidarReading(l)
+	reading.Mount = lidar.Mount{X: -0.3}
+	reading.Distances[120] = 500
+	if !cd.areaOccupied(reading) {
+		t.Error("Obstacle ahead of the robot not detected")
+	}
+
+	// With the LIDAR 0.3 m ahead of the center, an obstacle 0.2 m ahead of it
+	// is 0.5 m ahead of the robot
+	reading.Mount = lidar.Mount{X: 0.3}
+	reading.Distances[120] = 200
+	if cd.areaOccupied(reading) {
+		t.Error("Obstacle outside the radius detected")
+	}
+
+	// With the LIDAR 0.2 m to the left of the center, an obstacle 0.1 m ahead
+	// of it is within the radius but outside the angle
+	reading.Mount = lidar.Mount{Y: 0.2}
+	reading.Distances[120] = 100
+	if cd.areaOccupied(reading) {
+		t.Error("Obstacle to the left of the robot detected")
+	}
+
+	// and with it also 0.1 m ahead of the center, an obstacle 0.2 m to its
+	// right is 0.1 m ahead of the robot
+	reading.Mount = lidar.Mount{X: 0.1, Y: 0.2}
+	reading.Distances[120] = 0
+	reading.Distances[30] = 200
+	if !cd.areaOccupied(reading) {
+		t.Error("Obstacle ahead of the robot not detected")
+	}
+}
@@ -63,16 +63,38 @@ func init() {
 
 	SENSORLOGS_ROOT = ASSETS_ROOT + getString(section, "sensorlogs_root")
 	SENSORLOGS_TIME_FORMAT = getString(section, "sensorlogs_time_format")
-	USE_LIDAR = getBool(section, "use_lidar")
+	SENSORLOGS_FORMAT = getString(section, "sensorlogs_format")
+	SENSORLOGS_COMPRESS = getBool(section, "sensorlogs_compress")
+	SENSORS = getString(section, "sensors")
 	LIDAR_COM_NAME = getString(section, "lidar_com_name")
 	LIDAR_BAUD_RATE = getInt(section, "lidar_baud_rate")
 	LIDAR_NUM_DISTANCES = getInt(section, "lidar_num_distances")
 	LIDAR_RADIAL_SPAN = getFloat64(section, "lidar_radial_span")
+	LIDAR_START_ANGLE = getInt(section, "lidar_start_angle")
+	LIDAR_DRIVER = getString(section, "lidar_driver")
+	URG_CLUSTER = getInt(section, "urg_cluster")
+	URG_ENCODING = getInt(section, "urg_encoding")
+	URG_INTENSITY = getBool(section, "urg_intensity")
+	RPLIDAR_EXPRESS = getBool(section, "rplidar_express")
+	RPLIDAR_MOTOR_PWM = getInt(section, "rplidar_motor_pwm")
 	LIDAR_MAX_DISTANCE = getFloat64(section, "lidar_max_distance")
 	LIDAR_POSITION_X = getFloat64(section, "lidar_position_x")
 	LIDAR_POSITION_Y = getFloat64(section, "lidar_position_y")
+	LIDAR_YAW = getFloat64(section, "lidar_yaw")
+	LIDAR_CLOCKWISE = getBool(section, "lidar_clockwise")
+	LIDAR_START_OFFSET = getFloat64(section, "lidar_start_offset")
+	LIDAR_FILTERS = getString(section, "lidar_filters")
+	LIDAR_FILTER_MIN_RANGE = getFloat64(section, "lidar_filter_min_range")
+	LIDAR_FILTER_MAX_RANGE = getFloat64(section, "lidar_filter_max_range")
+	LIDAR_FILTER_MASKS = getString(section, "lidar_filter_masks")
+	LIDAR_FILTER_MEDIAN_WINDOW = getInt(section, "lidar_filter_median_window")
+	LIDAR_FILTER_TEMPORAL_SCANS = getInt(section, "lidar_filter_temporal_scans")
+	LIDAR_FILTER_SHADOW_MIN_ANGLE = getFloat64(section, "lidar_filter_shadow_min_angle")
+	LIDAR_FILTER_SHADOW_MAX_ANGLE = getFloat64(section, "lidar_filter_shadow_max_angle")
+	LIDAR_FILTER_SHADOW_NEIGHBOURS = getInt(section, "lidar_filter_shadow_neighbours")
+	LIDAR_FILTER_MIN_INTENSITY = getFloat64(section, "lidar_filter_min_intensity")
+	LIDAR_FILTER_MAX_INTENSITY = getFloat64(section, "lidar_filter_max_intensity")
 
-	USE_ODOMETRY = getBool(section, "use_odometry")
 	ODOMETRY_COM_NAME = getString(section, "odometry_com_name")
 	ODOMETRY_BAUD_RATE = getInt(section, "odometry_baud_rate")
 
@@ -104,6 +126,15 @@ func init() {
 	HECTORSLAM_MAP_UPDATE_MIN_DIST_DIFF = getFloat64(section, "hectorslam_map_update_min_dist_diff")
 	HECTORSLAM_USE_ODOMETRY = getBool(section, "hectorslam_use_odometry")
 	HECTORSLAM_USE_LIDAR_CORRECTION = getBool(section, "hectorslam_use_lidar_correction")
+	HECTORSLAM_LOOP_CLOSURE = getBool(section, "hectorslam_loop_closure")
+
+	MCL_MIN_PARTICLES = getInt(section, "mcl_min_particles")
+	MCL_MAX_PARTICLES = getInt(section, "mcl_max_particles")
+	MCL_BEAMS = getInt(section, "mcl_beams")
+	MCL_MAP_LEVEL = getInt(section, "mcl_map_level")
+	MCL_FIELD_STD_DEV = getFloat64(section, "mcl_field_std_dev")
+	MCL_INITIAL_STD_DEV_XY = getFloat64(section, "mcl_initial_std_dev_xy")
+	MCL_INITIAL_STD_DEV_THETA = getFloat64(section, "mcl_initial_std_dev_theta")
 
 	MOTORS_COM_NAME = getString(section, "motors_com_name")
 	MOTORS_BAUD_RATE = getInt(section, "motors_baud_rate")
@@ -119,6 +150,31 @@ func init() {
 	LOOKAHEAD_P = getFloat64(section, "lookahead_p")
 	LOOKAHEAD_U = getFloat64(section, "lookahead_u")
 	LOOKAHEAD_TDELTA = getInt(section, "lookahead_tdelta")
+	LOOKAHEAD_TURN_SCALE = getFloat64(section, "lookahead_turn_scale")
+	LOOKAHEAD_STRAIGHT_ANGLE = getFloat64(section, "lookahead_straight_angle")
+
+	ROTATE_SPEED = getFloat64(section, "rotate_speed")
+	ROTATE_TOLERANCE = getFloat64(section, "rotate_tolerance")
+
+	PATH_PLANNER = getString(section, "path_planner")
+
+	PATH_FOLLOWER = getString(section, "path_follower")
+	FOLLOW_MAX_SPEED = getFloat64(section, "follow_max_speed")
+	FOLLOW_MIN_SPEED = getFloat64(section, "follow_min_speed")
+	FOLLOW_CURVATURE_GAIN = getFloat64(section, "follow_curvature_gain")
+	FOLLOW_CURVATURE_DISTANCE = getFloat64(section, "follow_curvature_distance")
+	FOLLOW_GOAL_SLOWDOWN = getFloat64(section, "follow_goal_slowdown")
+	FOLLOW_GOAL_TOLERANCE = getFloat64(section, "follow_goal_tolerance")
+
+	PUREPURSUIT_MIN_LOOKAHEAD = getFloat64(section, "purepursuit_min_lookahead")
+	PUREPURSUIT_MAX_LOOKAHEAD = getFloat64(section, "purepursuit_max_lookahead")
+	PUREPURSUIT_LOOKAHEAD_GAIN = getFloat64(section, "purepursuit_lookahead_gain")
+
+	STANLEY_GAIN = getFloat64(section, "stanley_gain")
+	STANLEY_SOFTENING = getFloat64(section, "stanley_softening")
+	STANLEY_HEADING_GAIN = getFloat64(section, "stanley_heading_gain")
+	STANLEY_WHEELBASE = getFloat64(section, "stanley_wheelbase")
+	STANLEY_MAX_STEER = getFloat64(section, "stanley_max_steer")
 
 	ASTAR_MAX_ITERATIONS = getInt(section, "astar_max_iterations")
 	ASTAR_UNKNOWN_PUNISH = getFloat64(section, "astar_unknown_punish")
@@ -126,6 +182,77 @@ func init() {
 	ASTAR_SMOOTHING_SMOOTH_WEIGHT = getFloat64(section, "astar_smoothing_smooth_weight")
 	ASTAR_CHECK_RADIUS = getFloat64(section, "astar_check_radius")
 	ASTAR_SHRINK_FACTOR = getInt(section, "astar_shrink_factor")
+
+	HYBRIDASTAR_STEP_LENGTH = getFloat64(section, "hybridastar_step_length")
+	HYBRIDASTAR_TURN_RADIUS = getFloat64(section, "hybridastar_turn_radius")
+	HYBRIDASTAR_TURNS = getInt(section, "hybridastar_turns")
+	HYBRIDASTAR_REVERSE_COST = getFloat64(section, "hybridastar_reverse_cost")
+	HYBRIDASTAR_HEADING_BINS = getInt(section, "hybridastar_heading_bins")
+	HYBRIDASTAR_GOAL_RADIUS = getFloat64(section, "hybridastar_goal_radius")
+	HYBRIDASTAR_ANALYTIC_INTERVAL = getInt(section, "hybridastar_analytic_interval")
+	HYBRIDASTAR_MAX_ITERATIONS = getInt(section, "hybridastar_max_iterations")
+	HYBRIDASTAR_MAX_TIME = getInt(section, "hybridastar_max_time")
+
+	COSTMAP_INFLATION_RADIUS = getFloat64(section, "costmap_inflation_radius")
+	COSTMAP_COST_SCALING = getFloat64(section, "costmap_cost_scaling")
+	COSTMAP_UNKNOWN_COST = getInt(section, "costmap_unknown_cost")
+	COSTMAP_WEIGHT = getFloat64(section, "costmap_weight")
+
+	REPLAN_CHECK_INTERVAL = getInt(section, "replan_check_interval")
+
+	SIMULATOR_MAP = getString(section, "simulator_map")
+	SIMULATOR_PNG_RESOLUTION = getFloat64(section, "simulator_png_resolution")
+	SIMULATOR_START_X = getFloat64(section, "simulator_start_x")
+	SIMULATOR_START_Y = getFloat64(section, "simulator_start_y")
+	SIMULATOR_START_THETA = getFloat64(section, "simulator_start_theta")
+	SIMULATOR_MAX_WHEEL_SPEED = getFloat64(section, "simulator_max_wheel_speed")
+	SIMULATOR_LIDAR_BEAMS = getInt(section, "simulator_lidar_beams")
+	SIMULATOR_LIDAR_NOISE = getFloat64(section, "simulator_lidar_noise")
+	SIMULATOR_ODOMETRY_NOISE = getFloat64(section, "simulator_odometry_noise")
+	SIMULATOR_SCAN_PERIOD = getInt(section, "simulator_scan_period")
+	SIMULATOR_SEED = getInt(section, "simulator_seed")
+
+	TELEMETRY_MAX_RATE = getFloat64(section, "telemetry_max_rate")
+	TELEMETRY_SCAN_DECIMATION = getInt(section, "telemetry_scan_decimation")
+
+	MISSION_QUEUE_FILE = ASSETS_ROOT + getString(section, "mission_queue_file")
+}
+
+// Get a string option from any section, or def if the section or the option
+// doesn't exist. Used for optional settings, e.g. per sensor.
+func GetStringDefault(section, option, def string) string {
+	value, err := ConfigFile.GetString(section, option)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Get an int option from any section, or def if it doesn't exist
+func GetIntDefault(section, option string, def int) int {
+	value, err := ConfigFile.GetInt(section, option)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Get a bool option from any section, or def if it doesn't exist
+func GetBoolDefault(section, option string, def bool) bool {
+	value, err := ConfigFile.GetBool(section, option)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Get a float64 option from any section, or def if it doesn't exist
+func GetFloat64Default(section, option string, def float64) float64 {
+	value, err := ConfigFile.GetFloat64(section, option)
+	if err != nil {
+		return def
+	}
+	return value
 }
 
 func getString(section, option string) string {
@@ -188,14 +315,38 @@ var (
 var (
 	SENSORLOGS_ROOT        string
 	SENSORLOGS_TIME_FORMAT string
-	USE_LIDAR              bool
+	SENSORLOGS_FORMAT      string
+	SENSORLOGS_COMPRESS    bool
+	SENSORS                string
 	LIDAR_COM_NAME         string
 	LIDAR_BAUD_RATE        int
 	LIDAR_NUM_DISTANCES    int
 	LIDAR_RADIAL_SPAN      float64
+	LIDAR_START_ANGLE      int
+	LIDAR_DRIVER           string
+	URG_CLUSTER            int
+	URG_ENCODING           int
+	URG_INTENSITY          bool
+	RPLIDAR_EXPRESS        bool
+	RPLIDAR_MOTOR_PWM      int
 	LIDAR_MAX_DISTANCE     float64
 	LIDAR_POSITION_X       float64
 	LIDAR_POSITION_Y       float64
+	LIDAR_YAW              float64
+	LIDAR_CLOCKWISE        bool
+	LIDAR_START_OFFSET     float64
+
+	LIDAR_FILTERS                  string
+	LIDAR_FILTER_MIN_RANGE         float64
+	LIDAR_FILTER_MAX_RANGE         float64
+	LIDAR_FILTER_MASKS             string
+	LIDAR_FILTER_MEDIAN_WINDOW     int
+	LIDAR_FILTER_TEMPORAL_SCANS    int
+	LIDAR_FILTER_SHADOW_MIN_ANGLE  float64
+	LIDAR_FILTER_SHADOW_MAX_ANGLE  float64
+	LIDAR_FILTER_SHADOW_NEIGHBOURS int
+	LIDAR_FILTER_MIN_INTENSITY     float64
+	LIDAR_FILTER_MAX_INTENSITY     float64
 )
 
 // Driving
@@ -240,6 +391,18 @@ var (
 	HECTORSLAM_MAP_UPDATE_MIN_DIST_DIFF  float64
 	HECTORSLAM_USE_ODOMETRY              bool
 	HECTORSLAM_USE_LIDAR_CORRECTION      bool
+	HECTORSLAM_LOOP_CLOSURE              bool
+)
+
+// MCL
+var (
+	MCL_MIN_PARTICLES         int
+	MCL_MAX_PARTICLES         int
+	MCL_BEAMS                 int
+	MCL_MAP_LEVEL             int
+	MCL_FIELD_STD_DEV         float64
+	MCL_INITIAL_STD_DEV_XY    float64
+	MCL_INITIAL_STD_DEV_THETA float64
 )
 
 // Motors
@@ -263,17 +426,56 @@ var (
 
 // Odometry
 var (
-	USE_ODOMETRY       bool
 	ODOMETRY_COM_NAME  string
 	ODOMETRY_BAUD_RATE int
 )
 
 // Lookahead
 var (
-	LOOKAHEAD_DISTANCE float64
-	LOOKAHEAD_P        float64
-	LOOKAHEAD_U        float64
-	LOOKAHEAD_TDELTA   int
+	LOOKAHEAD_DISTANCE       float64
+	LOOKAHEAD_P              float64
+	LOOKAHEAD_U              float64
+	LOOKAHEAD_TDELTA         int
+	LOOKAHEAD_TURN_SCALE     float64
+	LOOKAHEAD_STRAIGHT_ANGLE float64
+)
+
+// Rotation in place
+var (
+	ROTATE_SPEED     float64
+	ROTATE_TOLERANCE float64
+)
+
+// Path planning
+var (
+	PATH_PLANNER string
+)
+
+// Path following
+var (
+	PATH_FOLLOWER             string
+	FOLLOW_MAX_SPEED          float64
+	FOLLOW_MIN_SPEED          float64
+	FOLLOW_CURVATURE_GAIN     float64
+	FOLLOW_CURVATURE_DISTANCE float64
+	FOLLOW_GOAL_SLOWDOWN      float64
+	FOLLOW_GOAL_TOLERANCE     float64
+)
+
+// Pure pursuit path following
+var (
+	PUREPURSUIT_MIN_LOOKAHEAD  float64
+	PUREPURSUIT_MAX_LOOKAHEAD  float64
+	PUREPURSUIT_LOOKAHEAD_GAIN float64
+)
+
+// Stanley path following
+var (
+	STANLEY_GAIN         float64
+	STANLEY_SOFTENING    float64
+	STANLEY_HEADING_GAIN float64
+	STANLEY_WHEELBASE    float64
+	STANLEY_MAX_STEER    float64
 )
 
 // Astar pathplanning
@@ -285,3 +487,55 @@ var (
 	ASTAR_CHECK_RADIUS            float64
 	ASTAR_SHRINK_FACTOR           int
 )
+
+// Hybrid A* pathplanning
+var (
+	HYBRIDASTAR_STEP_LENGTH       float64
+	HYBRIDASTAR_TURN_RADIUS       float64
+	HYBRIDASTAR_TURNS             int
+	HYBRIDASTAR_REVERSE_COST      float64
+	HYBRIDASTAR_HEADING_BINS      int
+	HYBRIDASTAR_GOAL_RADIUS       float64
+	HYBRIDASTAR_ANALYTIC_INTERVAL int
+	HYBRIDASTAR_MAX_ITERATIONS    int
+	HYBRIDASTAR_MAX_TIME          int
+)
+
+// Costmap
+var (
+	COSTMAP_INFLATION_RADIUS float64
+	COSTMAP_COST_SCALING     float64
+	COSTMAP_UNKNOWN_COST     int
+	COSTMAP_WEIGHT           float64
+)
+
+// Replanning
+var (
+	REPLAN_CHECK_INTERVAL int
+)
+
+// Simulator
+var (
+	SIMULATOR_MAP             string
+	SIMULATOR_PNG_RESOLUTION  float64
+	SIMULATOR_START_X         float64
+	SIMULATOR_START_Y         float64
+	SIMULATOR_START_THETA     float64
+	SIMULATOR_MAX_WHEEL_SPEED float64
+	SIMULATOR_LIDAR_BEAMS     int
+	SIMULATOR_LIDAR_NOISE     float64
+	SIMULATOR_ODOMETRY_NOISE  float64
+	SIMULATOR_SCAN_PERIOD     int
+	SIMULATOR_SEED            int
+)
+
+// Telemetry
+var (
+	TELEMETRY_MAX_RATE        float64
+	TELEMETRY_SCAN_DECIMATION int
+)
+
+// Missions
+var (
+	MISSION_QUEUE_FILE string
+)
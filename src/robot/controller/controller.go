@@ -2,12 +2,22 @@ package controller
 
 import (
 	"errors"
+	"reflect"
+	"sync"
 
-	//	"robot/config"
+	"hectormapping/map/gridmap"
+
+	"robot/config"
+	"robot/maplayers"
+	"robot/mission"
 	"robot/model"
 	"robot/motor"
+	"robot/motor/peasing"
+	"robot/pathplanning/costmap"
 	"robot/sensors"
+	"robot/simulator"
 	"robot/slam"
+	"robot/telemetry"
 )
 
 // State holds all state information about the whole robot. The object pointer
@@ -17,19 +27,44 @@ type Controller struct {
 	SlamController   *slam.SlamController
 	SensorController *sensors.SensorController
 	MotorController  *motor.MotorController
+	MissionExecutor  *mission.Executor
 	Robot            model.Robot
+
+	// The costmap of the current map, with the map and keep-out shapes it
+	// was made from
+	costmapLock    sync.Mutex
+	costmap        *costmap.Costmap
+	costmapOccMap  gridmap.OccGridMap
+	costmapKeepOut []maplayers.Shape
 }
 
 // Makes an arbitrary state. Under default operation use MakeDefaultState().
-func MakeController(robot model.Robot, sensors *sensors.SensorController) *Controller {
+func MakeController(robot model.Robot, sensorController *sensors.SensorController) *Controller {
 	diffWheeledRobot := robot.(*model.DifferentialWheeledRobot)
 
-	return &Controller{
-		SlamController:   slam.MakeSlamController(),
-		MotorController:  motor.MakeMotorController(diffWheeledRobot),
+	// SLAM uses the first LIDAR and odometry
+	lidar := sensorController.GetSensorOfType(sensors.LIDAR)
+	odometry := sensorController.GetSensorOfType(sensors.ODOMETRY)
+
+	// Stream the scans of the LIDAR as telemetry
+	if lidar != nil {
+		telemetry.ForwardScans(lidar)
+	}
+
+	c := &Controller{
+		SlamController:   slam.MakeSlamController(lidar, odometry),
+		MotorController:  makeMotorController(diffWheeledRobot),
 		Robot:            robot,
-		SensorController: sensors,
+		SensorController: sensorController,
 	}
+
+	// Paths are checked and replanned in the costmap of the current map
+	c.MotorController.SetCostmapSource(c.GetCostmap)
+
+	// Missions move the robot through the controller
+	c.MissionExecutor = mission.MakeExecutor(c, config.MISSION_QUEUE_FILE)
+
+	return c
 }
 
 // Make a state from default parameters and parameters found in the config
@@ -41,30 +76,122 @@ func MakeDefaultController() *Controller {
 	return MakeController(robot, sensors)
 }
 
+// Make the motor controller. When simulated sensors are in use the motors
+// drive the robot in the same simulated world.
+func makeMotorController(robot *model.DifferentialWheeledRobot) *motor.MotorController {
+	if world := simulator.GetDefaultWorldIfMade(); world != nil {
+		return motor.MakeMotorControllerWithDriver(robot, peasing.MakePEasingMotorDriver(simulator.MakeMotor(world)))
+	}
+
+	return motor.MakeMotorController(robot)
+}
+
+// Plan a path to the goal from the current position. The heading of the goal
+// is ignored.
 func (c *Controller) PlanPath(goal [3]float64) error {
+	return c.PlanPathWithHeading(goal, 0, "")
+}
+
+// Plan a path to the goal, at the end of which the robot rotates to the
+// heading of the goal until within tolerance radians. A tolerance of 0 ignores
+// the heading. planner names the path planner, "" for the one in the config.
+func (c *Controller) PlanPathWithHeading(goal [3]float64, tolerance float64, planner string) error {
 
-	// Get map from slam
+	// Get the costmap of the map from slam
 	slam := c.SlamController.GetSlam()
 	if slam == nil {
 		return errors.New("Slam not initialized")
 	}
-	occMap := slam.GetMapRepresentation().GetGridMap(0)
+	costs, err := c.GetCostmap()
+	if err != nil {
+		return err
+	}
 
 	// Get current position
 	position := slam.GetPosition()
 
-	return c.MotorController.PlanPath(occMap, [3]float64{position.X, position.Y, position.Theta}, goal)
+	return c.MotorController.PlanPathWithHeading(costs, [3]float64{position.X, position.Y, position.Theta}, goal, tolerance, planner)
 
 }
 
-func (c *Controller) FollowPath() error {
+// Follow the planned path with the named path follower, "" for the one in the
+// config
+func (c *Controller) FollowPath(follower string) error {
 
 	slam := c.SlamController.GetSlam()
 	if slam == nil {
 		return errors.New("Slam not initialized")
 	}
 
-	c.MotorController.FollowPath(slam)
+	_, err := c.MotorController.FollowPath(slam, c.SensorController.GetSensorOfType(sensors.LIDAR), follower)
+
+	return err
+}
+
+// Plan a path to the goal and follow it with the named path follower, "" for
+// the one in the config. The returned channel gives true when the robot has
+// arrived.
+func (c *Controller) GoTo(goal [3]float64, follower string) (chan bool, error) {
+	slam := c.SlamController.GetSlam()
+	if slam == nil {
+		return nil, errors.New("Slam not initialized")
+	}
+
+	err := c.PlanPath(goal)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.MotorController.FollowPath(slam, c.SensorController.GetSensorOfType(sensors.LIDAR), follower)
+}
+
+// Rotate in place to a heading. The returned channel gives true when the
+// heading is reached.
+func (c *Controller) Rotate(theta float64) (chan bool, error) {
+	slam := c.SlamController.GetSlam()
+	if slam == nil {
+		return nil, errors.New("Slam not initialized")
+	}
+
+	return c.MotorController.Rotate(slam, theta), nil
+}
+
+// Stop following paths and rotating, and stop the motors
+func (c *Controller) Stop() {
+	c.MotorController.ManualSpeeds(0, 0)
+}
+
+// Get the pose of a waypoint of the current map
+func (c *Controller) GetWaypoint(name string) ([3]float64, error) {
+	waypoint, err := c.SlamController.GetWaypoints().Get(name)
+	if err != nil {
+		return [3]float64{}, err
+	}
+	return waypoint.GetPose(), nil
+}
+
+// Get the costmap the planners use for the current map. It's made again when
+// the map or the keep-out layer has changed since it was last made.
+func (c *Controller) GetCostmap() (*costmap.Costmap, error) {
+	slam := c.SlamController.GetSlam()
+	if slam == nil {
+		return nil, errors.New("Slam not initialized")
+	}
+	occMap := slam.GetMapRepresentation().GetGridMap(0)
+	layers := c.SlamController.GetLayers()
+	shapes := layers.GetKeepOut()
+
+	c.costmapLock.Lock()
+	defer c.costmapLock.Unlock()
+
+	if c.costmap == nil || c.costmapOccMap != occMap ||
+		c.costmap.GetUpdateIndex() != occMap.GetUpdateIndex() ||
+		!reflect.DeepEqual(c.costmapKeepOut, shapes) {
+
+		c.costmap = costmap.MakeCostmap(occMap, layers.GetKeepOutMask(occMap), config.ASTAR_SHRINK_FACTOR)
+		c.costmapOccMap = occMap
+		c.costmapKeepOut = shapes
+	}
 
-	return nil
+	return c.costmap, nil
 }
@@ -10,6 +10,7 @@ import (
 	"robot/controller"
 	"robot/logging"
 	"robot/logging/datalog"
+	"robot/telemetry"
 	"robot/web"
 )
 
@@ -35,6 +36,7 @@ func main() {
 	ws := web.MakeWebServer(controller)
 	logging.AddWriter(ws.GetLogWriter())
 	datalog.AddWriter(ws.GetDataLogWriter())
+	datalog.AddWriter(telemetry.DataWriter{})
 	go ws.Serve()
 
 	logger.Println("Ready to do awesome things.")
@@ -0,0 +1,189 @@
+// Package maplayers holds the edits operators make to a map, on top of the
+// grid map built by SLAM.
+//
+// There are two kinds of edits:
+//   - Keep-out shapes, polygons blocking off areas such as stairwells and
+//     polylines acting as virtual walls, e.g. along glass the LIDAR can't see.
+//     They are kept in the keep-out layer, which the path planners respect
+//     and scan matching ignores.
+//   - Painting, which sets the cells of a shape occupied, free or unknown in
+//     the grid map itself, e.g. to erase ghost obstacles left by people.
+package maplayers
+
+import (
+	"errors"
+	"sync"
+
+	"hectormapping/map/gridmap"
+	"hectormapping/map/maprep"
+)
+
+// Shape types
+const (
+	POLYGON  = "polygon"
+	POLYLINE = "polyline"
+)
+
+// Values cells can be painted with
+const (
+	OCCUPIED = "occupied"
+	FREE     = "free"
+	UNKNOWN  = "unknown"
+)
+
+// Log odds given to painted cells, as certain as cells updated a few times by
+// scans
+const PAINT_LOG_ODDS = 2.0
+
+// A Shape is a polygon or a polyline in world coordinates. A polyline covers
+// the cells within Width/2 of it, and at least a line of connected cells.
+type Shape struct {
+	ID     int          `json:"id"`
+	Type   string       `json:"type"`
+	Points [][2]float64 `json:"points"`
+	Width  float64      `json:"width"`
+}
+
+// Layers are the edits of a map which are kept apart from the grid map
+type Layers struct {
+	lock sync.Mutex
+
+	KeepOut []Shape
+	NextID  int
+}
+
+func MakeLayers() *Layers {
+	return &Layers{
+		KeepOut: make([]Shape, 0),
+		NextID:  1,
+	}
+}
+
+// Check that a shape has enough points for its type
+func (s Shape) Validate() error {
+	switch s.Type {
+	case POLYGON:
+		if len(s.Points) < 3 {
+			return errors.New("A polygon needs at least 3 points.")
+		}
+	case POLYLINE:
+		if len(s.Points) < 2 {
+			return errors.New("A polyline needs at least 2 points.")
+		}
+		if s.Width < 0 {
+			return errors.New("Invalid polyline width.")
+		}
+	default:
+		return errors.New("No such shape type.")
+	}
+	return nil
+}
+
+// Add a shape to the keep-out layer, returning its id
+func (l *Layers) AddKeepOut(shape Shape) (int, error) {
+	if err := shape.Validate(); err != nil {
+		return 0, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	shape.ID = l.NextID
+	l.NextID++
+	l.KeepOut = append(l.KeepOut, shape)
+
+	return shape.ID, nil
+}
+
+// Remove a shape from the keep-out layer
+func (l *Layers) RemoveKeepOut(id int) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i := range l.KeepOut {
+		if l.KeepOut[i].ID == id {
+			l.KeepOut = append(l.KeepOut[:i], l.KeepOut[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("No such keep-out shape.")
+}
+
+// Get the shapes of the keep-out layer
+func (l *Layers) GetKeepOut() []Shape {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	shapes := make([]Shape, len(l.KeepOut))
+	copy(shapes, l.KeepOut)
+	return shapes
+}
+
+// Get the cells of a grid map covered by the keep-out layer
+func (l *Layers) GetKeepOutMask(gridMap gridmap.GridMap) *Mask {
+	mask := MakeMask(gridMap.GetSizeX(), gridMap.GetSizeY())
+	for _, shape := range l.GetKeepOut() {
+		forEachCell(gridMap, shape, mask.Set)
+	}
+	return mask
+}
+
+// Paint the cells of a shape in all levels of a map representation with a
+// value: OCCUPIED, FREE or UNKNOWN
+func Paint(mapRep maprep.MapRepresentation, shape Shape, value string) error {
+	if err := shape.Validate(); err != nil {
+		return err
+	}
+
+	var logOdds float64
+	switch value {
+	case OCCUPIED:
+		logOdds = PAINT_LOG_ODDS
+	case FREE:
+		logOdds = -PAINT_LOG_ODDS
+	case UNKNOWN:
+		logOdds = 0
+	default:
+		return errors.New("No such cell value.")
+	}
+
+	for level := 0; level < mapRep.GetMapLevels(); level++ {
+		gridMap := mapRep.GetGridMap(level)
+		forEachCell(gridMap, shape, func(x, y int) {
+			gridMap.GetCell(x, y).Set(logOdds)
+		})
+		gridMap.SetUpdated()
+	}
+	mapRep.OnMapUpdated()
+
+	return nil
+}
+
+// A Mask marks cells of a grid map
+type Mask struct {
+	sizeX, sizeY int
+	cells        []bool
+}
+
+func MakeMask(sizeX, sizeY int) *Mask {
+	return &Mask{
+		sizeX: sizeX,
+		sizeY: sizeY,
+		cells: make([]bool, sizeX*sizeY),
+	}
+}
+
+// Mark a cell
+func (m *Mask) Set(x, y int) {
+	if x >= 0 && y >= 0 && x < m.sizeX && y < m.sizeY {
+		m.cells[y*m.sizeX+x] = true
+	}
+}
+
+// Determine if a cell is marked. Nothing is marked in a nil mask.
+func (m *Mask) IsSet(x, y int) bool {
+	if m == nil || x < 0 || y < 0 || x >= m.sizeX || y >= m.sizeY {
+		return false
+	}
+	return m.cells[y*m.sizeX+x]
+}
@@ -0,0 +1,139 @@
+package maplayers
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"hectormapping/map/maprep"
+)
+
+// Cells of 0.1 m, with world (0, 0) at cell (0, 0)
+func makeMapRep() *maprep.MapRepMultiMap {
+	return maprep.MakeMapRepMultiMap(0.1, 100, 100, 2, [2]float64{0, 0})
+}
+
+func TestKeepOutMask(t *testing.T) {
+	mapRep := makeMapRep()
+	gridMap := mapRep.GetGridMap(0)
+
+	l := MakeLayers()
+	zone, err := l.AddKeepOut(Shape{Type: POLYGON, Points: [][2]float64{{1, 1}, {2, 1}, {2, 2}, {1, 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.AddKeepOut(Shape{Type: POLYLINE, Points: [][2]float64{{5, 0}, {5, 3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mask := l.GetKeepOutMask(gridMap)
+	if !mask.IsSet(15, 15) || mask.IsSet(25, 15) || mask.IsSet(15, 5) {
+		t.Error("Polygon covers the wrong cells")
+	}
+
+	// A thin wall is still a line of connected cells
+	for y := 0; y < 30; y++ {
+		if !mask.IsSet(49, y) && !mask.IsSet(50, y) {
+			t.Errorf("Wall has a gap at y = %d", y)
+		}
+	}
+	if mask.IsSet(52, 10) || mask.IsSet(50, 35) {
+		t.Error("Wall is too wide or too long")
+	}
+
+	if err := l.RemoveKeepOut(zone); err != nil {
+		t.Error(err)
+	}
+	if l.GetKeepOutMask(gridMap).IsSet(15, 15) {
+		t.Error("Removed polygon is still kept out")
+	}
+	if _, err := l.AddKeepOut(Shape{Type: POLYGON, Points: [][2]float64{{0, 0}, {1, 1}}}); err == nil {
+		t.Error("Polygon with 2 points was accepted")
+	}
+
+	// Scan matching uses the grid map, which is left alone
+	if gridMap.GetCell(50, 10).IsOccupied() {
+		t.Error("Keep-out changed the grid map")
+	}
+}
+
+func TestPaint(t *testing.T) {
+	mapRep := makeMapRep()
+	ghost := Shape{Type: POLYGON, Points: [][2]float64{{1, 1}, {3, 1}, {3, 3}, {1, 3}}}
+
+	err := Paint(mapRep, Shape{Type: POLYLINE, Points: [][2]float64{{0, 2}, {4, 2}}, Width: 0.2}, OCCUPIED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for level := 0; level < mapRep.GetMapLevels(); level++ {
+		gridMap := mapRep.GetGridMap(level)
+		cell := gridMap.GetMapCoords([2]float64{2, 2})
+		if !gridMap.GetCell(int(cell[0]), int(cell[1])).IsOccupied() {
+			t.Errorf("Painted wall is missing at level %d", level)
+		}
+	}
+
+	if err := Paint(mapRep, ghost, FREE); err != nil {
+		t.Fatal(err)
+	}
+	if !mapRep.GetGridMap(0).GetCell(20, 20).IsFree() || mapRep.GetGridMap(0).GetCell(35, 20).IsFree() {
+		t.Error("Erasing painted the wrong cells")
+	}
+
+	if err := Paint(mapRep, ghost, "purple"); err == nil {
+		t.Error("Painting with an invalid value was accepted")
+	}
+}
+
+func TestPaintCell(t *testing.T) {
+	mapRep := makeMapRep()
+
+	// A square smaller than a cell, around the center of the cell at (2, 1)
+	square := Shape{Type: POLYGON, Points: [][2]float64{{1.96, 0.96}, {2.04, 0.96}, {2.04, 1.04}, {1.96, 1.04}}}
+	if err := Paint(mapRep, square, OCCUPIED); err != nil {
+		t.Fatal(err)
+	}
+
+	for level := 0; level < mapRep.GetMapLevels(); level++ {
+		gridMap := mapRep.GetGridMap(level)
+		cell := gridMap.GetMapCoords([2]float64{2, 1})
+		x, y := int(cell[0]+0.5), int(cell[1]+0.5)
+		if !gridMap.GetCell(x, y).IsOccupied() {
+			t.Errorf("Cell (%d, %d) isn't painted at level %d", x, y, level)
+		}
+
+		painted := 0
+		for j := 0; j < gridMap.GetSizeY(); j++ {
+			for i := 0; i < gridMap.GetSizeX(); i++ {
+				if gridMap.GetCell(i, j).IsOccupied() {
+					painted++
+				}
+			}
+		}
+		if painted != 1 {
+			t.Errorf("Painted %d cells at level %d, want 1", painted, level)
+		}
+	}
+}
+
+func TestEncodeLayers(t *testing.T) {
+	l := MakeLayers()
+	l.AddKeepOut(Shape{Type: POLYLINE, Points: [][2]float64{{0, 0}, {1, 0}}, Width: 0.3})
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(l); err != nil {
+		t.Fatal(err)
+	}
+	var decoded *Layers
+	if err := gob.NewDecoder(buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// New shapes get new ids
+	id, _ := decoded.AddKeepOut(Shape{Type: POLYLINE, Points: [][2]float64{{0, 1}, {1, 1}}})
+	shapes := decoded.GetKeepOut()
+	if len(shapes) != 2 || shapes[0].Width != 0.3 || id == shapes[0].ID {
+		t.Errorf("Got shapes %+v", shapes)
+	}
+}
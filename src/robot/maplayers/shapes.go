@@ -0,0 +1,96 @@
+package maplayers
+
+import (
+	"math"
+
+	"hectormapping/map/gridmap"
+
+	"robot/tools/intmath"
+)
+
+// Call f with the coordinates of each cell of a grid map whose center is
+// covered by a shape
+func forEachCell(gridMap gridmap.GridMap, shape Shape, f func(x, y int)) {
+	if len(shape.Points) == 0 {
+		return
+	}
+
+	// The shape in map coordinates, in which cell (x, y) has its center at
+	// (x, y), as map coordinates are rounded to cells
+	points := make([][2]float64, len(shape.Points))
+	for i, p := range shape.Points {
+		points[i] = gridMap.GetMapCoords(p)
+	}
+
+	// Polylines are at least a line of connected cells wide
+	halfWidth := math.Max(shape.Width/2*gridMap.GetScaleToMap(), math.Sqrt2/2)
+	margin := 0.0
+	if shape.Type == POLYLINE {
+		margin = halfWidth
+	}
+
+	// The cells within the bounding box
+	minX, minY := points[0][0], points[0][1]
+	maxX, maxY := minX, minY
+	for _, p := range points[1:] {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	x0 := intmath.Max(int(math.Floor(minX-margin)), 0)
+	y0 := intmath.Max(int(math.Floor(minY-margin)), 0)
+	x1 := intmath.Min(int(math.Ceil(maxX+margin)), gridMap.GetSizeX()-1)
+	y1 := intmath.Min(int(math.Ceil(maxY+margin)), gridMap.GetSizeY()-1)
+
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			center := [2]float64{float64(x), float64(y)}
+
+			var covered bool
+			if shape.Type == POLYGON {
+				covered = insidePolygon(points, center)
+			} else {
+				covered = distanceToPolyline(points, center) <= halfWidth
+			}
+
+			if covered {
+				f(x, y)
+			}
+		}
+	}
+}
+
+// Determine if a point is inside a polygon, by the even-odd rule
+func insidePolygon(polygon [][2]float64, p [2]float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Get the distance from a point to the closest segment of a polyline
+func distanceToPolyline(polyline [][2]float64, p [2]float64) float64 {
+	distance := math.Inf(1)
+	for i := 1; i < len(polyline); i++ {
+		distance = math.Min(distance, distanceToSegment(polyline[i-1], polyline[i], p))
+	}
+	return distance
+}
+
+// Get the distance from a point to the line segment from a to b
+func distanceToSegment(a, b, p [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	lengthSquared := dx*dx + dy*dy
+
+	t := 0.0
+	if lengthSquared > 0 {
+		t = ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / lengthSquared
+		t = math.Max(0, math.Min(1, t))
+	}
+
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
@@ -18,5 +18,8 @@ type MapMetaData struct {
 	// Map type string
 	MapType string
 
-	// @TODO: Add position history, landmarks, pictures, +++
+	// The position history, map layers and waypoints are saved next to the
+	// meta data, see Map
+
+	// @TODO: Add pictures, +++
 }
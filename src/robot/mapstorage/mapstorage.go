@@ -4,6 +4,9 @@
 //  - Grid map data
 //  - Meta data
 //  - Map thumbnail
+//  - Trajectory of the robot while mapping, if recorded
+//  - Map layers, the edits of the map, if any
+//  - Waypoints in the map, if any
 //
 // These are saved together and can later be loaded.
 package mapstorage
@@ -24,17 +27,26 @@ import (
 	"hectormapping/map/maprep"
 
 	"robot/config"
+	"robot/maplayers"
+	"robot/slam/trajectory"
+	"robot/waypoints"
 )
 
 const MAP_FILE_EXTENSION = ".tigermap"
 const MAPREP_SUBFILE_NAME = "map"
 const MAPDATA_SUBFILE_NAME = "meta"
 const THUMBNAIL_SUBFILE_NAME = "thumb.png"
+const TRAJECTORY_SUBFILE_NAME = "trajectory"
+const LAYERS_SUBFILE_NAME = "layers"
+const WAYPOINTS_SUBFILE_NAME = "waypoints"
 
 // A Map is a grid map in the expanded sense, that is, complete with meta data.
 type Map struct {
-	Meta   *MapMetaData
-	MapRep maprep.MapRepresentation
+	Meta       *MapMetaData
+	MapRep     maprep.MapRepresentation
+	Trajectory []trajectory.Pose
+	Layers     *maplayers.Layers
+	Waypoints  *waypoints.Waypoints
 }
 
 // Return a map with filenames as keys and meta data as descriptions.
@@ -123,6 +135,15 @@ func (m *Map) Save(filename string) error {
 	m.saveMapDataToArchive(archive)
 	m.saveMapRepToArchive(archive)
 	m.saveThumbnailToArchive(archive)
+	if len(m.Trajectory) > 0 {
+		m.saveTrajectoryToArchive(archive)
+	}
+	if m.Layers != nil {
+		m.saveLayersToArchive(archive)
+	}
+	if m.Waypoints != nil {
+		m.saveWaypointsToArchive(archive)
+	}
 	// Debug: fmt.Println("Saved entire map") //but an error prevents the map data being saved *********************
 
 	// Close the archive
@@ -169,8 +190,12 @@ func (m *Map) saveMapRepToArchive(archive *zip.Writer) error {
 	return nil
 }
 
-// Load a complete Map object from file
+// Load a complete Map object from file. A map_server YAML file is imported,
+// see ImportROS.
 func Load(filename string) (*Map, error) {
+	if path.Ext(filename) == ROS_YAML_EXTENSION {
+		return ImportROS(filename)
+	}
 
 	m := &Map{}
 
@@ -199,6 +224,21 @@ func Load(filename string) (*Map, error) {
 			if err != nil {
 				return nil, err
 			}
+		case TRAJECTORY_SUBFILE_NAME:
+			err := m.loadTrajectoryFromFile(f)
+			if err != nil {
+				return nil, err
+			}
+		case LAYERS_SUBFILE_NAME:
+			err := m.loadLayersFromFile(f)
+			if err != nil {
+				return nil, err
+			}
+		case WAYPOINTS_SUBFILE_NAME:
+			err := m.loadWaypointsFromFile(f)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -241,6 +281,9 @@ func Copy(filename, newName string) error {
 	// Transfer map and thumbnail
 	moveSubFile(oldArchive, newArchive, MAPREP_SUBFILE_NAME)
 	moveSubFile(oldArchive, newArchive, THUMBNAIL_SUBFILE_NAME)
+	moveSubFile(oldArchive, newArchive, TRAJECTORY_SUBFILE_NAME)
+	moveSubFile(oldArchive, newArchive, LAYERS_SUBFILE_NAME)
+	moveSubFile(oldArchive, newArchive, WAYPOINTS_SUBFILE_NAME)
 
 	// Load curernt MapMetaData
 	mmd, err := LoadMapMetaData(filename)
@@ -438,6 +481,102 @@ func (m *Map) saveThumbnailToArchive(archive *zip.Writer) error {
 	return nil
 }
 
+// Create the trajectory file in the archive and write the poses to it.
+func (m *Map) saveTrajectoryToArchive(archive *zip.Writer) error {
+
+	// Create the trajectory file in the archive
+	trajectoryfile, err := archive.Create(TRAJECTORY_SUBFILE_NAME)
+	if err != nil {
+		return err
+	}
+
+	// Encode the poses
+	err = gob.NewEncoder(trajectoryfile).Encode(m.Trajectory)
+	if err != nil {
+		fmt.Printf("An error occured in saveTrajectoryToArchive:\n%v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Load the trajectory file in the archive
+func (m *Map) loadTrajectoryFromFile(file *zip.File) error {
+
+	// Open the file in the archive
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return gob.NewDecoder(rc).Decode(&m.Trajectory)
+}
+
+// Create the layers file in the archive and write the layers to it.
+func (m *Map) saveLayersToArchive(archive *zip.Writer) error {
+
+	// Create the layers file in the archive
+	layersfile, err := archive.Create(LAYERS_SUBFILE_NAME)
+	if err != nil {
+		return err
+	}
+
+	// Encode the layers
+	err = gob.NewEncoder(layersfile).Encode(m.Layers)
+	if err != nil {
+		fmt.Printf("An error occured in saveLayersToArchive:\n%v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Load the layers file in the archive
+func (m *Map) loadLayersFromFile(file *zip.File) error {
+
+	// Open the file in the archive
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return gob.NewDecoder(rc).Decode(&m.Layers)
+}
+
+// Create the waypoints file in the archive and write the waypoints to it.
+func (m *Map) saveWaypointsToArchive(archive *zip.Writer) error {
+
+	// Create the waypoints file in the archive
+	waypointsfile, err := archive.Create(WAYPOINTS_SUBFILE_NAME)
+	if err != nil {
+		return err
+	}
+
+	// Encode the waypoints
+	err = gob.NewEncoder(waypointsfile).Encode(m.Waypoints)
+	if err != nil {
+		fmt.Printf("An error occured in saveWaypointsToArchive:\n%v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Load the waypoints file in the archive
+func (m *Map) loadWaypointsFromFile(file *zip.File) error {
+
+	// Open the file in the archive
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return gob.NewDecoder(rc).Decode(&m.Waypoints)
+}
+
 // Load the map data file in the archive
 func (m *Map) loadMapDataFromFile(file *zip.File) error {
 
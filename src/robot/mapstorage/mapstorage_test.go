@@ -6,14 +6,35 @@ import (
 	"fmt"
 	"image/png"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"testing"
 
 	"hectormapping/map/gridmap/logoddsmap"
 	"hectormapping/map/mapimages"
 	"hectormapping/map/maprep"
+
+	"robot/maplayers"
+	"robot/waypoints"
 )
 
+// Directory the maps saved by the tests are written to, removed afterwards
+var testOutput string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "mapstorage")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	testOutput = dir
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestSaveMultiMap(t *testing.T) {
 
 	mapRep := maprep.MakeMapRepMultiMap(0.025, 1024, 1024, 3, [2]float64{0, 0})
@@ -28,7 +49,7 @@ func TestSaveMultiMap(t *testing.T) {
 
 	t.Logf("Number of map levels in saved maprep: %d", m.MapRep.GetMapLevels())
 
-	err := m.Save("testoutput\\multimap")
+	err := m.Save(path.Join(testOutput, "multimap"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -36,7 +57,7 @@ func TestSaveMultiMap(t *testing.T) {
 
 func TestLoadMultiMap(t *testing.T) {
 
-	m, err := Load("testoutput\\multimap")
+	m, err := Load(path.Join(testOutput, "multimap"))
 	if err != nil && err != io.EOF {
 		t.Error(err)
 	}
@@ -61,7 +82,7 @@ func TestSaveSingleMap(t *testing.T) {
 		MapRep: mapRep,
 	}
 
-	err := m.Save("testoutput\\singlemap")
+	err := m.Save(path.Join(testOutput, "singlemap"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -69,7 +90,7 @@ func TestSaveSingleMap(t *testing.T) {
 
 func TestLoadSingleMap(t *testing.T) {
 
-	m, err := Load("testoutput\\singlemap")
+	m, err := Load(path.Join(testOutput, "singlemap"))
 	if err != nil && err != io.EOF {
 		t.Error(err)
 	}
@@ -115,7 +136,7 @@ func TestLoadMakeFullImages(t *testing.T) {
 		}
 
 		// Save the image
-		file, err := os.Create("testoutput\\" + filename + ".png")
+		file, err := os.Create(path.Join(testOutput, filename+".png"))
 		defer file.Close()
 		if err != nil {
 			t.Error(err)
@@ -128,7 +149,7 @@ func TestLoadMakeFullImages(t *testing.T) {
 
 func TestLoadMeta(t *testing.T) {
 
-	meta, err := LoadMapMetaData("testoutput\\singlemap")
+	meta, err := LoadMapMetaData(path.Join(testOutput, "singlemap"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -143,7 +164,7 @@ func TestLoadMeta(t *testing.T) {
 
 func TestLoadThumbnail(t *testing.T) {
 
-	thumb, err := LoadMapThumbnail("testoutput\\singlemap")
+	thumb, err := LoadMapThumbnail(path.Join(testOutput, "singlemap"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -205,3 +226,39 @@ func TestGetMaps(t *testing.T) {
 	}
 
 }
+
+func TestSaveWaypointsAndLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mapstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &Map{
+		Meta:      &MapMetaData{Name: "Office"},
+		MapRep:    maprep.MakeMapRepMultiMap(0.05, 64, 64, 2, [2]float64{0.5, 0.5}),
+		Layers:    maplayers.MakeLayers(),
+		Waypoints: waypoints.MakeWaypoints(),
+	}
+	m.Layers.AddKeepOut(maplayers.Shape{Type: maplayers.POLYLINE, Points: [][2]float64{{0, 0}, {1, 0}}})
+	m.Waypoints.Add(waypoints.Waypoint{Name: "dock", X: 1, Y: -1, Tags: []string{"charging"}})
+
+	filename := path.Join(dir, "office")
+	if err := m.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Layers == nil || len(loaded.Layers.GetKeepOut()) != 1 {
+		t.Error("Map layers were not loaded")
+	}
+	if loaded.Waypoints == nil {
+		t.Fatal("Waypoints were not loaded")
+	}
+	if dock, err := loaded.Waypoints.Get("dock"); err != nil || dock.Y != -1 || !dock.HasTag("charging") {
+		t.Errorf("Got dock %+v, %v", dock, err)
+	}
+}
@@ -0,0 +1,472 @@
+package mapstorage
+
+// Export and import of maps in the format of the ROS map_server: an image,
+// normally a PGM, and a YAML file describing it. See
+// http://wiki.ros.org/map_server for the format.
+//
+// Only level 0 of a map representation is exported. On import, the coarser
+// levels are made from level 0, as HectorSLAM needs all of them.
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"hectormapping/map/gridmap"
+	"hectormapping/map/maprep"
+
+	"robot/config"
+)
+
+const ROS_YAML_EXTENSION = ".yaml"
+const ROS_IMAGE_EXTENSION = ".pgm"
+
+// Pixel values of exported maps, as written by the ROS map_saver
+const (
+	ROS_OCCUPIED_PIXEL = 0
+	ROS_FREE_PIXEL     = 254
+	ROS_UNKNOWN_PIXEL  = 205
+)
+
+// Log odds given to the occupied and free cells of imported maps. The sign is
+// what matters to the SLAM algorithms, the magnitude is that of a cell
+// updated a few times.
+const ROS_CELL_LOG_ODDS = 2.0
+
+// The contents of a map_server YAML file
+type ROSMapMetaData struct {
+	// Image file, relative to the YAML file
+	Image      string
+	Resolution float64
+	// The world pose of the lower left pixel: x, y and yaw
+	Origin [3]float64
+	// If set, white is occupied and black is free
+	Negate         bool
+	OccupiedThresh float64
+	FreeThresh     float64
+	// trinary, scale or raw. Raw maps aren't supported.
+	Mode string
+}
+
+// Get the file path of a ROS map file. Like getFilePath, the map storage root
+// is used if no folder is specified, and the extension is replaced by ext.
+func getROSFilePath(filename, ext string) string {
+	if path.Dir(filename) == "." {
+		filename = config.MAP_STORAGE_ROOT + filename
+	}
+	return strings.TrimSuffix(filename, path.Ext(filename)) + ext
+}
+
+// Export level 0 of a map representation to a PGM image and a YAML file,
+// named by filename with the extensions replaced
+func ExportROS(mapRep maprep.MapRepresentation, filename string) error {
+	yamlPath := getROSFilePath(filename, ROS_YAML_EXTENSION)
+	imagePath := getROSFilePath(filename, ROS_IMAGE_EXTENSION)
+
+	imageFile, err := os.Create(imagePath)
+	if err != nil {
+		return err
+	}
+	defer imageFile.Close()
+
+	err = EncodeROSImage(imageFile, mapRep)
+	if err != nil {
+		return err
+	}
+
+	yamlFile, err := os.Create(yamlPath)
+	if err != nil {
+		return err
+	}
+	defer yamlFile.Close()
+
+	return EncodeROSYAML(yamlFile, mapRep, path.Base(imagePath))
+}
+
+// Export level 0 of a map representation to a ZIP archive holding the image
+// and the YAML file, named by name
+func ExportROSArchive(w io.Writer, mapRep maprep.MapRepresentation, name string) error {
+	archive := zip.NewWriter(w)
+
+	imagefile, err := archive.Create(name + ROS_IMAGE_EXTENSION)
+	if err != nil {
+		return err
+	}
+	err = EncodeROSImage(imagefile, mapRep)
+	if err != nil {
+		return err
+	}
+
+	yamlfile, err := archive.Create(name + ROS_YAML_EXTENSION)
+	if err != nil {
+		return err
+	}
+	err = EncodeROSYAML(yamlfile, mapRep, name+ROS_IMAGE_EXTENSION)
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// Write level 0 of a map representation as a binary PGM. The top row of the
+// image is the top row of the map.
+func EncodeROSImage(w io.Writer, mapRep maprep.MapRepresentation) error {
+	gridMap := mapRep.GetGridMap(0)
+	sizeX, sizeY := gridMap.GetSizeX(), gridMap.GetSizeY()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P5\n# CREATOR: tigerslam %.3f m/pix\n%d %d\n255\n", gridMap.GetCellLength(), sizeX, sizeY)
+
+	for y := sizeY - 1; y >= 0; y-- {
+		for x := 0; x < sizeX; x++ {
+			cell := gridMap.GetCell(x, y)
+			if cell.IsOccupied() {
+				bw.WriteByte(ROS_OCCUPIED_PIXEL)
+			} else if cell.IsFree() {
+				bw.WriteByte(ROS_FREE_PIXEL)
+			} else {
+				bw.WriteByte(ROS_UNKNOWN_PIXEL)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Write the YAML file describing the image of level 0 of a map representation
+func EncodeROSYAML(w io.Writer, mapRep maprep.MapRepresentation, imageName string) error {
+	gridMap := mapRep.GetGridMap(0)
+	origin := gridMap.GetWorldCoords([2]float64{0, 0})
+
+	_, err := fmt.Fprintf(w, "image: %s\nresolution: %f\norigin: [%f, %f, %f]\nnegate: 0\noccupied_thresh: 0.65\nfree_thresh: 0.196\n",
+		imageName, gridMap.GetCellLength(), origin[0], origin[1], 0.0)
+	return err
+}
+
+// Import a map from a map_server YAML file and the image it refers to, which
+// must be in the directory of the YAML file or below it. The map gets
+// config.HECTORSLAM_LEVELS levels, and is padded at the top and right if its
+// size isn't divisible by the scale of the coarsest level.
+func ImportROS(filename string) (*Map, error) {
+	yamlPath := getROSFilePath(filename, ROS_YAML_EXTENSION)
+
+	yamlFile, err := os.Open(yamlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer yamlFile.Close()
+
+	meta, err := DecodeROSYAML(yamlFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The image must be next to the YAML file, or below it
+	imagePath := path.Clean(meta.Image)
+	if path.IsAbs(imagePath) || imagePath == ".." || strings.HasPrefix(imagePath, "../") {
+		return nil, errors.New("The image of a ROS map must be in the directory of its YAML file: " + meta.Image)
+	}
+	imagePath = path.Join(path.Dir(yamlPath), imagePath)
+	imageFile, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer imageFile.Close()
+
+	var im image.Image
+	if path.Ext(imagePath) == ROS_IMAGE_EXTENSION {
+		im, err = DecodePGM(imageFile)
+	} else {
+		im, _, err = image.Decode(imageFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mapRep, err := MakeMapRepFromROS(meta, im, config.HECTORSLAM_LEVELS)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(path.Base(yamlPath), ROS_YAML_EXTENSION)
+	return &Map{
+		Meta: &MapMetaData{
+			Name:        name,
+			Description: "Imported from " + path.Base(yamlPath),
+			MapType:     "logodds",
+			Mdp:         mapRep.GetGridMap(0).GetMapDimProperties(),
+		},
+		MapRep: mapRep,
+	}, nil
+}
+
+// Make a map representation with the given number of levels from a
+// map_server image and its meta data
+func MakeMapRepFromROS(meta *ROSMapMetaData, im image.Image, levels int) (*maprep.MapRepMultiMap, error) {
+	if meta.Resolution <= 0 {
+		return nil, errors.New("Invalid resolution.")
+	}
+	if meta.Origin[2] != 0 {
+		return nil, errors.New("Rotated map origins are not supported.")
+	}
+	if meta.Mode == "raw" {
+		return nil, errors.New("Raw maps are not supported.")
+	}
+	if levels < 1 {
+		levels = 1
+	}
+
+	// Each level has half the size of the previous
+	bounds := im.Bounds()
+	scale := 1 << uint(levels-1)
+	sizeX := (bounds.Dx() + scale - 1) / scale * scale
+	sizeY := (bounds.Dy() + scale - 1) / scale * scale
+
+	// The origin is the corner of cell (0, 0)
+	startCoords := [2]float64{
+		-meta.Origin[0] / (meta.Resolution * float64(sizeX)),
+		-meta.Origin[1] / (meta.Resolution * float64(sizeY)),
+	}
+	mapRep := maprep.MakeMapRepMultiMap(meta.Resolution, sizeX, sizeY, levels, startCoords)
+
+	gridMap := mapRep.GetGridMap(0)
+	for j := bounds.Min.Y; j < bounds.Max.Y; j++ {
+		y := bounds.Max.Y - 1 - j
+		for i := bounds.Min.X; i < bounds.Max.X; i++ {
+			x := i - bounds.Min.X
+			gray := color.Gray16Model.Convert(im.At(i, j)).(color.Gray16)
+			gridMap.GetCell(x, y).Set(rosLogOdds(meta, float64(gray.Y)/0xffff))
+		}
+	}
+
+	for level := 1; level < levels; level++ {
+		downsample(mapRep.GetGridMap(level-1), mapRep.GetGridMap(level))
+	}
+
+	return mapRep, nil
+}
+
+// Get the log odds of a cell from the brightness of its pixel, in [0, 1]
+func rosLogOdds(meta *ROSMapMetaData, brightness float64) float64 {
+	p := 1 - brightness
+	if meta.Negate {
+		p = brightness
+	}
+
+	if p > meta.OccupiedThresh {
+		return ROS_CELL_LOG_ODDS
+	} else if p < meta.FreeThresh {
+		return -ROS_CELL_LOG_ODDS
+	} else if meta.Mode == "scale" {
+		logOdds := math.Log(p / (1 - p))
+		return math.Max(-ROS_CELL_LOG_ODDS, math.Min(logOdds, ROS_CELL_LOG_ODDS))
+	}
+	return 0
+}
+
+// Fill in a map from one with twice its resolution. A cell is occupied if any
+// of the cells it covers are, else free if any of them are.
+func downsample(fine, coarse gridmap.OccGridMap) {
+	for y := 0; y < coarse.GetSizeY(); y++ {
+		for x := 0; x < coarse.GetSizeX(); x++ {
+			maxValue, minValue := 0.0, 0.0
+			for _, c := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				fx, fy := 2*x+c[0], 2*y+c[1]
+				if fine.HasGridValue(fx, fy) {
+					v := fine.GetCell(fx, fy).GetValue()
+					maxValue = math.Max(maxValue, v)
+					minValue = math.Min(minValue, v)
+				}
+			}
+
+			if maxValue > 0 {
+				coarse.GetCell(x, y).Set(maxValue)
+			} else {
+				coarse.GetCell(x, y).Set(minValue)
+			}
+		}
+	}
+}
+
+// Read a map_server YAML file. Only the flat key: value form map_server uses
+// is understood.
+func DecodeROSYAML(r io.Reader) (*ROSMapMetaData, error) {
+	meta := &ROSMapMetaData{
+		OccupiedThresh: 0.65,
+		FreeThresh:     0.196,
+		Mode:           "trinary",
+	}
+
+	found := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, errors.New("Invalid line in map YAML: " + line)
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), "\"'")
+
+		var err error
+		switch key {
+		case "image":
+			meta.Image = value
+		case "resolution":
+			meta.Resolution, err = strconv.ParseFloat(value, 64)
+		case "origin":
+			meta.Origin, err = parseROSOrigin(value)
+		case "negate":
+			meta.Negate = value == "1" || value == "true"
+		case "occupied_thresh":
+			meta.OccupiedThresh, err = strconv.ParseFloat(value, 64)
+		case "free_thresh":
+			meta.FreeThresh, err = strconv.ParseFloat(value, 64)
+		case "mode":
+			meta.Mode = value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s in map YAML: %v", key, err)
+		}
+		found[key] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"image", "resolution", "origin"} {
+		if !found[key] {
+			return nil, errors.New("Map YAML has no " + key + ".")
+		}
+	}
+
+	return meta, nil
+}
+
+// Parse an origin such as [-10.0, -10.0, 0.0]
+func parseROSOrigin(value string) ([3]float64, error) {
+	var origin [3]float64
+
+	fields := strings.Split(strings.Trim(value, "[] "), ",")
+	if len(fields) != 3 {
+		return origin, errors.New("The origin must have x, y and yaw.")
+	}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return origin, err
+		}
+		origin[i] = v
+	}
+	return origin, nil
+}
+
+// Read a PGM image, binary (P5) or plain (P2)
+func DecodePGM(r io.Reader) (*image.Gray16, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := readPGMToken(br)
+	if err != nil {
+		return nil, err
+	}
+	if magic != "P5" && magic != "P2" {
+		return nil, errors.New("Not a PGM image.")
+	}
+
+	var header [3]int
+	for i := range header {
+		token, err := readPGMToken(br)
+		if err != nil {
+			return nil, err
+		}
+		header[i], err = strconv.Atoi(token)
+		if err != nil || header[i] <= 0 {
+			return nil, errors.New("Invalid PGM header.")
+		}
+	}
+	width, height, maxVal := header[0], header[1], header[2]
+	if maxVal > 0xffff {
+		return nil, errors.New("Invalid PGM header.")
+	}
+
+	im := image.NewGray16(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var v int
+			if magic == "P2" {
+				token, err := readPGMToken(br)
+				if err != nil {
+					return nil, err
+				}
+				v, err = strconv.Atoi(token)
+				if err != nil {
+					return nil, errors.New("Invalid PGM pixel.")
+				}
+			} else if maxVal < 256 {
+				b, err := br.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				v = int(b)
+			} else {
+				var b [2]byte
+				if _, err := io.ReadFull(br, b[:]); err != nil {
+					return nil, err
+				}
+				v = int(b[0])<<8 | int(b[1])
+			}
+			if v > maxVal {
+				v = maxVal
+			}
+			im.SetGray16(x, y, color.Gray16{uint16(v * 0xffff / maxVal)})
+		}
+	}
+
+	return im, nil
+}
+
+// Read a whitespace separated token of a PGM header, skipping comments. The
+// single whitespace after the token is consumed.
+func readPGMToken(br *bufio.Reader) (string, error) {
+	token := make([]byte, 0, 8)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", err
+		}
+
+		switch {
+		case b == '#' && len(token) == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, b)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package mapstorage
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"hectormapping/map/maprep"
+)
+
+func TestROSExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rosmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mapRep := maprep.MakeMapRepMultiMap(0.05, 64, 48, 2, [2]float64{0.25, 0.5})
+	gridMap := mapRep.GetGridMap(0)
+	gridMap.GetCell(3, 40).Set(1.5)
+	gridMap.GetCell(10, 5).Set(-0.4)
+
+	err = ExportROS(mapRep, path.Join(dir, "office"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Load(path.Join(dir, "office.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := m.MapRep.GetGridMap(0)
+	if imported.GetSizeX() < 64 || imported.GetSizeY() < 48 {
+		t.Fatalf("Imported map has size (%d, %d)", imported.GetSizeX(), imported.GetSizeY())
+	}
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			a, b := gridMap.GetCell(x, y), imported.GetCell(x, y)
+			if a.IsOccupied() != b.IsOccupied() || a.IsFree() != b.IsFree() {
+				t.Errorf("Cell (%d, %d) has value %f, want the class of %f", x, y, b.GetValue(), a.GetValue())
+			}
+		}
+	}
+
+	want := gridMap.GetWorldCoords([2]float64{3, 40})
+	got := imported.GetWorldCoords([2]float64{3, 40})
+	if math.Abs(want[0]-got[0]) > 1e-6 || math.Abs(want[1]-got[1]) > 1e-6 {
+		t.Errorf("Cell (3, 40) is at %v, want %v", got, want)
+	}
+
+	// The coarser levels are made from level 0
+	for level := 1; level < m.MapRep.GetMapLevels(); level++ {
+		if !m.MapRep.GetGridMap(level).GetCell(3>>uint(level), 40>>uint(level)).IsOccupied() {
+			t.Errorf("Occupied cell is missing at level %d", level)
+		}
+	}
+}
+
+func TestImportROSImagePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rosmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pgm := []byte("P2\n4 4\n255\n0 255 255 255\n255 255 255 255\n255 255 255 255\n255 255 255 0\n")
+	err = os.Mkdir(path.Join(dir, "maps"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path.Join(dir, "maps", "office.pgm"), pgm, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for image, ok := range map[string]bool{
+		"maps/office.pgm":                    true,
+		"./maps/../maps/office.pgm":          true,
+		"../office.pgm":                      false,
+		"maps/../../office.pgm":              false,
+		path.Join(dir, "maps", "office.pgm"): false,
+	} {
+		yaml := "image: " + image + "\nresolution: 0.05\norigin: [0.0, 0.0, 0.0]\n"
+		err := ioutil.WriteFile(path.Join(dir, "office.yaml"), []byte(yaml), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ImportROS(path.Join(dir, "office.yaml"))
+		if ok && err != nil {
+			t.Errorf("Image %s was rejected: %s", image, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("Image %s outside the directory of the YAML file was accepted", image)
+		}
+	}
+}
+
+func TestDecodeROSYAML(t *testing.T) {
+	yaml := `# Hand edited
+image: "maps/office.png"
+resolution: 0.025
+origin: [-12.5, -3.0, 0.0]  # lower left
+negate: 1
+free_thresh: 0.2
+mode: scale
+`
+	meta, err := DecodeROSYAML(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Image != "maps/office.png" || meta.Resolution != 0.025 || meta.Origin != [3]float64{-12.5, -3, 0} {
+		t.Errorf("Got %+v", meta)
+	}
+	if !meta.Negate || meta.OccupiedThresh != 0.65 || meta.FreeThresh != 0.2 || meta.Mode != "scale" {
+		t.Errorf("Got %+v", meta)
+	}
+
+	if _, err := DecodeROSYAML(strings.NewReader("image: a.pgm\n")); err == nil {
+		t.Error("YAML without resolution and origin was accepted")
+	}
+}
+
+func TestDecodePGM(t *testing.T) {
+	pgm := "P2\n# plain\n3 2\n15\n0 15 7\n15 0 15\n"
+	im, err := DecodePGM(strings.NewReader(pgm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Bounds().Dx() != 3 || im.Bounds().Dy() != 2 {
+		t.Fatalf("Got size %v", im.Bounds())
+	}
+	if im.Gray16At(1, 0).Y != 0xffff || im.Gray16At(1, 1).Y != 0 || im.Gray16At(2, 0).Y != 7*0xffff/15 {
+		t.Errorf("Got pixels %v", im.Pix)
+	}
+}
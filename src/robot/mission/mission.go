@@ -0,0 +1,583 @@
+// Package mission executes missions: queued steps such as going to a pose or
+// waypoint, waiting, rotating in place and patrolling a loop of steps.
+//
+// The steps are executed one at a time, in order, through a Navigator. Each
+// step is a task in the queue with its own status. The queue is saved to a
+// file whenever it changes, so a restarted program can resume it.
+package mission
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"robot/fsm"
+	"robot/logging"
+	"robot/pathfollowing"
+)
+
+// Step types
+const (
+	GOTO   = "goto"
+	WAIT   = "wait"
+	ROTATE = "rotate"
+	PATROL = "patrol"
+)
+
+// Task statuses
+const (
+	PENDING = "pending"
+	RUNNING = "running"
+	DONE    = "done"
+	FAILED  = "failed"
+	SKIPPED = "skipped"
+	ABORTED = "aborted"
+)
+
+// Executor states
+const (
+	IDLE      = "IDLE"
+	EXECUTING = "EXECUTING"
+	PAUSED    = "PAUSED"
+)
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+// Returned by steps which were interrupted by pausing, skipping or aborting
+var errInterrupted = errors.New("Interrupted.")
+
+// A Step of a mission. Which fields are used depends on the type.
+type Step struct {
+	Type string `json:"type"`
+
+	// GOTO: the pose to go to, or the name of a waypoint, and the name of
+	// the path follower, "" for the one in the config
+	Pose     [3]float64 `json:"pose"`
+	Waypoint string     `json:"waypoint,omitempty"`
+	Follower string     `json:"follower,omitempty"`
+
+	// WAIT: the time to wait
+	Seconds float64 `json:"seconds,omitempty"`
+
+	// ROTATE: the heading to rotate to, in radians
+	Theta float64 `json:"theta,omitempty"`
+
+	// PATROL: steps which are repeated Loops times, or until skipped or
+	// aborted if Loops is 0. Patrols can't contain patrols.
+	Steps []Step `json:"steps,omitempty"`
+	Loops int    `json:"loops,omitempty"`
+}
+
+// Check that the step has what its type needs
+func (s Step) Validate() error {
+	switch s.Type {
+	case GOTO:
+		if !pathfollowing.IsFollower(s.Follower) {
+			return errors.New("No such path follower: " + s.Follower)
+		}
+	case ROTATE:
+	case WAIT:
+		if s.Seconds <= 0 {
+			return errors.New("A wait needs a positive number of seconds.")
+		}
+	case PATROL:
+		if len(s.Steps) == 0 {
+			return errors.New("A patrol needs steps.")
+		}
+		if s.Loops < 0 {
+			return errors.New("Invalid number of loops.")
+		}
+		for _, step := range s.Steps {
+			if step.Type == PATROL {
+				return errors.New("Patrols can't contain patrols.")
+			}
+			if err := step.Validate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("No such step type: " + s.Type)
+	}
+	return nil
+}
+
+// A Task is a step in the queue, and how far it has come
+type Task struct {
+	ID     int    `json:"id"`
+	Step   Step   `json:"step"`
+	Status string `json:"status"`
+
+	// Why the task failed
+	Message string `json:"message,omitempty"`
+
+	// For patrols, the loop and the step within the loop being executed
+	Loop  int `json:"loop"`
+	Index int `json:"index"`
+
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// The Navigator moves the robot. The channels returned give true when the
+// robot has arrived, or false if it didn't. GoTo follows the path with the
+// named path follower, "" for the one in the config.
+type Navigator interface {
+	GoTo(goal [3]float64, follower string) (chan bool, error)
+	Rotate(theta float64) (chan bool, error)
+	Stop()
+	GetWaypoint(name string) ([3]float64, error)
+}
+
+// The Executor keeps the queue of tasks, and executes them
+type Executor struct {
+	fsm.FSM
+
+	lock      sync.Mutex
+	navigator Navigator
+
+	// File the queue is saved to, none if empty
+	filename string
+
+	tasks  []*Task
+	nextID int
+
+	// Closed to interrupt the running task, and why: PAUSED, SKIPPED or
+	// ABORTED
+	cancel    chan struct{}
+	interrupt string
+}
+
+// The contents of the queue file
+type queueFile struct {
+	NextID int     `json:"nextId"`
+	Tasks  []*Task `json:"tasks"`
+}
+
+// Make an executor, loading the queue from filename if it exists. A loaded
+// queue with unfinished tasks is paused until resumed.
+func MakeExecutor(navigator Navigator, filename string) *Executor {
+	e := &Executor{
+		FSM:       *fsm.MakeFSM(IDLE),
+		navigator: navigator,
+		filename:  filename,
+		tasks:     make([]*Task, 0),
+		nextID:    1,
+	}
+
+	if filename == "" {
+		return e
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Println("Unable to read the mission queue:", err)
+		}
+		return e
+	}
+
+	var queue queueFile
+	if err := json.Unmarshal(buf, &queue); err != nil {
+		logger.Println("Unable to read the mission queue:", err)
+		return e
+	}
+
+	e.tasks = queue.Tasks
+	e.nextID = queue.NextID
+	for _, task := range e.tasks {
+		if task.Status == RUNNING {
+			task.Status = PENDING
+		}
+		if task.Status == PENDING {
+			e.SetState(PAUSED)
+		}
+	}
+
+	return e
+}
+
+// Add steps to the end of the queue, and start executing them unless paused.
+// Returns the ids of the new tasks.
+func (e *Executor) Add(steps []Step) ([]int, error) {
+	for _, step := range steps {
+		if err := step.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ids := make([]int, len(steps))
+	for i, step := range steps {
+		e.tasks = append(e.tasks, &Task{
+			ID:     e.nextID,
+			Step:   step,
+			Status: PENDING,
+		})
+		ids[i] = e.nextID
+		e.nextID++
+	}
+
+	if e.GetState() == IDLE {
+		e.start()
+	}
+	e.save()
+
+	return ids, nil
+}
+
+// Get a copy of the tasks in the queue
+func (e *Executor) GetTasks() []Task {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	tasks := make([]Task, len(e.tasks))
+	for i := range e.tasks {
+		tasks[i] = *e.tasks[i]
+	}
+	return tasks
+}
+
+// Get the state of the executor, IDLE, EXECUTING or PAUSED
+func (e *Executor) GetStatus() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.GetStateString()
+}
+
+// Stop the robot and pause the queue. The interrupted task is executed again
+// on resuming, patrols from the step they were at.
+func (e *Executor) Pause() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.GetState() != EXECUTING {
+		return errors.New("No mission is executing.")
+	}
+
+	e.interruptTask(PAUSED)
+	return nil
+}
+
+// Resume executing the queue
+func (e *Executor) Resume() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch e.GetState() {
+	case EXECUTING:
+		return errors.New("The mission is already executing.")
+	case IDLE:
+		return errors.New("No mission is paused.")
+	}
+
+	e.start()
+	return nil
+}
+
+// Skip the running task, or the next pending one if paused
+func (e *Executor) Skip() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.GetState() == EXECUTING {
+		e.interruptTask(SKIPPED)
+		return nil
+	}
+
+	task := e.nextPending()
+	if task == nil {
+		return errors.New("No task to skip.")
+	}
+	task.Status = SKIPPED
+	task.Finished = time.Now()
+	e.save()
+
+	return nil
+}
+
+// Stop the robot, and abort the running and pending tasks
+func (e *Executor) Abort() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.GetState() == EXECUTING {
+		e.interruptTask(ABORTED)
+		return
+	}
+
+	e.abortPending()
+	e.SetState(IDLE)
+	e.save()
+}
+
+// Remove the finished tasks from the queue
+func (e *Executor) Clear() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	tasks := make([]*Task, 0)
+	for _, task := range e.tasks {
+		if task.Status == PENDING || task.Status == RUNNING {
+			tasks = append(tasks, task)
+		}
+	}
+	e.tasks = tasks
+	e.save()
+}
+
+// Start executing the pending tasks. Must be called with the lock held.
+func (e *Executor) start() {
+	e.SetState(EXECUTING)
+	e.interrupt = ""
+	go e.run()
+}
+
+// Interrupt the running task. Must be called with the lock held.
+func (e *Executor) interruptTask(reason string) {
+	if e.interrupt != "" {
+		return
+	}
+	e.interrupt = reason
+	if e.cancel != nil {
+		close(e.cancel)
+	}
+}
+
+// Execute pending tasks until there are none, or the executor is interrupted
+func (e *Executor) run() {
+	for {
+		e.lock.Lock()
+
+		// Interrupted between tasks
+		if e.interrupt != "" {
+			e.finishInterrupt(nil)
+			e.lock.Unlock()
+			return
+		}
+
+		task := e.nextPending()
+		if task == nil {
+			logger.Println("Mission completed.")
+			e.SetState(IDLE)
+			e.save()
+			e.lock.Unlock()
+			return
+		}
+
+		task.Status = RUNNING
+		task.Message = ""
+		if task.Started.IsZero() {
+			task.Started = time.Now()
+		}
+		cancel := make(chan struct{})
+		e.cancel = cancel
+		e.save()
+		e.lock.Unlock()
+
+		logger.Printf("Executing mission task %d: %s\n", task.ID, task.Step.Type)
+		err := e.execute(task, cancel)
+
+		e.lock.Lock()
+		e.cancel = nil
+		if err == errInterrupted || e.interrupt != "" {
+			stop := e.finishInterrupt(task)
+			e.lock.Unlock()
+			if stop {
+				return
+			}
+			continue
+		}
+
+		task.Finished = time.Now()
+		if err != nil {
+			// Let the operator decide what to do
+			logger.Printf("Mission task %d failed: %v\n", task.ID, err)
+			task.Status = FAILED
+			task.Message = err.Error()
+			e.SetState(PAUSED)
+			e.save()
+			e.lock.Unlock()
+			return
+		}
+
+		task.Status = DONE
+		e.save()
+		e.lock.Unlock()
+	}
+}
+
+// Handle an interruption of a task, or between tasks if task is nil. Returns
+// true if the executor stops. Must be called with the lock held.
+func (e *Executor) finishInterrupt(task *Task) bool {
+	reason := e.interrupt
+	e.interrupt = ""
+
+	if task != nil {
+		switch reason {
+		case PAUSED:
+			task.Status = PENDING
+		case SKIPPED, ABORTED:
+			task.Status = reason
+			task.Finished = time.Now()
+		}
+	}
+
+	stop := true
+	switch reason {
+	case PAUSED:
+		e.SetState(PAUSED)
+	case ABORTED:
+		e.abortPending()
+		e.SetState(IDLE)
+	default:
+		stop = false
+	}
+	e.save()
+
+	return stop
+}
+
+// Abort the pending tasks. Must be called with the lock held.
+func (e *Executor) abortPending() {
+	for _, task := range e.tasks {
+		if task.Status == PENDING {
+			task.Status = ABORTED
+			task.Finished = time.Now()
+		}
+	}
+}
+
+// Get the first pending task, or nil. Must be called with the lock held.
+func (e *Executor) nextPending() *Task {
+	for _, task := range e.tasks {
+		if task.Status == PENDING {
+			return task
+		}
+	}
+	return nil
+}
+
+// Execute a task, returning errInterrupted if cancel is closed before it's
+// done
+func (e *Executor) execute(task *Task, cancel chan struct{}) error {
+	if task.Step.Type != PATROL {
+		return e.executeStep(task.Step, cancel)
+	}
+
+	patrol := task.Step
+	for {
+		e.lock.Lock()
+		loop, index := task.Loop, task.Index
+		e.lock.Unlock()
+
+		if patrol.Loops > 0 && loop >= patrol.Loops {
+			return nil
+		}
+
+		err := e.executeStep(patrol.Steps[index], cancel)
+		if err != nil {
+			return err
+		}
+
+		// Remember how far the patrol has come
+		e.lock.Lock()
+		task.Index++
+		if task.Index >= len(patrol.Steps) {
+			task.Index = 0
+			task.Loop++
+		}
+		e.save()
+		e.lock.Unlock()
+	}
+}
+
+// Execute a step which isn't a patrol
+func (e *Executor) executeStep(step Step, cancel chan struct{}) error {
+	switch step.Type {
+	case GOTO:
+		goal := step.Pose
+		if step.Waypoint != "" {
+			var err error
+			goal, err = e.navigator.GetWaypoint(step.Waypoint)
+			if err != nil {
+				return err
+			}
+		}
+
+		arrived, err := e.navigator.GoTo(goal, step.Follower)
+		if err != nil {
+			return err
+		}
+		return e.wait(arrived, cancel, "Did not arrive at the goal.")
+
+	case ROTATE:
+		done, err := e.navigator.Rotate(step.Theta)
+		if err != nil {
+			return err
+		}
+		return e.wait(done, cancel, "Did not reach the heading.")
+
+	case WAIT:
+		timer := time.NewTimer(time.Duration(step.Seconds * float64(time.Second)))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			return nil
+		case <-cancel:
+			return errInterrupted
+		}
+	}
+
+	return errors.New("No such step type: " + step.Type)
+}
+
+// Wait for the navigator to finish, stopping the robot if cancel is closed
+// first
+func (e *Executor) wait(done chan bool, cancel chan struct{}, failure string) error {
+	select {
+	case ok := <-done:
+		if !ok {
+			return errors.New(failure)
+		}
+		return nil
+	case <-cancel:
+		e.navigator.Stop()
+		return errInterrupted
+	}
+}
+
+// Save the queue to the file. Errors are logged, as the queue still works
+// without the file. Must be called with the lock held.
+func (e *Executor) save() {
+	if e.filename == "" {
+		return
+	}
+
+	buf, err := json.MarshalIndent(queueFile{e.nextID, e.tasks}, "", "\t")
+	if err != nil {
+		logger.Println("Unable to save the mission queue:", err)
+		return
+	}
+
+	// Write to a new file first, so a crash doesn't leave half a queue
+	tmp := e.filename + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err == nil {
+		err = os.Rename(tmp, e.filename)
+	}
+	if err != nil {
+		logger.Println("Unable to save the mission queue:", err)
+	}
+}
@@ -0,0 +1,246 @@
+package mission
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A navigator which arrives when told to
+type testNavigator struct {
+	lock    sync.Mutex
+	goals   [][3]float64
+	done    chan bool
+	stopped int
+}
+
+func makeTestNavigator() *testNavigator {
+	return &testNavigator{done: make(chan bool, 1)}
+}
+
+func (n *testNavigator) GoTo(goal [3]float64, follower string) (chan bool, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.goals = append(n.goals, goal)
+	return n.done, nil
+}
+
+func (n *testNavigator) Rotate(theta float64) (chan bool, error) {
+	return n.GoTo([3]float64{0, 0, theta}, "")
+}
+
+func (n *testNavigator) Stop() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.stopped++
+}
+
+func (n *testNavigator) GetWaypoint(name string) ([3]float64, error) {
+	if name == "dock" {
+		return [3]float64{1, 2, 3}, nil
+	}
+	return [3]float64{}, errors.New("No such waypoint.")
+}
+
+func (n *testNavigator) getGoals() [][3]float64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return append([][3]float64{}, n.goals...)
+}
+
+// Wait until cond holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out")
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []Step{
+		{Type: "fly"},
+		{Type: WAIT},
+		{Type: PATROL},
+		{Type: PATROL, Steps: []Step{{Type: PATROL, Steps: []Step{{Type: GOTO}}}}},
+		{Type: PATROL, Steps: []Step{{Type: WAIT}}},
+		{Type: GOTO, Follower: "autopilot"},
+	}
+	for _, step := range invalid {
+		if step.Validate() == nil {
+			t.Error("Invalid step accepted:", step)
+		}
+	}
+
+	nav := makeTestNavigator()
+	e := MakeExecutor(nav, "")
+	if _, err := e.Add(invalid[1:2]); err == nil {
+		t.Error("Invalid step added")
+	}
+	if len(e.GetTasks()) != 0 || e.GetStatus() != IDLE {
+		t.Error("Queue changed by invalid steps")
+	}
+}
+
+func TestExecute(t *testing.T) {
+	nav := makeTestNavigator()
+	e := MakeExecutor(nav, "")
+
+	ids, err := e.Add([]Step{
+		{Type: GOTO, Waypoint: "dock"},
+		{Type: WAIT, Seconds: 0.01},
+		{Type: ROTATE, Theta: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Error("Wrong ids:", ids)
+	}
+
+	waitFor(t, func() bool { return len(nav.getGoals()) == 1 })
+	if nav.getGoals()[0] != [3]float64{1, 2, 3} {
+		t.Error("Wrong goal:", nav.getGoals()[0])
+	}
+	if tasks := e.GetTasks(); tasks[0].Status != RUNNING || tasks[1].Status != PENDING {
+		t.Error("Wrong statuses:", tasks)
+	}
+
+	nav.done <- true
+	waitFor(t, func() bool { return len(nav.getGoals()) == 2 })
+	nav.done <- true
+	waitFor(t, func() bool { return e.GetStatus() == IDLE })
+
+	for _, task := range e.GetTasks() {
+		if task.Status != DONE {
+			t.Errorf("Task %d is %s", task.ID, task.Status)
+		}
+	}
+
+	e.Clear()
+	if len(e.GetTasks()) != 0 {
+		t.Error("Finished tasks not cleared")
+	}
+}
+
+func TestFailure(t *testing.T) {
+	nav := makeTestNavigator()
+	e := MakeExecutor(nav, "")
+
+	e.Add([]Step{{Type: GOTO, Waypoint: "nowhere"}, {Type: GOTO}})
+	waitFor(t, func() bool { return e.GetStatus() == PAUSED })
+
+	tasks := e.GetTasks()
+	if tasks[0].Status != FAILED || tasks[0].Message == "" || tasks[1].Status != PENDING {
+		t.Error("Wrong statuses after failure:", tasks)
+	}
+
+	// Skipping while paused skips the next pending task
+	if err := e.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := e.GetTasks(); tasks[1].Status != SKIPPED {
+		t.Error("Task not skipped:", tasks[1].Status)
+	}
+	if e.Skip() == nil {
+		t.Error("Skipped without pending tasks")
+	}
+}
+
+func TestPauseSkipAbort(t *testing.T) {
+	nav := makeTestNavigator()
+	e := MakeExecutor(nav, "")
+
+	e.Add([]Step{{Type: GOTO}, {Type: GOTO}, {Type: GOTO}})
+	waitFor(t, func() bool { return len(nav.getGoals()) == 1 })
+
+	// A paused task is executed again on resuming
+	if err := e.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return e.GetStatus() == PAUSED })
+	if tasks := e.GetTasks(); tasks[0].Status != PENDING {
+		t.Error("Paused task is", tasks[0].Status)
+	}
+	if e.Pause() == nil {
+		t.Error("Paused twice")
+	}
+
+	if err := e.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(nav.getGoals()) == 2 })
+
+	e.Skip()
+	waitFor(t, func() bool { return len(nav.getGoals()) == 3 })
+	if tasks := e.GetTasks(); tasks[0].Status != SKIPPED || tasks[1].Status != RUNNING {
+		t.Error("Wrong statuses after skipping:", tasks)
+	}
+
+	e.Abort()
+	waitFor(t, func() bool { return e.GetStatus() == IDLE })
+	if tasks := e.GetTasks(); tasks[1].Status != ABORTED || tasks[2].Status != ABORTED {
+		t.Error("Wrong statuses after aborting:", tasks)
+	}
+	if nav.stopped != 3 {
+		t.Error("Robot stopped", nav.stopped, "times")
+	}
+}
+
+func TestPatrolPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "missions.json")
+
+	nav := makeTestNavigator()
+	e := MakeExecutor(nav, filename)
+	e.Add([]Step{{
+		Type:  PATROL,
+		Loops: 2,
+		Steps: []Step{{Type: GOTO, Pose: [3]float64{1, 0, 0}}, {Type: GOTO, Pose: [3]float64{2, 0, 0}}},
+	}})
+
+	// Stop in the middle of the second loop
+	for i := 0; i < 3; i++ {
+		waitFor(t, func() bool { return len(nav.getGoals()) == i+1 })
+		if i < 2 {
+			nav.done <- true
+		}
+	}
+	e.Pause()
+	waitFor(t, func() bool { return e.GetStatus() == PAUSED })
+
+	// The queue is loaded paused, and the patrol goes on where it was
+	nav = makeTestNavigator()
+	e = MakeExecutor(nav, filename)
+	if e.GetStatus() != PAUSED {
+		t.Fatal("Loaded queue is", e.GetStatus())
+	}
+	if tasks := e.GetTasks(); len(tasks) != 1 || tasks[0].Loop != 1 || tasks[0].Index != 0 {
+		t.Fatal("Wrong patrol loaded:", tasks)
+	}
+
+	e.Resume()
+	waitFor(t, func() bool { return len(nav.getGoals()) == 1 })
+	nav.done <- true
+	waitFor(t, func() bool { return len(nav.getGoals()) == 2 })
+	nav.done <- true
+	waitFor(t, func() bool { return e.GetStatus() == IDLE })
+
+	goals := nav.getGoals()
+	if goals[0][0] != 1 || goals[1][0] != 2 {
+		t.Error("Wrong goals after resuming:", goals)
+	}
+	if e = MakeExecutor(nav, filename); e.nextID != 2 || e.GetStatus() != IDLE {
+		t.Error("Wrong queue loaded:", e.nextID, e.GetStatus())
+	}
+}
@@ -1,30 +1,39 @@
 package motor
 
 import (
+	"errors"
 	"log"
+	"math"
 	"sync"
 	"time"
 
-	"hectormapping/map/gridmap"
-
 	"robot/collisionavoidance"
+	"robot/config"
 	"robot/fsm"
 	"robot/logging"
 	"robot/model"
 	// "robot/motor/driver"
 	"robot/motor/peasing"
+	"robot/pathfollowing"
 	"robot/pathfollowing/lookahead"
+	"robot/pathfollowing/purepursuit"
+	"robot/pathfollowing/stanley"
 	"robot/pathplanning"
 	"robot/pathplanning/astar"
-	// "robot/pathplanning/hybridastar"
+	"robot/pathplanning/costmap"
+	"robot/pathplanning/dstarlite"
+	"robot/pathplanning/hybridastar"
 	"robot/pathplanning/path"
+	"robot/sensors/sensor"
 	"robot/slam"
+	"robot/telemetry"
 )
 
 // States
 const (
 	MANUAL        = "MANUAL"
 	PATHFOLLOWING = "PATHFOLLOWING"
+	ROTATING      = "ROTATING"
 )
 
 var logger *log.Logger
@@ -74,19 +83,42 @@ type MotorController struct {
 	// Pathplanner is responsible for calculating paths in a map.
 	pathPlanner pathplanning.PathPlanner
 
+	// Name of the path follower following the paths, "" for the one in the
+	// config
+	follower string
+
 	// Goal position for path planning in real world coordinates.
 	goal [3]float64
 
+	// Maximum error of the heading at the end of the path, in radians. The
+	// heading of the goal is ignored if this is 0.
+	headingTolerance float64
+
 	// Current planned path.
 	path     *path.Path
 	pathLock *sync.Mutex
-	occMap   gridmap.OccGridMap
+
+	// Costmap the path was planned in
+	costmap *costmap.Costmap
+
+	// Gets the costmap of the current map when replanning, may be nil
+	costmapSource func() (*costmap.Costmap, error)
+
+	// Incremental planner for replanning while following the path
+	replanner  *dstarlite.DStarLitePlanner
+	replanLock sync.Mutex
 }
 
 func MakeMotorController(robot *model.DifferentialWheeledRobot) *MotorController {
+	// return MakeMotorControllerWithDriver(robot, driver.MakeDefaultMotor())
+	return MakeMotorControllerWithDriver(robot, peasing.MakeDefaultPEasingMotorDriver())
+}
+
+// Make a motor controller which sends its speeds to the given motor driver,
+// e.g. a simulated one.
+func MakeMotorControllerWithDriver(robot *model.DifferentialWheeledRobot, motor MotorDriver) *MotorController {
 	mc := &MotorController{
-		// motor: driver.MakeDefaultMotor(),
-		motor: peasing.MakeDefaultPEasingMotorDriver(),
+		motor: motor,
 		robot: robot,
 	}
 
@@ -111,11 +143,27 @@ func (m *MotorController) ManualSpeeds(left, right float64) error {
 	m.SetState(MANUAL)
 
 	// Set the speeds and return any error
-	return m.motor.SetSpeeds(left, right)
+	return m.setSpeeds(left, right)
+}
+
+// Set the function giving the costmap of the current map, which the path is
+// checked and replanned in while it's followed. Without it, the costmap the
+// path was planned in is used.
+func (m *MotorController) SetCostmapSource(source func() (*costmap.Costmap, error)) {
+	m.costmapSource = source
+}
+
+// Set goal and plan an initial path to this goal in a costmap. The heading of
+// the goal is ignored.
+func (m *MotorController) PlanPath(c *costmap.Costmap, currentLocation, goal [3]float64) error {
+	return m.PlanPathWithHeading(c, currentLocation, goal, 0, "")
 }
 
-// Set goal and plan an initial path to this goal
-func (m *MotorController) PlanPath(occMap gridmap.OccGridMap, currentLocation, goal [3]float64) error {
+// Plan a path like PlanPath, to a goal whose heading the robot rotates in place
+// to at the end of the path, until within tolerance radians. A tolerance of 0
+// ignores the heading. The path is planned by the named planner, e.g.
+// pathplanning.HYBRID_ASTAR, or by config.PATH_PLANNER if planner is "".
+func (m *MotorController) PlanPathWithHeading(c *costmap.Costmap, currentLocation, goal [3]float64, tolerance float64, planner string) error {
 
 	var err error
 
@@ -125,16 +173,20 @@ func (m *MotorController) PlanPath(occMap gridmap.OccGridMap, currentLocation, g
 	}
 
 	m.goal = goal
-	m.occMap = occMap
+	m.headingTolerance = tolerance
+	m.costmap = c
+
+	m.pathPlanner, err = m.makePathPlanner(planner)
+	if err != nil {
+		return err
+	}
 
 	p, err := m.planPathDirectly(currentLocation, m.goal)
 	if err != nil {
 		return err
 	}
 
-	m.pathLock.Lock()
-	m.path = p
-	m.pathLock.Unlock()
+	m.setPath(p)
 
 	return nil
 }
@@ -142,29 +194,90 @@ func (m *MotorController) PlanPath(occMap gridmap.OccGridMap, currentLocation, g
 // Plan a path from a location to another
 func (m *MotorController) planPathDirectly(from, to [3]float64) (*path.Path, error) {
 
-	pathPlanner := astar.MakeAstarPlanner(m.occMap, m.robot)
-	return pathPlanner.PlanPath(from, to)
+	return m.pathPlanner.PlanPath(from, to)
+
+}
+
+// Make the named path planner for the current map, or the one of
+// config.PATH_PLANNER if planner is ""
+func (m *MotorController) makePathPlanner(planner string) (pathplanning.PathPlanner, error) {
+	if planner == "" {
+		planner = config.PATH_PLANNER
+	}
+
+	switch planner {
+	case pathplanning.ASTAR:
+		return astar.MakeAstarPlannerWithCostmap(m.costmap, m.robot), nil
+	case pathplanning.HYBRID_ASTAR:
+		return hybridastar.MakeHybridPathPlannerWithCostmap(m.costmap, m.robot, config.HYBRIDASTAR_GOAL_RADIUS), nil
+	}
 
+	return nil, errors.New("Unknown path planner: " + planner)
+}
+
+// Make the named path follower, or the one of config.PATH_FOLLOWER if follower
+// is "". Lookahead is used for unknown names.
+func (m *MotorController) makePathFollower(follower string) pathfollowing.PathFollower {
+	if follower == "" {
+		follower = config.PATH_FOLLOWER
+	}
+
+	switch follower {
+	case pathfollowing.PURE_PURSUIT:
+		return purepursuit.MakePurePursuit()
+	case pathfollowing.STANLEY:
+		return stanley.MakeStanley()
+	}
+
+	return lookahead.MakeLookahead()
 }
 
 // Follow a path. Takes a SLAM algorithm as input, from which the continuously
-// updated position is drawn.
-func (m *MotorController) FollowPath(slamAlg slam.Slam) {
+// updated position is drawn, and the LIDAR used for collision avoidance. If
+// the LIDAR is nil, no collision avoidance is done. The path is followed by
+// the named follower, e.g. pathfollowing.PURE_PURSUIT, or by
+// config.PATH_FOLLOWER if follower is "". The returned channel gives true when
+// the robot has arrived at the goal, or false if the path following failed or
+// was stopped.
+func (m *MotorController) FollowPath(slamAlg slam.Slam, lidar sensor.Sensor, follower string) (chan bool, error) {
+
+	if !pathfollowing.IsFollower(follower) {
+		return nil, errors.New("Unknown path follower: " + follower)
+	}
 
 	logger.Println("Starting path following")
+	arrived := make(chan bool, 1)
 
 	if m.GetState() == PATHFOLLOWING {
-		return
+		arrived <- false
+		return arrived, nil
 	}
 
+	m.follower = follower
 	m.SetState(PATHFOLLOWING)
 
+	// Replan as the map changes
+	m.replanLock.Lock()
+	m.replanner = nil
+	if m.costmap != nil {
+		m.replanner = dstarlite.MakeDStarLitePlanner(m.costmap)
+	}
+	m.replanLock.Unlock()
+	stopWatching := make(chan struct{})
+	go m.watchMap(slamAlg, stopWatching)
+
 	// Set up collision avoidance
-	collisionDetector := collisionavoidance.MakeDefaultCollisionDetector()
-	collisionDetector.Start()
+	var collisionDetector *collisionavoidance.CollisionDetector
+	if lidar != nil {
+		collisionDetector = collisionavoidance.MakeDefaultCollisionDetector(lidar)
+		collisionDetector.Start()
+	}
 
 	go func() {
-		defer collisionDetector.Stop()
+		defer close(stopWatching)
+		if collisionDetector != nil {
+			defer collisionDetector.Stop()
+		}
 		for {
 
 			// Follow the current path
@@ -173,20 +286,31 @@ func (m *MotorController) FollowPath(slamAlg slam.Slam) {
 			// Wait for the sub path following to finish
 			successful := <-outcome
 
+			// Turn to the heading of the goal
+			if successful && m.headingTolerance > 0 {
+				logger.Println("Rotating to the goal heading.")
+				if !m.rotate(slamAlg, m.goal[2], m.headingTolerance, PATHFOLLOWING) {
+					arrived <- false
+					return
+				}
+			}
+
 			if successful {
 				logger.Println("Successfully arrived at goal.")
 				m.SetState(MANUAL)
+				arrived <- true
 				return
 			} else {
 
 				// We did not arrive at goal as planned. Check if we have
 				// switched to manual mode.
 				if m.GetState() != PATHFOLLOWING || m.path == nil || slamAlg == nil {
+					arrived <- false
 					return
 				} else {
 
 					logger.Println("Starting backing")
-					m.motor.SetSpeeds(-0.5, -0.5)
+					m.setSpeeds(-0.5, -0.5)
 
 					// We need to wait with the replanning until the robot is
 					// done backing, to get a good start coordinate.
@@ -194,7 +318,7 @@ func (m *MotorController) FollowPath(slamAlg slam.Slam) {
 					wg.Add(1)
 					time.AfterFunc(2*time.Second, func() {
 						logger.Println("Stopping backing")
-						m.motor.SetSpeeds(0, 0)
+						m.setSpeeds(0, 0)
 						wg.Done()
 					})
 
@@ -202,18 +326,16 @@ func (m *MotorController) FollowPath(slamAlg slam.Slam) {
 					wg.Wait()
 
 					// Plan new path to real goal
-					currPos := slamAlg.GetPosition()
-					replannedPath, err := m.planPathDirectly([3]float64{currPos.X, currPos.Y, currPos.Theta}, m.goal)
+					replannedPath, err := m.replan(slamAlg, true)
 					if err != nil {
 						logger.Println("Unable to find alternative route to goal.")
 						m.SetState(MANUAL)
+						arrived <- false
 						return
 					}
 
 					// Set the new path and continue the journey
-					m.pathLock.Lock()
-					m.path = replannedPath
-					m.pathLock.Unlock()
+					m.setPath(replannedPath)
 
 				}
 
@@ -221,6 +343,143 @@ func (m *MotorController) FollowPath(slamAlg slam.Slam) {
 		}
 	}()
 
+	return arrived, nil
+}
+
+// Check the SLAM map along the rest of the path every
+// config.REPLAN_CHECK_INTERVAL ms until stop is closed, and replan from the
+// current position if an obstacle has appeared on the path.
+func (m *MotorController) watchMap(slamAlg slam.Slam, stop chan struct{}) {
+	if config.REPLAN_CHECK_INTERVAL <= 0 || slamAlg == nil {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(config.REPLAN_CHECK_INTERVAL) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if m.GetState() != PATHFOLLOWING {
+			continue
+		}
+
+		p, err := m.replan(slamAlg, false)
+		if err != nil {
+			logger.Println("Unable to replan around an obstacle:", err)
+			continue
+		}
+		if p != nil {
+			logger.Println("Replanned around an obstacle on the path.")
+			m.setPath(p)
+		}
+	}
+}
+
+// Refresh the cells along the rest of the path from the costmap of the current
+// map, and plan a new path to the goal from the current position if the path is blocked, or
+// if force is set. Returns a nil path if the path was kept.
+func (m *MotorController) replan(slamAlg slam.Slam, force bool) (*path.Path, error) {
+	m.replanLock.Lock()
+	defer m.replanLock.Unlock()
+
+	if m.replanner == nil {
+		return nil, errors.New("No path planned.")
+	}
+
+	c := m.costmap
+	if m.costmapSource != nil {
+		var err error
+		if c, err = m.costmapSource(); err != nil {
+			return nil, err
+		}
+	}
+	pos := slamAlg.GetPosition()
+	position := [2]float64{pos.X, pos.Y}
+
+	blocked := false
+	if current := m.GetPath(); current != nil {
+
+		// Leave out the points the robot is on, as it may be closer to a wall
+		// than paths are planned
+		points := make([][2]float64, 0)
+		for _, p := range current.PointsAhead(position, c.GetCellLength()/2) {
+			if math.Hypot(p[0]-position[0], p[1]-position[1]) > config.ASTAR_CHECK_RADIUS {
+				points = append(points, p)
+			}
+		}
+		blocked = m.replanner.UpdatePoints(c, points)
+	}
+
+	m.pathLock.Lock()
+	m.costmap = c
+	m.pathLock.Unlock()
+
+	if !blocked && !force {
+		return nil, nil
+	}
+
+	return m.replanner.PlanPath([3]float64{pos.X, pos.Y, pos.Theta}, m.goal)
+}
+
+// Rotate in place until the robot has the given heading, in radians. The
+// returned channel gives true when the heading is reached, or false if the
+// rotation was stopped, e.g. by setting speeds manually.
+func (m *MotorController) Rotate(slamAlg slam.Slam, theta float64) chan bool {
+
+	logger.Printf("Rotating to %.2f\n", theta)
+	done := make(chan bool, 1)
+
+	if m.GetState() == PATHFOLLOWING {
+		m.StopPathFollowing()
+	}
+	m.SetState(ROTATING)
+
+	go func() {
+		if m.rotate(slamAlg, theta, config.ROTATE_TOLERANCE, ROTATING) {
+			m.SetState(MANUAL)
+			done <- true
+		} else {
+			done <- false
+		}
+	}()
+
+	return done
+}
+
+// Rotate in place until the heading is within tolerance of theta, returning
+// true, or until the state is no longer state, returning false.
+func (m *MotorController) rotate(slamAlg slam.Slam, theta, tolerance float64, state fsm.State) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if m.GetState() != state {
+			return false
+		}
+
+		// Turn the shortest way
+		pos := slamAlg.GetPosition()
+		diff := math.Remainder(theta-pos.Theta, 2*math.Pi)
+		if math.Abs(diff) < tolerance {
+			m.setSpeeds(0, 0)
+			return true
+		}
+
+		// Slow down close to the heading, counter clockwise is positive
+		speed := config.ROTATE_SPEED * math.Min(1, math.Abs(diff)/(math.Pi/4)+0.2)
+		if diff > 0 {
+			m.setSpeeds(-speed, speed)
+		} else {
+			m.setSpeeds(speed, -speed)
+		}
+
+		<-ticker.C
+	}
 }
 
 // Follow a path stricly, return a channel which gives off a boolen value
@@ -233,8 +492,9 @@ func (m *MotorController) followSubPath(collisionDetector *collisionavoidance.Co
 	logger.Println("Starting following of subpath")
 	successful := make(chan bool)
 
-	follower := lookahead.MakeLookahead()
-	follower.SetPath(m.path)
+	follower := m.makePathFollower(m.follower)
+	followed := m.path
+	follower.SetPath(followed)
 
 	interval := 100 * time.Millisecond
 
@@ -255,7 +515,7 @@ func (m *MotorController) followSubPath(collisionDetector *collisionavoidance.Co
 				case <-collisionDetector.StopChan:
 
 					// Detected an obstacle, so stop the motors, wait for resume
-					m.motor.SetSpeeds(0, 0)
+					m.setSpeeds(0, 0)
 					// logger.Println("Detected obstacle: stopping.")
 					timer := time.NewTimer(5 * time.Second)
 					select {
@@ -282,6 +542,12 @@ func (m *MotorController) followSubPath(collisionDetector *collisionavoidance.Co
 			// Lock the path, so it can't be changed or deleted
 			m.pathLock.Lock()
 
+			// Start over on the path if it has been replanned
+			if m.path != nil && m.path != followed {
+				followed = m.path
+				follower.SetPath(followed)
+			}
+
 			// Get the speed update
 			pos := slamAlg.GetPosition()
 			//logger.Printf("followSubPath: Current Position is X = %.3v Y = %.3v Theta = %.3v\n", pos.X, pos.Y, pos.Theta)
@@ -289,13 +555,13 @@ func (m *MotorController) followSubPath(collisionDetector *collisionavoidance.Co
 
 			// If we're finished
 			if finished {
-				m.motor.SetSpeeds(0, 0)
+				m.setSpeeds(0, 0)
 				m.pathLock.Unlock()
 				successful <- true
 				return
 			}
 
-			err := m.motor.SetSpeeds(v_l, v_r)
+			err := m.setSpeeds(v_l, v_r)
 			if err != nil {
 				logger.Println(err)
 			}
@@ -328,9 +594,20 @@ func (m *MotorController) GetPath() *path.Path {
 // Deletes the path -- is safe to run even when path following (any path
 // following will be aborted).
 func (m *MotorController) DeletePath() {
+	m.setPath(nil)
+}
 
+// Replace the path, and publish it as telemetry
+func (m *MotorController) setPath(p *path.Path) {
 	m.pathLock.Lock()
-	defer m.pathLock.Unlock()
+	m.path = p
+	m.pathLock.Unlock()
 
-	m.path = nil
+	telemetry.Publish(telemetry.PATH, p)
+}
+
+// Set the speeds of the motors, and publish them as telemetry
+func (m *MotorController) setSpeeds(left, right float64) error {
+	telemetry.Publish(telemetry.MOTOR, telemetry.MotorCommand{Left: left, Right: right})
+	return m.motor.SetSpeeds(left, right)
 }
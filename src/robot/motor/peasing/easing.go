@@ -27,9 +27,18 @@ const USE_AUTO_STOP = true
 // The P-value in the P-controller
 const P_PARAMETER = 0.3
 
+// The motor being eased, typically the physical motor driver. Has the same
+// methods as motor.MotorDriver, which can't be imported here.
+type Driver interface {
+	Connect() error
+	Disconnect() error
+	IsConnected() bool
+	SetSpeeds(left, right float64) error
+}
+
 // Implements MotorDriver
 type PEasingMotorDriver struct {
-	driver          *driver.Motor
+	driver          Driver
 	stopChan        chan bool
 	leftRef         float64
 	rightRef        float64
@@ -39,13 +48,18 @@ type PEasingMotorDriver struct {
 	err             error
 }
 
-func MakeDefaultPEasingMotorDriver() *PEasingMotorDriver {
+// Ease the speeds of an arbitrary motor driver
+func MakePEasingMotorDriver(d Driver) *PEasingMotorDriver {
 	return &PEasingMotorDriver{
-		driver: driver.MakeDefaultMotor(),
-		// driver: &dummydriver.DummyDriver{},
+		driver: d,
 	}
 }
 
+func MakeDefaultPEasingMotorDriver() *PEasingMotorDriver {
+	return MakePEasingMotorDriver(driver.MakeDefaultMotor())
+	// return MakePEasingMotorDriver(&dummydriver.DummyDriver{})
+}
+
 // Connect the motor, set up speed updating
 func (q *PEasingMotorDriver) Connect() error {
 
@@ -19,6 +19,7 @@ import (
 	"robot/logging"
 	"robot/pathfollowing/los"
 	"robot/pathplanning/path"
+	"robot/telemetry"
 )
 
 var logger *log.Logger
@@ -52,7 +53,6 @@ func (l *Lookahead) SetPath(p *path.Path) {
 // finished, the flag "finished" will be set. Otherwise, the left and right
 // return float64s will assume some values [-1, 1].
 func (l *Lookahead) SpeedUpdate(pos [3]float64) (left, right float64, finished bool) {
-	k := 0.5 // Set the scale constant for motor PID during turns and course corrections - see line # 93 below
 	// Get the points. If this fails, we're finished.
 	pointA, pointB, err := l.getPoints()
 	if err != nil {
@@ -65,6 +65,7 @@ func (l *Lookahead) SpeedUpdate(pos [3]float64) (left, right float64, finished b
 
 	// Get course angle and along-track distance
 	chi_d, s := l.courseAngle(pointA, pointB, [2]float64{pos[0], pos[1]})
+	l.publishTarget(pointA, pointB, s)
 
 	// If we're past the distance of the line segment, move on to next one
 	// (takes effect on next update)
@@ -80,46 +81,26 @@ func (l *Lookahead) SpeedUpdate(pos [3]float64) (left, right float64, finished b
 
 	//logger.Printf("Index = %v and e_chi = %.3v\n", l.currIndex, e_chi)
 
-	// BJW CODE: Limit e_chi to -1.57 to +1.57 range (i.e. -/+ 90 degrees).
-	if e_chi < -1.57 {
-		e_chi = -1.57
-	}
-
-	if e_chi > 1.57 {
-		e_chi = 1.57
-	}
+	// Limit the error to +-90 degrees
+	e_chi = math.Max(-math.Pi/2, math.Min(math.Pi/2, e_chi))
 
-	// This lets the robot go faster on straightaways ***********************************************************
-	if math.Abs(e_chi) < 0.05 {
+	// Scale the speeds down for turns and course corrections, so the robot
+	// only goes fast on straightaways
+	k := config.LOOKAHEAD_TURN_SCALE
+	if math.Abs(e_chi) < config.LOOKAHEAD_STRAIGHT_ANGLE {
 		k = 1.0
 	}
 
-	// This didn't work: k = (-50/157)*math.Abs(e_chi) + 1.0
-
-	// BJW CODE: If e_chi is negative, turn clockwise; otherwise turn counterclockwise.
-	if e_chi <= 0 {
-		//logger.Printf("Clockwise: Index = %v and e_chi = %.3v\n", l.currIndex, e_chi)
-		left = k * (((25.0 / 157.0) * e_chi) + 0.5)
-		right = k * (((75.0 / 157.0) * e_chi) + 0.5)
-		//logger.Printf("left = %v and right = %v\n\n", left, right)
-	} else {
-		//logger.Printf("Counterclockwise: Index = %v and e_chi = %.3v\n", l.currIndex, e_chi)
-		left = k * (((-75.0 / 157.0) * e_chi) + 0.5)
-		right = k * (((-25.0 / 157.0) * e_chi) + 0.5)
-		//logger.Printf("left = %v and right = %v\n\n", left, right)
-	}
-
 	// We can now caluclate a "delta_rl", i.e. a difference between the
 	// control signals to right and left wheels (right - left), which should
 	// be used, as simply p*e_chi. P is the proportional factor in a PID
-	// controller.
-	// ORIGINAL CODE: delta_rl := config.LOOKAHEAD_P * e_chi
-
-	//logger.Println("delta_rl = ", delta_rl)
+	// controller. The robot slows down as much as the error turns it.
+	delta_rl := config.LOOKAHEAD_P * e_chi
+	u := config.LOOKAHEAD_U - config.LOOKAHEAD_P*math.Abs(e_chi)
 
 	// Split the delta into two parts, add speed
-	// ORIGINAL CODE: right = 0.5*delta_rl + config.LOOKAHEAD_U
-	// ORIGINAL CODE: left = -0.5*delta_rl + config.LOOKAHEAD_U
+	right = k * (u + 0.5*delta_rl)
+	left = k * (u - 0.5*delta_rl)
 
 	return
 
@@ -176,6 +157,26 @@ func (l *Lookahead) getPoints() (pointA, pointB [2]float64, err error) {
 	return
 }
 
+// Publish the point we're steering towards, a lookahead distance ahead of
+// the projection of the position on the line segment
+func (l *Lookahead) publishTarget(pointA, pointB [2]float64, s float64) {
+	d := l.segmentDistance(pointA, pointB)
+	if d == 0 {
+		return
+	}
+
+	ahead := (s + config.LOOKAHEAD_DISTANCE) / d
+	telemetry.Publish(telemetry.TARGET, telemetry.Target{
+		Segment: l.currIndex,
+		From:    pointA,
+		To:      pointB,
+		Point: [2]float64{
+			pointA[0] + ahead*(pointB[0]-pointA[0]),
+			pointA[1] + ahead*(pointB[1]-pointA[1]),
+		},
+	})
+}
+
 // Get the distance between two points, i.e. the maximum distance we should
 // travel along the particular line segment.
 func (l *Lookahead) segmentDistance(pointA, pointB [2]float64) float64 {
@@ -3,13 +3,64 @@
 package pathfollowing
 
 import (
+	"math"
+
+	"robot/config"
 	"robot/pathplanning/path"
 )
 
+// Names of the path followers
+const (
+	LOOKAHEAD    = "lookahead"
+	PURE_PURSUIT = "purepursuit"
+	STANLEY      = "stanley"
+)
+
+// Distance in meters ahead of its last progress along a path within which the
+// robot is projected on the path. Limits how far followers skip ahead where a
+// path comes back close to itself.
+const TRACKING_WINDOW = 1.0
+
 // Each path following algorithm implements a common interface, PathFollower.
 type PathFollower interface {
 	// Assigns a path to follow.
-	SetPath(p path.Path)
+	SetPath(p *path.Path)
 	// Computes speeds for motors.
 	SpeedUpdate(pos [3]float64) (left, right float64, finished bool)
 }
+
+// Determine if name is the name of a path follower, or "" for the one in the
+// config
+func IsFollower(name string) bool {
+	switch name {
+	case "", LOOKAHEAD, PURE_PURSUIT, STANLEY:
+		return true
+	}
+	return false
+}
+
+// Get the motor speed to follow a path at, config.FOLLOW_MAX_SPEED where it's
+// straight and far from the end, slower where it curves and near the end.
+// curvature is the curvature of the path ahead in 1/m, and remaining is the
+// distance left along it.
+func ProfileSpeed(curvature, remaining float64) float64 {
+	speed := config.FOLLOW_MAX_SPEED / (1 + config.FOLLOW_CURVATURE_GAIN*math.Abs(curvature))
+	if remaining < config.FOLLOW_GOAL_SLOWDOWN {
+		speed = math.Min(speed, config.FOLLOW_MAX_SPEED*remaining/config.FOLLOW_GOAL_SLOWDOWN)
+	}
+	return math.Max(speed, config.FOLLOW_MIN_SPEED)
+}
+
+// Get the speeds of the wheels for driving at speed along an arc with
+// curvature in 1/m, positive to the left. Both are scaled down if either
+// would be faster than 1.
+func WheelSpeeds(speed, curvature float64) (left, right float64) {
+	left = speed * (1 - curvature*config.ROBOT_BASE_WIDTH/2)
+	right = speed * (1 + curvature*config.ROBOT_BASE_WIDTH/2)
+
+	if fastest := math.Max(math.Abs(left), math.Abs(right)); fastest > 1 {
+		left /= fastest
+		right /= fastest
+	}
+	return
+}
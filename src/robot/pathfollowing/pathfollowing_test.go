@@ -0,0 +1,92 @@
+package pathfollowing
+
+import (
+	"math"
+	"testing"
+
+	"robot/config"
+	"robot/pathplanning/path"
+)
+
+var lPath = &path.Path{
+	Poses: [][3]float64{
+		{0, 0, 0},
+		{3, 0, 0},
+		{3, 3, 0},
+	},
+}
+
+func TestProfileSpeed(t *testing.T) {
+	if got := ProfileSpeed(0, 10); got != config.FOLLOW_MAX_SPEED {
+		t.Errorf("Got %f on a straight path, wanted %f", got, config.FOLLOW_MAX_SPEED)
+	}
+	if ProfileSpeed(2, 10) >= ProfileSpeed(1, 10) {
+		t.Error("Not slower in a sharper curve")
+	}
+	if ProfileSpeed(0, config.FOLLOW_GOAL_SLOWDOWN/2) >= ProfileSpeed(0, config.FOLLOW_GOAL_SLOWDOWN) {
+		t.Error("Not slower near the goal")
+	}
+	if got := ProfileSpeed(100, 0); got != config.FOLLOW_MIN_SPEED {
+		t.Errorf("Got %f, wanted the min speed %f", got, config.FOLLOW_MIN_SPEED)
+	}
+}
+
+func TestWheelSpeeds(t *testing.T) {
+	if left, right := WheelSpeeds(0.5, 0); left != 0.5 || right != 0.5 {
+		t.Errorf("Got %f, %f going straight", left, right)
+	}
+	if left, right := WheelSpeeds(0.5, 1); left >= right {
+		t.Errorf("Got %f, %f turning left", left, right)
+	}
+	left, right := WheelSpeeds(1, -10)
+	if left != 1 || right >= left {
+		t.Errorf("Got %f, %f turning sharply right", left, right)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := MakeTracker(lPath)
+	if tracker.Length() != 6 {
+		t.Fatalf("Got length %f, wanted 6", tracker.Length())
+	}
+
+	progress, crossTrack := tracker.Update([2]float64{1, 0.2}, TRACKING_WINDOW)
+	if math.Abs(progress-1) > 1e-9 || math.Abs(crossTrack-0.2) > 1e-9 {
+		t.Errorf("Got progress %f and cross-track error %f, wanted 1 and 0.2", progress, crossTrack)
+	}
+
+	// The second segment is outside the window
+	tracker.Update([2]float64{3.1, 2}, TRACKING_WINDOW)
+	if tracker.GetSegment() != 0 {
+		t.Error("Skipped ahead outside the window")
+	}
+
+	progress, crossTrack = tracker.Update([2]float64{3.1, 0.5}, TRACKING_WINDOW)
+	if tracker.GetSegment() != 1 || math.Abs(progress-3.5) > 1e-9 || math.Abs(crossTrack+0.1) > 1e-9 {
+		t.Errorf("Got segment %d, progress %f and cross-track error %f", tracker.GetSegment(), progress, crossTrack)
+	}
+
+	// Progress never goes back
+	if progress, _ = tracker.Update([2]float64{2, 0}, TRACKING_WINDOW); progress < 3.5 {
+		t.Errorf("Progress went back to %f", progress)
+	}
+
+	point, heading := tracker.PointAt(4)
+	if math.Abs(point[0]-3) > 1e-9 || math.Abs(point[1]-1) > 1e-9 || math.Abs(heading-math.Pi/2) > 1e-9 {
+		t.Errorf("Got point %v and heading %f", point, heading)
+	}
+
+	if got := tracker.Curvature(2, 2); math.Abs(got-math.Pi/4) > 1e-9 {
+		t.Errorf("Got curvature %f across the corner, wanted %f", got, math.Pi/4)
+	}
+	if got := tracker.Curvature(4, 1); got != 0 {
+		t.Errorf("Got curvature %f on a straight part", got)
+	}
+
+	if tracker.Finished([2]float64{3, 2}, 0.1) {
+		t.Error("Finished before the end")
+	}
+	if !tracker.Finished([2]float64{3.05, 2.95}, 0.1) {
+		t.Error("Not finished at the end")
+	}
+}
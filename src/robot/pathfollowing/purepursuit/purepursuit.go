@@ -0,0 +1,74 @@
+// Package purepursuit implements adaptive pure pursuit steering. The robot
+// steers along the arc through a point on the path a lookahead distance ahead
+// of it, the distance growing with the speed: short for following tight
+// turns closely at low speed, long for driving smoothly at high speed.
+package purepursuit
+
+import (
+	"math"
+
+	"robot/config"
+	"robot/pathfollowing"
+	"robot/pathplanning/path"
+)
+
+// PurePursuit implements the PathFollower interface
+type PurePursuit struct {
+	tracker *pathfollowing.Tracker
+
+	// The speed of the last update
+	speed float64
+}
+
+func MakePurePursuit() *PurePursuit {
+	return &PurePursuit{}
+}
+
+// Set the path to follow, starting at its first pose
+func (pp *PurePursuit) SetPath(p *path.Path) {
+	pp.tracker = pathfollowing.MakeTracker(p)
+	pp.speed = config.FOLLOW_MIN_SPEED
+}
+
+// Get the speeds of the wheels, [-1, 1], for following the path from pos.
+// finished is set when the end of the path is reached.
+func (pp *PurePursuit) SpeedUpdate(pos [3]float64) (left, right float64, finished bool) {
+	position := [2]float64{pos[0], pos[1]}
+	if pp.tracker == nil || pp.tracker.Finished(position, config.FOLLOW_GOAL_TOLERANCE) {
+		return 0, 0, true
+	}
+
+	lookahead := pp.lookaheadDistance()
+	progress, _ := pp.tracker.Update(position, pathfollowing.TRACKING_WINDOW)
+	if pp.tracker.Finished(position, config.FOLLOW_GOAL_TOLERANCE) {
+		return 0, 0, true
+	}
+
+	// The arc through the target point, tangent to the heading of the robot
+	target, _ := pp.tracker.PointAt(progress + lookahead)
+	dx, dy := target[0]-pos[0], target[1]-pos[1]
+	alpha := math.Atan2(dy, dx) - pos[2]
+	curvature := 0.0
+	if distance := math.Hypot(dx, dy); distance > 0 {
+		curvature = 2 * math.Sin(alpha) / distance
+
+		// Turn as sharp as toward a point to the side if the point is behind
+		if math.Cos(alpha) < 0 {
+			curvature = math.Copysign(2/distance, math.Sin(alpha))
+		}
+	}
+	pp.tracker.PublishTarget(target)
+
+	// Slow down for the arc, or for the path ahead if it curves more
+	pathCurvature := pp.tracker.Curvature(progress, config.FOLLOW_CURVATURE_DISTANCE)
+	pp.speed = pathfollowing.ProfileSpeed(math.Max(math.Abs(curvature), pathCurvature), pp.tracker.Remaining(position))
+
+	left, right = pathfollowing.WheelSpeeds(pp.speed, curvature)
+	return left, right, false
+}
+
+// Get the lookahead distance for the speed of the last update
+func (pp *PurePursuit) lookaheadDistance() float64 {
+	distance := config.PUREPURSUIT_LOOKAHEAD_GAIN * pp.speed
+	return math.Max(config.PUREPURSUIT_MIN_LOOKAHEAD, math.Min(config.PUREPURSUIT_MAX_LOOKAHEAD, distance))
+}
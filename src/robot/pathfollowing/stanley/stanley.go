@@ -0,0 +1,66 @@
+// Package stanley implements Stanley steering, as described in Thrun et. al.
+// "Stanley: The Robot that Won the DARPA Grand Challenge". The robot is
+// steered like a car whose front axle is config.STANLEY_WHEELBASE ahead of
+// it, by the heading error of the path at the front axle and by the
+// cross-track error of the front axle, the latter less at high speeds.
+package stanley
+
+import (
+	"math"
+
+	"robot/config"
+	"robot/pathfollowing"
+	"robot/pathplanning/path"
+)
+
+// Stanley implements the PathFollower interface
+type Stanley struct {
+	tracker *pathfollowing.Tracker
+
+	// The speed of the last update
+	speed float64
+}
+
+func MakeStanley() *Stanley {
+	return &Stanley{}
+}
+
+// Set the path to follow, starting at its first pose
+func (s *Stanley) SetPath(p *path.Path) {
+	s.tracker = pathfollowing.MakeTracker(p)
+	s.speed = config.FOLLOW_MIN_SPEED
+}
+
+// Get the speeds of the wheels, [-1, 1], for following the path from pos.
+// finished is set when the end of the path is reached.
+func (s *Stanley) SpeedUpdate(pos [3]float64) (left, right float64, finished bool) {
+	position := [2]float64{pos[0], pos[1]}
+	if s.tracker == nil || s.tracker.Finished(position, config.FOLLOW_GOAL_TOLERANCE) {
+		return 0, 0, true
+	}
+
+	// Project the front axle on the path
+	front := [2]float64{
+		pos[0] + config.STANLEY_WHEELBASE*math.Cos(pos[2]),
+		pos[1] + config.STANLEY_WHEELBASE*math.Sin(pos[2]),
+	}
+	progress, crossTrack := s.tracker.Update(front, pathfollowing.TRACKING_WINDOW)
+	point, heading := s.tracker.PointAt(progress)
+	s.tracker.PublishTarget(point)
+
+	// Steer by the heading error, and toward the path
+	headingError := math.Remainder(heading-pos[2], 2*math.Pi)
+	steer := config.STANLEY_HEADING_GAIN*headingError +
+		math.Atan2(-config.STANLEY_GAIN*crossTrack, config.STANLEY_SOFTENING+s.speed)
+	steer = math.Max(-config.STANLEY_MAX_STEER, math.Min(config.STANLEY_MAX_STEER, steer))
+
+	// The curvature of a car with the steering angle
+	curvature := math.Tan(steer) / config.STANLEY_WHEELBASE
+
+	// Slow down for steering, or for the path ahead if it curves more
+	pathCurvature := s.tracker.Curvature(progress, config.FOLLOW_CURVATURE_DISTANCE)
+	s.speed = pathfollowing.ProfileSpeed(math.Max(math.Abs(curvature), pathCurvature), s.tracker.Remaining(position))
+
+	left, right = pathfollowing.WheelSpeeds(s.speed, curvature)
+	return left, right, false
+}
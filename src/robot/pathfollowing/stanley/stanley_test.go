@@ -0,0 +1,61 @@
+package stanley
+
+import (
+	"math"
+	"testing"
+
+	"robot/model"
+	"robot/pathplanning/path"
+)
+
+var testPath = &path.Path{
+	Poses: [][3]float64{
+		{0, 0, 0},
+		{3, 0, 0},
+		{3, 3, 0},
+	},
+}
+
+// Simulate following the path from a bit beside its start, with the wheel
+// speeds in m/s
+func TestStanley(t *testing.T) {
+	s := MakeStanley()
+	s.SetPath(testPath)
+	robot := model.MakeDefaultDifferentialWheeledRobot()
+
+	pos := model.Position{X: 0, Y: 0.3, Theta: 0}
+	maxError := 0.0
+	var straightSpeed, cornerSpeed float64
+	for i := 0; ; i++ {
+		if i > 1000 {
+			t.Fatalf("Not finished, at %v", pos)
+		}
+
+		left, right, finished := s.SpeedUpdate([3]float64{pos.X, pos.Y, pos.Theta})
+		if finished {
+			break
+		}
+		pos = robot.RollPosition(left*0.1, right*0.1, pos)
+
+		// Distance from the path, and the speeds on the straight part
+		// and in the corner
+		maxError = math.Max(maxError, math.Min(math.Abs(pos.Y)+math.Max(0, pos.X-3), math.Abs(pos.X-3)+math.Max(0, -pos.Y)))
+		speed := (left + right) / 2
+		if pos.X > 1 && pos.X < 1.5 {
+			straightSpeed = math.Max(straightSpeed, speed)
+		}
+		if pos.X > 2.5 && pos.Y < 0.5 {
+			cornerSpeed = math.Max(cornerSpeed, speed)
+		}
+	}
+
+	if math.Hypot(pos.X-3, pos.Y-3) > 0.2 {
+		t.Errorf("Finished at %v, not at the end", pos)
+	}
+	if maxError > 0.35 {
+		t.Errorf("Got %f from the path", maxError)
+	}
+	if cornerSpeed >= straightSpeed {
+		t.Errorf("Got speed %f in the corner, %f on the straight part", cornerSpeed, straightSpeed)
+	}
+}
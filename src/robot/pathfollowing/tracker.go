@@ -0,0 +1,175 @@
+package pathfollowing
+
+import (
+	"math"
+
+	"robot/pathplanning/path"
+	"robot/telemetry"
+)
+
+// A Tracker keeps track of the progress of the robot along a path, for path
+// followers. The progress only moves forward, so a path crossing itself is
+// followed in order.
+type Tracker struct {
+	path *path.Path
+
+	// Distance along the path to each pose
+	distances []float64
+
+	// The segment the robot was last projected on, and the progress along
+	// the path
+	segment  int
+	progress float64
+}
+
+// Make a tracker for a path, starting at its first pose
+func MakeTracker(p *path.Path) *Tracker {
+	t := &Tracker{
+		path:      p,
+		distances: make([]float64, len(p.Poses)),
+	}
+	for i := 1; i < len(p.Poses); i++ {
+		t.distances[i] = t.distances[i-1] + math.Hypot(p.Poses[i][0]-p.Poses[i-1][0], p.Poses[i][1]-p.Poses[i-1][1])
+	}
+	return t
+}
+
+// Get the length of the path
+func (t *Tracker) Length() float64 {
+	if len(t.distances) == 0 {
+		return 0
+	}
+	return t.distances[len(t.distances)-1]
+}
+
+// Get the current segment, from pose i to pose i+1
+func (t *Tracker) GetSegment() int {
+	return t.segment
+}
+
+// Update the progress with the position of the robot, projecting it on the
+// path within window meters ahead of the last progress. Returns the progress,
+// and the cross-track error: the distance from the path, positive if the
+// robot is to the left of it.
+func (t *Tracker) Update(position [2]float64, window float64) (progress, crossTrack float64) {
+	if len(t.path.Poses) < 2 {
+		return t.progress, 0
+	}
+
+	distance := math.Inf(1)
+	for i := t.segment; i < len(t.path.Poses)-1 && t.distances[i] <= t.progress+window; i++ {
+		s, e := project(t.path.Poses[i], t.path.Poses[i+1], position)
+		if d := math.Abs(e); d < distance {
+			distance = d
+			t.segment = i
+			progress, crossTrack = math.Max(t.progress, t.distances[i]+s), e
+		}
+	}
+
+	t.progress = progress
+	return
+}
+
+// Get the point at a distance along the path, and the heading of the path
+// there
+func (t *Tracker) PointAt(distance float64) (point [2]float64, heading float64) {
+	poses := t.path.Poses
+	if len(poses) == 0 {
+		return
+	}
+	if len(poses) == 1 {
+		return [2]float64{poses[0][0], poses[0][1]}, poses[0][2]
+	}
+
+	i := 0
+	for i < len(poses)-2 && t.distances[i+1] < distance {
+		i++
+	}
+
+	a, b := poses[i], poses[i+1]
+	length := t.distances[i+1] - t.distances[i]
+	heading = math.Atan2(b[1]-a[1], b[0]-a[0])
+	f := 1.0
+	if length > 0 {
+		f = math.Max(0, math.Min(1, (distance-t.distances[i])/length))
+	}
+
+	return [2]float64{a[0] + f*(b[0]-a[0]), a[1] + f*(b[1]-a[1])}, heading
+}
+
+// Get the curvature of the path from a distance along it to ahead meters
+// further, as the change of heading per meter
+func (t *Tracker) Curvature(distance, ahead float64) float64 {
+	end := math.Min(distance+ahead, t.Length())
+	if end <= distance {
+		return 0
+	}
+
+	// Sum the turns at the poses in between, so turning back and forth
+	// counts too
+	turned := 0.0
+	_, last := t.PointAt(distance)
+	for i := 1; i < len(t.path.Poses)-1 && t.distances[i] < end; i++ {
+		if t.distances[i] <= distance {
+			continue
+		}
+		_, heading := t.PointAt(t.distances[i] + 1e-9)
+		turned += math.Abs(math.Remainder(heading-last, 2*math.Pi))
+		last = heading
+	}
+
+	return turned / (end - distance)
+}
+
+// Project a position on the line segment from a to b. Returns the distance
+// from a along the segment to the projection, within the segment, and the
+// signed distance from the line, positive to the left.
+func project(a, b [3]float64, p [2]float64) (s, e float64) {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return 0, math.Hypot(p[0]-a[0], p[1]-a[1])
+	}
+
+	s = ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / length
+	e = (dx*(p[1]-a[1]) - dy*(p[0]-a[0])) / length
+
+	if s < 0 || s > length {
+		s = math.Max(0, math.Min(length, s))
+		e = math.Copysign(math.Hypot(p[0]-(a[0]+s*dx/length), p[1]-(a[1]+s*dy/length)), e)
+	}
+	return
+}
+
+// Determine if the robot has arrived at the end of the path: it's within
+// tolerance of it, or past it in the direction of the last segment
+func (t *Tracker) Finished(position [2]float64, tolerance float64) bool {
+	poses := t.path.Poses
+	if len(poses) < 2 {
+		return true
+	}
+
+	end, heading := t.PointAt(t.Length())
+	dx, dy := position[0]-end[0], position[1]-end[1]
+	if math.Hypot(dx, dy) < tolerance {
+		return true
+	}
+	return t.progress >= t.Length() && dx*math.Cos(heading)+dy*math.Sin(heading) > 0
+}
+
+// Get the distance left to the end of the path: along the path from the
+// progress, but at least the distance straight to the end
+func (t *Tracker) Remaining(position [2]float64) float64 {
+	end, _ := t.PointAt(t.Length())
+	return math.Max(t.Length()-t.progress, math.Hypot(end[0]-position[0], end[1]-position[1]))
+}
+
+// Publish the point a follower steers towards, with the current segment
+func (t *Tracker) PublishTarget(point [2]float64) {
+	target := telemetry.Target{Segment: t.segment, From: point, To: point, Point: point}
+	if poses := t.path.Poses; t.segment+1 < len(poses) {
+		target.From = [2]float64{poses[t.segment][0], poses[t.segment][1]}
+		target.To = [2]float64{poses[t.segment+1][0], poses[t.segment+1][1]}
+	}
+	telemetry.Publish(telemetry.TARGET, target)
+}
@@ -2,7 +2,6 @@ package astar
 
 import (
 	"container/heap"
-	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -11,24 +10,27 @@ import (
 
 	"robot/config"
 	"robot/logging"
+	"robot/maplayers"
 	"robot/model"
-	"robot/pathplanning/binarymap"
+	"robot/pathplanning"
+	"robot/pathplanning/costmap"
 	"robot/pathplanning/path"
 )
 
 var logger *log.Logger
 
+// Cost of moving through a cell where the robot would hit an obstacle. It's
+// not infinite, so the robot can get away from an obstacle it's too close to.
+const LETHAL_STEP_COST = 1e5
+
 func init() {
 	logger = logging.New()
 }
 
 type AstarPlanner struct {
 
-	// Reference to the original grid map
-	occMap gridmap.OccGridMap
-
-	// Reference to an internal binary map
-	binMap *binarymap.OccGridMapBinary
+	// Costs of the cells
+	costmap *costmap.Costmap
 
 	robot *model.DifferentialWheeledRobot
 
@@ -40,7 +42,7 @@ type AstarPlanner struct {
 	// Start state in world coordinates
 	start [3]float64
 
-	// Maximum index of the binmap
+	// Maximum index of the costmap
 	_maxMapIndex int
 
 	// Neighbours, represented in the shift of index
@@ -48,24 +50,32 @@ type AstarPlanner struct {
 }
 
 // Create and initialize a AstarPlanner from a given gridmap. Create the
-// internal map using a shrink factor, such that the number of cells is
+// costmap using a shrink factor, such that the number of cells is
 // size(gridmap)/shrinkfactor.
 func MakeAstarPlanner(gridMap gridmap.OccGridMap, robot *model.DifferentialWheeledRobot) *AstarPlanner {
+	return MakeAstarPlannerWithKeepOut(gridMap, nil, robot)
+}
+
+// Create an AstarPlanner which treats the cells of gridMap marked in keepOut
+// as obstacles
+func MakeAstarPlannerWithKeepOut(gridMap gridmap.OccGridMap, keepOut *maplayers.Mask, robot *model.DifferentialWheeledRobot) *AstarPlanner {
+	return MakeAstarPlannerWithCostmap(costmap.MakeCostmap(gridMap, keepOut, config.ASTAR_SHRINK_FACTOR), robot)
+}
+
+// Create an AstarPlanner which plans in a costmap made beforehand
+func MakeAstarPlannerWithCostmap(c *costmap.Costmap, robot *model.DifferentialWheeledRobot) *AstarPlanner {
 	a := &AstarPlanner{
-		robot:  robot,
-		occMap: gridMap,
+		robot:   robot,
+		costmap: c,
 	}
-
-	// Prepare an internal binary map representation
-	a.binMap = binarymap.MakeShrunkenBinaryMap(gridMap, config.ASTAR_SHRINK_FACTOR, config.ASTAR_CHECK_RADIUS)
-	a._maxMapIndex = a.binMap.GetSizeX()*a.binMap.GetSizeY() - 1
+	a._maxMapIndex = c.GetSizeX()*c.GetSizeY() - 1
 
 	// Build the neighbours
 	a.neighbours = []int{
-		-a.binMap.GetSizeX(), // North
-		1,                    // East
-		a.binMap.GetSizeX(),  // South
-		-1,                   // West
+		-c.GetSizeX(), // North
+		1,             // East
+		c.GetSizeX(),  // South
+		-1,            // West
 	}
 
 	return a
@@ -134,22 +144,25 @@ func (a *AstarPlanner) PlanPath(from, to [3]float64) (*path.Path, error) {
 		}
 	}
 
-	return nil, errors.New(fmt.Sprintf("No path found to goal. Used %d iterations.", i))
+	if i >= config.ASTAR_MAX_ITERATIONS {
+		return nil, pathplanning.MakePlanningError(pathplanning.ITERATION_LIMIT, i, "No path found to goal within %d iterations.", i)
+	}
+	return nil, pathplanning.MakePlanningError(pathplanning.NO_PATH, i, "No path found to goal. Used %d iterations.", i)
 }
 
 // Set a new goal
 func (a *AstarPlanner) setGoal(goal [3]float64) error {
 	goalPositionWorld := [2]float64{goal[0], goal[1]}
-	goalPositionMap := a.binMap.GetMapCoords(goalPositionWorld)
+	goalPositionMap := a.costmap.GetMapCoords(goalPositionWorld)
 
 	// Check if it's outside the map
-	if a.binMap.PointOutOfMapBounds(goalPositionMap) {
-		return errors.New("Goal out of map bounds")
+	if a.costmap.PointOutOfMapBounds(goalPositionMap) {
+		return pathplanning.MakePlanningError(pathplanning.GOAL_OUT_OF_MAP, 0, "Goal out of map bounds")
 	}
 
-	// Check if it's occupied -- do it in the internal binary map
-	if a.binMap.IsOccupied(int(goalPositionMap[0]), int(goalPositionMap[1])) {
-		return errors.New("Goal is occupied")
+	// Check if it's occupied -- do it in the costmap
+	if a.costmap.IsLethal(int(goalPositionMap[0]), int(goalPositionMap[1])) {
+		return pathplanning.MakePlanningError(pathplanning.GOAL_OCCUPIED, 0, "Goal is occupied")
 	}
 
 	a.goal = goal
@@ -163,8 +176,8 @@ func (a *AstarPlanner) setGoal(goal [3]float64) error {
 
 // Return the corresponding map index for the map pose
 func (a *AstarPlanner) getMapIndex(pose [3]float64) int {
-	mapPose := a.binMap.GetMapCoordsPose(pose)
-	return int(mapPose[0]) + a.binMap.GetSizeX()*int(mapPose[1])
+	mapCoords := a.costmap.GetMapCoords([2]float64{pose[0], pose[1]})
+	return int(mapCoords[0]) + a.costmap.GetSizeX()*int(mapCoords[1])
 }
 
 func (a *AstarPlanner) heuristic(node *Node) float64 {
@@ -174,30 +187,22 @@ func (a *AstarPlanner) heuristic(node *Node) float64 {
 		return 1e9
 	}
 
-	// Check if it's occupied
-	if a.binMap.GetCellByIndex(node.mapIndex).IsOccupied() {
-		return 1e5
-	}
-
-	// Check the Euclidian distance to goal
-	euclidian := a.euclidianDistanceToGoal(node.mapIndex)
-
-	// Get the corresponding cell in the original map
-	coords := a.getCoords(node.mapIndex)
-	occMapCell := a.occMap.GetCell(coords[0]*config.ASTAR_SHRINK_FACTOR, coords[1]*config.ASTAR_SHRINK_FACTOR)
-	if !occMapCell.IsFree() {
-
-		// It's not free, so multiply by UNKNOWN_PUNISH
-		euclidian *= config.ASTAR_UNKNOWN_PUNISH
+	// The Euclidian distance to goal, as moving a cell costs at least 1
+	return a.euclidianDistanceToGoal(node.mapIndex)
+}
 
+// The cost of moving into the cell with the map index, from the costmap
+func (a *AstarPlanner) stepCost(mapIndex int) float64 {
+	cost := costmap.TraversalCost(a.costmap.GetCostByIndex(mapIndex))
+	if math.IsInf(cost, 1) {
+		return LETHAL_STEP_COST
 	}
-
-	return euclidian
+	return cost
 }
 
 // Get the world coordinates of the mapindex
 func (a *AstarPlanner) getCoords(mapIndex int) [2]int {
-	sizeX := a.binMap.GetSizeX()
+	sizeX := a.costmap.GetSizeX()
 
 	y := mapIndex / sizeX
 	x := mapIndex % sizeX
@@ -217,7 +222,7 @@ func (a *AstarPlanner) euclidianDistanceToGoal(mapIndex int) float64 {
 func (a *AstarPlanner) makeNode(parent *Node, shift int) *Node {
 
 	mapIndex := parent.mapIndex + shift
-	g := parent.g + 1
+	g := parent.g + a.stepCost(mapIndex)
 
 	node := &Node{
 		parent:   parent,
@@ -234,17 +239,11 @@ func (a *AstarPlanner) reconstructPath(endNode *Node) *path.Path {
 
 	p := path.MakePath()
 
-	mdp := a.binMap.GetMapDimProperties()
-	offset := mdp.GetTopLeftOffset()
-
 	for current := endNode; current.parent != nil; current = current.parent {
 
 		mapCoords := a.getCoords(current.mapIndex)
-		p.Poses = append(p.Poses, [3]float64{
-			float64(mapCoords[0])*a.binMap.GetCellLength() - offset[0],
-			float64(mapCoords[1])*a.binMap.GetCellLength() - offset[1],
-			0,
-		})
+		worldCoords := a.costmap.GetWorldCoords([2]float64{float64(mapCoords[0]), float64(mapCoords[1])})
+		p.Poses = append(p.Poses, [3]float64{worldCoords[0], worldCoords[1], 0})
 	}
 
 	// Reverse to get first node first
@@ -258,6 +257,14 @@ func (a *AstarPlanner) reconstructPath(endNode *Node) *path.Path {
 	p.Smooth(config.ASTAR_SMOOTHING_DATA_WEIGHT, config.ASTAR_SMOOTHING_SMOOTH_WEIGHT, 0.001)
 	p.Simplify()
 
+	// End at the goal itself rather than the corner of its cell, with the
+	// heading of the goal
+	if len(p.Poses) == 0 {
+		p.Poses = append(p.Poses, a.goal)
+	} else {
+		p.Poses[len(p.Poses)-1] = a.goal
+	}
+
 	pathlen := len(p.Poses)
 
 	logger.Printf("Path length = %v nodes\n\n", pathlen)
@@ -1 +1,66 @@
-package astar
\ No newline at end of file
+package astar
+
+import (
+	"testing"
+
+	"hectormapping/map/maprep"
+
+	"robot/model"
+)
+
+func TestPathEndsAtGoal(t *testing.T) {
+
+	// A free map of 10 x 10 m, with world (0, 0) at cell (0, 0)
+	gridMap := maprep.MakeMapRepMultiMap(0.05, 200, 200, 1, [2]float64{0, 0}).GetGridMap(0)
+	for y := 0; y < gridMap.GetSizeY(); y++ {
+		for x := 0; x < gridMap.GetSizeX(); x++ {
+			gridMap.GetCell(x, y).Set(-2)
+		}
+	}
+	robot := model.MakeDefaultDifferentialWheeledRobot()
+
+	goal := [3]float64{6.02, 3.03, 1.5}
+	p, err := MakeAstarPlanner(gridMap, robot).PlanPath([3]float64{2, 2, 0}, goal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := p.Poses[len(p.Poses)-1]; last != goal {
+		t.Error("Path ends at", last)
+	}
+
+	// A goal in the cell of the start is a path of the goal only
+	goal = [3]float64{2.01, 2.01, -1}
+	p, err = MakeAstarPlanner(gridMap, robot).PlanPath([3]float64{2, 2, 0}, goal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Poses) != 1 || p.Poses[0] != goal {
+		t.Error("Wrong path to the start:", p.Poses)
+	}
+}
+
+func TestKeepClearOfWalls(t *testing.T) {
+
+	// A wall along y = 3 m in a free map of 10 x 10 m
+	gridMap := maprep.MakeMapRepMultiMap(0.05, 200, 200, 1, [2]float64{0, 0}).GetGridMap(0)
+	for y := 0; y < gridMap.GetSizeY(); y++ {
+		for x := 0; x < gridMap.GetSizeX(); x++ {
+			gridMap.GetCell(x, y).Set(-2)
+		}
+	}
+	for x := 0; x < gridMap.GetSizeX(); x++ {
+		gridMap.GetCell(x, 60).Set(2)
+	}
+
+	// Start and goal are inflated by the wall, the path between them isn't
+	p, err := MakeAstarPlanner(gridMap, model.MakeDefaultDifferentialWheeledRobot()).PlanPath([3]float64{2, 3.5, 0}, [3]float64{8, 3.5, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, point := range p.PointsAhead([2]float64{2, 3.5}, 0.05) {
+		if point[0] > 4 && point[0] < 6 && point[1] < 3.7 {
+			t.Error("Path doesn't keep clear of the wall:", p.Poses)
+			break
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"hectormapping/map/gridmap/mapdimensionproperties"
 	"hectormapping/map/gridmap/occbase"
 
+	"robot/maplayers"
 	"robot/tools/intmath"
 )
 
@@ -83,8 +84,9 @@ func (o *OccGridMapBinary) GobDecode(buf []byte) error {
 // The cell radius (in meters) is added in each direction to the area checked
 // for occupancy for each cell in the binary map. In other words, if the
 // OccMap (original map) has any occupied cells within the corresponding cell
-// in the binary map + radius, the binary cell is set occupied.
-func MakeShrunkenBinaryMap(occMap gridmap.OccGridMap, shrinkFactor int, checkRadius float64) *OccGridMapBinary {
+// in the binary map + radius, the binary cell is set occupied. Cells of the
+// occMap marked in keepOut count as occupied. keepOut may be nil.
+func MakeShrunkenBinaryMap(occMap gridmap.OccGridMap, shrinkFactor int, checkRadius float64, keepOut *maplayers.Mask) *OccGridMapBinary {
 	binMap := BinaryMapFromOccGridMap(occMap, shrinkFactor)
 	binMap.ConcreteGridFunctions = MakeGridMapBinaryFunctions()
 
@@ -96,7 +98,7 @@ func MakeShrunkenBinaryMap(occMap gridmap.OccGridMap, shrinkFactor int, checkRad
 	for i := 0; i < binMap.GetSizeX(); i++ {
 		for j := 0; j < binMap.GetSizeY(); j++ {
 
-			if pieceIsFree(occMap, i*shrinkFactor-cellRadius, j*shrinkFactor-cellRadius, shrinkFactor+2*cellRadius) {
+			if pieceIsFree(occMap, keepOut, i*shrinkFactor-cellRadius, j*shrinkFactor-cellRadius, shrinkFactor+2*cellRadius) {
 				binMap.ConcreteGridFunctions.UpdateSetFree(binMap.GetCell(i, j))
 			}
 
@@ -107,8 +109,9 @@ func MakeShrunkenBinaryMap(occMap gridmap.OccGridMap, shrinkFactor int, checkRad
 }
 
 // Check a piece of size size^2 for occupied pixels, starting at (xMin, yMin)
-// for occupied cells. Return true if there are no occupied cells in the piece.
-func pieceIsFree(occMap gridmap.OccGridMap, xMin, yMin, size int) bool {
+// for occupied cells. Return true if there are no occupied or kept out cells
+// in the piece.
+func pieceIsFree(occMap gridmap.OccGridMap, keepOut *maplayers.Mask, xMin, yMin, size int) bool {
 
 	xMin = intmath.Max(xMin, 0)
 	yMin = intmath.Max(yMin, 0)
@@ -118,7 +121,7 @@ func pieceIsFree(occMap gridmap.OccGridMap, xMin, yMin, size int) bool {
 
 	for x := xMin; x < xMax; x++ {
 		for y := yMin; y < yMax; y++ {
-			if occMap.GetCell(x, y).IsOccupied() {
+			if occMap.GetCell(x, y).IsOccupied() || keepOut.IsSet(x, y) {
 				return false
 			}
 		}
@@ -0,0 +1,186 @@
+// Package costmap implements graded cost grids for path planning. Rather than
+// only telling free from occupied, each cell has a cost from 0 to 254, which
+// is high close to obstacles and decays with the distance to them
+// (inflation). Unknown space and keep-out areas have costs too, so planners
+// keep their distance from walls and prefer explored space.
+package costmap
+
+import (
+	"math"
+
+	"hectormapping/map/gridmap"
+
+	"robot/config"
+	"robot/maplayers"
+)
+
+// Costs of cells
+const (
+	FREE_COST         = 0
+	MAX_INFLATED_COST = 252
+
+	// Cells closer to an obstacle than the robot may come, i.e.
+	// ASTAR_CHECK_RADIUS
+	INSCRIBED_COST = 253
+
+	// Obstacles and kept out cells
+	LETHAL_COST = 254
+)
+
+// Costmap is a grid of costs made from an OccGridMap, with cells shrinkFactor
+// times the length of the cells of the OccGridMap
+type Costmap struct {
+
+	// Reference to the original grid map
+	occMap gridmap.OccGridMap
+
+	shrinkFactor int
+	sizeX, sizeY int
+	cellLength   float64
+
+	costs []uint8
+
+	// Update index of the original grid map when the costmap was made
+	updateIndex int
+}
+
+// Make a costmap of occMap with cells shrinkFactor times the length of the
+// cells of occMap. A cell is an obstacle if any of the cells of occMap it
+// covers is occupied or marked in keepOut, and unknown if none of them is
+// free. keepOut may be nil.
+func MakeCostmap(occMap gridmap.OccGridMap, keepOut *maplayers.Mask, shrinkFactor int) *Costmap {
+	c := &Costmap{
+		occMap:       occMap,
+		shrinkFactor: shrinkFactor,
+		sizeX:        occMap.GetSizeX() / shrinkFactor,
+		sizeY:        occMap.GetSizeY() / shrinkFactor,
+		cellLength:   occMap.GetCellLength() * float64(shrinkFactor),
+		updateIndex:  occMap.GetUpdateIndex(),
+	}
+
+	obstacles := make([]bool, c.sizeX*c.sizeY)
+	unknown := make([]bool, c.sizeX*c.sizeY)
+	for y := 0; y < c.sizeY; y++ {
+		for x := 0; x < c.sizeX; x++ {
+			obstacles[y*c.sizeX+x], unknown[y*c.sizeX+x] = c.cellState(keepOut, x, y)
+		}
+	}
+
+	distances := distanceTransform(obstacles, c.sizeX, c.sizeY)
+
+	c.costs = make([]uint8, c.sizeX*c.sizeY)
+	for i := range c.costs {
+		cost := inflationCost(distances[i] * c.cellLength)
+		if unknown[i] && cost < config.COSTMAP_UNKNOWN_COST {
+			cost = config.COSTMAP_UNKNOWN_COST
+		}
+		c.costs[i] = uint8(cost)
+	}
+
+	return c
+}
+
+// Determine if cell (x, y) is an obstacle, or if it's unknown
+func (c *Costmap) cellState(keepOut *maplayers.Mask, x, y int) (obstacle, unknown bool) {
+	unknown = true
+	for i := x * c.shrinkFactor; i < (x+1)*c.shrinkFactor; i++ {
+		for j := y * c.shrinkFactor; j < (y+1)*c.shrinkFactor; j++ {
+			cell := c.occMap.GetCell(i, j)
+			if cell.IsOccupied() || keepOut.IsSet(i, j) {
+				return true, false
+			}
+			if cell.IsFree() {
+				unknown = false
+			}
+		}
+	}
+	return false, unknown
+}
+
+// Get the cost of a cell at distance meters from the closest obstacle
+func inflationCost(distance float64) int {
+	switch {
+	case distance == 0:
+		return LETHAL_COST
+	case distance < config.ASTAR_CHECK_RADIUS:
+		return INSCRIBED_COST
+	case distance > config.COSTMAP_INFLATION_RADIUS:
+		return FREE_COST
+	}
+	return int(MAX_INFLATED_COST * math.Exp(-config.COSTMAP_COST_SCALING*(distance-config.ASTAR_CHECK_RADIUS)))
+}
+
+func (c *Costmap) GetSizeX() int {
+	return c.sizeX
+}
+
+func (c *Costmap) GetSizeY() int {
+	return c.sizeY
+}
+
+func (c *Costmap) GetCellLength() float64 {
+	return c.cellLength
+}
+
+// Get the update index the original grid map had when the costmap was made
+func (c *Costmap) GetUpdateIndex() int {
+	return c.updateIndex
+}
+
+// Get the cost of cell (x, y). Cells outside the map are lethal.
+func (c *Costmap) GetCost(x, y int) uint8 {
+	if x < 0 || y < 0 || x >= c.sizeX || y >= c.sizeY {
+		return LETHAL_COST
+	}
+	return c.costs[y*c.sizeX+x]
+}
+
+// Get the cost of the cell with index y*sizeX + x
+func (c *Costmap) GetCostByIndex(index int) uint8 {
+	if index < 0 || index >= len(c.costs) {
+		return LETHAL_COST
+	}
+	return c.costs[index]
+}
+
+// Determine if the robot would hit an obstacle in cell (x, y)
+func (c *Costmap) IsLethal(x, y int) bool {
+	return c.GetCost(x, y) >= INSCRIBED_COST
+}
+
+// Get the cost of moving a distance through a cell with cost, relative to
+// moving the same distance through a free cell. It's infinite for lethal
+// cells.
+func TraversalCost(cost uint8) float64 {
+	if cost >= INSCRIBED_COST {
+		return math.Inf(1)
+	}
+	return 1 + config.COSTMAP_WEIGHT*float64(cost)/MAX_INFLATED_COST
+}
+
+// Get the map coordinates of the costmap for the world coordinates
+func (c *Costmap) GetMapCoords(worldCoords [2]float64) [2]float64 {
+	mapCoords := c.occMap.GetMapCoords(worldCoords)
+	return [2]float64{mapCoords[0] / float64(c.shrinkFactor), mapCoords[1] / float64(c.shrinkFactor)}
+}
+
+// Get the world coordinates for the map coordinates of the costmap
+func (c *Costmap) GetWorldCoords(mapCoords [2]float64) [2]float64 {
+	return c.occMap.GetWorldCoords([2]float64{mapCoords[0] * float64(c.shrinkFactor), mapCoords[1] * float64(c.shrinkFactor)})
+}
+
+// Determine if map coordinates of the costmap are outside of it
+func (c *Costmap) PointOutOfMapBounds(mapCoords [2]float64) bool {
+	return mapCoords[0] < 0 || mapCoords[1] < 0 ||
+		mapCoords[0] >= float64(c.sizeX) || mapCoords[1] >= float64(c.sizeY)
+}
+
+// Get the index of the cell with the world coordinates, y*sizeX + x. It's -1
+// outside the map.
+func (c *Costmap) GetIndex(worldCoords [2]float64) int {
+	mapCoords := c.GetMapCoords(worldCoords)
+	if c.PointOutOfMapBounds(mapCoords) {
+		return -1
+	}
+	return int(mapCoords[0]) + c.sizeX*int(mapCoords[1])
+}
@@ -0,0 +1,132 @@
+package costmap
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"hectormapping/map/gridmap"
+	"hectormapping/map/maprep"
+
+	"robot/config"
+	"robot/maplayers"
+)
+
+// A free map of 10 x 10 m, with world (0, 0) at cell (0, 0)
+func makeFreeMap() gridmap.OccGridMap {
+	gridMap := maprep.MakeMapRepMultiMap(0.05, 200, 200, 1, [2]float64{0, 0}).GetGridMap(0)
+	for y := 0; y < gridMap.GetSizeY(); y++ {
+		for x := 0; x < gridMap.GetSizeX(); x++ {
+			gridMap.GetCell(x, y).Set(-2)
+		}
+	}
+	return gridMap
+}
+
+// Get the cost at the world coordinates
+func costAt(c *Costmap, x, y float64) uint8 {
+	mapCoords := c.GetMapCoords([2]float64{x, y})
+	return c.GetCost(int(mapCoords[0]), int(mapCoords[1]))
+}
+
+func TestDistanceTransform(t *testing.T) {
+	sizeX, sizeY := 37, 23
+	obstacles := make([]bool, sizeX*sizeY)
+	for i := range obstacles {
+		obstacles[i] = rand.Intn(40) == 0
+	}
+
+	distances := distanceTransform(obstacles, sizeX, sizeY)
+	for i, distance := range distances {
+		closest := math.Inf(1)
+		for j, obstacle := range obstacles {
+			if obstacle {
+				dx, dy := float64(i%sizeX-j%sizeX), float64(i/sizeX-j/sizeX)
+				closest = math.Min(closest, math.Hypot(dx, dy))
+			}
+		}
+		if math.Abs(distance-closest) > 1e-9 {
+			t.Fatalf("Distance of cell %d is %f, not %f", i, distance, closest)
+		}
+	}
+
+	for _, distance := range distanceTransform(make([]bool, 6), 3, 2) {
+		if !math.IsInf(distance, 1) {
+			t.Error("Finite distance without obstacles:", distance)
+		}
+	}
+}
+
+func TestMakeCostmap(t *testing.T) {
+	gridMap := makeFreeMap()
+
+	// A wall at x = 5 m and an unknown area at x > 8 m
+	for y := 0; y < gridMap.GetSizeY(); y++ {
+		gridMap.GetCell(100, y).Set(2)
+		for x := 160; x < gridMap.GetSizeX(); x++ {
+			gridMap.GetCell(x, y).Set(0)
+		}
+	}
+
+	// A keep-out area around (2, 2)
+	keepOut := maplayers.MakeMask(gridMap.GetSizeX(), gridMap.GetSizeY())
+	keepOut.Set(40, 40)
+
+	c := MakeCostmap(gridMap, keepOut, 2)
+	if c.GetSizeX() != 100 || c.GetCellLength() != 0.1 {
+		t.Fatal("Wrong size of costmap:", c.GetSizeX(), c.GetCellLength())
+	}
+
+	if cost := costAt(c, 5.02, 5); cost != LETHAL_COST {
+		t.Error("Wall has cost", cost)
+	}
+	if cost := costAt(c, 2.02, 2.02); cost != LETHAL_COST {
+		t.Error("Keep-out area has cost", cost)
+	}
+	if cost := costAt(c, 5.02+config.ASTAR_CHECK_RADIUS-0.1, 5); cost != INSCRIBED_COST {
+		t.Error("Cell close to the wall has cost", cost)
+	}
+	if cost := costAt(c, 5.02+config.COSTMAP_INFLATION_RADIUS+0.1, 5); cost != FREE_COST {
+		t.Error("Cell far from the wall has cost", cost)
+	}
+	if cost := costAt(c, 9, 5); int(cost) != config.COSTMAP_UNKNOWN_COST {
+		t.Error("Unknown cell has cost", cost)
+	}
+
+	// Costs decay away from the wall
+	last := uint8(LETHAL_COST)
+	for x := 5.02; x < 5.02+config.COSTMAP_INFLATION_RADIUS; x += 0.1 {
+		cost := costAt(c, x, 5)
+		if cost > last {
+			t.Errorf("Cost rises from %d to %d at %.2f m", last, cost, x)
+		}
+		last = cost
+	}
+
+	if !c.IsLethal(-1, 0) || c.GetCostByIndex(c.GetSizeX()*c.GetSizeY()) != LETHAL_COST {
+		t.Error("Cells outside the map aren't lethal")
+	}
+	if !math.IsInf(TraversalCost(INSCRIBED_COST), 1) || TraversalCost(FREE_COST) != 1 {
+		t.Error("Wrong traversal costs")
+	}
+}
+
+func TestGetTile(t *testing.T) {
+	gridMap := makeFreeMap()
+	gridMap.GetCell(0, 0).Set(2)
+	c := MakeCostmap(gridMap, nil, 2)
+
+	// At zoom level 0 the tile covers the map, with y flipped
+	im := c.GetTile(0, 0, 0)
+	if _, _, _, a := im.At(0, 255).RGBA(); a == 0 {
+		t.Error("Obstacle not drawn")
+	}
+	if _, _, _, a := im.At(128, 128).RGBA(); a != 0 {
+		t.Error("Free cell drawn")
+	}
+
+	// The obstacle is in the bottom left tile at zoom level 1
+	if _, _, _, a := c.GetTile(1, 0, 1).At(0, 255).RGBA(); a == 0 {
+		t.Error("Obstacle not drawn at zoom level 1")
+	}
+}
@@ -0,0 +1,100 @@
+package costmap
+
+import "math"
+
+// Compute the Euclidean distance, in cells, from each cell of a sizeX x sizeY
+// grid to the closest obstacle, by the algorithm of Felzenszwalb and
+// Huttenlocher, "Distance Transforms of Sampled Functions". The distances
+// are infinite if there are no obstacles.
+func distanceTransform(obstacles []bool, sizeX, sizeY int) []float64 {
+	squared := make([]float64, sizeX*sizeY)
+	for i, obstacle := range obstacles {
+		if obstacle {
+			squared[i] = 0
+		} else {
+			squared[i] = math.Inf(1)
+		}
+	}
+
+	// Transform the columns, then the rows
+	n := sizeX
+	if sizeY > n {
+		n = sizeY
+	}
+	f := make([]float64, n)
+	d := make([]float64, n)
+	v := make([]int, n)
+	z := make([]float64, n+1)
+
+	for x := 0; x < sizeX; x++ {
+		for y := 0; y < sizeY; y++ {
+			f[y] = squared[y*sizeX+x]
+		}
+		transform1D(f[:sizeY], d, v, z)
+		for y := 0; y < sizeY; y++ {
+			squared[y*sizeX+x] = d[y]
+		}
+	}
+
+	for y := 0; y < sizeY; y++ {
+		copy(f, squared[y*sizeX:(y+1)*sizeX])
+		transform1D(f[:sizeX], d, v, z)
+		copy(squared[y*sizeX:(y+1)*sizeX], d[:sizeX])
+	}
+
+	for i := range squared {
+		squared[i] = math.Sqrt(squared[i])
+	}
+
+	return squared
+}
+
+// Squared distance transform of f in one dimension, into d, as the lower
+// envelope of the parabolas rooted at the finite values of f. v and z hold
+// the parabolas of the envelope and their boundaries.
+func transform1D(f, d []float64, v []int, z []float64) {
+	n := len(f)
+
+	// Index of the rightmost parabola in the envelope
+	k := -1
+	for q := 0; q < n; q++ {
+		if math.IsInf(f[q], 1) {
+			continue
+		}
+		for k >= 0 {
+			s := intersection(f, v[k], q)
+			if s > z[k] {
+				z[k+1] = s
+				break
+			}
+			k--
+		}
+		if k < 0 {
+			z[0] = math.Inf(-1)
+		}
+		k++
+		v[k] = q
+		z[k+1] = math.Inf(1)
+	}
+
+	if k < 0 {
+		for q := range d[:n] {
+			d[q] = math.Inf(1)
+		}
+		return
+	}
+
+	k = 0
+	for q := 0; q < n; q++ {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		dq := float64(q - v[k])
+		d[q] = dq*dq + f[v[k]]
+	}
+}
+
+// Position where the parabolas rooted at p and q intersect
+func intersection(f []float64, p, q int) float64 {
+	return ((f[q] + float64(q*q)) - (f[p] + float64(p*p))) / float64(2*q-2*p)
+}
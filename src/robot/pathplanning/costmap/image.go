@@ -0,0 +1,58 @@
+package costmap
+
+import (
+	"image"
+	"image/color"
+
+	"hectormapping/map/mapimages"
+
+	"robot/tools/intmath"
+)
+
+var lethalColor = color.NRGBA{64, 0, 64, 255}
+var inscribedColor = color.NRGBA{160, 0, 160, 224}
+
+// Get the colour of a cost, transparent for free cells and going from blue
+// to red with the cost
+func costColor(cost uint8) color.NRGBA {
+	switch {
+	case cost == LETHAL_COST:
+		return lethalColor
+	case cost == INSCRIBED_COST:
+		return inscribedColor
+	case cost == FREE_COST:
+		return color.NRGBA{}
+	}
+	scaled := int(cost) * 255 / MAX_INFLATED_COST
+	return color.NRGBA{uint8(scaled), 0, uint8(255 - scaled), uint8(64 + scaled*3/4)}
+}
+
+// GetTile produces a debug image of the costs, of the size of a map tile, to
+// be drawn on top of the map tile at zoomLevel, tileX and tileY
+func (c *Costmap) GetTile(zoomLevel uint, tileX, tileY int) image.Image {
+	im := image.NewNRGBA(image.Rect(0, 0, mapimages.TILE_SIZE, mapimages.TILE_SIZE))
+
+	// The tile covers the same cells of the original map at all levels of
+	// the map
+	numTiles := 1 << zoomLevel
+	cellsPerTile := float64(intmath.Max(c.occMap.GetSizeX(), c.occMap.GetSizeY())) / float64(numTiles)
+	startX := float64(tileX) * cellsPerTile
+	startY := float64(numTiles-1-tileY) * cellsPerTile
+	stepSize := cellsPerTile / mapimages.TILE_SIZE / float64(c.shrinkFactor)
+
+	for j := 0; j < mapimages.TILE_SIZE; j++ {
+		y := int(startY/float64(c.shrinkFactor) + (float64(j)+0.5)*stepSize)
+		if y < 0 || y >= c.sizeY {
+			continue
+		}
+		for i := 0; i < mapimages.TILE_SIZE; i++ {
+			x := int(startX/float64(c.shrinkFactor) + (float64(i)+0.5)*stepSize)
+			if x < 0 || x >= c.sizeX {
+				continue
+			}
+			im.SetNRGBA(i, mapimages.TILE_SIZE-1-j, costColor(c.costs[y*c.sizeX+x]))
+		}
+	}
+
+	return im
+}
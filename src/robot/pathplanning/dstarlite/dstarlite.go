@@ -0,0 +1,395 @@
+// Package dstarlite implements D* Lite, an incremental path planner for
+// replanning while following a path.
+//
+// D* Lite searches from the goal towards the start, in the same costmap as the
+// other planners. When cells of the costmap change, or the robot has moved,
+// the next search repairs the previous one instead of starting over, so
+// replanning around a new obstacle only touches the cells affected by it.
+//
+// See S. Koenig and M. Likhachev, D* Lite, AAAI 2002.
+package dstarlite
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"robot/config"
+	"robot/logging"
+	"robot/pathplanning/costmap"
+	"robot/pathplanning/path"
+)
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+var inf = math.Inf(1)
+
+// The 8 neighbours of a cell, and the length of the edges to them in cells
+var neighbours = [8][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}, {1, 1}, {-1, 1}, {-1, -1}, {1, -1}}
+var neighbourLengths = [8]float64{1, 1, 1, 1, math.Sqrt2, math.Sqrt2, math.Sqrt2, math.Sqrt2}
+
+type DStarLitePlanner struct {
+	costmap      *costmap.Costmap
+	sizeX, sizeY int
+
+	// The cost of entering each cell of the costmap, infinite for lethal
+	// cells
+	cost []float64
+
+	// Cost to the goal of each cell, and the one-step lookahead of it
+	g   []float64
+	rhs []float64
+
+	queue *cellQueue
+
+	// Added to the keys when the start moves, so the queue can be kept
+	km float64
+
+	// Map indices of the start and goal, and of the start when the keys were
+	// last modified. goal is -1 before the first search.
+	start, goal, last int
+
+	// Number of cells expanded by the searches so far
+	expanded int
+}
+
+// Make a D* Lite planner for a costmap
+func MakeDStarLitePlanner(c *costmap.Costmap) *DStarLitePlanner {
+	d := &DStarLitePlanner{goal: -1}
+	d.setCostmap(c)
+	return d
+}
+
+// Set the costmap and the costs of all its cells, forgetting the last search
+func (d *DStarLitePlanner) setCostmap(c *costmap.Costmap) {
+	d.costmap = c
+	d.sizeX, d.sizeY = c.GetSizeX(), c.GetSizeY()
+
+	d.cost = make([]float64, d.sizeX*d.sizeY)
+	for y := 0; y < d.sizeY; y++ {
+		for x := 0; x < d.sizeX; x++ {
+			d.cost[y*d.sizeX+x] = d.cellCost(x, y)
+		}
+	}
+	d.goal = -1
+}
+
+// Plan a path. The search is reused if the goal is the same as in the last
+// call, repairing it for the cells changed by UpdatePoints.
+func (d *DStarLitePlanner) PlanPath(from, to [3]float64) (*path.Path, error) {
+	start, err := d.getMapIndex(from)
+	if err != nil {
+		return nil, errors.New("Start out of map bounds")
+	}
+	goal, err := d.getMapIndex(to)
+	if err != nil {
+		return nil, errors.New("Goal out of map bounds")
+	}
+	if math.IsInf(d.cost[goal], 1) {
+		return nil, errors.New("Goal is occupied")
+	}
+
+	if goal != d.goal {
+		d.reset(goal, start)
+	} else {
+		d.km += d.heuristic(d.last, start)
+		d.start, d.last = start, start
+	}
+
+	// Cells off the paths checked so far may have changed in the map, so
+	// check the cells around the path found and search again until the path
+	// is clear
+	expanded := d.expanded
+	var cells []int
+	for {
+		if err := d.computeShortestPath(); err != nil {
+			return nil, err
+		}
+		if math.IsInf(d.g[start], 1) {
+			return nil, errors.New("No path found to goal.")
+		}
+
+		cells, err = d.extractCells()
+		if err != nil {
+			return nil, err
+		}
+		if !d.refreshCells(d.withNeighbours(cells)) {
+			break
+		}
+	}
+	logger.Printf("Planned a D* Lite path to the goal, expanding %d cells.\n", d.expanded-expanded)
+
+	return d.makePath(from, to, cells), nil
+}
+
+// Refresh the cells under the given points, in world coordinates, from c,
+// which is usually a costmap of the map the planner was made for after SLAM
+// has updated it. The next search is repaired for the cells which changed.
+// Returns true if any of the points are in a cell which has become lethal.
+//
+// Cells which were already lethal don't count, as smoothed paths may cut the
+// corners of them. If c has another size than the last costmap, e.g. as the
+// map has grown, the next search starts over.
+func (d *DStarLitePlanner) UpdatePoints(c *costmap.Costmap, points [][2]float64) bool {
+	if c.GetSizeX() != d.sizeX || c.GetSizeY() != d.sizeY {
+		d.setCostmap(c)
+	} else {
+		d.costmap = c
+	}
+
+	cells := make([]int, 0, len(points))
+	wasFree := make([]bool, 0, len(points))
+	for _, p := range points {
+		if index, err := d.getMapIndex([3]float64{p[0], p[1], 0}); err == nil {
+			cells = append(cells, index)
+			wasFree = append(wasFree, !math.IsInf(d.cost[index], 1))
+		}
+	}
+	d.refreshCells(cells)
+
+	for i, index := range cells {
+		if wasFree[i] && math.IsInf(d.cost[index], 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh cells from the costmap, and update the search for those which
+// changed. The neighbours of changed cells are refreshed too, so
+// a new obstacle is found as a whole. Returns true if any cell changed.
+func (d *DStarLitePlanner) refreshCells(cells []int) bool {
+	changed := false
+	refreshed := make(map[int]bool)
+	for len(cells) > 0 {
+		index := cells[len(cells)-1]
+		cells = cells[:len(cells)-1]
+		if refreshed[index] {
+			continue
+		}
+		refreshed[index] = true
+		x, y := index%d.sizeX, index/d.sizeX
+
+		cost := d.cellCost(x, y)
+		if cost == d.cost[index] {
+			continue
+		}
+		d.cost[index] = cost
+		changed = true
+
+		// The cost of the edges into the cell, and of the diagonal edges
+		// past it, changed for its neighbours
+		neighbours := d.getNeighbours(index)
+		if d.goal >= 0 {
+			d.updateVertex(index)
+			for _, n := range neighbours {
+				d.updateVertex(n)
+			}
+		}
+		cells = append(cells, neighbours...)
+	}
+	return changed
+}
+
+// Get the cost of entering cell (x, y) of the costmap, weighted as in the
+// other planners
+func (d *DStarLitePlanner) cellCost(x, y int) float64 {
+	return costmap.TraversalCost(d.costmap.GetCost(x, y))
+}
+
+// Start a new search towards a goal
+func (d *DStarLitePlanner) reset(goal, start int) {
+	d.g = make([]float64, len(d.cost))
+	d.rhs = make([]float64, len(d.cost))
+	for i := range d.g {
+		d.g[i], d.rhs[i] = inf, inf
+	}
+
+	d.queue = makeCellQueue()
+	d.km = 0
+	d.goal, d.start, d.last = goal, start, start
+
+	d.rhs[goal] = 0
+	d.queue.insert(goal, d.calculateKey(goal))
+}
+
+// Expand cells until the cost of the start is known. Cells with the same key
+// as the start are expanded too, as the path may go through them.
+func (d *DStarLitePlanner) computeShortestPath() error {
+	for i := 0; ; i++ {
+		u, kOld, ok := d.queue.top()
+		if !ok || (d.calculateKey(d.start).less(kOld) && d.rhs[d.start] == d.g[d.start]) {
+			return nil
+		}
+		if i >= config.ASTAR_MAX_ITERATIONS {
+			return errors.New(fmt.Sprintf("No path found to goal. Used %d iterations.", i))
+		}
+		d.expanded++
+
+		kNew := d.calculateKey(u)
+		switch {
+		case kOld.less(kNew):
+			d.queue.insert(u, kNew)
+
+		case d.g[u] > d.rhs[u]:
+			d.g[u] = d.rhs[u]
+			d.queue.remove(u)
+			for _, n := range d.getNeighbours(u) {
+				d.updateVertex(n)
+			}
+
+		default:
+			d.g[u] = inf
+			d.updateVertex(u)
+			for _, n := range d.getNeighbours(u) {
+				d.updateVertex(n)
+			}
+		}
+	}
+}
+
+// Recompute the lookahead of a cell, and queue it if it's inconsistent
+func (d *DStarLitePlanner) updateVertex(u int) {
+	if u != d.goal {
+		d.rhs[u] = inf
+		for i, n := range d.getNeighbourSlots(u) {
+			if n >= 0 {
+				d.rhs[u] = math.Min(d.rhs[u], d.edgeCost(u, n, i)+d.g[n])
+			}
+		}
+	}
+
+	d.queue.remove(u)
+	if d.g[u] != d.rhs[u] {
+		d.queue.insert(u, d.calculateKey(u))
+	}
+}
+
+func (d *DStarLitePlanner) calculateKey(u int) key {
+	k := math.Min(d.g[u], d.rhs[u])
+	return key{k + d.heuristic(d.start, u) + d.km, k}
+}
+
+// Octile distance between two cells, which never overestimates the cost
+func (d *DStarLitePlanner) heuristic(a, b int) float64 {
+	dx := math.Abs(float64(a%d.sizeX - b%d.sizeX))
+	dy := math.Abs(float64(a/d.sizeX - b/d.sizeX))
+	return math.Max(dx, dy) + (math.Sqrt2-1)*math.Min(dx, dy)
+}
+
+// Get the cost of moving from cell u to its neighbour n in slot i of
+// neighbours. Diagonal moves can't cut the corners of occupied cells.
+func (d *DStarLitePlanner) edgeCost(u, n, i int) float64 {
+	if i >= 4 {
+		x, y := u%d.sizeX, u/d.sizeX
+		if math.IsInf(d.cost[y*d.sizeX+x+neighbours[i][0]], 1) ||
+			math.IsInf(d.cost[(y+neighbours[i][1])*d.sizeX+x], 1) {
+			return inf
+		}
+	}
+	return neighbourLengths[i] * d.cost[n]
+}
+
+// Get the indices of the neighbours of a cell by slot of neighbours, -1 for
+// those outside the map
+func (d *DStarLitePlanner) getNeighbourSlots(u int) [8]int {
+	x, y := u%d.sizeX, u/d.sizeX
+
+	var slots [8]int
+	for i, shift := range neighbours {
+		nx, ny := x+shift[0], y+shift[1]
+		if nx < 0 || ny < 0 || nx >= d.sizeX || ny >= d.sizeY {
+			slots[i] = -1
+		} else {
+			slots[i] = ny*d.sizeX + nx
+		}
+	}
+	return slots
+}
+
+// Get the indices of the neighbours of a cell inside the map
+func (d *DStarLitePlanner) getNeighbours(u int) []int {
+	all := d.getNeighbourSlots(u)
+	inside := make([]int, 0, 8)
+	for _, n := range all {
+		if n >= 0 {
+			inside = append(inside, n)
+		}
+	}
+	return inside
+}
+
+// Get the cells of the path, following the cheapest neighbours from the start
+// to the goal
+func (d *DStarLitePlanner) extractCells() ([]int, error) {
+	cells := []int{d.start}
+	visited := map[int]bool{d.start: true}
+
+	for u := d.start; u != d.goal; {
+		next, best := -1, inf
+		for i, n := range d.getNeighbourSlots(u) {
+			if n < 0 {
+				continue
+			}
+			if c := d.edgeCost(u, n, i) + d.g[n]; c < best {
+				next, best = n, c
+			}
+		}
+		if next < 0 || visited[next] {
+			return nil, errors.New("No path found to goal.")
+		}
+		u = next
+		visited[u] = true
+		cells = append(cells, u)
+	}
+
+	return cells, nil
+}
+
+// Get cells and their neighbours, each once
+func (d *DStarLitePlanner) withNeighbours(cells []int) []int {
+	seen := make(map[int]bool)
+	all := make([]int, 0, 9*len(cells))
+	for _, u := range cells {
+		for _, n := range append(d.getNeighbours(u), u) {
+			if !seen[n] {
+				seen[n] = true
+				all = append(all, n)
+			}
+		}
+	}
+	return all
+}
+
+// Make a path through the centers of the cells of a path, from the start
+// pose to the goal pose
+func (d *DStarLitePlanner) makePath(from, to [3]float64, cells []int) *path.Path {
+	p := path.MakePath()
+	p.Poses = append(p.Poses, from)
+
+	// The start and goal poses replace the centers of their cells
+	for _, u := range cells[1 : len(cells)-1] {
+		center := d.costmap.GetWorldCoords([2]float64{float64(u%d.sizeX) + 0.5, float64(u/d.sizeX) + 0.5})
+		p.Poses = append(p.Poses, [3]float64{center[0], center[1], 0})
+	}
+	p.Poses = append(p.Poses, to)
+
+	p.Smooth(config.ASTAR_SMOOTHING_DATA_WEIGHT, config.ASTAR_SMOOTHING_SMOOTH_WEIGHT, 0.001)
+	p.Simplify()
+
+	return p
+}
+
+// Get the index of the cell of the costmap a pose in world coordinates is in
+func (d *DStarLitePlanner) getMapIndex(pose [3]float64) (int, error) {
+	mapCoords := d.costmap.GetMapCoords([2]float64{pose[0], pose[1]})
+	if d.costmap.PointOutOfMapBounds(mapCoords) {
+		return 0, errors.New("Out of map bounds.")
+	}
+	return int(mapCoords[1])*d.sizeX + int(mapCoords[0]), nil
+}
@@ -0,0 +1,138 @@
+package dstarlite
+
+import (
+	"math"
+	"testing"
+
+	"hectormapping/map/gridmap"
+	"hectormapping/map/maprep"
+
+	"robot/config"
+	"robot/pathplanning/costmap"
+	"robot/pathplanning/path"
+)
+
+// A free map of 10 x 10 m, with world (0, 0) at cell (0, 0)
+func makeFreeMap() gridmap.OccGridMap {
+	gridMap := maprep.MakeMapRepMultiMap(0.05, 200, 200, 1, [2]float64{0, 0}).GetGridMap(0)
+	for y := 0; y < gridMap.GetSizeY(); y++ {
+		for x := 0; x < gridMap.GetSizeX(); x++ {
+			gridMap.GetCell(x, y).Set(-2)
+		}
+	}
+	return gridMap
+}
+
+// Make a costmap of a map as the planners do
+func makeCostmap(gridMap gridmap.OccGridMap) *costmap.Costmap {
+	return costmap.MakeCostmap(gridMap, nil, config.ASTAR_SHRINK_FACTOR)
+}
+
+// Occupy the cells of a wall from (x, y0) to (x, y1) in world coordinates
+func addWall(gridMap gridmap.OccGridMap, x, y0, y1 float64) {
+	for y := y0; y <= y1; y += 0.025 {
+		c := gridMap.GetMapCoords([2]float64{x, y})
+		gridMap.GetCell(int(c[0]), int(c[1])).Set(2)
+	}
+}
+
+// Determine if a path passes closer than distance to the wall at x from y0
+// to y1
+func crossesWall(p *path.Path, x, y0, y1, distance float64) bool {
+	for _, point := range p.PointsAhead([2]float64{p.Poses[0][0], p.Poses[0][1]}, 0.01) {
+		if math.Abs(point[0]-x) < distance && point[1] > y0-distance && point[1] < y1+distance {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPlanPath(t *testing.T) {
+	gridMap := makeFreeMap()
+	addWall(gridMap, 5, 2, 8)
+	d := MakeDStarLitePlanner(makeCostmap(gridMap))
+
+	from, to := [3]float64{2, 5, 0}, [3]float64{8, 5, 1}
+	p, err := d.PlanPath(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Poses[0] != from || p.Poses[len(p.Poses)-1] != to {
+		t.Error("Path doesn't go from start to goal:", p.Poses)
+	}
+	if crossesWall(p, 5, 2, 8, 0.25) {
+		t.Error("Path goes through the wall:", p.Poses)
+	}
+
+	if _, err := d.PlanPath(from, [3]float64{5, 5, 0}); err == nil {
+		t.Error("Planned a path into the wall")
+	}
+	if _, err := d.PlanPath(from, [3]float64{20, 5, 0}); err == nil {
+		t.Error("Planned a path out of the map")
+	}
+}
+
+func TestCellCosts(t *testing.T) {
+	gridMap := makeFreeMap()
+	addWall(gridMap, 5, 2, 8)
+	c := makeCostmap(gridMap)
+	d := MakeDStarLitePlanner(c)
+
+	// Cells cost as much as in the other planners, including the inflation
+	// around the wall
+	for i := range d.cost {
+		if want := costmap.TraversalCost(c.GetCostByIndex(i)); d.cost[i] != want {
+			t.Fatalf("Cell %d costs %v, expected %v", i, d.cost[i], want)
+		}
+	}
+}
+
+func TestReplan(t *testing.T) {
+
+	// A long wall to go around, so the first search covers much of the map
+	gridMap := makeFreeMap()
+	addWall(gridMap, 5, 0, 8)
+	d := MakeDStarLitePlanner(makeCostmap(gridMap))
+
+	from, to := [3]float64{2, 1, 0}, [3]float64{8, 1, 0}
+	p, err := d.PlanPath(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changed along the path
+	if d.UpdatePoints(makeCostmap(gridMap), p.PointsAhead([2]float64{2, 1}, 0.05)) {
+		t.Error("Free path is blocked")
+	}
+
+	// After the robot has moved a bit, an obstacle appears on the path a
+	// meter in front of it
+	ahead := p.PointsAhead([2]float64{2, 1}, 0.05)
+	position, obstacle := ahead[20], ahead[40]
+	addWall(gridMap, obstacle[0]-0.1, obstacle[1]-0.1, obstacle[1]+0.1)
+	addWall(gridMap, obstacle[0]+0.1, obstacle[1]-0.1, obstacle[1]+0.1)
+	if !d.UpdatePoints(makeCostmap(gridMap), p.PointsAhead(position, 0.05)) {
+		t.Fatal("Blocked path is free")
+	}
+
+	expanded := d.expanded
+	p, err = d.PlanPath([3]float64{position[0], position[1], 0}, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crossesWall(p, obstacle[0], obstacle[1]-0.1, obstacle[1]+0.1, 0.1) {
+		t.Error("Replanned path goes through the obstacle:", p.Poses)
+	}
+	if crossesWall(p, 5, 0, 8, 0.25) {
+		t.Error("Replanned path goes through the wall:", p.Poses)
+	}
+
+	// The search is repaired rather than done again
+	fresh := MakeDStarLitePlanner(makeCostmap(gridMap))
+	if _, err := fresh.PlanPath([3]float64{position[0], position[1], 0}, to); err != nil {
+		t.Fatal(err)
+	}
+	if d.expanded-expanded >= fresh.expanded/2 {
+		t.Errorf("Replanning expanded %d cells, planning again %d", d.expanded-expanded, fresh.expanded)
+	}
+}
@@ -0,0 +1,89 @@
+package dstarlite
+
+import (
+	"container/heap"
+	"math"
+)
+
+// The key of a cell in the queue, compared lexicographically
+type key [2]float64
+
+// Keys closer than this are equal, as costs summed in different orders differ
+// by rounding
+const epsilon = 1e-9
+
+func (k key) less(other key) bool {
+	if math.Abs(k[0]-other[0]) > epsilon {
+		return k[0] < other[0]
+	}
+	return k[1] < other[1]-epsilon
+}
+
+type entry struct {
+	index int
+	key   key
+}
+
+// Priority queue of cells by key. Cells are removed or given new keys by
+// marking their old entries as stale, which are skipped when met.
+type cellQueue struct {
+	entries []entry
+
+	// The current key of each queued cell
+	queued map[int]key
+}
+
+func makeCellQueue() *cellQueue {
+	return &cellQueue{
+		entries: make([]entry, 0),
+		queued:  make(map[int]key),
+	}
+}
+
+// Insert a cell, or change its key if it's queued
+func (q *cellQueue) insert(index int, k key) {
+	q.queued[index] = k
+	heap.Push(q, entry{index, k})
+}
+
+// Remove a cell if it's queued
+func (q *cellQueue) remove(index int) {
+	delete(q.queued, index)
+}
+
+// Get the cell with the smallest key, without removing it. ok is false if the
+// queue is empty.
+func (q *cellQueue) top() (index int, k key, ok bool) {
+	for len(q.entries) > 0 {
+		e := q.entries[0]
+		if current, queued := q.queued[e.index]; queued && current == e.key {
+			return e.index, e.key, true
+		}
+		heap.Pop(q)
+	}
+	return 0, key{}, false
+}
+
+// Len is the number of entries, including stale ones
+func (q *cellQueue) Len() int {
+	return len(q.entries)
+}
+
+func (q *cellQueue) Less(i, j int) bool {
+	return q.entries[i].key.less(q.entries[j].key)
+}
+
+func (q *cellQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+}
+
+func (q *cellQueue) Push(x interface{}) {
+	q.entries = append(q.entries, x.(entry))
+}
+
+func (q *cellQueue) Pop() interface{} {
+	n := len(q.entries)
+	e := q.entries[n-1]
+	q.entries = q.entries[:n-1]
+	return e
+}
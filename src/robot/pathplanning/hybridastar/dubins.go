@@ -0,0 +1,186 @@
+package hybridastar
+
+import "math"
+
+// Segments of Dubins paths: turning left, going straight or turning right
+const (
+	left = iota
+	straight
+	right
+)
+
+// The words of Dubins paths
+var dubinsWords = [][3]int{
+	{left, straight, left},
+	{left, straight, right},
+	{right, straight, left},
+	{right, straight, right},
+	{right, left, right},
+	{left, right, left},
+}
+
+// A Dubins path is the shortest path between two poses for a car which can
+// only go forward, turning with a radius of at least radius. It's made of
+// three segments, each a turn at the smallest radius or a straight line.
+type DubinsPath struct {
+	start  [3]float64
+	radius float64
+	word   [3]int
+
+	// The lengths of the segments, in units of radius
+	lengths [3]float64
+}
+
+// Find the shortest Dubins path from start to goal. ok is false if none is
+// found, which only happens for degenerate poses.
+func ShortestDubinsPath(start, goal [3]float64, radius float64) (dubins DubinsPath, ok bool) {
+	dx, dy := goal[0]-start[0], goal[1]-start[1]
+	d := math.Hypot(dx, dy) / radius
+	theta := mod2Pi(math.Atan2(dy, dx))
+	alpha := mod2Pi(start[2] - theta)
+	beta := mod2Pi(goal[2] - theta)
+
+	best := math.Inf(1)
+	for _, word := range dubinsWords {
+		lengths, found := dubinsSegments(word, alpha, beta, d)
+		if !found {
+			continue
+		}
+		if length := lengths[0] + lengths[1] + lengths[2]; length < best {
+			best = length
+			dubins = DubinsPath{start, radius, word, lengths}
+			ok = true
+		}
+	}
+	return
+}
+
+// Compute the segment lengths of a word, for the normalized problem of going
+// distance d along the x axis, from heading alpha to heading beta. The
+// formulas are those of Shkel and Lumelsky, "Classification of the Dubins
+// set".
+func dubinsSegments(word [3]int, alpha, beta, d float64) (lengths [3]float64, ok bool) {
+	sa, sb := math.Sin(alpha), math.Sin(beta)
+	ca, cb := math.Cos(alpha), math.Cos(beta)
+	cab := math.Cos(alpha - beta)
+
+	switch word {
+	case [3]int{left, straight, left}:
+		pSquared := 2 + d*d - 2*cab + 2*d*(sa-sb)
+		if pSquared < 0 {
+			return
+		}
+		phi := math.Atan2(cb-ca, d+sa-sb)
+		return [3]float64{mod2Pi(phi - alpha), math.Sqrt(pSquared), mod2Pi(beta - phi)}, true
+
+	case [3]int{right, straight, right}:
+		pSquared := 2 + d*d - 2*cab + 2*d*(sb-sa)
+		if pSquared < 0 {
+			return
+		}
+		phi := math.Atan2(ca-cb, d-sa+sb)
+		return [3]float64{mod2Pi(alpha - phi), math.Sqrt(pSquared), mod2Pi(phi - beta)}, true
+
+	case [3]int{left, straight, right}:
+		pSquared := -2 + d*d + 2*cab + 2*d*(sa+sb)
+		if pSquared < 0 {
+			return
+		}
+		p := math.Sqrt(pSquared)
+		phi := math.Atan2(-ca-cb, d+sa+sb) - math.Atan2(-2, p)
+		return [3]float64{mod2Pi(phi - alpha), p, mod2Pi(phi - beta)}, true
+
+	case [3]int{right, straight, left}:
+		pSquared := -2 + d*d + 2*cab - 2*d*(sa+sb)
+		if pSquared < 0 {
+			return
+		}
+		p := math.Sqrt(pSquared)
+		phi := math.Atan2(ca+cb, d-sa-sb) - math.Atan2(2, p)
+		return [3]float64{mod2Pi(alpha - phi), p, mod2Pi(beta - phi)}, true
+
+	case [3]int{right, left, right}:
+		c := (6 - d*d + 2*cab + 2*d*(sa-sb)) / 8
+		if math.Abs(c) > 1 {
+			return
+		}
+		phi := math.Atan2(ca-cb, d-sa+sb)
+		p := mod2Pi(2*math.Pi - math.Acos(c))
+		t := mod2Pi(alpha - phi + mod2Pi(p/2))
+		return [3]float64{t, p, mod2Pi(alpha - beta - t + p)}, true
+
+	case [3]int{left, right, left}:
+		c := (6 - d*d + 2*cab + 2*d*(sb-sa)) / 8
+		if math.Abs(c) > 1 {
+			return
+		}
+		phi := math.Atan2(ca-cb, d+sa-sb)
+		p := mod2Pi(2*math.Pi - math.Acos(c))
+		t := mod2Pi(-alpha - phi + p/2)
+		return [3]float64{t, p, mod2Pi(beta - alpha - t + p)}, true
+	}
+
+	return
+}
+
+// Get the length of the path in meters
+func (dp DubinsPath) Length() float64 {
+	return (dp.lengths[0] + dp.lengths[1] + dp.lengths[2]) * dp.radius
+}
+
+// Get the pose at a distance in meters along the path
+func (dp DubinsPath) PoseAt(distance float64) [3]float64 {
+	pose := dp.start
+	remaining := distance / dp.radius
+	for i, segment := range dp.word {
+		length := math.Min(remaining, dp.lengths[i])
+		pose = dp.move(pose, segment, length)
+		remaining -= length
+		if remaining <= 0 {
+			break
+		}
+	}
+	return pose
+}
+
+// Sample poses along the path, step meters apart, excluding the start and
+// including the end
+func (dp DubinsPath) Sample(step float64) [][3]float64 {
+	length := dp.Length()
+	n := int(math.Ceil(length / step))
+	poses := make([][3]float64, 0, n)
+	for i := 1; i < n; i++ {
+		poses = append(poses, dp.PoseAt(float64(i)*step))
+	}
+	return append(poses, dp.PoseAt(length))
+}
+
+// Move along a segment for length, in units of radius
+func (dp DubinsPath) move(pose [3]float64, segment int, length float64) [3]float64 {
+	r := dp.radius
+	x, y, theta := pose[0], pose[1], pose[2]
+	switch segment {
+	case left:
+		return [3]float64{
+			x + r*(math.Sin(theta+length)-math.Sin(theta)),
+			y - r*(math.Cos(theta+length)-math.Cos(theta)),
+			theta + length,
+		}
+	case right:
+		return [3]float64{
+			x - r*(math.Sin(theta-length)-math.Sin(theta)),
+			y + r*(math.Cos(theta-length)-math.Cos(theta)),
+			theta - length,
+		}
+	}
+	return [3]float64{x + r*length*math.Cos(theta), y + r*length*math.Sin(theta), theta}
+}
+
+// Wrap an angle to [0, 2 pi)
+func mod2Pi(angle float64) float64 {
+	angle = math.Mod(angle, 2*math.Pi)
+	if angle < 0 {
+		angle += 2 * math.Pi
+	}
+	return angle
+}
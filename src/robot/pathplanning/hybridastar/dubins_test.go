@@ -0,0 +1,45 @@
+package hybridastar
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDubinsPath(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		start := [3]float64{rand.Float64()*10 - 5, rand.Float64()*10 - 5, rand.Float64() * 2 * math.Pi}
+		goal := [3]float64{rand.Float64()*10 - 5, rand.Float64()*10 - 5, rand.Float64() * 2 * math.Pi}
+
+		dubins, ok := ShortestDubinsPath(start, goal, 0.5)
+		if !ok {
+			t.Fatal("No Dubins path from", start, "to", goal)
+		}
+
+		// The path ends at the goal, and is at least as long as the distance
+		end := dubins.PoseAt(dubins.Length())
+		if math.Hypot(end[0]-goal[0], end[1]-goal[1]) > 1e-6 ||
+			math.Abs(math.Remainder(end[2]-goal[2], 2*math.Pi)) > 1e-6 {
+			t.Fatal("Dubins path from", start, "to", goal, "ends at", end)
+		}
+		if dubins.Length() < math.Hypot(goal[0]-start[0], goal[1]-start[1])-1e-9 {
+			t.Fatal("Dubins path shorter than the distance")
+		}
+
+		// Samples are a step apart
+		samples := dubins.Sample(0.1)
+		last := start
+		for _, pose := range samples {
+			if math.Hypot(pose[0]-last[0], pose[1]-last[1]) > 0.1+1e-9 {
+				t.Fatal("Samples too far apart:", last, pose)
+			}
+			last = pose
+		}
+	}
+
+	// Straight ahead
+	dubins, _ := ShortestDubinsPath([3]float64{1, 1, math.Pi / 4}, [3]float64{3, 3, math.Pi / 4}, 0.5)
+	if math.Abs(dubins.Length()-math.Sqrt(8)) > 1e-9 {
+		t.Error("Straight Dubins path has length", dubins.Length())
+	}
+}
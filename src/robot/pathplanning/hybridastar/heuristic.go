@@ -0,0 +1,80 @@
+package hybridastar
+
+import (
+	"container/heap"
+	"math"
+
+	"robot/pathplanning/costmap"
+)
+
+// A cell and its cost to the goal, in a queue
+type costEntry struct {
+	index int
+	cost  float64
+}
+
+type costQueue []costEntry
+
+func (q costQueue) Len() int            { return len(q) }
+func (q costQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q costQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *costQueue) Push(x interface{}) { *q = append(*q, x.(costEntry)) }
+func (q *costQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Compute the cost of going from each cell of the costmap to the goal cell,
+// ignoring how the robot can turn, by Dijkstra's algorithm in 8 directions.
+// Cells where the robot would hit an obstacle are not passed, and cells
+// without a way to the goal have an infinite cost. The costs are in the
+// units of the costs of transitions, meters weighted by the costs of cells.
+func holonomicCosts(c *costmap.Costmap, goalIndex int) []float64 {
+	sizeX, sizeY := c.GetSizeX(), c.GetSizeY()
+	costs := make([]float64, sizeX*sizeY)
+	for i := range costs {
+		costs[i] = math.Inf(1)
+	}
+
+	diagonal := math.Sqrt2 * c.GetCellLength()
+	moves := []struct {
+		dx, dy int
+		length float64
+	}{
+		{1, 0, c.GetCellLength()}, {-1, 0, c.GetCellLength()},
+		{0, 1, c.GetCellLength()}, {0, -1, c.GetCellLength()},
+		{1, 1, diagonal}, {1, -1, diagonal}, {-1, 1, diagonal}, {-1, -1, diagonal},
+	}
+
+	costs[goalIndex] = 0
+	queue := &costQueue{{goalIndex, 0}}
+	for queue.Len() > 0 {
+		e := heap.Pop(queue).(costEntry)
+		if e.cost > costs[e.index] {
+			continue
+		}
+		x, y := e.index%sizeX, e.index/sizeX
+
+		// Moving from the neighbour to the cell costs as much as moving
+		// through the cell in a transition
+		factor := costmap.TraversalCost(c.GetCost(x, y))
+		if math.IsInf(factor, 1) && e.index != goalIndex {
+			continue
+		}
+		for _, move := range moves {
+			nx, ny := x+move.dx, y+move.dy
+			if nx < 0 || ny < 0 || nx >= sizeX || ny >= sizeY {
+				continue
+			}
+			neighbour := ny*sizeX + nx
+			if cost := e.cost + move.length*factor; cost < costs[neighbour] {
+				costs[neighbour] = cost
+				heap.Push(queue, costEntry{neighbour, cost})
+			}
+		}
+	}
+
+	return costs
+}
@@ -2,33 +2,48 @@
 // "Junior: The Stanford Entry in the Urban Challenge". It uses a (discrete)
 // grid map, but uses a robot motion model to search along paths that are
 // realizible (continuous paths). In each search step, it searches along a
-// discrete (finite) set of possible continuous motions.
+// discrete (finite) set of possible continuous motions. Every few steps, it
+// tries to reach the goal with a Dubins path, the shortest path a robot
+// turning at most as sharp as the motions can drive.
 package hybridastar
 
 import (
 	"container/heap"
-	"errors"
-	"fmt"
+	"log"
 	"math"
-
-	"image/png"
-	"os"
+	"time"
 
 	"hectormapping/map/gridmap"
-	"hectormapping/map/mapimages"
 
+	"robot/config"
+	"robot/logging"
+	"robot/maplayers"
 	"robot/model"
-	"robot/pathplanning/binarymap"
+	"robot/pathplanning"
+	"robot/pathplanning/costmap"
 	"robot/pathplanning/path"
 )
 
-const UNKNOWN_PUNISH = 100.0
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+// Cost of moving through a cell where the robot would hit an obstacle, per
+// unit of transition cost. Such moves are only made to get away from an
+// obstacle the robot is too close to.
+const LETHAL_STEP_COST = 1e5
 
 // A transition consists of the relative coordinates it results in, and a cost.
 // A transition should be executable for the robot.
 type Transition struct {
 	move [3]float64
 	cost float64
+
+	// Relative coordinates along the motion, ending with move, for checking
+	// the cells it passes
+	samples [][3]float64
 }
 
 // For simplicity, a combine functions in a HybridPathPlanner.
@@ -37,43 +52,55 @@ type HybridPathPlanner struct {
 	transitions []*Transition
 	// Model of the robot
 	robot *model.DifferentialWheeledRobot
-	// Reference to the original grid map
-	occMap gridmap.OccGridMap
-	// Reference to an internal binary map
-	binMap *binarymap.OccGridMapBinary
+	// Costs of the cells
+	costmap *costmap.Costmap
 	// Goal state in world coordinates
 	goal [3]float64
 	// Start state in world coordinates
 	start [3]float64
 	// Radius of the goal
 	radius float64
+	// Cost of going from each cell to the goal, ignoring how the robot turns
+	goalCosts []float64
+
+	// Turn radius of Dubins shots to the goal, and the number of expansions
+	// between them
+	turnRadius       float64
+	analyticInterval int
 
-	// Maximum index of the binMap
-	_maxMapIndex int
+	// Number of headings told apart in each cell
+	headingBins int
+
+	// Budget of a search
+	maxIterations int
+	maxTime       time.Duration
 }
 
 // Create and initialize a HybridPathPlanner from a given gridMap and a robot
-// model. Create the internal map using a shrinkFactor, such that the number
-// of cells is gridMap/shrinkFactor. The radius represents the radius of a
+// model. Create the costmap using a shrinkFactor, such that the number of
+// cells is gridMap/shrinkFactor. The radius represents the radius of a
 // circle around the goal position which should be considered allowable.
 func MakeHybridPathPlanner(gridMap gridmap.OccGridMap, robot *model.DifferentialWheeledRobot, shrinkFactor int, radius float64) *HybridPathPlanner {
-	hpp := &HybridPathPlanner{
-		robot:  robot,
-		occMap: gridMap,
-		radius: radius,
-	}
+	return MakeHybridPathPlannerWithKeepOut(gridMap, nil, robot, shrinkFactor, radius)
+}
+
+// Create a HybridPathPlanner which treats the cells of gridMap marked in
+// keepOut as obstacles
+func MakeHybridPathPlannerWithKeepOut(gridMap gridmap.OccGridMap, keepOut *maplayers.Mask, robot *model.DifferentialWheeledRobot, shrinkFactor int, radius float64) *HybridPathPlanner {
+	return MakeHybridPathPlannerWithCostmap(costmap.MakeCostmap(gridMap, keepOut, shrinkFactor), robot, radius)
+}
 
-	// Prepare an internal binary map representation
-	// hpp.binMap = binarymap.BinaryMapFromOccGridMap(gridMap, shrinkFactor)
-	hpp.binMap = binarymap.MakeShrunkenBinaryMap(gridMap, shrinkFactor, radius)
-	hpp._maxMapIndex = hpp.binMap.GetSizeX()*hpp.binMap.GetSizeY() - 1
-
-	// DEBUG -- save an image of the binmap
-	img, _ := mapimages.GetGridmapImage(hpp.binMap)
-	if img != nil {
-		f, _ := os.Create("binmap.png")
-		png.Encode(f, img)
-		f.Close()
+// Create a HybridPathPlanner which plans in a costmap made beforehand
+func MakeHybridPathPlannerWithCostmap(c *costmap.Costmap, robot *model.DifferentialWheeledRobot, radius float64) *HybridPathPlanner {
+	hpp := &HybridPathPlanner{
+		robot:            robot,
+		costmap:          c,
+		radius:           radius,
+		turnRadius:       config.HYBRIDASTAR_TURN_RADIUS,
+		analyticInterval: config.HYBRIDASTAR_ANALYTIC_INTERVAL,
+		headingBins:      config.HYBRIDASTAR_HEADING_BINS,
+		maxIterations:    config.HYBRIDASTAR_MAX_ITERATIONS,
+		maxTime:          time.Duration(config.HYBRIDASTAR_MAX_TIME) * time.Millisecond,
 	}
 
 	// Build the set of allowable transitions
@@ -82,103 +109,120 @@ func MakeHybridPathPlanner(gridMap gridmap.OccGridMap, robot *model.Differential
 	return hpp
 }
 
+// Plan a path from a pose to another. If no path is found, the error is a
+// *pathplanning.PlanningError telling why.
 func (hpp *HybridPathPlanner) PlanPath(from, to [3]float64) (*path.Path, error) {
 
-	fmt.Printf("From: %.2f, %.2f, %.2f\n", from[0], from[1], from[2])
-	fmt.Printf("To: %.2f, %.2f, %.2f\n", to[0], to[1], to[2])
-
 	if err := hpp.setGoal(to); err != nil {
 		return nil, err
 	}
+	if hpp.getMapIndex(from) < 0 {
+		return nil, pathplanning.MakePlanningError(pathplanning.START_OUT_OF_MAP, 0, "Start out of map bounds.")
+	}
+	hpp.start = from
 
+	// The start has no way to the goal, even when turning freely
+	hpp.goalCosts = holonomicCosts(hpp.costmap, hpp.getMapIndex(to))
+	if math.IsInf(hpp.goalCosts[hpp.getMapIndex(from)], 1) {
+		return nil, pathplanning.MakePlanningError(pathplanning.NO_PATH, 0, "No path found to goal.")
+	}
+
+	started := time.Now()
 	counter := 0
 
-	// The set of nodes already evaluated
+	// The set of states already evaluated, by state index
 	closedMap := map[int]*Node{}
 
 	// The set of tentative nodes to be evalueated, initially containing the
 	// start node.
 	openSet := MakeNodeQueue()
-	openMap := map[int]bool{}
+	openMap := map[int]*Node{}
 
 	// Add the start node
-	openSet.Push(&Node{nil, from, hpp.getMapIndex(from), 0, 0})
+	openSet.Push(&Node{position: from, mapIndex: hpp.getMapIndex(from)})
 	openSet.nodes[0].f = hpp.heuristic(openSet.nodes[0])
-	openMap[openSet.nodes[0].mapIndex] = true
+	openMap[hpp.getStateIndex(openSet.nodes[0])] = openSet.nodes[0]
 
 	// Continue until the openSet is empty
 	for openSet.Len() > 0 {
 
 		// The current node is the one from openSet with the lowest F value
 		current := heap.Pop(openSet).(*Node)
-		delete(openMap, current.mapIndex)
+		state := hpp.getStateIndex(current)
+		delete(openMap, state)
 
 		counter++
-
-		// Check if position is in the map
-		currMapPos := hpp.binMap.GetMapCoords([2]float64{current.position[0], current.position[1]})
-		if hpp.binMap.PointOutOfMapBounds(currMapPos) {
-			continue
+		if counter > hpp.maxIterations {
+			return nil, pathplanning.MakePlanningError(pathplanning.ITERATION_LIMIT, counter, "No path found to goal within %d iterations.", hpp.maxIterations)
+		}
+		if time.Since(started) > hpp.maxTime {
+			return nil, pathplanning.MakePlanningError(pathplanning.TIME_LIMIT, counter, "No path found to goal within %v.", hpp.maxTime)
 		}
 
 		// Check if we have now reached the goal node
 		if hpp.goalIsReached(current.position) {
-			fmt.Printf("Checked %d nodes.\n", counter)
-			return reconstructPath(current), nil
+			logger.Printf("Planned a hybrid A* path to the goal after %d iterations\n", counter)
+			return hpp.reconstructPath(current, nil), nil
+		}
+
+		// Try to drive straight to the goal
+		if hpp.analyticInterval > 0 && counter%hpp.analyticInterval == 0 {
+			if shot, ok := hpp.shootToGoal(current.position); ok {
+				logger.Printf("Planned a hybrid A* path to the goal after %d iterations, ending with a Dubins path\n", counter)
+				return hpp.reconstructPath(current, shot), nil
+			}
 		}
 
 		// Add current to closedset
-		closedMap[current.mapIndex] = current
+		closedMap[state] = current
 
 		// For each neighbour, implemented by a transition
 		for _, trans := range hpp.transitions {
 
 			// Create the (tentative) node, set up it's scores
 			node := hpp.makeNode(current, trans)
+			if node == nil {
+				continue
+			}
+			nodeState := hpp.getStateIndex(node)
 
-			// Check if we already have a better value for this mapindex
-			if closedNode, ok := closedMap[node.mapIndex]; ok && node.g >= closedNode.g {
+			// Check if we already have a better value for this state
+			if closedNode, ok := closedMap[nodeState]; ok && node.g >= closedNode.g {
 				continue
 			}
 
 			// If transition node not in openset or tentative g score < gscore[neighbour]
-			if _, ok := openMap[node.mapIndex]; !ok {
+			if openNode, ok := openMap[nodeState]; !ok {
 				heap.Push(openSet, node)
-				openMap[node.mapIndex] = true
+				openMap[nodeState] = node
+			} else if node.g < openNode.g {
+				openSet.Replace(openNode, node)
+				openMap[nodeState] = node
 			}
 		}
 	}
 
-	return nil, errors.New("Path not found.")
+	return nil, pathplanning.MakePlanningError(pathplanning.NO_PATH, counter, "No path found to goal. Used %d iterations.", counter)
 }
 
-// Heuristic
+// Heuristic: the cost of going to the goal through the costmap, ignoring how
+// the robot turns. It's infinite if there is no way to the goal.
 func (hpp *HybridPathPlanner) heuristic(node *Node) float64 {
-
-	// Check if it's inside the map
-	if node.mapIndex > hpp._maxMapIndex || node.mapIndex < 0 {
-		return 1e9
-	}
-
-	// Check if it's occupied
-	if hpp.binMap.GetCellByIndex(node.mapIndex).IsOccupied() {
-		return 1e5
-	}
-
-	// Check the Euclidian distance to goal
 	euclidian := hpp.euclidianDistanceToGoal(node.position)
-
-	// Get the corresponding cell in the original map
-	occMapCoords := hpp.occMap.GetMapCoords([2]float64{node.position[0], node.position[1]})
-	occMapCell := hpp.occMap.GetCell(int(occMapCoords[0]), int(occMapCoords[1]))
-	if !occMapCell.IsFree() {
-
-		// It's not free, so multiply by UNKNOWN_PUNISH
-		euclidian *= UNKNOWN_PUNISH
-
+	if hpp.goalCosts == nil {
+		return euclidian
 	}
+	return math.Max(euclidian, hpp.goalCosts[node.mapIndex])
+}
 
-	return euclidian
+// The factor the cost of a transition through the cell with the map index is
+// multiplied by, from the costmap
+func (hpp *HybridPathPlanner) costFactor(mapIndex int) float64 {
+	cost := costmap.TraversalCost(hpp.costmap.GetCostByIndex(mapIndex))
+	if math.IsInf(cost, 1) {
+		return LETHAL_STEP_COST
+	}
+	return cost
 }
 
 // Determines if a goal is reached.
@@ -195,21 +239,38 @@ func (hpp *HybridPathPlanner) goalIsReached(pose [3]float64) bool {
 	return false
 }
 
+// Try to reach the goal from a pose along a Dubins path. ok is false if the
+// path passes cells where the robot would hit an obstacle.
+func (hpp *HybridPathPlanner) shootToGoal(pose [3]float64) (poses [][3]float64, ok bool) {
+	dubins, ok := ShortestDubinsPath(pose, hpp.goal, hpp.turnRadius)
+	if !ok {
+		return nil, false
+	}
+
+	// Check the cells along the path, then keep poses about a step apart
+	checked := dubins.Sample(hpp.costmap.GetCellLength() / 2)
+	for _, p := range checked {
+		if hpp.costmap.GetCostByIndex(hpp.getMapIndex(p)) >= costmap.INSCRIBED_COST {
+			return nil, false
+		}
+	}
+
+	return dubins.Sample(config.HYBRIDASTAR_STEP_LENGTH), true
+}
+
 // Set a new goal
 func (hpp *HybridPathPlanner) setGoal(goal [3]float64) error {
 	goalPositionWorld := [2]float64{goal[0], goal[1]}
-	goalPositionMap := hpp.binMap.GetMapCoords(goalPositionWorld)
-
-	fmt.Printf("World(%.2f, %.2f) = Map(%.2f, %.2f)\n", goalPositionWorld[0], goalPositionWorld[1], goalPositionMap[0], goalPositionMap[1])
+	goalPositionMap := hpp.costmap.GetMapCoords(goalPositionWorld)
 
 	// Check if it's outside the map
-	if hpp.binMap.PointOutOfMapBounds(goalPositionMap) {
-		return errors.New("hpp.SetGoal: Goal out of map bounds.")
+	if hpp.costmap.PointOutOfMapBounds(goalPositionMap) {
+		return pathplanning.MakePlanningError(pathplanning.GOAL_OUT_OF_MAP, 0, "Goal out of map bounds.")
 	}
 
-	// Check if it's occupied -- do it in the internal binary map
-	if hpp.binMap.IsOccupied(int(goalPositionMap[0]), int(goalPositionMap[1])) {
-		return errors.New("Goal is occupied.")
+	// Check if it's occupied -- do it in the costmap
+	if hpp.costmap.IsLethal(int(goalPositionMap[0]), int(goalPositionMap[1])) {
+		return pathplanning.MakePlanningError(pathplanning.GOAL_OCCUPIED, 0, "Goal is occupied.")
 	}
 
 	hpp.goal = goal
@@ -217,60 +278,55 @@ func (hpp *HybridPathPlanner) setGoal(goal [3]float64) error {
 	return nil
 }
 
-// Build a list of allowable transitions for the robot.
+// Build a list of allowable transitions for the robot: arcs of
+// HYBRIDASTAR_STEP_LENGTH, at HYBRIDASTAR_TURNS turn radii to each side down
+// to HYBRIDASTAR_TURN_RADIUS, and straight ahead. The wheel distances of each
+// arc are rolled through the robot model. Reversing is added if it has a
+// cost.
 func (hpp *HybridPathPlanner) buildTransitions() {
 	if hpp.robot == nil {
 		return
 	}
 
 	hpp.transitions = make([]*Transition, 0)
-	startPos := model.Position{}
-	var endPos model.Position
-
-	// Turn left, forward, turn right, reverse
-	rolls := [][3]float64{
-		[3]float64{0.3, 0.5, 0.6},
-		[3]float64{0.5, 0.5, 0.5},
-		[3]float64{0.5, 0.3, 0.6},
-		[3]float64{-0.5, -0.5, 10.0},
+	step := config.HYBRIDASTAR_STEP_LENGTH
+	turns := config.HYBRIDASTAR_TURNS
+
+	// Wheel distances of the motions, going forward
+	rolls := [][2]float64{{step, step}}
+	for i := 1; i <= turns; i++ {
+		radius := config.HYBRIDASTAR_TURN_RADIUS * float64(turns) / float64(i)
+		left, right := hpp.robot.TurnDistances(radius, step/radius)
+		rolls = append(rolls, [2]float64{left, right})
+		left, right = hpp.robot.TurnDistances(-radius, step/radius)
+		rolls = append(rolls, [2]float64{left, right})
 	}
 
-	//	var left, right float64
-	//	rolls := make([][3]float64, 3)
-	//	left, right = hpp.robot.TurnDistances(0.2, 0.7853981633974) // pi/4
-	//	rolls[0] = [3]float64{left, right, (left + right) / 2}
-	//	rolls[1] = [3]float64{0.7, 0.7, 0.7}
-	//	left, right = hpp.robot.TurnDistances(-0.2, 0.7853981633974) // pi/4
-	//	rolls[2] = [3]float64{left, right, (left + right) / 2}
-
-	//	rolls := [][3]float64{
-	//		[3]float64{-0.2, 0.1, 0.45},
-	//		[3]float64{0.5, 0.5, 0.5},
-	//		[3]float64{2.0, 2.0, 2.0},
-	//		[3]float64{0.1, -0.2, 0.45},
-	//	}
-
-	// Smooth + hard
-	//	rolls := [][3]float64{
-	//		[3]float64{-0.2, 0.1, 0.5},
-	//		[3]float64{0.4, 0.5, 0.45},
-	//		[3]float64{0.5, 0.5, 0.5},
-	//		[3]float64{0.5, 0.4, 0.45},
-	//		[3]float64{0.1, -0.2, 0.5},
-	//	}
-
-	// Smooth
-	//	rolls := [][3]float64{
-	//		[3]float64{0.4, 0.5, 0.45},
-	//		[3]float64{0.5, 0.5, 0.5},
-	//		[3]float64{0.5, 0.4, 0.45},
-	//	}
+	// Distance between the samples of each motion
+	sampleDistance := step
+	if hpp.costmap != nil {
+		sampleDistance = hpp.costmap.GetCellLength() / 2
+	}
+	samples := int(math.Ceil(step / sampleDistance))
 
 	for _, roll := range rolls {
-		endPos = hpp.robot.RollPosition(roll[0], roll[1], startPos)
-		transition := &Transition{[3]float64{endPos.X, endPos.Y, endPos.Theta}, roll[2]}
-		hpp.transitions = append(hpp.transitions, transition)
+		hpp.addTransition(roll[0], roll[1], step, samples)
+		if config.HYBRIDASTAR_REVERSE_COST > 0 {
+			hpp.addTransition(-roll[0], -roll[1], step*config.HYBRIDASTAR_REVERSE_COST, samples)
+		}
+	}
+}
+
+// Add a transition where the wheels roll distLeft and distRight
+func (hpp *HybridPathPlanner) addTransition(distLeft, distRight, cost float64, samples int) {
+	transition := &Transition{cost: cost}
+	for i := 1; i <= samples; i++ {
+		fraction := float64(i) / float64(samples)
+		endPos := hpp.robot.RollPosition(distLeft*fraction, distRight*fraction, model.Position{})
+		transition.samples = append(transition.samples, [3]float64{endPos.X, endPos.Y, endPos.Theta})
 	}
+	transition.move = transition.samples[samples-1]
+	hpp.transitions = append(hpp.transitions, transition)
 }
 
 // Calculate the euclidian distance to the goal
@@ -281,10 +337,27 @@ func (hpp *HybridPathPlanner) euclidianDistanceToGoal(pose [3]float64) float64 {
 
 // Make a node from a parent node and a transition. Should update position, g
 // score, map index and f score (with heuristic), as well as set the parent
-// node as the node's parent for back-tracing.
+// node as the node's parent for back-tracing. Returns nil if the transition
+// leaves the map, or takes the robot where it would hit an obstacle from
+// where it wouldn't.
 func (hpp *HybridPathPlanner) makeNode(parent *Node, transition *Transition) *Node {
+	parentLethal := hpp.costmap.GetCostByIndex(parent.mapIndex) >= costmap.INSCRIBED_COST
+
+	// Sum the costs of the cells along the motion
+	g := parent.g
+	for _, sample := range transition.samples {
+		mapIndex := hpp.getMapIndex(hpp.movePose(parent.position, sample))
+		if mapIndex < 0 {
+			return nil
+		}
+		factor := hpp.costFactor(mapIndex)
+		if factor == LETHAL_STEP_COST && !parentLethal {
+			return nil
+		}
+		g += transition.cost / float64(len(transition.samples)) * factor
+	}
+
 	position := hpp.movePose(parent.position, transition.move)
-	g := parent.g + transition.cost
 
 	node := &Node{
 		parent:   parent,
@@ -294,6 +367,9 @@ func (hpp *HybridPathPlanner) makeNode(parent *Node, transition *Transition) *No
 	}
 
 	node.f = g + hpp.heuristic(node)
+	if math.IsInf(node.f, 1) {
+		return nil
+	}
 
 	return node
 }
@@ -308,10 +384,21 @@ func (hpp *HybridPathPlanner) movePose(pose, move [3]float64) [3]float64 {
 	}
 }
 
-// Return the corresponding map index for the map pose
+// Return the corresponding map index for the map pose, or -1 if it's outside
+// the map
 func (hpp *HybridPathPlanner) getMapIndex(pose [3]float64) int {
-	mapPose := hpp.binMap.GetMapCoordsPose(pose)
-	return int(mapPose[0]) + hpp.binMap.GetSizeX()*int(mapPose[1])
+	return hpp.costmap.GetIndex([2]float64{pose[0], pose[1]})
+}
+
+// Get the index of the state of a node, telling apart the cell and the
+// heading of the node
+func (hpp *HybridPathPlanner) getStateIndex(node *Node) int {
+	bins := hpp.headingBins
+	if bins < 1 {
+		bins = 1
+	}
+	bin := int(mod2Pi(node.position[2])/(2*math.Pi)*float64(bins)) % bins
+	return node.mapIndex*bins + bin
 }
 
 // Check if a node with map index mapIndex is in the NodeQueue
@@ -324,7 +411,9 @@ func (hpp *HybridPathPlanner) mapIndexInQueue(set *NodeQueue, mapIndex int) bool
 	return false
 }
 
-func reconstructPath(endNode *Node) *path.Path {
+// Make the path to a node, followed by the poses of a shot to the goal if
+// any. The path ends at the goal itself.
+func (hpp *HybridPathPlanner) reconstructPath(endNode *Node, shot [][3]float64) *path.Path {
 	p := path.MakePath()
 
 	for current := endNode; current.parent != nil; current = current.parent {
@@ -339,5 +428,10 @@ func reconstructPath(endNode *Node) *path.Path {
 	}
 	p.Poses = rev
 
+	if shot != nil {
+		p.Poses = append(p.Poses, shot[:len(shot)-1]...)
+	}
+	p.Poses = append(p.Poses, hpp.goal)
+
 	return p
 }
@@ -4,11 +4,16 @@ import (
 	"fmt"
 	"image"
 	_ "image/png"
+	"math"
 	"os"
 	"testing"
 
+	"hectormapping/map/gridmap"
+	"hectormapping/map/maprep"
+
 	"robot/model"
-	"robot/pathplanning/binarymap"
+	"robot/pathplanning"
+	"robot/pathplanning/costmap"
 	"robot/pathplanning/path"
 )
 
@@ -40,7 +45,7 @@ func TestMovePose(t *testing.T) {
 
 func TestGetMapIndex(t *testing.T) {
 	hpp := &HybridPathPlanner{}
-	hpp.binMap = binarymap.MakeOccGridMapBinary(0.1, [2]int{1024, 1024}, [2]float64{0, 0})
+	hpp.costmap = costmap.MakeCostmap(maprep.MakeMapRepMultiMap(0.1, 1024, 1024, 1, [2]float64{0, 0}).GetGridMap(0), nil, 1)
 
 	i0 := hpp.getMapIndex([3]float64{0, 0, 0})
 	t.Logf("Index of (0, 0): %d", i0)
@@ -64,12 +69,7 @@ func TestMapPlanning(t *testing.T) {
 }
 
 func _testFreePlanning(mapName string, from, to [3]float64, t *testing.T) {
-	hpp := &HybridPathPlanner{}
-	hpp.binMap = BinaryMapFromPNG(mapName)
-	hpp._maxMapIndex = hpp.binMap.GetSizeX()*hpp.binMap.GetSizeY() - 1
-	hpp.robot = model.MakeDefaultDifferentialWheeledRobot()
-	hpp.buildTransitions()
-	hpp.radius = 1.0
+	hpp := MakeHybridPathPlanner(GridMapFromPNG(mapName), model.MakeDefaultDifferentialWheeledRobot(), 1, 1.0)
 
 	path, err := hpp.PlanPath(from, to)
 	if err != nil {
@@ -82,6 +82,75 @@ func _testFreePlanning(mapName string, from, to [3]float64, t *testing.T) {
 	LogPathToFile(path, "path-"+mapName)
 }
 
+// A free map of 10 x 10 m with cells of 0.05 m, with world (0, 0) at cell
+// (0, 0)
+func makeFreeMap() gridmap.OccGridMap {
+	gridMap := maprep.MakeMapRepMultiMap(0.05, 200, 200, 1, [2]float64{0, 0}).GetGridMap(0)
+	for y := 0; y < gridMap.GetSizeY(); y++ {
+		for x := 0; x < gridMap.GetSizeX(); x++ {
+			gridMap.GetCell(x, y).Set(-2)
+		}
+	}
+	return gridMap
+}
+
+func TestDubinsShot(t *testing.T) {
+	hpp := MakeHybridPathPlanner(makeFreeMap(), model.MakeDefaultDifferentialWheeledRobot(), 2, 0.1)
+	hpp.analyticInterval = 1
+
+	goal := [3]float64{6, 7, math.Pi}
+	p, err := hpp.PlanPath([3]float64{2, 2, 0}, goal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := p.Poses[len(p.Poses)-1]; last != goal {
+		t.Error("Path ends at", last)
+	}
+
+	// The path is smooth enough to drive
+	for i := 1; i < len(p.Poses)-1; i++ {
+		if d := math.Hypot(p.Poses[i][0]-p.Poses[i-1][0], p.Poses[i][1]-p.Poses[i-1][1]); d > 0.31 {
+			t.Fatal("Poses too far apart:", p.Poses[i-1], p.Poses[i])
+		}
+	}
+}
+
+func TestPlanningErrors(t *testing.T) {
+	gridMap := makeFreeMap()
+
+	// A box around (5, 5)
+	for i := 80; i <= 120; i++ {
+		gridMap.GetCell(i, 80).Set(2)
+		gridMap.GetCell(i, 120).Set(2)
+		gridMap.GetCell(80, i).Set(2)
+		gridMap.GetCell(120, i).Set(2)
+	}
+	robot := model.MakeDefaultDifferentialWheeledRobot()
+
+	reasons := []struct {
+		goal   [3]float64
+		reason string
+	}{
+		{[3]float64{20, 5, 0}, pathplanning.GOAL_OUT_OF_MAP},
+		{[3]float64{4, 5, 0}, pathplanning.GOAL_OCCUPIED},
+		{[3]float64{5, 5, 0}, pathplanning.NO_PATH},
+	}
+	for _, r := range reasons {
+		_, err := MakeHybridPathPlanner(gridMap, robot, 2, 0.3).PlanPath([3]float64{2, 2, 0}, r.goal)
+		if planningErr, ok := err.(*pathplanning.PlanningError); !ok || planningErr.Reason != r.reason {
+			t.Errorf("Planning to %v failed with %v, not %s", r.goal, err, r.reason)
+		}
+	}
+
+	hpp := MakeHybridPathPlanner(gridMap, robot, 2, 0.3)
+	hpp.analyticInterval = 0
+	hpp.maxIterations = 10
+	_, err := hpp.PlanPath([3]float64{2, 2, 0}, [3]float64{8, 8, 0})
+	if planningErr, ok := err.(*pathplanning.PlanningError); !ok || planningErr.Reason != pathplanning.ITERATION_LIMIT {
+		t.Error("Planning beyond the iteration limit failed with", err)
+	}
+}
+
 func LogPathToFile(path *path.Path, filename string) {
 	file, err := os.Create("testoutput/" + filename + ".csv")
 	if err != nil {
@@ -95,7 +164,7 @@ func LogPathToFile(path *path.Path, filename string) {
 	file.Close()
 }
 
-func BinaryMapFromPNG(filename string) *binarymap.OccGridMapBinary {
+func GridMapFromPNG(filename string) gridmap.OccGridMap {
 	file, err := os.Open("testinput/" + filename + ".png")
 	if err != nil {
 		return nil
@@ -105,19 +174,19 @@ func BinaryMapFromPNG(filename string) *binarymap.OccGridMapBinary {
 	img, _, err := image.Decode(file)
 	size := [2]int{img.Bounds().Dx(), img.Bounds().Dy()}
 
-	binMap := binarymap.MakeOccGridMapBinary(0.1, size, [2]float64{0, 0})
+	gridMap := maprep.MakeMapRepMultiMap(0.1, size[0], size[1], 1, [2]float64{0, 0}).GetGridMap(0)
 
 	for i := 0; i < size[0]; i++ {
 		for j := 0; j < size[1]; j++ {
 			r, g, b, _ := img.At(i, j).RGBA()
-			cell := binMap.GetCell(i, j)
+			cell := gridMap.GetCell(i, j)
 			if r == 0 && g == 0 && b == 0 {
-				binMap.ConcreteGridFunctions.UpdateSetOccupied(cell)
+				cell.Set(2)
 			} else {
-				binMap.ConcreteGridFunctions.UpdateSetFree(cell)
+				cell.Set(-2)
 			}
 		}
 	}
 
-	return binMap
+	return gridMap
 }
@@ -1,6 +1,7 @@
 package hybridastar
 
 import (
+	"container/heap"
 )
 
 // Node for the A* algorithm
@@ -10,6 +11,7 @@ type Node struct {
 	mapIndex int // The index (cell identifier) of the position in the map
 	f float64 // Estimated total cost from start to goal through this node
 	g float64 // Cost from start along the best known path
+	index int // The index of the node in its NodeQueue
 }
 
 // NodeQueue is a priority queue for nodes, implemented as a heap supported by
@@ -38,11 +40,15 @@ func (nq *NodeQueue) Less(i, j int) bool {
 // Swap swaps the elements with indexes i and j.
 func (nq *NodeQueue) Swap(i, j int) {
 	nq.nodes[i], nq.nodes[j] = nq.nodes[j], nq.nodes[i]
+	nq.nodes[i].index = i
+	nq.nodes[j].index = j
 }
 
 // Push
 func (nq *NodeQueue) Push(x interface{}) {
-	nq.nodes = append(nq.nodes, x.(*Node))
+	node := x.(*Node)
+	node.index = len(nq.nodes)
+	nq.nodes = append(nq.nodes, node)
 }
 
 // Pop
@@ -54,3 +60,11 @@ func (nq *NodeQueue) Pop() interface{} {
 	return node
 }
 
+// Replace a node in the queue with one of the same state, e.g. one reached
+// along a cheaper path
+func (nq *NodeQueue) Replace(old, node *Node) {
+	node.index = old.index
+	nq.nodes[node.index] = node
+	heap.Fix(nq, node.index)
+}
+
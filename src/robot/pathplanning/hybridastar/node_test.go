@@ -25,3 +25,30 @@ func TestNodeQueue(t *testing.T) {
 	}
 }
 
+
+func TestNodeQueueReplace(t *testing.T) {
+	nodeQueue := MakeNodeQueue()
+
+	nodes := []*Node{{f: 2}, {f: 1}, {f: 3}, {f: 0.3}}
+	for _, node := range nodes {
+		heap.Push(nodeQueue, node)
+	}
+
+	// A cheaper node for the state of the most expensive one comes out first
+	nodeQueue.Replace(nodes[2], &Node{f: 0.1})
+
+	last := -1.0
+	for nodeQueue.Len() > 0 {
+		current := heap.Pop(nodeQueue).(*Node)
+		if current == nodes[2] {
+			t.Error("Replaced node still in the queue")
+		}
+		if current.f < last {
+			t.Error("Did not arrive in ascending order")
+		}
+		last = current.f
+	}
+	if last != 2 {
+		t.Errorf("Last node should have f 2, got %f", last)
+	}
+}
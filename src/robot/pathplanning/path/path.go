@@ -90,6 +90,57 @@ func (p *Path) Smooth(weightData, weightSmooth, tolerance float64) {
 
 }
 
+// Get points along the rest of the path, from the point on it closest to a
+// position, no more than step apart. The points include the end of the path.
+func (p *Path) PointsAhead(position [2]float64, step float64) [][2]float64 {
+	points := make([][2]float64, 0)
+	if len(p.Poses) == 0 {
+		return points
+	}
+	if len(p.Poses) == 1 {
+		return append(points, [2]float64{p.Poses[0][0], p.Poses[0][1]})
+	}
+
+	// Find the closest point, on segment i
+	closest, segment := [2]float64{}, 0
+	distance := math.Inf(1)
+	for i := 0; i < len(p.Poses)-1; i++ {
+		q := projection(p.Poses[i], p.Poses[i+1], position)
+		if d := math.Hypot(q[0]-position[0], q[1]-position[1]); d < distance {
+			closest, segment, distance = q, i, d
+		}
+	}
+
+	// Walk the rest of the segments
+	from := closest
+	for i := segment + 1; i < len(p.Poses); i++ {
+		to := [2]float64{p.Poses[i][0], p.Poses[i][1]}
+		length := math.Hypot(to[0]-from[0], to[1]-from[1])
+		n := int(math.Ceil(length / step))
+		for j := 0; j < n; j++ {
+			t := float64(j) / float64(n)
+			points = append(points, [2]float64{from[0] + t*(to[0]-from[0]), from[1] + t*(to[1]-from[1])})
+		}
+		from = to
+	}
+
+	return append(points, from)
+}
+
+// Get the point on the line segment from a to b closest to p
+func projection(a, b [3]float64, p [2]float64) [2]float64 {
+	dx, dy := deltas(a, b)
+	lengthSquared := dx*dx + dy*dy
+
+	t := 0.0
+	if lengthSquared > 0 {
+		t = ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / lengthSquared
+		t = math.Max(0, math.Min(1, t))
+	}
+
+	return [2]float64{a[0] + t*dx, a[1] + t*dy}
+}
+
 // Find deltaX and deltaY from two points
 func deltas(a, b [3]float64) (deltaX, deltaY float64) {
 	return b[0] - a[0], b[1] - a[1]
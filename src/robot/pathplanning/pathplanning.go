@@ -3,9 +3,47 @@
 package pathplanning
 
 import (
+	"fmt"
+
 	"robot/pathplanning/path"
 )
 
+// Names of the path planners
+const (
+	ASTAR        = "astar"
+	HYBRID_ASTAR = "hybridastar"
+)
+
+// Reasons path planning fails
+const (
+	START_OUT_OF_MAP = "startOutOfMap"
+	GOAL_OUT_OF_MAP  = "goalOutOfMap"
+	GOAL_OCCUPIED    = "goalOccupied"
+	NO_PATH          = "noPath"
+	ITERATION_LIMIT  = "iterationLimit"
+	TIME_LIMIT       = "timeLimit"
+)
+
 type PathPlanner interface {
 	PlanPath(from, to [3]float64) (*path.Path, error)
 }
+
+// PlanningError is returned by path planners when no path is found, telling
+// why
+type PlanningError struct {
+	Reason     string `json:"reason"`
+	Message    string `json:"message"`
+	Iterations int    `json:"iterations"`
+}
+
+func MakePlanningError(reason string, iterations int, format string, a ...interface{}) *PlanningError {
+	return &PlanningError{
+		Reason:     reason,
+		Message:    fmt.Sprintf(format, a...),
+		Iterations: iterations,
+	}
+}
+
+func (e *PlanningError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,343 @@
+// The binlog package implements a binary sensor log format. A log starts with
+// a header describing the robot and the logged sensors, followed by chunks of
+// length-prefixed records, which may be compressed. The log ends with an index
+// of the chunks by timestamp, so a reader can seek in it.
+//
+// Layout of a log, with all integers in little endian:
+//
+//	"TSLG" | version uint16 | header length uint32 | header (JSON)
+//	chunk length uint32 | chunk ...
+//	0 uint32 | number of chunks uvarint | index entries
+//	index offset int64 | "TSLX"
+//
+// A chunk is a sequence of records, each prefixed with its length as an
+// uvarint. A log which was not closed properly has no index, but can still be
+// read.
+package binlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"robot/config"
+	"robot/logging"
+	"robot/model"
+	"robot/sensors/lidar"
+	"robot/sensors/odometry"
+	"robot/sensors/sensor"
+)
+
+var logger *log.Logger
+
+const (
+	MAGIC         = "TSLG"
+	TRAILER_MAGIC = "TSLX"
+	VERSION       = 1
+
+	COMPRESSION_NONE  = "none"
+	COMPRESSION_FLATE = "flate"
+
+	LIDAR_TYPE    = "lidar"
+	ODOMETRY_TYPE = "odometry"
+)
+
+// Kinds of records
+const (
+	KIND_LIDAR    byte = 1
+	KIND_ODOMETRY byte = 2
+)
+
+var byteOrder = binary.LittleEndian
+
+func init() {
+	logger = logging.New()
+}
+
+// The header describes the log, the robot and the sensors it was made with
+type Header struct {
+	Created     time.Time
+	Compression string
+	Robot       model.DifferentialWheeledRobot
+	Sensors     []SensorHeader
+}
+
+// Parameters of a logged sensor. The type is one of the types in the sensors
+// option, and the parameters are those the sensor reports, plus its serial
+// port settings and mounting.
+type SensorHeader struct {
+	Name       string
+	Type       string
+	Parameters map[string]interface{}
+}
+
+// Make a header describing the given sensors and robot
+func MakeHeader(sensors []sensor.Sensor, robot *model.DifferentialWheeledRobot, compress bool) Header {
+	h := Header{
+		Created:     time.Now(),
+		Compression: COMPRESSION_NONE,
+		Robot:       *robot,
+		Sensors:     make([]SensorHeader, 0, len(sensors)),
+	}
+	if compress {
+		h.Compression = COMPRESSION_FLATE
+	}
+
+	for _, s := range sensors {
+		sh := SensorHeader{
+			Name:       s.GetTypeName(),
+			Parameters: s.GetParameters(),
+		}
+
+		switch s.(type) {
+		case *lidar.Lidar:
+			sh.Type = LIDAR_TYPE
+			sh.Parameters["Baud rate"] = config.GetIntDefault(sh.Name, "lidar_baud_rate", config.LIDAR_BAUD_RATE)
+		case *odometry.Encoder:
+			sh.Type = ODOMETRY_TYPE
+		default:
+			continue
+		}
+
+		h.Sensors = append(h.Sensors, sh)
+	}
+
+	return h
+}
+
+// Make sensors from the sensor headers, so readings can be made from the
+// records. The sensors have no devices, and can't be connected.
+func (h *Header) MakeSensors() []sensor.Sensor {
+	sensors := make([]sensor.Sensor, 0, len(h.Sensors))
+
+	for _, sh := range h.Sensors {
+		switch sh.Type {
+		case LIDAR_TYPE:
+			l := lidar.MakeLidar(sh.Name, nil,
+				sh.getFloat64("Radial span", config.LIDAR_RADIAL_SPAN),
+				sh.getFloat64("Max distance", config.LIDAR_MAX_DISTANCE),
+				int(sh.getFloat64("Distances", float64(config.LIDAR_NUM_DISTANCES))))
+
+			// Logs from before the mount was in the header have the mount
+			// of the config file
+			mount := lidar.MakeMount(sh.Name)
+			l.Mount = lidar.Mount{
+				X:           sh.getFloat64("Position x", mount.X),
+				Y:           sh.getFloat64("Position y", mount.Y),
+				Yaw:         sh.getFloat64("Yaw", mount.Yaw),
+				Clockwise:   sh.getBool("Clockwise", mount.Clockwise),
+				StartOffset: sh.getFloat64("Start offset", mount.StartOffset),
+			}
+			sensors = append(sensors, l)
+		case ODOMETRY_TYPE:
+			sensors = append(sensors, odometry.MakeEncoder(sh.Name, nil))
+		}
+	}
+
+	return sensors
+}
+
+// Get the type of the sensor with the given name, or an empty string if the
+// log has no such sensor
+func (h *Header) GetSensorType(name string) string {
+	for _, sh := range h.Sensors {
+		if sh.Name == name {
+			return sh.Type
+		}
+	}
+	return ""
+}
+
+// Get a numeric parameter. Numbers are float64 after a round trip through
+// JSON, but ints when the header was just made.
+func (sh *SensorHeader) getFloat64(name string, def float64) float64 {
+	switch v := sh.Parameters[name].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	}
+	return def
+}
+
+// Get a bool parameter
+func (sh *SensorHeader) getBool(name string, def bool) bool {
+	if v, ok := sh.Parameters[name].(bool); ok {
+		return v
+	}
+	return def
+}
+
+// A Record is a sensor reading as it is stored in the log. The values are the
+// same as in the body of a CSV record: the span and distances for a LIDAR, and
+// the pulses of the left and right wheel for odometry.
+type Record struct {
+	Kind      byte
+	Sensor    string
+	Timestamp time.Time
+	Values    []float64
+}
+
+// Make a record from a reading
+func MakeRecord(reading sensor.SensorReading) (*Record, error) {
+	r := &Record{
+		Sensor:    reading.GetSensor().GetTypeName(),
+		Timestamp: reading.GetTimestamp(),
+	}
+
+	switch reading := reading.(type) {
+	case *lidar.LidarReading:
+		r.Kind = KIND_LIDAR
+		r.Values = make([]float64, len(reading.Distances)+1)
+		r.Values[0] = reading.Span
+		copy(r.Values[1:], reading.Distances)
+	case *odometry.OdometryReading:
+		r.Kind = KIND_ODOMETRY
+		r.Values = []float64{float64(reading.LeftPulses), float64(reading.RightPulses)}
+	default:
+		return nil, errors.New("Can't log readings of this type.")
+	}
+
+	return r, nil
+}
+
+// Make a reading from the record, from the given sensor
+func (r *Record) MakeReading(s sensor.Sensor) (reading sensor.SensorReading, err error) {
+	switch s := s.(type) {
+	case *lidar.Lidar:
+		if r.Kind != KIND_LIDAR || len(r.Values) == 0 {
+			return nil, errors.New("Record is not a LIDAR reading.")
+		}
+		lr := lidar.MakeLidarReading(s)
+		lr.Span = r.Values[0]
+		lr.Distances = make([]float64, len(r.Values)-1)
+		copy(lr.Distances, r.Values[1:])
+		reading = lr
+	case *odometry.Encoder:
+		if r.Kind != KIND_ODOMETRY || len(r.Values) != 2 {
+			return nil, errors.New("Record is not an odometry reading.")
+		}
+		or := odometry.MakeOdometryReading(s)
+		or.LeftPulses = int(r.Values[0])
+		or.RightPulses = int(r.Values[1])
+		reading = or
+	default:
+		return nil, errors.New("Invalid sensor type")
+	}
+
+	reading.SetTimestamp(r.Timestamp)
+	return reading, nil
+}
+
+// The record as the fields of a CSV record, without losing precision
+func (r *Record) Strings() []string {
+	fields := make([]string, 2, len(r.Values)+2)
+	fields[0] = r.Sensor
+	fields[1] = r.Timestamp.Format(config.SENSORLOGS_TIME_FORMAT)
+	for _, v := range r.Values {
+		fields = append(fields, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return fields
+}
+
+// Encode the record. LIDAR distances are stored as float32, which is exact for
+// whole millimeters.
+func (r *Record) encode(buf []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+
+	buf = append(buf, r.Kind)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(r.Sensor)))]...)
+	buf = append(buf, r.Sensor...)
+	buf = append(buf, tmp[:binary.PutVarint(tmp[:], r.Timestamp.UnixNano())]...)
+
+	switch r.Kind {
+	case KIND_LIDAR:
+		var u64 [8]byte
+		byteOrder.PutUint64(u64[:], math.Float64bits(r.Values[0]))
+		buf = append(buf, u64[:]...)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(r.Values)-1))]...)
+		var u32 [4]byte
+		for _, v := range r.Values[1:] {
+			byteOrder.PutUint32(u32[:], math.Float32bits(float32(v)))
+			buf = append(buf, u32[:]...)
+		}
+	case KIND_ODOMETRY:
+		for _, v := range r.Values {
+			buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(v))]...)
+		}
+	}
+
+	return buf
+}
+
+var errInvalidRecord = errors.New("Invalid record.")
+
+// Decode a record encoded by encode
+func decodeRecord(buf []byte) (*Record, error) {
+	if len(buf) == 0 {
+		return nil, errInvalidRecord
+	}
+	r := &Record{Kind: buf[0]}
+	buf = buf[1:]
+
+	n, k := binary.Uvarint(buf)
+	if k <= 0 || uint64(len(buf)-k) < n {
+		return nil, errInvalidRecord
+	}
+	r.Sensor = string(buf[k : k+int(n)])
+	buf = buf[k+int(n):]
+
+	ns, k := binary.Varint(buf)
+	if k <= 0 {
+		return nil, errInvalidRecord
+	}
+	r.Timestamp = time.Unix(0, ns)
+	buf = buf[k:]
+
+	switch r.Kind {
+	case KIND_LIDAR:
+		if len(buf) < 8 {
+			return nil, errInvalidRecord
+		}
+		span := math.Float64frombits(byteOrder.Uint64(buf))
+		buf = buf[8:]
+
+		n, k := binary.Uvarint(buf)
+		if k <= 0 || uint64(len(buf)-k) < 4*n {
+			return nil, errInvalidRecord
+		}
+		buf = buf[k:]
+
+		r.Values = make([]float64, n+1)
+		r.Values[0] = span
+		for i := 1; i < len(r.Values); i++ {
+			r.Values[i] = float64(math.Float32frombits(byteOrder.Uint32(buf)))
+			buf = buf[4:]
+		}
+	case KIND_ODOMETRY:
+		r.Values = make([]float64, 2)
+		for i := range r.Values {
+			v, k := binary.Varint(buf)
+			if k <= 0 {
+				return nil, errInvalidRecord
+			}
+			r.Values[i] = float64(v)
+			buf = buf[k:]
+		}
+	default:
+		return nil, errors.New("Unknown record kind.")
+	}
+
+	return r, nil
+}
+
+// An index entry tells where a chunk starts, the earliest timestamp in it and
+// how many records it has
+type IndexEntry struct {
+	Timestamp time.Time
+	Offset    int64
+	Records   int
+}
@@ -0,0 +1,271 @@
+package binlog
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"robot/model"
+	"robot/sensors/lidar"
+	"robot/sensors/odometry"
+	"robot/sensors/sensor"
+)
+
+// Write n LIDAR readings, each followed by an odometry reading, 100 ms apart
+func writeTestLog(t *testing.T, n int, compress bool, close bool) ([]byte, time.Time) {
+	l := lidar.MakeDefaultLidar()
+	e := odometry.MakeDefaultEncoder()
+
+	var buf bytes.Buffer
+	bw, err := MakeWriter(&buf, MakeHeader([]sensor.Sensor{l, e}, model.MakeDefaultDifferentialWheeledRobot(), compress))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bw.ChunkSize = 4096
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		lr := lidar.MakeLidarReading(l)
+		for j := range lr.Distances {
+			lr.Distances[j] = float64(1000+i+j) + 0.25
+		}
+		lr.SetTimestamp(start.Add(time.Duration(i) * 100 * time.Millisecond))
+
+		or := odometry.MakeOdometryReading(e)
+		or.LeftPulses, or.RightPulses = i, -i
+		or.SetTimestamp(lr.GetTimestamp().Add(time.Millisecond))
+
+		for _, reading := range []sensor.SensorReading{lr, or} {
+			err = bw.WriteReading(reading)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if close {
+		err = bw.Close()
+	} else {
+		err = bw.Flush()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes(), start
+}
+
+func readAll(t *testing.T, br *Reader) []*Record {
+	records := make([]*Record, 0)
+	for {
+		r, err := br.ReadRecord()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		data, start := writeTestLog(t, 50, compress, true)
+
+		isBinary, err := IsBinaryLog(bytes.NewReader(data))
+		if err != nil || !isBinary {
+			t.Fatalf("Log not detected as binary: %v", err)
+		}
+
+		br, err := MakeReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header := br.GetHeader()
+		if header.GetSensorType(lidar.NAME) != LIDAR_TYPE || header.GetSensorType(odometry.NAME) != ODOMETRY_TYPE {
+			t.Errorf("Header has the wrong sensors: %v", header.Sensors)
+		}
+		if len(br.index) < 2 {
+			t.Errorf("Index has %d chunks, want several", len(br.index))
+		}
+
+		records := readAll(t, br)
+		if len(records) != 100 {
+			t.Fatalf("Read %d records, want 100", len(records))
+		}
+
+		lr := records[10]
+		if lr.Sensor != lidar.NAME || !lr.Timestamp.Equal(start.Add(500*time.Millisecond)) {
+			t.Errorf("Record 10 is %s at %s", lr.Sensor, lr.Timestamp)
+		}
+		if lr.Values[1] != 1005.25 {
+			t.Errorf("First distance is %f, want 1005.25", lr.Values[1])
+		}
+
+		or := records[11]
+		if or.Values[0] != 5 || or.Values[1] != -5 {
+			t.Errorf("Odometry record has pulses %v, want 5, -5", or.Values)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	plain, _ := writeTestLog(t, 50, false, true)
+	compressed, _ := writeTestLog(t, 50, true, true)
+
+	if len(compressed) >= len(plain) {
+		t.Errorf("Compressed log is %d bytes, uncompressed %d", len(compressed), len(plain))
+	}
+}
+
+func TestSeek(t *testing.T) {
+	data, start := writeTestLog(t, 50, true, true)
+	br, err := MakeReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = br.Seek(start.Add(3050 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := br.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(3100 * time.Millisecond); !r.Timestamp.Equal(want) {
+		t.Errorf("First record after seeking is at %s, want %s", r.Timestamp, want)
+	}
+
+	// Seeking before the start gives the first record
+	err = br.Seek(start.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := readAll(t, br); len(records) != 100 {
+		t.Errorf("Read %d records after seeking to the start, want 100", len(records))
+	}
+}
+
+// A log which was not closed has no index, and may end in a partial chunk
+func TestUnclosedLog(t *testing.T) {
+	data, start := writeTestLog(t, 50, true, false)
+
+	br, err := MakeReader(bytes.NewReader(data[:len(data)-10]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAll(t, br)
+	if len(records) == 0 || len(records) >= 100 {
+		t.Errorf("Read %d records from a truncated log", len(records))
+	}
+
+	err = br.Seek(start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := br.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Timestamp.Equal(start.Add(time.Second)) {
+		t.Errorf("First record after seeking is at %s", r.Timestamp)
+	}
+}
+
+// A chunk which can't be decompressed is skipped
+func TestCorruptChunk(t *testing.T) {
+	data, _ := writeTestLog(t, 50, true, true)
+	br, err := MakeReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reserved block type after the length of the second chunk
+	corrupt := br.index[1]
+	data[corrupt.Offset+4] = 0xff
+
+	records := readAll(t, br)
+	if want := 100 - corrupt.Records; len(records) != want {
+		t.Errorf("Read %d records, want %d", len(records), want)
+	}
+}
+
+func TestMakeSensors(t *testing.T) {
+	l := lidar.MakeLidar("FRONT", nil, 180, 5000, 181)
+	data, err := jsonRoundTrip(MakeHeader([]sensor.Sensor{l}, model.MakeDefaultDifferentialWheeledRobot(), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sensors := data.MakeSensors()
+	if len(sensors) != 1 {
+		t.Fatalf("Made %d sensors, want 1", len(sensors))
+	}
+	made, ok := sensors[0].(*lidar.Lidar)
+	if !ok || made.GetTypeName() != "FRONT" || made.RadialSpan != 180 || made.Distances != 181 {
+		t.Errorf("Made sensor %v, want a copy of %v", sensors[0], l)
+	}
+}
+
+func TestMakeSensorsMount(t *testing.T) {
+	l := lidar.MakeLidar("REAR", nil, 240, 4000, 241)
+	l.Mount = lidar.Mount{X: -0.2, Y: 0.05, Yaw: 180, Clockwise: true, StartOffset: 7}
+	data, err := jsonRoundTrip(MakeHeader([]sensor.Sensor{l}, model.MakeDefaultDifferentialWheeledRobot(), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	made, ok := data.MakeSensors()[0].(*lidar.Lidar)
+	if !ok || made.Mount != l.Mount {
+		t.Errorf("Made sensor with mount %+v, want %+v", made.Mount, l.Mount)
+	}
+
+	// Readings of the sensor get the mount too
+	if reading := lidar.MakeLidarReading(made); reading.Mount != l.Mount {
+		t.Errorf("Reading has mount %+v, want %+v", reading.Mount, l.Mount)
+	}
+}
+
+// Write and read a header, to get it as a reader would
+func jsonRoundTrip(h Header) (*Header, error) {
+	var buf bytes.Buffer
+	_, err := MakeWriter(&buf, h)
+	if err != nil {
+		return nil, err
+	}
+	br, err := MakeReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return br.GetHeader(), nil
+}
+
+func TestSeekRecord(t *testing.T) {
+	data, start := writeTestLog(t, 50, true, true)
+	br, err := MakeReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = br.SeekRecord(61)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.GetPosition() != 61 {
+		t.Errorf("Position is %d after seeking to record 61", br.GetPosition())
+	}
+
+	r, err := br.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(3001 * time.Millisecond); r.Sensor != odometry.NAME || !r.Timestamp.Equal(want) {
+		t.Errorf("Record 61 is %s at %s, want %s at %s", r.Sensor, r.Timestamp, odometry.NAME, want)
+	}
+}
@@ -0,0 +1,401 @@
+package binlog
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// A Reader reads records from a binary log
+type Reader struct {
+	r      io.ReadSeeker
+	header Header
+
+	// Where the chunks start and end. The end is unknown, -1, if the log has
+	// no index.
+	dataStart, dataEnd int64
+
+	index []IndexEntry
+
+	// Offset of the next chunk, the records left of the current chunk, and a
+	// record which was read while seeking
+	offset  int64
+	chunk   []byte
+	pending *Record
+
+	// Number of the next record
+	position int
+}
+
+// Check if the log is a binary log. The log is read from the start, and left
+// at the start.
+func IsBinaryLog(r io.ReadSeeker) (bool, error) {
+	_, err := r.Seek(0, 0)
+	if err != nil {
+		return false, err
+	}
+
+	magic := make([]byte, len(MAGIC))
+	_, err = io.ReadFull(r, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	_, seekErr := r.Seek(0, 0)
+	if err == nil {
+		err = seekErr
+	}
+
+	return string(magic) == MAGIC, err
+}
+
+// Make a reader, reading the header and index of the log
+func MakeReader(r io.ReadSeeker) (*Reader, error) {
+	br := &Reader{
+		r:       r,
+		dataEnd: -1,
+	}
+
+	_, err := r.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	start := make([]byte, len(MAGIC)+6)
+	_, err = io.ReadFull(r, start)
+	if err != nil || string(start[:len(MAGIC)]) != MAGIC {
+		return nil, errors.New("Not a binary sensor log.")
+	}
+	if version := byteOrder.Uint16(start[4:]); version > VERSION {
+		return nil, errors.New("Unsupported log version.")
+	}
+
+	data := make([]byte, byteOrder.Uint32(start[6:]))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &br.header)
+	if err != nil {
+		return nil, err
+	}
+
+	br.dataStart = int64(len(start) + len(data))
+	br.offset = br.dataStart
+
+	err = br.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return br, nil
+}
+
+// Read the index from the end of the log, if there is one
+func (br *Reader) readIndex() error {
+	end, err := br.r.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+
+	trailerLength := int64(8 + len(TRAILER_MAGIC))
+	if end-trailerLength < br.dataStart {
+		return nil
+	}
+
+	_, err = br.r.Seek(end-trailerLength, 0)
+	if err != nil {
+		return err
+	}
+	trailer := make([]byte, trailerLength)
+	_, err = io.ReadFull(br.r, trailer)
+	if err != nil {
+		return err
+	}
+	if string(trailer[8:]) != TRAILER_MAGIC {
+		logger.Println("Sensor log has no index, it was probably not closed.")
+		return nil
+	}
+
+	indexOffset := int64(byteOrder.Uint64(trailer))
+	if indexOffset < br.dataStart || indexOffset > end-trailerLength-4 {
+		return errors.New("Invalid index offset.")
+	}
+
+	_, err = br.r.Seek(indexOffset, 0)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, end-trailerLength-indexOffset)
+	_, err = io.ReadFull(br.r, buf)
+	if err != nil {
+		return err
+	}
+
+	// Skip the end marker of the chunks
+	buf = buf[4:]
+	n, k := binary.Uvarint(buf)
+	if k <= 0 {
+		return errors.New("Invalid index.")
+	}
+	buf = buf[k:]
+
+	index := make([]IndexEntry, n)
+	for i := range index {
+		values := [3]int64{}
+		for j := range values {
+			var k int
+			if j == 0 {
+				values[j], k = binary.Varint(buf)
+			} else {
+				var v uint64
+				v, k = binary.Uvarint(buf)
+				values[j] = int64(v)
+			}
+			if k <= 0 {
+				return errors.New("Invalid index.")
+			}
+			buf = buf[k:]
+		}
+		index[i] = IndexEntry{
+			Timestamp: time.Unix(0, values[0]),
+			Offset:    values[1],
+			Records:   int(values[2]),
+		}
+	}
+
+	br.index = index
+	br.dataEnd = indexOffset
+
+	return nil
+}
+
+// Get the header of the log
+func (br *Reader) GetHeader() *Header {
+	return &br.header
+}
+
+// Read the next chunk. A chunk cut short, as the last chunk of a log which
+// was not closed may be, is treated as the end of the log. A chunk which
+// can't be decompressed is skipped, leaving the chunk empty.
+func (br *Reader) readChunk() error {
+	if br.dataEnd >= 0 && br.offset >= br.dataEnd {
+		return io.EOF
+	}
+
+	_, err := br.r.Seek(br.offset, 0)
+	if err != nil {
+		return err
+	}
+
+	var length [4]byte
+	_, err = io.ReadFull(br.r, length[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+
+	n := byteOrder.Uint32(length[:])
+	if n == 0 {
+		return io.EOF
+	}
+
+	data := make([]byte, n)
+	_, err = io.ReadFull(br.r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		logger.Println("Sensor log ends in an incomplete chunk.")
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+
+	if br.header.Compression == COMPRESSION_FLATE {
+		fr := flate.NewReader(bytes.NewReader(data))
+		data, err = ioutil.ReadAll(fr)
+		fr.Close()
+		if err != nil {
+			logger.Println("Skipping a corrupt chunk of the sensor log:", err)
+			data = nil
+		}
+	}
+
+	br.chunk = data
+	br.offset += int64(len(length)) + int64(n)
+
+	return nil
+}
+
+// Read the next record. Returns io.EOF at the end of the log.
+func (br *Reader) ReadRecord() (*Record, error) {
+	if br.pending != nil {
+		r := br.pending
+		br.pending = nil
+		br.position++
+		return r, nil
+	}
+
+	for len(br.chunk) == 0 {
+		err := br.readChunk()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n, k := binary.Uvarint(br.chunk)
+	if k <= 0 || uint64(len(br.chunk)-k) < n {
+		br.chunk = nil
+		return nil, errInvalidRecord
+	}
+	data := br.chunk[k : k+int(n)]
+	br.chunk = br.chunk[k+int(n):]
+
+	r, err := decodeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	br.position++
+
+	return r, nil
+}
+
+// Get the number of the next record, counting from 0
+func (br *Reader) GetPosition() int {
+	return br.position
+}
+
+// Start reading from the beginning of the log
+func (br *Reader) Rewind() {
+	br.offset = br.dataStart
+	br.chunk = nil
+	br.pending = nil
+	br.position = 0
+}
+
+// Get the index of the log. If the log has no index, it is made by reading
+// through the log.
+func (br *Reader) GetIndex() ([]IndexEntry, error) {
+	if br.index != nil {
+		return br.index, nil
+	}
+
+	offset, chunk, pending, position := br.offset, br.chunk, br.pending, br.position
+	br.Rewind()
+
+	index := make([]IndexEntry, 0)
+	var err error
+	for {
+		entry := IndexEntry{Offset: br.offset}
+		err = br.readChunk()
+		if err != nil {
+			break
+		}
+
+		for len(br.chunk) > 0 {
+			var r *Record
+			r, err = br.ReadRecord()
+			if err != nil {
+				break
+			}
+			if entry.Records == 0 || r.Timestamp.Before(entry.Timestamp) {
+				entry.Timestamp = r.Timestamp
+			}
+			entry.Records++
+		}
+		if err != nil {
+			break
+		}
+
+		index = append(index, entry)
+	}
+
+	br.offset, br.chunk, br.pending, br.position = offset, chunk, pending, position
+	if err != io.EOF {
+		return nil, err
+	}
+
+	br.index = index
+	return index, nil
+}
+
+// Seek to the first record at or after the given time. Records are in the
+// order they were logged, which may not be strictly by timestamp when several
+// sensors are logged, so records just before the time may follow.
+func (br *Reader) Seek(t time.Time) error {
+	index, err := br.GetIndex()
+	if err != nil {
+		return err
+	}
+
+	// The last chunk starting at or before the time
+	i := sort.Search(len(index), func(i int) bool {
+		return index[i].Timestamp.After(t)
+	}) - 1
+
+	br.seekChunk(index, i)
+
+	for {
+		r, err := br.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !r.Timestamp.Before(t) {
+			br.pending = r
+			br.position--
+			return nil
+		}
+	}
+}
+
+// Seek to the record with the given number, counting from 0
+func (br *Reader) SeekRecord(n int) error {
+	index, err := br.GetIndex()
+	if err != nil {
+		return err
+	}
+
+	// The chunk with the record
+	i, first := 0, 0
+	for i < len(index)-1 && first+index[i].Records <= n {
+		first += index[i].Records
+		i++
+	}
+
+	br.seekChunk(index, i)
+
+	for br.position < n {
+		_, err := br.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Continue reading from the start of the chunk with the given index
+func (br *Reader) seekChunk(index []IndexEntry, i int) {
+	br.Rewind()
+	if i <= 0 {
+		return
+	}
+
+	br.offset = index[i].Offset
+	for _, entry := range index[:i] {
+		br.position += entry.Records
+	}
+}
@@ -0,0 +1,203 @@
+package binlog
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"robot/sensors/sensor"
+)
+
+// A Writer writes readings to a binary log. It is safe to write from several
+// goroutines.
+type Writer struct {
+	w      io.Writer
+	header Header
+	offset int64
+
+	// Records of the current chunk, and the record being encoded
+	chunk        []byte
+	record       []byte
+	chunkRecords int
+	chunkEntry   IndexEntry
+
+	index  []IndexEntry
+	closed bool
+	lock   *sync.Mutex
+
+	// The chunk is written when it reaches this number of bytes before
+	// compression
+	ChunkSize int
+}
+
+// Make a writer, and write the header to w
+func MakeWriter(w io.Writer, header Header) (*Writer, error) {
+	if header.Compression != COMPRESSION_NONE && header.Compression != COMPRESSION_FLATE {
+		return nil, errors.New("Unknown compression.")
+	}
+
+	bw := &Writer{
+		w:         w,
+		header:    header,
+		index:     make([]IndexEntry, 0),
+		lock:      new(sync.Mutex),
+		ChunkSize: 64 * 1024,
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(data)+10)
+	buf = append(buf, MAGIC...)
+	buf = append(buf, 0, 0, 0, 0, 0, 0)
+	byteOrder.PutUint16(buf[4:], VERSION)
+	byteOrder.PutUint32(buf[6:], uint32(len(data)))
+	buf = append(buf, data...)
+
+	err = bw.write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return bw, nil
+}
+
+func (bw *Writer) write(buf []byte) error {
+	n, err := bw.w.Write(buf)
+	bw.offset += int64(n)
+	return err
+}
+
+// Write a reading to the log
+func (bw *Writer) WriteReading(reading sensor.SensorReading) error {
+	r, err := MakeRecord(reading)
+	if err != nil {
+		return err
+	}
+	return bw.WriteRecord(r)
+}
+
+// Write a record to the log
+func (bw *Writer) WriteRecord(r *Record) error {
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+
+	if bw.closed {
+		return errors.New("Log is closed.")
+	}
+
+	if bw.chunkRecords == 0 || r.Timestamp.Before(bw.chunkEntry.Timestamp) {
+		bw.chunkEntry.Timestamp = r.Timestamp
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	bw.record = r.encode(bw.record[:0])
+	bw.chunk = append(bw.chunk, tmp[:binary.PutUvarint(tmp[:], uint64(len(bw.record)))]...)
+	bw.chunk = append(bw.chunk, bw.record...)
+	bw.chunkRecords++
+
+	if len(bw.chunk) >= bw.ChunkSize {
+		return bw.flush()
+	}
+	return nil
+}
+
+// Write the current chunk to the underlying writer
+func (bw *Writer) Flush() error {
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+
+	return bw.flush()
+}
+
+func (bw *Writer) flush() error {
+	if bw.chunkRecords == 0 {
+		return nil
+	}
+
+	data := bw.chunk
+	if bw.header.Compression == COMPRESSION_FLATE {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(bw.chunk)
+		if err != nil {
+			return err
+		}
+		err = fw.Close()
+		if err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	entry := bw.chunkEntry
+	entry.Offset = bw.offset
+	entry.Records = bw.chunkRecords
+
+	var length [4]byte
+	byteOrder.PutUint32(length[:], uint32(len(data)))
+	err := bw.write(length[:])
+	if err != nil {
+		return err
+	}
+	err = bw.write(data)
+	if err != nil {
+		return err
+	}
+
+	bw.index = append(bw.index, entry)
+	bw.chunk = bw.chunk[:0]
+	bw.chunkRecords = 0
+
+	return nil
+}
+
+// Write the last chunk and the index. Closing doesn't close the underlying
+// writer.
+func (bw *Writer) Close() error {
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+
+	if bw.closed {
+		return nil
+	}
+
+	err := bw.flush()
+	if err != nil {
+		return err
+	}
+	bw.closed = true
+
+	indexOffset := bw.offset
+	var tmp [binary.MaxVarintLen64]byte
+
+	// The end of the chunks is marked by a zero length
+	buf := make([]byte, 4, 4+len(bw.index)*24+len(TRAILER_MAGIC)+8)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(bw.index)))]...)
+	for _, entry := range bw.index {
+		buf = append(buf, tmp[:binary.PutVarint(tmp[:], entry.Timestamp.UnixNano())]...)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(entry.Offset))]...)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(entry.Records))]...)
+	}
+
+	var u64 [8]byte
+	byteOrder.PutUint64(u64[:], uint64(indexOffset))
+	buf = append(buf, u64[:]...)
+	buf = append(buf, TRAILER_MAGIC...)
+
+	return bw.write(buf)
+}
+
+// Get the header written to the log
+func (bw *Writer) GetHeader() Header {
+	return bw.header
+}
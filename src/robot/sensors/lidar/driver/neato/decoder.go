@@ -0,0 +1,198 @@
+package neato
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// The packet format of the Neato XV LIDAR. A packet holds four readings:
+//
+//	0xFA, index, speed (2 bytes), 4 x [distance (2 bytes), strength (2 bytes)], checksum (2 bytes)
+//
+// All words are little endian. The index runs from 0xA0 to 0xF9 over a
+// revolution, a packet covering the degrees 4*(index-0xA0) to
+// 4*(index-0xA0)+3. The speed is in 1/64 RPM. The top bit of a distance
+// flags it invalid and the next one flags a weak signal, the remaining 14
+// bits are the distance in mm.
+const (
+	PACKET_LENGTH          = 22
+	START_BYTE             = 0xFA
+	FIRST_INDEX            = 0xA0
+	PACKETS_PER_REVOLUTION = 90
+	READINGS_PER_PACKET    = 4
+	DEGREES                = PACKETS_PER_REVOLUTION * READINGS_PER_PACKET
+)
+
+// A Packet of four readings
+type Packet struct {
+	// The index of the packet in the revolution, 0-89
+	Index int
+
+	// Rotation speed in revolutions per minute
+	RPM float64
+
+	// Distances in mm, 0 where invalid, and signal strengths
+	Distances [READINGS_PER_PACKET]int
+	Strengths [READINGS_PER_PACKET]int
+
+	// Readings flagged invalid, and flagged as having a weak signal
+	Invalid [READINGS_PER_PACKET]bool
+	Weak    [READINGS_PER_PACKET]bool
+
+	// When the packet was decoded
+	Timestamp time.Time
+}
+
+// A Revolution is a full turn of the LIDAR, one reading per degree
+type Revolution struct {
+	// Distances in mm, 0 where invalid or missing, and signal strengths
+	Distances [DEGREES]int
+	Strengths [DEGREES]int
+
+	// The mean rotation speed in revolutions per minute
+	RPM float64
+
+	// The number of packets received out of PACKETS_PER_REVOLUTION
+	Packets int
+
+	// When the first packet was decoded
+	Timestamp time.Time
+}
+
+// A Decoder decodes packets from a stream of bytes, e.g. a serial port. It
+// finds the start of packets wherever they are in the stream, so it may be
+// started in the middle of a packet, and packets may be split across reads.
+// Packets with bad checksums are skipped.
+type Decoder struct {
+	r *bufio.Reader
+
+	// A packet read past the end of a revolution
+	pending *Packet
+
+	// Packets skipped for bad checksums
+	checksumErrors int
+}
+
+// Make a decoder reading from r
+func MakeDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r: bufio.NewReaderSize(r, 16*PACKET_LENGTH),
+	}
+}
+
+// Get the number of packets skipped for bad checksums
+func (d *Decoder) GetChecksumErrors() int {
+	return d.checksumErrors
+}
+
+// Read the next valid packet. Returns the error of the reader if it fails.
+func (d *Decoder) ReadPacket() (*Packet, error) {
+	if p := d.pending; p != nil {
+		d.pending = nil
+		return p, nil
+	}
+
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != START_BYTE {
+			continue
+		}
+
+		// Look at the rest of the packet, without consuming it in case
+		// this wasn't the start of one
+		rest, err := d.r.Peek(PACKET_LENGTH - 1)
+		if err != nil {
+			return nil, err
+		}
+		if rest[0] < FIRST_INDEX || rest[0] >= FIRST_INDEX+PACKETS_PER_REVOLUTION {
+			continue
+		}
+
+		data := make([]byte, PACKET_LENGTH)
+		data[0] = START_BYTE
+		copy(data[1:], rest)
+		if checksum(data) != word(data, PACKET_LENGTH-2) {
+			d.checksumErrors++
+			continue
+		}
+
+		d.r.Discard(PACKET_LENGTH - 1)
+		return parsePacket(data), nil
+	}
+}
+
+// Read packets until the index wraps around, and gather them in a revolution.
+// Packets lost to bad checksums leave zeros in the revolution.
+func (d *Decoder) ReadRevolution() (*Revolution, error) {
+	rev := &Revolution{}
+	speed := 0.0
+	last := -1
+
+	for {
+		p, err := d.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		// The next revolution has started
+		if p.Index <= last {
+			d.pending = p
+			break
+		}
+
+		if last < 0 {
+			rev.Timestamp = p.Timestamp
+		}
+		last = p.Index
+
+		for i := 0; i < READINGS_PER_PACKET; i++ {
+			rev.Distances[p.Index*READINGS_PER_PACKET+i] = p.Distances[i]
+			rev.Strengths[p.Index*READINGS_PER_PACKET+i] = p.Strengths[i]
+		}
+		speed += p.RPM
+		rev.Packets++
+	}
+
+	rev.RPM = speed / float64(rev.Packets)
+	return rev, nil
+}
+
+// Parse a packet with a valid checksum
+func parsePacket(data []byte) *Packet {
+	p := &Packet{
+		Index:     int(data[1] - FIRST_INDEX),
+		RPM:       float64(word(data, 2)) / 64,
+		Timestamp: time.Now(),
+	}
+
+	for i := 0; i < READINGS_PER_PACKET; i++ {
+		offset := 4 + 4*i
+		p.Invalid[i] = data[offset+1]&0x80 != 0
+		p.Weak[i] = data[offset+1]&0x40 != 0
+		if !p.Invalid[i] {
+			p.Distances[i] = int(word(data, offset) & 0x3FFF)
+		}
+		p.Strengths[i] = int(word(data, offset+2))
+	}
+
+	return p
+}
+
+// Get the little endian word at offset
+func word(data []byte, offset int) uint16 {
+	return uint16(data[offset]) | uint16(data[offset+1])<<8
+}
+
+// Compute the checksum of a packet, from the words before the checksum
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i < PACKET_LENGTH-2; i += 2 {
+		sum = sum<<1 + uint32(word(data, i))
+	}
+	sum = (sum & 0x7FFF) + (sum >> 15)
+	return uint16(sum & 0x7FFF)
+}
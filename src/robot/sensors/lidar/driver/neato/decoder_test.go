@@ -0,0 +1,139 @@
+package neato
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// Encode a packet the way the LIDAR does. The distance of a reading is
+// flagged invalid if it's negative.
+func encodePacket(index int, rpm float64, distances, strengths [READINGS_PER_PACKET]int) []byte {
+	data := make([]byte, PACKET_LENGTH)
+	data[0] = START_BYTE
+	data[1] = byte(FIRST_INDEX + index)
+	speed := uint16(rpm * 64)
+	data[2], data[3] = byte(speed), byte(speed>>8)
+
+	for i := 0; i < READINGS_PER_PACKET; i++ {
+		offset := 4 + 4*i
+		distance := uint16(distances[i])
+		if distances[i] < 0 {
+			distance = 0x8000
+		}
+		data[offset], data[offset+1] = byte(distance), byte(distance>>8)
+		data[offset+2], data[offset+3] = byte(strengths[i]), byte(strengths[i]>>8)
+	}
+
+	sum := checksum(data)
+	data[PACKET_LENGTH-2], data[PACKET_LENGTH-1] = byte(sum), byte(sum>>8)
+	return data
+}
+
+// Encode the packets from first to last, with distances of 1000 mm plus the
+// degree
+func encodeRevolution(first, last int, rpm float64) []byte {
+	var stream []byte
+	for index := first; index <= last; index++ {
+		var distances, strengths [READINGS_PER_PACKET]int
+		for i := range distances {
+			distances[i] = 1000 + index*READINGS_PER_PACKET + i
+			strengths[i] = 100 + i
+		}
+		stream = append(stream, encodePacket(index, rpm, distances, strengths)...)
+	}
+	return stream
+}
+
+func TestReadPacket(t *testing.T) {
+	// Starting in the middle of a packet, with a start byte in the noise
+	stream := []byte{0x12, START_BYTE, 0x03, START_BYTE}
+	stream = append(stream, encodePacket(5, 300, [4]int{1500, -1, 0x4000 | 250, 3000}, [4]int{10, 20, 30, 40})...)
+
+	// Split across reads of a byte at a time
+	d := MakeDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+	p, err := d.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Index != 5 || p.RPM != 300 {
+		t.Errorf("Got index %d and %f RPM, wanted 5 and 300", p.Index, p.RPM)
+	}
+	if p.Distances != [4]int{1500, 0, 250, 3000} {
+		t.Errorf("Got distances %v", p.Distances)
+	}
+	if p.Strengths != [4]int{10, 20, 30, 40} {
+		t.Errorf("Got strengths %v", p.Strengths)
+	}
+	if p.Invalid != [4]bool{false, true, false, false} || p.Weak != [4]bool{false, false, true, false} {
+		t.Errorf("Got flags %v and %v", p.Invalid, p.Weak)
+	}
+
+	if _, err := d.ReadPacket(); err != io.EOF {
+		t.Errorf("Got %v at the end of the stream, wanted EOF", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	corrupt := encodePacket(1, 300, [4]int{1, 2, 3, 4}, [4]int{})
+	corrupt[6]++
+	stream := append(corrupt, encodePacket(2, 300, [4]int{1, 2, 3, 4}, [4]int{})...)
+
+	d := MakeDecoder(bytes.NewReader(stream))
+	p, err := d.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Index != 2 {
+		t.Errorf("Got packet %d, wanted the corrupt one skipped", p.Index)
+	}
+	if d.GetChecksumErrors() != 1 {
+		t.Errorf("Got %d checksum errors, wanted 1", d.GetChecksumErrors())
+	}
+}
+
+func TestReadRevolution(t *testing.T) {
+	// The end of a revolution, a full one with a lost packet, and the start
+	// of the next
+	stream := encodeRevolution(80, 89, 240)
+	full := encodeRevolution(0, 89, 300)
+	full[10*PACKET_LENGTH+4]++
+	stream = append(stream, full...)
+	stream = append(stream, encodeRevolution(0, 0, 300)...)
+
+	d := MakeDecoder(iotest.HalfReader(bytes.NewReader(stream)))
+	rev, err := d.ReadRevolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Packets != 10 || rev.RPM != 240 || rev.Distances[0] != 0 || rev.Distances[359] != 1359 {
+		t.Errorf("Got %d packets at %f RPM in the partial revolution", rev.Packets, rev.RPM)
+	}
+
+	rev, err = d.ReadRevolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Packets != 89 || rev.RPM != 300 {
+		t.Errorf("Got %d packets at %f RPM, wanted 89 at 300", rev.Packets, rev.RPM)
+	}
+	if rev.Timestamp.IsZero() {
+		t.Error("No timestamp")
+	}
+	for degree, distance := range rev.Distances {
+		want := 1000 + degree
+		if degree/READINGS_PER_PACKET == 10 {
+			want = 0
+		}
+		if distance != want {
+			t.Fatalf("Got %d mm at %d degrees, wanted %d", distance, degree, want)
+		}
+	}
+
+	// The last revolution isn't finished
+	if _, err := d.ReadRevolution(); err != io.EOF {
+		t.Errorf("Got %v, wanted EOF", err)
+	}
+}
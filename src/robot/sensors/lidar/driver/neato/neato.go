@@ -0,0 +1,239 @@
+// Package neato is the driver of the Neato XV LIDAR. The LIDAR streams its
+// readings as soon as it's powered, in packets of four degrees which are
+// decoded and gathered into revolutions. The scans handed out are a window of
+// the revolution, starting at a degree of the LIDAR's own.
+package neato
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"robot/logging"
+	"robot/sensors/lidar/driver"
+)
+
+// Time between attempts to reopen the port after an error
+const RECONNECT_DELAY = time.Second
+
+// Time to wait for a scan before giving up
+const SCAN_TIMEOUT = 2 * time.Second
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+var errNotConnected = errors.New("Neato LIDAR not connected.")
+
+// Neato implements lidar.Device, reading from a port which is reopened after
+// errors until disconnected
+type Neato struct {
+	// Opens the port the LIDAR streams on
+	open func() (io.ReadCloser, error)
+
+	// The degree of the first distance of the scans, and their number of
+	// distances, one per degree
+	startAngle int
+	beams      int
+
+	reconnectDelay time.Duration
+
+	lock  sync.Mutex
+	port  io.ReadCloser
+	stop  chan struct{}
+	scans chan *driver.Scan
+}
+
+// Make a Neato LIDAR on a serial port
+func MakeNeato(device string, baudRate, startAngle, beams int) *Neato {
+	return MakeNeatoWithOpener(func() (io.ReadCloser, error) {
+		return serial.OpenPort(&serial.Config{Name: device, Baud: baudRate})
+	}, startAngle, beams)
+}
+
+// Make a Neato LIDAR reading from whatever open returns, e.g. a recorded
+// stream
+func MakeNeatoWithOpener(open func() (io.ReadCloser, error), startAngle, beams int) *Neato {
+	return &Neato{
+		open:           open,
+		startAngle:     startAngle,
+		beams:          beams,
+		reconnectDelay: RECONNECT_DELAY,
+	}
+}
+
+// Open the port, and start decoding scans from it
+func (n *Neato) Connect() error {
+	port, err := n.open()
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.stop != nil {
+		port.Close()
+		return errors.New("Neato LIDAR already connected.")
+	}
+	n.port = port
+	n.stop = make(chan struct{})
+	n.scans = make(chan *driver.Scan, 1)
+
+	go n.run(port, n.stop, n.scans)
+
+	return nil
+}
+
+// Stop decoding and close the port
+func (n *Neato) Disconnect() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.stop == nil {
+		return
+	}
+
+	close(n.stop)
+	n.stop = nil
+	if n.port != nil {
+		n.port.Close()
+		n.port = nil
+	}
+}
+
+// The LIDAR streams by itself, so there's nothing to request
+func (n *Neato) RequestInfiniteData() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.stop == nil {
+		return errNotConnected
+	}
+	return nil
+}
+
+// Wait for the next scan, and get its distances
+func (n *Neato) ReceiveData() ([]float64, error) {
+	scan, err := n.ReceiveScan()
+	if err != nil {
+		return nil, err
+	}
+	return scan.Distances, nil
+}
+
+// Wait for the next scan. Scans which weren't received in time are dropped, so
+// this always gives the latest.
+func (n *Neato) ReceiveScan() (*driver.Scan, error) {
+	n.lock.Lock()
+	scans, stop := n.scans, n.stop
+	n.lock.Unlock()
+	if stop == nil {
+		return nil, errNotConnected
+	}
+
+	timer := time.NewTimer(SCAN_TIMEOUT)
+	defer timer.Stop()
+
+	select {
+	case scan := <-scans:
+		return scan, nil
+	case <-stop:
+		return nil, errNotConnected
+	case <-timer.C:
+		return nil, errors.New("No scan from the Neato LIDAR.")
+	}
+}
+
+// Decode scans from the port into scans until stop is closed, reopening it
+// after errors
+func (n *Neato) run(port io.ReadCloser, stop chan struct{}, scans chan *driver.Scan) {
+	for {
+		err := n.decode(port, stop, scans)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		logger.Println("Neato LIDAR:", err)
+		port.Close()
+
+		// Reopen the port
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(n.reconnectDelay):
+			}
+
+			port, err = n.open()
+			if err != nil {
+				logger.Println("Neato LIDAR:", err)
+				continue
+			}
+
+			n.lock.Lock()
+			if n.stop != stop {
+				n.lock.Unlock()
+				port.Close()
+				return
+			}
+			n.port = port
+			n.lock.Unlock()
+
+			logger.Println("Neato LIDAR reconnected.")
+			break
+		}
+	}
+}
+
+// Decode scans from the port until it fails, replacing scans not yet received
+func (n *Neato) decode(port io.Reader, stop chan struct{}, scans chan *driver.Scan) error {
+	decoder := MakeDecoder(port)
+	for {
+		rev, err := decoder.ReadRevolution()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		scan := n.makeScan(rev)
+		select {
+		case scans <- scan:
+		default:
+			// Replace the old scan
+			select {
+			case <-scans:
+			default:
+			}
+			scans <- scan
+		}
+	}
+}
+
+// Get the window of a revolution which makes up the scans
+func (n *Neato) makeScan(rev *Revolution) *driver.Scan {
+	scan := &driver.Scan{
+		Distances:   make([]float64, n.beams),
+		Intensities: make([]float64, n.beams),
+		RPM:         rev.RPM,
+		Timestamp:   rev.Timestamp,
+	}
+
+	for i := range scan.Distances {
+		angle := ((n.startAngle+i)%DEGREES + DEGREES) % DEGREES
+		scan.Distances[i] = float64(rev.Distances[angle])
+		scan.Intensities[i] = float64(rev.Strengths[angle])
+	}
+
+	return scan
+}
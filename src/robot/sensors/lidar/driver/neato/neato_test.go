@@ -0,0 +1,73 @@
+package neato
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Opens streams in turn, failing when there are none left
+type testOpener struct {
+	lock    sync.Mutex
+	streams [][]byte
+	opened  int
+}
+
+func (o *testOpener) open() (io.ReadCloser, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.opened >= len(o.streams) {
+		return nil, errors.New("No more streams.")
+	}
+	o.opened++
+	return ioutil.NopCloser(bytes.NewReader(o.streams[o.opened-1])), nil
+}
+
+func TestNeato(t *testing.T) {
+	// The first stream ends, as if the port failed, and the second one is
+	// opened
+	second := encodeRevolution(0, 89, 300)
+	second = append(second, encodeRevolution(0, 0, 300)...)
+	opener := &testOpener{streams: [][]byte{encodeRevolution(0, 1, 300), second}}
+
+	n := MakeNeatoWithOpener(opener.open, 350, 20)
+	n.reconnectDelay = time.Millisecond
+	if _, err := n.ReceiveData(); err == nil {
+		t.Error("Received data before connecting")
+	}
+
+	if err := n.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.RequestInfiniteData(); err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := n.ReceiveScan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Distances) != 20 || len(scan.Intensities) != 20 {
+		t.Fatalf("Got %d distances, wanted 20", len(scan.Distances))
+	}
+
+	// The window wraps around the end of the revolution
+	if scan.Distances[0] != 1350 || scan.Distances[10] != 1000 || scan.Distances[19] != 1009 {
+		t.Errorf("Got distances %v", scan.Distances)
+	}
+	if scan.Intensities[0] != 102 || scan.RPM != 300 || scan.Timestamp.IsZero() {
+		t.Errorf("Got intensity %f, %f RPM and timestamp %v", scan.Intensities[0], scan.RPM, scan.Timestamp)
+	}
+	if opener.opened != 2 {
+		t.Errorf("Opened %d streams, wanted a reconnect", opener.opened)
+	}
+
+	n.Disconnect()
+	if _, err := n.ReceiveScan(); err == nil {
+		t.Error("Received a scan after disconnecting")
+	}
+}
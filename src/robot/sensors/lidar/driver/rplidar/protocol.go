@@ -0,0 +1,346 @@
+package rplidar
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Requests are a start byte and a command, followed by the size of a payload,
+// the payload and a checksum for commands with a payload. Responses start
+// with a descriptor: two start bytes, the length of the response and whether
+// more responses follow in 4 bytes, and the type of the response.
+const (
+	START_BYTE  = 0xA5
+	START_BYTE2 = 0x5A
+
+	DESCRIPTOR_LENGTH = 7
+)
+
+// Commands
+const (
+	CMD_STOP          = 0x25
+	CMD_RESET         = 0x40
+	CMD_SCAN          = 0x20
+	CMD_EXPRESS_SCAN  = 0x82
+	CMD_GET_INFO      = 0x50
+	CMD_GET_HEALTH    = 0x52
+	CMD_SET_MOTOR_PWM = 0xF0
+)
+
+// Response types
+const (
+	TYPE_INFO         = 0x04
+	TYPE_HEALTH       = 0x06
+	TYPE_SCAN         = 0x81
+	TYPE_EXPRESS_SCAN = 0x82
+)
+
+// Lengths of responses
+const (
+	INFO_LENGTH           = 20
+	HEALTH_LENGTH         = 3
+	SAMPLE_LENGTH         = 5
+	EXPRESS_PACKET_LENGTH = 84
+	EXPRESS_CABINS        = 16
+	EXPRESS_SAMPLES       = 2 * EXPRESS_CABINS
+)
+
+// Health statuses
+const (
+	HEALTH_GOOD    = 0
+	HEALTH_WARNING = 1
+	HEALTH_ERROR   = 2
+)
+
+// The device information from GET_INFO
+type Info struct {
+	Model    int
+	Firmware string
+	Hardware int
+	Serial   string
+}
+
+// The health from GET_HEALTH
+type Health struct {
+	Status    int
+	ErrorCode int
+}
+
+// A Sample is a distance measured at an angle
+type Sample struct {
+	// Degrees clockwise, as seen from above, from the front of the LIDAR
+	Angle float64
+
+	// Distance in mm, 0 where nothing was measured
+	Distance float64
+
+	// Signal quality, 0 in express scans which don't measure it
+	Quality int
+
+	// Set on the first sample of a revolution in scans
+	Start bool
+}
+
+// A Revolution of samples
+type Revolution struct {
+	Samples []Sample
+
+	// When the first sample was decoded
+	Timestamp time.Time
+
+	// Rotation speed in revolutions per minute, from the time since the
+	// previous revolution, 0 for the first
+	RPM float64
+}
+
+// Encode a request, with a payload if it isn't nil
+func request(cmd byte, payload []byte) []byte {
+	data := []byte{START_BYTE, cmd}
+	if payload == nil {
+		return data
+	}
+
+	data = append(data, byte(len(payload)))
+	data = append(data, payload...)
+	sum := byte(0)
+	for _, b := range data {
+		sum ^= b
+	}
+	return append(data, sum)
+}
+
+// Read a response descriptor, skipping bytes before it, and check its type
+func readDescriptor(r *bufio.Reader, dataType byte) (length int, err error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != START_BYTE {
+			continue
+		}
+		if next, err := r.Peek(1); err != nil {
+			return 0, err
+		} else if next[0] == START_BYTE2 {
+			break
+		}
+	}
+
+	data := make([]byte, DESCRIPTOR_LENGTH-1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, err
+	}
+	if data[5] != dataType {
+		return 0, errors.New("Unexpected RPLIDAR response type " + strconv.Itoa(int(data[5])) + ".")
+	}
+
+	// The lower 30 bits of the 32 bit word hold the length
+	length = int(data[1]) | int(data[2])<<8 | int(data[3])<<16 | int(data[4]&0x3F)<<24
+	return length, nil
+}
+
+// Parse the response to GET_INFO
+func parseInfo(data []byte) Info {
+	return Info{
+		Model:    int(data[0]),
+		Firmware: strconv.Itoa(int(data[2])) + "." + strconv.Itoa(int(data[1])),
+		Hardware: int(data[3]),
+		Serial:   hex.EncodeToString(data[4:20]),
+	}
+}
+
+// Parse the response to GET_HEALTH
+func parseHealth(data []byte) Health {
+	return Health{
+		Status:    int(data[0]),
+		ErrorCode: int(data[1]) | int(data[2])<<8,
+	}
+}
+
+// A Decoder decodes the samples streamed after SCAN or EXPRESS_SCAN, from
+// after the response descriptor. It skips bytes which fail the checks of the
+// samples or the checksums of express packets until it's in sync again.
+type Decoder struct {
+	r       *bufio.Reader
+	express bool
+
+	// An express packet, whose samples are decoded when the start angle of
+	// the next is known
+	last *expressPacket
+
+	// Samples decoded but not read, and a sample read past the end of a
+	// revolution
+	samples []Sample
+	pending *Sample
+
+	// When the last revolution started
+	lastStart time.Time
+
+	errors int
+}
+
+// Make a decoder for the samples of a scan, express or not
+func MakeDecoder(r *bufio.Reader, express bool) *Decoder {
+	return &Decoder{r: r, express: express}
+}
+
+// Get the number of samples and express packets skipped
+func (d *Decoder) GetErrors() int {
+	return d.errors
+}
+
+// Read the next sample
+func (d *Decoder) ReadSample() (Sample, error) {
+	if d.pending != nil {
+		s := *d.pending
+		d.pending = nil
+		return s, nil
+	}
+
+	for len(d.samples) == 0 {
+		var err error
+		if d.express {
+			err = d.readExpressPacket()
+		} else {
+			err = d.readSample()
+		}
+		if err != nil {
+			return Sample{}, err
+		}
+	}
+
+	s := d.samples[0]
+	d.samples = d.samples[1:]
+	return s, nil
+}
+
+// Read samples until the angle wraps around, or a sample starts a new
+// revolution
+func (d *Decoder) ReadRevolution() (*Revolution, error) {
+	rev := &Revolution{}
+	for {
+		s, err := d.ReadSample()
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(rev.Samples); n > 0 && (s.Start || s.Angle < rev.Samples[n-1].Angle-180) {
+			d.pending = &s
+			break
+		}
+
+		if len(rev.Samples) == 0 {
+			rev.Timestamp = time.Now()
+		}
+		rev.Samples = append(rev.Samples, s)
+	}
+
+	if !d.lastStart.IsZero() {
+		if elapsed := rev.Timestamp.Sub(d.lastStart); elapsed > 0 {
+			rev.RPM = float64(time.Minute) / float64(elapsed)
+		}
+	}
+	d.lastStart = rev.Timestamp
+
+	return rev, nil
+}
+
+// Read a sample of a scan: the quality and start flags, whose lowest two bits
+// must differ, the angle in 1/64 degrees with a check bit which must be set,
+// and the distance in 1/4 mm
+func (d *Decoder) readSample() error {
+	data, err := d.r.Peek(SAMPLE_LENGTH)
+	if err != nil {
+		return err
+	}
+	start := data[0]&1 != 0
+	if start == (data[0]&2 != 0) || data[1]&1 == 0 {
+		d.errors++
+		d.r.Discard(1)
+		return nil
+	}
+
+	d.samples = append(d.samples, Sample{
+		Angle:    float64(int(data[1])>>1|int(data[2])<<7) / 64,
+		Distance: float64(int(data[3])|int(data[4])<<8) / 4,
+		Quality:  int(data[0] >> 2),
+		Start:    start,
+	})
+	d.r.Discard(SAMPLE_LENGTH)
+	return nil
+}
+
+// An express packet: the angle of its first sample, and 16 cabins of two
+// distances with the offsets of their angles
+type expressPacket struct {
+	start  float64
+	cabins []byte
+}
+
+// Read an express packet: two bytes with sync nibbles and a checksum, the
+// start angle in 1/64 degrees with a flag for a new scan, and the cabins. The
+// samples of the previous packet are decoded once its end is known.
+func (d *Decoder) readExpressPacket() error {
+	data, err := d.r.Peek(EXPRESS_PACKET_LENGTH)
+	if err != nil {
+		return err
+	}
+	if data[0]>>4 != 0xA || data[1]>>4 != 0x5 {
+		d.errors++
+		d.r.Discard(1)
+		return nil
+	}
+
+	sum := byte(0)
+	for _, b := range data[2:] {
+		sum ^= b
+	}
+	if sum != data[0]&0xF|data[1]<<4 {
+		d.errors++
+		d.last = nil
+		d.r.Discard(1)
+		return nil
+	}
+
+	p := &expressPacket{
+		start:  float64(int(data[2])|int(data[3]&0x7F)<<8) / 64,
+		cabins: append([]byte{}, data[4:]...),
+	}
+	d.r.Discard(EXPRESS_PACKET_LENGTH)
+
+	if d.last != nil {
+		d.samples = append(d.samples, d.last.decode(p.start)...)
+	}
+	d.last = p
+	return nil
+}
+
+// Decode the samples of a packet, spread evenly from its start angle to the
+// start angle of the next, less their offsets in 1/8 degrees
+func (p *expressPacket) decode(next float64) []Sample {
+	diff := next - p.start
+	if diff < 0 {
+		diff += 360
+	}
+
+	samples := make([]Sample, 0, EXPRESS_SAMPLES)
+	for c := 0; c < EXPRESS_CABINS; c++ {
+		cabin := p.cabins[5*c : 5*c+5]
+		for j := 0; j < 2; j++ {
+			value := int(cabin[2*j]) | int(cabin[2*j+1])<<8
+			offset := int(cabin[4]>>(4*uint(j)))&0xF | (value&3)<<4
+
+			angle := p.start + diff*float64(2*c+j)/EXPRESS_SAMPLES - float64(offset)/8
+			samples = append(samples, Sample{
+				Angle:    math.Mod(angle+360, 360),
+				Distance: float64(value >> 2),
+			})
+		}
+	}
+	return samples
+}
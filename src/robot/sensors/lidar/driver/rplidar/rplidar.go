@@ -0,0 +1,398 @@
+// Package rplidar is the driver of Slamtec RPLIDAR A1 and A2 LIDARs. The
+// LIDAR is asked for its info and health, and then streams samples tagged
+// with their angles, in scans or in express scans, which have more samples
+// but no signal qualities. The samples of each revolution are put into a
+// fixed number of distances over a radial span, like the other LIDARs give.
+package rplidar
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"robot/logging"
+	"robot/sensors/lidar/driver"
+)
+
+// Time between attempts to reopen the port after an error
+const RECONNECT_DELAY = time.Second
+
+// Time to wait for a scan before giving up
+const SCAN_TIMEOUT = 2 * time.Second
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+var errNotConnected = errors.New("RPLIDAR not connected.")
+
+// Rplidar implements lidar.ScanDevice for an RPLIDAR
+type Rplidar struct {
+	// Opens the port of the LIDAR
+	open func() (io.ReadWriteCloser, error)
+
+	// The degree of the first distance of the scans, and their number of
+	// distances over the radial span
+	startAngle float64
+	radialSpan float64
+	beams      int
+
+	// Use express scans, and the PWM of the motor of the A2, 0 to leave it
+	express  bool
+	motorPWM int
+
+	reconnectDelay time.Duration
+
+	lock   sync.Mutex
+	port   io.ReadWriteCloser
+	stop   chan struct{}
+	scans  chan *driver.Scan
+	info   Info
+	health Health
+}
+
+// Make an RPLIDAR on a serial port. See MakeRplidarWithOpener for the
+// parameters.
+func MakeRplidar(device string, baudRate int, startAngle, radialSpan float64, beams int, express bool, motorPWM int) *Rplidar {
+	return MakeRplidarWithOpener(func() (io.ReadWriteCloser, error) {
+		return serial.OpenPort(&serial.Config{Name: device, Baud: baudRate, ReadTimeout: SCAN_TIMEOUT})
+	}, startAngle, radialSpan, beams, express, motorPWM)
+}
+
+// Make an RPLIDAR talking through whatever open returns, e.g. a fake device.
+// The scans have beams distances over radialSpan degrees from startAngle,
+// degrees being clockwise from the front of the LIDAR. Express scans are used
+// if express is set. The motor is set to motorPWM, if not 0, for the A2.
+func MakeRplidarWithOpener(open func() (io.ReadWriteCloser, error), startAngle, radialSpan float64, beams int, express bool, motorPWM int) *Rplidar {
+	return &Rplidar{
+		open:           open,
+		startAngle:     startAngle,
+		radialSpan:     radialSpan,
+		beams:          beams,
+		express:        express,
+		motorPWM:       motorPWM,
+		reconnectDelay: RECONNECT_DELAY,
+	}
+}
+
+// Open the port, check the health and start scanning
+func (r *Rplidar) Connect() error {
+	port, reader, err := r.start()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stop != nil {
+		port.Close()
+		return errors.New("RPLIDAR already connected.")
+	}
+	r.port = port
+	r.stop = make(chan struct{})
+	r.scans = make(chan *driver.Scan, 1)
+
+	go r.run(port, reader, r.stop, r.scans)
+
+	return nil
+}
+
+// Stop scanning and the motor, and close the port
+func (r *Rplidar) Disconnect() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stop == nil {
+		return
+	}
+
+	close(r.stop)
+	r.stop = nil
+	if r.port != nil {
+		r.port.Write(request(CMD_STOP, nil))
+		if r.motorPWM > 0 {
+			r.port.Write(request(CMD_SET_MOTOR_PWM, []byte{0, 0}))
+		}
+		r.port.Close()
+		r.port = nil
+	}
+}
+
+// The LIDAR scans from connecting, so there's nothing to request
+func (r *Rplidar) RequestInfiniteData() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stop == nil {
+		return errNotConnected
+	}
+	return nil
+}
+
+// Get the device information read when connecting
+func (r *Rplidar) GetInfo() Info {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.info
+}
+
+// Get the health read when connecting
+func (r *Rplidar) GetHealth() Health {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.health
+}
+
+// Wait for the next scan, and get its distances
+func (r *Rplidar) ReceiveData() ([]float64, error) {
+	scan, err := r.ReceiveScan()
+	if err != nil {
+		return nil, err
+	}
+	return scan.Distances, nil
+}
+
+// Wait for the next scan. Scans which weren't received in time are dropped, so
+// this always gives the latest.
+func (r *Rplidar) ReceiveScan() (*driver.Scan, error) {
+	r.lock.Lock()
+	scans, stop := r.scans, r.stop
+	r.lock.Unlock()
+	if stop == nil {
+		return nil, errNotConnected
+	}
+
+	timer := time.NewTimer(SCAN_TIMEOUT)
+	defer timer.Stop()
+
+	select {
+	case scan := <-scans:
+		return scan, nil
+	case <-stop:
+		return nil, errNotConnected
+	case <-timer.C:
+		return nil, errors.New("No scan from the RPLIDAR.")
+	}
+}
+
+// Open the port, stop any scan of an earlier connection, read the info and
+// health, and start the motor and the scan. Returns the port, and a reader
+// positioned at the first sample.
+func (r *Rplidar) start() (io.ReadWriteCloser, *bufio.Reader, error) {
+	port, err := r.open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := r.handshake(port)
+	if err != nil {
+		port.Close()
+		return nil, nil, err
+	}
+	return port, reader, nil
+}
+
+func (r *Rplidar) handshake(port io.ReadWriteCloser) (*bufio.Reader, error) {
+	// The LIDAR needs a moment after stopping before taking requests, and
+	// what it sent before stopping is skipped while reading the descriptor
+	// of the next response
+	if _, err := port.Write(request(CMD_STOP, nil)); err != nil {
+		return nil, err
+	}
+	time.Sleep(2 * time.Millisecond)
+	reader := bufio.NewReader(port)
+
+	data, err := query(port, reader, CMD_GET_INFO, TYPE_INFO, INFO_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	info := parseInfo(data)
+
+	data, err = query(port, reader, CMD_GET_HEALTH, TYPE_HEALTH, HEALTH_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	health := parseHealth(data)
+
+	r.lock.Lock()
+	r.info, r.health = info, health
+	r.lock.Unlock()
+
+	switch health.Status {
+	case HEALTH_WARNING:
+		logger.Println("RPLIDAR health warning, error code", health.ErrorCode)
+	case HEALTH_ERROR:
+		return nil, errors.New("RPLIDAR in error state, error code " + strconv.Itoa(health.ErrorCode) + ". It needs a reset.")
+	}
+
+	if r.motorPWM > 0 {
+		pwm := []byte{byte(r.motorPWM), byte(r.motorPWM >> 8)}
+		if _, err := port.Write(request(CMD_SET_MOTOR_PWM, pwm)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Start scanning
+	cmd, dataType := request(CMD_SCAN, nil), byte(TYPE_SCAN)
+	if r.express {
+		// The legacy express scan
+		cmd, dataType = request(CMD_EXPRESS_SCAN, []byte{0, 0, 0, 0, 0}), TYPE_EXPRESS_SCAN
+	}
+	if _, err := port.Write(cmd); err != nil {
+		return nil, err
+	}
+	if _, err := readDescriptor(reader, dataType); err != nil {
+		return nil, err
+	}
+
+	logger.Printf("RPLIDAR model %d, firmware %s, hardware %d, serial %s\n", info.Model, info.Firmware, info.Hardware, info.Serial)
+	return reader, nil
+}
+
+// Send a request, and read the single response of dataType
+func query(port io.Writer, reader *bufio.Reader, cmd, dataType byte, length int) ([]byte, error) {
+	if _, err := port.Write(request(cmd, nil)); err != nil {
+		return nil, err
+	}
+
+	n, err := readDescriptor(reader, dataType)
+	if err != nil {
+		return nil, err
+	}
+	if n != length {
+		return nil, errors.New("Unexpected RPLIDAR response length " + strconv.Itoa(n) + ".")
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	return data, err
+}
+
+// Decode scans into scans until stop is closed, restarting the LIDAR after
+// errors
+func (r *Rplidar) run(port io.ReadWriteCloser, reader *bufio.Reader, stop chan struct{}, scans chan *driver.Scan) {
+	for {
+		err := r.decode(reader, stop, scans)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		logger.Println("RPLIDAR:", err)
+		port.Close()
+
+		// Restart
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(r.reconnectDelay):
+			}
+
+			port, reader, err = r.start()
+			if err != nil {
+				logger.Println("RPLIDAR:", err)
+				continue
+			}
+
+			r.lock.Lock()
+			if r.stop != stop {
+				r.lock.Unlock()
+				port.Close()
+				return
+			}
+			r.port = port
+			r.lock.Unlock()
+
+			logger.Println("RPLIDAR reconnected.")
+			break
+		}
+	}
+}
+
+// Decode scans until reading fails, replacing scans not yet received
+func (r *Rplidar) decode(reader *bufio.Reader, stop chan struct{}, scans chan *driver.Scan) error {
+	decoder := MakeDecoder(reader, r.express)
+
+	// The first revolution is partial
+	if _, err := decoder.ReadRevolution(); err != nil {
+		return err
+	}
+
+	for {
+		rev, err := decoder.ReadRevolution()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		scan := r.makeScan(rev)
+		select {
+		case scans <- scan:
+		default:
+			// Replace the old scan
+			select {
+			case <-scans:
+			default:
+			}
+			scans <- scan
+		}
+	}
+}
+
+// Put the samples of a revolution into the distances of a scan. Each distance
+// is that of the sample closest to its angle, within half the angle between
+// the distances.
+func (r *Rplidar) makeScan(rev *Revolution) *driver.Scan {
+	scan := &driver.Scan{
+		Distances: make([]float64, r.beams),
+		RPM:       rev.RPM,
+		Timestamp: rev.Timestamp,
+	}
+	if !r.express {
+		scan.Intensities = make([]float64, r.beams)
+	}
+
+	step := r.radialSpan
+	if r.beams > 1 {
+		step /= float64(r.beams - 1)
+	}
+	closest := make([]float64, r.beams)
+	for i := range closest {
+		closest[i] = math.Inf(1)
+	}
+
+	for _, s := range rev.Samples {
+		if s.Distance == 0 {
+			continue
+		}
+
+		offset := math.Mod(s.Angle-r.startAngle+360, 360) / step
+		i := int(math.Floor(offset + 0.5))
+		if i < 0 || i >= r.beams {
+			continue
+		}
+		if d := math.Abs(offset - float64(i)); d < closest[i] {
+			closest[i] = d
+			scan.Distances[i] = s.Distance
+			if scan.Intensities != nil {
+				scan.Intensities[i] = float64(s.Quality)
+			}
+		}
+	}
+
+	return scan
+}
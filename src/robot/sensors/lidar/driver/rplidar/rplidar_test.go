@@ -0,0 +1,312 @@
+package rplidar
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// A fake RPLIDAR. Scans have a sample every degree, a quarter degree past it,
+// with a distance of 1000 mm plus the degree. Express scans have packets of 20
+// degrees, with distances of 2000 mm plus the packet.
+type fakeRplidar struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	in     []byte
+	out    bytes.Buffer
+	closed bool
+
+	// The commands received
+	commands []byte
+
+	health      byte
+	revolutions int
+}
+
+func makeFakeRplidar() *fakeRplidar {
+	f := &fakeRplidar{revolutions: 4}
+	f.cond = sync.NewCond(&f.lock)
+	return f
+}
+
+func (f *fakeRplidar) open() (io.ReadWriteCloser, error) {
+	return f, nil
+}
+
+func (f *fakeRplidar) Read(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for f.out.Len() == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return f.out.Read(p)
+}
+
+func (f *fakeRplidar) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.in = append(f.in, p...)
+	for len(f.in) >= 2 && f.in[0] == START_BYTE {
+		cmd := f.in[1]
+		length := 2
+		if cmd&0x80 != 0 {
+			// Commands with a payload
+			if len(f.in) < 3 || len(f.in) < 4+int(f.in[2]) {
+				break
+			}
+			length = 4 + int(f.in[2])
+		}
+		f.in = f.in[length:]
+		f.commands = append(f.commands, cmd)
+		f.handle(cmd)
+	}
+	f.cond.Broadcast()
+	return len(p), nil
+}
+
+func (f *fakeRplidar) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+	return nil
+}
+
+func (f *fakeRplidar) getCommands() []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]byte{}, f.commands...)
+}
+
+func (f *fakeRplidar) descriptor(length int, multiple bool, dataType byte) {
+	mode := byte(0)
+	if multiple {
+		mode = 0x40
+	}
+	f.out.Write([]byte{START_BYTE, START_BYTE2, byte(length), byte(length >> 8), byte(length >> 16), byte(length>>24) | mode, dataType})
+}
+
+func (f *fakeRplidar) handle(cmd byte) {
+	switch cmd {
+	case CMD_GET_INFO:
+		f.descriptor(INFO_LENGTH, false, TYPE_INFO)
+		f.out.Write([]byte{0x18, 24, 1, 7, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15})
+	case CMD_GET_HEALTH:
+		f.descriptor(HEALTH_LENGTH, false, TYPE_HEALTH)
+		f.out.Write([]byte{f.health, 0x12, 0x80})
+	case CMD_SCAN:
+		f.descriptor(SAMPLE_LENGTH, true, TYPE_SCAN)
+		for rev := 0; rev < f.revolutions; rev++ {
+			for degree := 0; degree < 360; degree++ {
+				f.out.Write(encodeSample(float64(degree)+0.25, float64(1000+degree), 10, degree == 0))
+			}
+		}
+	case CMD_EXPRESS_SCAN:
+		f.descriptor(EXPRESS_PACKET_LENGTH, true, TYPE_EXPRESS_SCAN)
+		for rev := 0; rev < f.revolutions; rev++ {
+			for packet := 0; packet < 18; packet++ {
+				var distances [EXPRESS_SAMPLES]int
+				for i := range distances {
+					distances[i] = 2000 + packet
+				}
+				f.out.Write(encodeExpressPacket(float64(20*packet), distances, [EXPRESS_SAMPLES]int{}))
+			}
+		}
+	}
+}
+
+// Encode a sample of a scan
+func encodeSample(angle, distance float64, quality int, start bool) []byte {
+	flags := byte(2)
+	if start {
+		flags = 1
+	}
+	q6 := int(angle * 64)
+	q2 := int(distance * 4)
+	return []byte{byte(quality)<<2 | flags, byte(q6&0x7F)<<1 | 1, byte(q6 >> 7), byte(q2), byte(q2 >> 8)}
+}
+
+// Encode an express packet, with offsets of the angles in 1/8 degrees
+func encodeExpressPacket(start float64, distances, offsets [EXPRESS_SAMPLES]int) []byte {
+	q6 := int(start * 64)
+	data := []byte{0xA0, 0x50, byte(q6), byte(q6 >> 8)}
+	for c := 0; c < EXPRESS_CABINS; c++ {
+		d1 := distances[2*c]<<2 | offsets[2*c]>>4
+		d2 := distances[2*c+1]<<2 | offsets[2*c+1]>>4
+		data = append(data, byte(d1), byte(d1>>8), byte(d2), byte(d2>>8), byte(offsets[2*c]&0xF|(offsets[2*c+1]&0xF)<<4))
+	}
+
+	sum := byte(0)
+	for _, b := range data[2:] {
+		sum ^= b
+	}
+	data[0] |= sum & 0xF
+	data[1] |= sum >> 4
+	return data
+}
+
+func TestRequest(t *testing.T) {
+	if got, want := request(CMD_EXPRESS_SCAN, []byte{0, 0, 0, 0, 0}), []byte{0xA5, 0x82, 5, 0, 0, 0, 0, 0, 0x22}; !bytes.Equal(got, want) {
+		t.Errorf("Got %x, wanted %x", got, want)
+	}
+	if got, want := request(CMD_STOP, nil), []byte{0xA5, 0x25}; !bytes.Equal(got, want) {
+		t.Errorf("Got %x, wanted %x", got, want)
+	}
+}
+
+func TestReadSample(t *testing.T) {
+	// A byte of noise, a sample, a sample failing its check bit, and a
+	// sample starting a revolution
+	stream := []byte{0x17}
+	stream = append(stream, encodeSample(359.5, 1234.25, 47, false)...)
+	bad := encodeSample(10, 500, 0, false)
+	bad[1] &^= 1
+	stream = append(stream, bad...)
+	stream = append(stream, encodeSample(0.5, 2000, 5, true)...)
+
+	d := MakeDecoder(bufio.NewReader(bytes.NewReader(stream)), false)
+	s, err := d.ReadSample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Sample{Angle: 359.5, Distance: 1234.25, Quality: 47}); s != want {
+		t.Errorf("Got %+v, wanted %+v", s, want)
+	}
+
+	s, err = d.ReadSample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Sample{Angle: 0.5, Distance: 2000, Quality: 5, Start: true}); s != want {
+		t.Errorf("Got %+v, wanted %+v", s, want)
+	}
+	if d.GetErrors() == 0 {
+		t.Error("Bad bytes not counted")
+	}
+}
+
+func TestExpressPacket(t *testing.T) {
+	var distances, offsets [EXPRESS_SAMPLES]int
+	for i := range distances {
+		distances[i] = 100 * i
+	}
+	offsets[3] = 20
+
+	stream := encodeExpressPacket(350, distances, offsets)
+	corrupt := encodeExpressPacket(10, distances, offsets)
+	corrupt[40] ^= 1
+	stream = append(stream, encodeExpressPacket(6, distances, offsets)...)
+	stream = append(stream, corrupt...)
+
+	d := MakeDecoder(bufio.NewReader(bytes.NewReader(stream)), true)
+	var samples []Sample
+	for {
+		s, err := d.ReadSample()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, s)
+	}
+
+	// Only the first packet is decoded, from 350 to 366 degrees
+	if len(samples) != EXPRESS_SAMPLES {
+		t.Fatalf("Got %d samples, wanted %d", len(samples), EXPRESS_SAMPLES)
+	}
+	for i, s := range samples {
+		angle := math.Mod(350+0.5*float64(i), 360)
+		if i == 3 {
+			angle -= 2.5
+		}
+		if math.Abs(s.Angle-angle) > 1e-9 || s.Distance != float64(100*i) {
+			t.Errorf("Got %+v for sample %d, wanted %f degrees", s, i, angle)
+		}
+	}
+	if d.GetErrors() == 0 {
+		t.Error("Bad packet not counted")
+	}
+}
+
+func TestRplidar(t *testing.T) {
+	f := makeFakeRplidar()
+	r := MakeRplidarWithOpener(f.open, 150, 240, 241, false, 0)
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RequestInfiniteData(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := r.GetInfo(), (Info{Model: 0x18, Firmware: "1.24", Hardware: 7, Serial: "000102030405060708090a0b0c0d0e0f"}); got != want {
+		t.Errorf("Got info %+v, wanted %+v", got, want)
+	}
+	if got, want := r.GetHealth(), (Health{Status: HEALTH_GOOD, ErrorCode: 0x8012}); got != want {
+		t.Errorf("Got health %+v, wanted %+v", got, want)
+	}
+
+	scan, err := r.ReceiveScan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Distances) != 241 || len(scan.Intensities) != 241 {
+		t.Fatalf("Got %d distances, wanted 241", len(scan.Distances))
+	}
+	for i, distance := range scan.Distances {
+		if want := float64(1000 + (150+i)%360); distance != want {
+			t.Fatalf("Got %f mm for distance %d, wanted %f", distance, i, want)
+		}
+	}
+	if scan.Intensities[0] != 10 || scan.Timestamp.IsZero() {
+		t.Errorf("Got intensity %f and timestamp %v", scan.Intensities[0], scan.Timestamp)
+	}
+
+	r.Disconnect()
+	if _, err := r.ReceiveScan(); err == nil {
+		t.Error("Received a scan after disconnecting")
+	}
+	if got, want := f.getCommands(), []byte{CMD_STOP, CMD_GET_INFO, CMD_GET_HEALTH, CMD_SCAN, CMD_STOP}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got commands %x, wanted %x", got, want)
+	}
+}
+
+func TestRplidarExpress(t *testing.T) {
+	f := makeFakeRplidar()
+	r := MakeRplidarWithOpener(f.open, 0, 360, 37, true, 660)
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Disconnect()
+
+	scan, err := r.ReceiveScan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.Intensities != nil {
+		t.Error("Got intensities from an express scan")
+	}
+	for i, distance := range scan.Distances[:36] {
+		if want := float64(2000 + 10*i/20); distance != want {
+			t.Errorf("Got %f mm for distance %d, wanted %f", distance, i, want)
+		}
+	}
+	if got, want := f.getCommands(), []byte{CMD_STOP, CMD_GET_INFO, CMD_GET_HEALTH, CMD_SET_MOTOR_PWM, CMD_EXPRESS_SCAN}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got commands %x, wanted %x", got, want)
+	}
+}
+
+func TestUnhealthy(t *testing.T) {
+	f := makeFakeRplidar()
+	f.health = HEALTH_ERROR
+	r := MakeRplidarWithOpener(f.open, 0, 360, 361, false, 0)
+	if err := r.Connect(); err == nil {
+		t.Error("Connected to a LIDAR in error state")
+	}
+}
@@ -0,0 +1,25 @@
+// Package driver holds what the LIDAR drivers in its subpackages have in
+// common: neato for the Neato XV LIDAR, urg for Hokuyo URG LIDARs and rplidar
+// for Slamtec RPLIDARs.
+package driver
+
+import (
+	"time"
+)
+
+// A Scan is a sweep of a LIDAR, with more than the distances, for drivers of
+// LIDARs which report it
+type Scan struct {
+	// Distances in mm, 0 where nothing was measured
+	Distances []float64
+
+	// Signal strengths of the distances, in the units of the LIDAR, nil if
+	// not measured
+	Intensities []float64
+
+	// Rotation speed of the LIDAR during the scan, in revolutions per minute
+	RPM float64
+
+	// When the scan started
+	Timestamp time.Time
+}
@@ -0,0 +1,149 @@
+package urg
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+)
+
+// SCIP 2.0 status codes
+const (
+	STATUS_OK       = "00"
+	STATUS_LASER_ON = "02" // BM: the laser was already on
+	STATUS_SCAN     = "99" // a scan of MD, MS or ME
+)
+
+// SCIP 2.0 commands
+const (
+	CMD_SCIP2     = "SCIP2.0"
+	CMD_VERSION   = "VV"
+	CMD_PARAMS    = "PP"
+	CMD_INFO      = "II"
+	CMD_LASER_ON  = "BM"
+	CMD_QUIT      = "QT"
+	CMD_GD        = "GD" // get a scan, 3 characters per distance
+	CMD_GS        = "GS" // get a scan, 2 characters per distance
+	CMD_GE        = "GE" // get a scan with intensities
+	CMD_MD        = "MD" // stream scans, 3 characters per distance
+	CMD_MS        = "MS" // stream scans, 2 characters per distance
+	CMD_ME        = "ME" // stream scans with intensities
+	STREAM_PREFIX = 13   // length of an MD echo up to the number of scans left
+)
+
+var errChecksum = errors.New("SCIP checksum mismatch.")
+
+// A response to a command: its echo, its status, and data lines without
+// their checksums
+type response struct {
+	echo   string
+	status string
+	lines  []string
+}
+
+// Compute the checksum character of data: the sum of the bytes, lower 6 bits,
+// plus 0x30
+func checksum(data string) byte {
+	sum := 0
+	for i := 0; i < len(data); i++ {
+		sum += int(data[i])
+	}
+	return byte(sum&0x3F + 0x30)
+}
+
+// Encode a value in chars characters of 6 bits each, plus 0x30
+func encode(value, chars int) string {
+	data := make([]byte, chars)
+	for i := chars - 1; i >= 0; i-- {
+		data[i] = byte(value&0x3F + 0x30)
+		value >>= 6
+	}
+	return string(data)
+}
+
+// Decode a value encoded in characters of 6 bits each
+func decode(data string) int {
+	value := 0
+	for i := 0; i < len(data); i++ {
+		value = value<<6 | int(data[i]-0x30)&0x3F
+	}
+	return value
+}
+
+// Read a line without its line feed
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Read the response to a command, skipping lines until its echo, which starts
+// with prefix. The lines of VV, PP and II have a semicolon before the checksum,
+// which isn't summed, and is left out. A response with a checksum mismatch is
+// read to its end, so the next one can be read, and errChecksum is returned.
+func readResponse(r *bufio.Reader, prefix string, info bool) (*response, error) {
+	res := &response{}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, prefix) {
+			res.echo = line
+			break
+		}
+	}
+
+	// The status, with a checksum unless the sensor was in SCIP 1.1 mode
+	status, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	mismatch := false
+	if len(status) >= 3 {
+		mismatch = checksum(status[:2]) != status[2]
+		status = status[:2]
+	}
+	res.status = status
+
+	// Data lines until an empty line
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		if len(line) < 2 {
+			mismatch = true
+			continue
+		}
+
+		data, sum := line[:len(line)-1], line[len(line)-1]
+		if info {
+			data = strings.TrimSuffix(data, ";")
+		}
+		if checksum(data) != sum {
+			mismatch = true
+		}
+		res.lines = append(res.lines, data)
+	}
+
+	if mismatch {
+		return nil, errChecksum
+	}
+	return res, nil
+}
+
+// Parse the KEY:VALUE lines of VV, PP and II
+func parseInfo(res *response) map[string]string {
+	info := make(map[string]string)
+	for _, line := range res.lines {
+		if i := strings.Index(line, ":"); i >= 0 {
+			info[line[:i]] = line[i+1:]
+		}
+	}
+	return info
+}
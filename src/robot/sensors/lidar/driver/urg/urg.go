@@ -0,0 +1,449 @@
+// Package urg is the driver of Hokuyo URG LIDARs, e.g. the URG-04LX, speaking
+// the SCIP 2.0 protocol over a serial port.
+//
+// The sensor measures at steps of its angular resolution, from step AMIN to
+// AMAX, AFRT pointing straight ahead. Scans cover the steps of a radial span
+// centred on the front, grouped into clusters of steps of which the sensor
+// reports the shortest distance. The scans are streamed with MD, MS or ME
+// once RequestInfiniteData is called, otherwise each is requested with GD, GS
+// or GE.
+package urg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"robot/logging"
+	"robot/sensors/lidar/driver"
+)
+
+// Time the serial port waits for data
+const READ_TIMEOUT = time.Second
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+var errNotConnected = errors.New("URG not connected.")
+
+// The parameters of the sensor, from PP
+type Parameters struct {
+	MinDistance int // mm
+	MaxDistance int // mm
+	Resolution  int // steps per revolution
+	FirstStep   int
+	LastStep    int
+	FrontStep   int
+	RPM         float64
+}
+
+// Urg implements lidar.ScanDevice for a Hokuyo URG LIDAR
+type Urg struct {
+	// Opens the port of the sensor
+	open func() (io.ReadWriteCloser, error)
+
+	// Degrees covered by the scans, the number of steps per cluster, the
+	// characters per distance, 2 or 3, and whether intensities are measured
+	radialSpan float64
+	cluster    int
+	encoding   int
+	intensity  bool
+
+	lock   sync.Mutex
+	port   io.ReadWriteCloser
+	reader *bufio.Reader
+
+	// What the sensor told about itself
+	version map[string]string
+	info    map[string]string
+	params  Parameters
+
+	// The steps scanned
+	startStep int
+	endStep   int
+
+	// Streaming with MD, MS or ME, and whether the stream must be restarted
+	// after an error
+	streaming bool
+	restart   bool
+}
+
+// Make an URG on a serial port. See MakeUrgWithOpener for the parameters.
+func MakeUrg(device string, baudRate int, radialSpan float64, cluster, encoding int, intensity bool) *Urg {
+	return MakeUrgWithOpener(func() (io.ReadWriteCloser, error) {
+		return serial.OpenPort(&serial.Config{Name: device, Baud: baudRate, ReadTimeout: READ_TIMEOUT})
+	}, radialSpan, cluster, encoding, intensity)
+}
+
+// Make an URG talking through whatever open returns, e.g. a fake device. The
+// scans cover radialSpan degrees, in clusters of steps, with 2 or 3
+// characters per distance. Intensities need 3 characters.
+func MakeUrgWithOpener(open func() (io.ReadWriteCloser, error), radialSpan float64, cluster, encoding int, intensity bool) *Urg {
+	if cluster < 1 {
+		cluster = 1
+	}
+	if encoding != 2 || intensity {
+		encoding = 3
+	}
+
+	return &Urg{
+		open:       open,
+		radialSpan: radialSpan,
+		cluster:    cluster,
+		encoding:   encoding,
+		intensity:  intensity,
+	}
+}
+
+// Open the port, switch the sensor to SCIP 2.0, read its parameters and turn
+// on the laser
+func (u *Urg) Connect() error {
+	port, err := u.open()
+	if err != nil {
+		return err
+	}
+
+	u.lock.Lock()
+	u.port = port
+	u.reader = bufio.NewReader(port)
+	u.lock.Unlock()
+
+	if err := u.handshake(); err != nil {
+		u.Disconnect()
+		return err
+	}
+
+	return nil
+}
+
+// Stop the sensor and close the port
+func (u *Urg) Disconnect() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.port == nil {
+		return
+	}
+
+	// Stop streaming and turn off the laser, without waiting for the
+	// response
+	io.WriteString(u.port, CMD_QUIT+"\n")
+	u.port.Close()
+	u.port = nil
+	u.streaming = false
+}
+
+// Determine if the port is open
+func (u *Urg) IsConnected() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.port != nil
+}
+
+// Get the version information from VV, e.g. PROD and FIRM
+func (u *Urg) GetVersion() map[string]string {
+	return u.version
+}
+
+// Get the state from II, e.g. LASR and STAT
+func (u *Urg) GetInfo() map[string]string {
+	return u.info
+}
+
+// Get the parameters from PP
+func (u *Urg) GetParameters() Parameters {
+	return u.params
+}
+
+// Get the steps scanned, from the first to the last
+func (u *Urg) GetSteps() (start, end int) {
+	return u.startStep, u.endStep
+}
+
+// Start streaming scans
+func (u *Urg) RequestInfiniteData() error {
+	if !u.IsConnected() {
+		return errNotConnected
+	}
+
+	res, err := u.command(u.scanCommand(true), false)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(res, STATUS_OK); err != nil {
+		return err
+	}
+
+	u.streaming = true
+	u.restart = false
+	return nil
+}
+
+// Get the distances of the next scan
+func (u *Urg) ReceiveData() ([]float64, error) {
+	scan, err := u.ReceiveScan()
+	if err != nil {
+		return nil, err
+	}
+	return scan.Distances, nil
+}
+
+// Get the next scan, streamed or requested. After an error the stream is
+// restarted, reconnecting if the sensor doesn't respond.
+func (u *Urg) ReceiveScan() (*driver.Scan, error) {
+	if !u.IsConnected() {
+		return nil, errNotConnected
+	}
+
+	if !u.streaming {
+		res, err := u.command(u.scanCommand(false), false)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkStatus(res, STATUS_OK); err != nil {
+			return nil, err
+		}
+		return u.parseScan(res)
+	}
+
+	if u.restart {
+		if err := u.recover(); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := readResponse(u.reader, u.scanCommand(true)[:STREAM_PREFIX], false)
+	if err == nil {
+		err = checkStatus(res, STATUS_SCAN)
+	}
+	if err != nil {
+		u.restart = true
+		return nil, err
+	}
+
+	scan, err := u.parseScan(res)
+	if err != nil {
+		u.restart = true
+	}
+	return scan, err
+}
+
+// Switch to SCIP 2.0, stopping any stream of an earlier connection, and read
+// the version, parameters and state. Then turn on the laser.
+func (u *Urg) handshake() error {
+	// Stop streaming. In SCIP 1.1 QT is an error, which is fine.
+	if _, err := u.command(CMD_QUIT, false); err != nil && err != errChecksum {
+		return err
+	}
+
+	// The status is 0 in SCIP 1.1, or an error if already in SCIP 2.0
+	if _, err := u.command(CMD_SCIP2, false); err != nil && err != errChecksum {
+		return err
+	}
+
+	res, err := u.command(CMD_VERSION, true)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(res, STATUS_OK); err != nil {
+		return err
+	}
+	u.version = parseInfo(res)
+
+	res, err = u.command(CMD_PARAMS, true)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(res, STATUS_OK); err != nil {
+		return err
+	}
+	u.params, err = parseParameters(parseInfo(res))
+	if err != nil {
+		return err
+	}
+
+	res, err = u.command(CMD_INFO, true)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(res, STATUS_OK); err != nil {
+		return err
+	}
+	u.info = parseInfo(res)
+
+	// The steps of the span, centred on the front
+	half := int(u.radialSpan / 360 * float64(u.params.Resolution) / 2)
+	u.startStep, u.endStep = u.params.FrontStep-half, u.params.FrontStep+half
+	if u.startStep < u.params.FirstStep {
+		u.startStep = u.params.FirstStep
+	}
+	if u.endStep > u.params.LastStep {
+		u.endStep = u.params.LastStep
+	}
+
+	res, err = u.command(CMD_LASER_ON, false)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(res, STATUS_OK, STATUS_LASER_ON); err != nil {
+		return err
+	}
+
+	logger.Printf("Connected to %s, firmware %s\n", u.version["PROD"], u.version["FIRM"])
+	return nil
+}
+
+// Stop the stream, read past what's left of it, and start it again.
+// Reconnects if that fails.
+func (u *Urg) recover() error {
+	logger.Println("Restarting the URG stream.")
+	_, err := u.command(CMD_QUIT, false)
+	if err == nil || err == errChecksum {
+		err = u.RequestInfiniteData()
+	}
+	if err == nil {
+		return nil
+	}
+
+	logger.Println("Reconnecting to the URG:", err)
+	u.Disconnect()
+	if err := u.Connect(); err != nil {
+		return err
+	}
+	return u.RequestInfiniteData()
+}
+
+// Send a command, and read its response
+func (u *Urg) command(cmd string, info bool) (*response, error) {
+	u.lock.Lock()
+	port, reader := u.port, u.reader
+	u.lock.Unlock()
+	if port == nil {
+		return nil, errNotConnected
+	}
+
+	if _, err := io.WriteString(port, cmd+"\n"); err != nil {
+		return nil, err
+	}
+	return readResponse(reader, cmd, info)
+}
+
+// Get the command which scans the steps, streaming or not
+func (u *Urg) scanCommand(stream bool) string {
+	code := CMD_GD
+	switch {
+	case u.intensity && stream:
+		code = CMD_ME
+	case u.intensity:
+		code = CMD_GE
+	case u.encoding == 2 && stream:
+		code = CMD_MS
+	case u.encoding == 2:
+		code = CMD_GS
+	case stream:
+		code = CMD_MD
+	}
+
+	cmd := fmt.Sprintf("%s%04d%04d%02d", code, u.startStep, u.endStep, u.cluster)
+	if stream {
+		// Every scan, infinitely many
+		cmd += "000"
+	}
+	return cmd
+}
+
+// Parse a scan: a timestamp line and the encoded distances, and intensities,
+// across the other lines. Distances under the min distance are error codes,
+// and become 0.
+func (u *Urg) parseScan(res *response) (*driver.Scan, error) {
+	if len(res.lines) < 1 {
+		return nil, errors.New("URG scan without a timestamp.")
+	}
+
+	data := ""
+	for _, line := range res.lines[1:] {
+		data += line
+	}
+
+	size := u.encoding
+	if u.intensity {
+		size *= 2
+	}
+	n := (u.endStep-u.startStep)/u.cluster + 1
+	if len(data) != n*size {
+		return nil, errors.New("URG scan of " + strconv.Itoa(len(data)) + " characters, expected " + strconv.Itoa(n*size) + ".")
+	}
+
+	scan := &driver.Scan{
+		Distances: make([]float64, n),
+		RPM:       u.params.RPM,
+		Timestamp: time.Now(),
+	}
+	if u.intensity {
+		scan.Intensities = make([]float64, n)
+	}
+
+	for i := 0; i < n; i++ {
+		value := data[i*size : (i+1)*size]
+		if distance := decode(value[:u.encoding]); distance >= u.params.MinDistance {
+			scan.Distances[i] = float64(distance)
+		}
+		if u.intensity {
+			scan.Intensities[i] = float64(decode(value[u.encoding:]))
+		}
+	}
+
+	return scan, nil
+}
+
+// Check that the status of a response is one of those expected
+func checkStatus(res *response, expected ...string) error {
+	for _, status := range expected {
+		if res.status == status {
+			return nil
+		}
+	}
+	return errors.New("URG status " + res.status + " in response to " + res.echo + ".")
+}
+
+// Parse the parameters from PP
+func parseParameters(info map[string]string) (Parameters, error) {
+	var p Parameters
+	values := []struct {
+		key   string
+		value *int
+	}{
+		{"DMIN", &p.MinDistance},
+		{"DMAX", &p.MaxDistance},
+		{"ARES", &p.Resolution},
+		{"AMIN", &p.FirstStep},
+		{"AMAX", &p.LastStep},
+		{"AFRT", &p.FrontStep},
+	}
+	for _, v := range values {
+		value, err := strconv.Atoi(info[v.key])
+		if err != nil {
+			return p, errors.New("Invalid URG parameter " + v.key + ": " + info[v.key])
+		}
+		*v.value = value
+	}
+
+	// The motor speed is optional
+	if rpm, err := strconv.ParseFloat(info["SCAN"], 64); err == nil {
+		p.RPM = rpm
+	}
+	if p.Resolution <= 0 || p.FirstStep > p.LastStep {
+		return p, errors.New("Invalid URG parameters.")
+	}
+
+	return p, nil
+}
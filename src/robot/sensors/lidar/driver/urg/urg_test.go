@@ -0,0 +1,279 @@
+package urg
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A fake URG-04LX, answering commands as scripted. Scans have distances of
+// 1000 mm plus the index of the distance, except an error code for the first,
+// and intensities of 500 plus the index.
+type fakeUrg struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	in     []byte
+	out    bytes.Buffer
+	closed bool
+
+	// The commands received
+	commands []string
+
+	// The number of scans streamed after MD, MS or ME, and the scan to
+	// corrupt, 1 for the first, 0 for none
+	streamScans int
+	corrupt     int
+}
+
+func makeFakeUrg() *fakeUrg {
+	f := &fakeUrg{streamScans: 3}
+	f.cond = sync.NewCond(&f.lock)
+	return f
+}
+
+func (f *fakeUrg) open() (io.ReadWriteCloser, error) {
+	return f, nil
+}
+
+func (f *fakeUrg) Read(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for f.out.Len() == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return f.out.Read(p)
+}
+
+func (f *fakeUrg) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.in = append(f.in, p...)
+	for {
+		i := bytes.IndexByte(f.in, '\n')
+		if i < 0 {
+			break
+		}
+		cmd := string(f.in[:i])
+		f.in = f.in[i+1:]
+		f.commands = append(f.commands, cmd)
+		f.handle(cmd)
+	}
+	f.cond.Broadcast()
+	return len(p), nil
+}
+
+func (f *fakeUrg) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+	return nil
+}
+
+func (f *fakeUrg) getCommands() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+// Write a response with checksums. Lines ending in ; are info lines.
+func (f *fakeUrg) respond(echo, status string, lines ...string) {
+	f.out.WriteString(echo + "\n" + status + string(checksum(status)) + "\n")
+	for _, line := range lines {
+		f.out.WriteString(line + string(checksum(strings.TrimSuffix(line, ";"))) + "\n")
+	}
+	f.out.WriteString("\n")
+}
+
+func (f *fakeUrg) handle(cmd string) {
+	switch cmd {
+	case CMD_QUIT, CMD_SCIP2, CMD_LASER_ON:
+		f.respond(cmd, STATUS_OK)
+		return
+	case CMD_VERSION:
+		f.respond(cmd, STATUS_OK, "VEND:Hokuyo Automatic Co.,Ltd.;", "PROD:SOKUIKI Sensor URG-04LX;", "FIRM:3.3.00;", "PROT:SCIP 2.0;", "SERI:H0000000;")
+		return
+	case CMD_PARAMS:
+		f.respond(cmd, STATUS_OK, "MODL:URG-04LX;", "DMIN:20;", "DMAX:5600;", "ARES:1024;", "AMIN:44;", "AMAX:725;", "AFRT:384;", "SCAN:600;")
+		return
+	case CMD_INFO:
+		f.respond(cmd, STATUS_OK, "MODL:URG-04LX;", "LASR:OFF;", "SCSP:Initial(600[rpm]);", "MESM:Measuring by Normal Mode;", "STAT:Stable 000 no error.;")
+		return
+	}
+
+	// Scans
+	start, _ := strconv.Atoi(cmd[2:6])
+	end, _ := strconv.Atoi(cmd[6:10])
+	cluster, _ := strconv.Atoi(cmd[10:12])
+	encoding, intensity := 3, false
+	switch cmd[:2] {
+	case CMD_GS, CMD_MS:
+		encoding = 2
+	case CMD_GE, CMD_ME:
+		intensity = true
+	}
+
+	lines := []string{encode(1234, 4)}
+	data := ""
+	for i := 0; i <= (end-start)/cluster; i++ {
+		distance := 1000 + i
+		if i == 0 {
+			distance = 10
+		}
+		data += encode(distance, encoding)
+		if intensity {
+			data += encode(500+i, 3)
+		}
+	}
+	for len(data) > 64 {
+		lines = append(lines, data[:64])
+		data = data[64:]
+	}
+	lines = append(lines, data)
+
+	switch cmd[:2] {
+	case CMD_GD, CMD_GS, CMD_GE:
+		f.respond(cmd, STATUS_OK, lines...)
+	default:
+		f.respond(cmd, STATUS_OK)
+		for i := 1; i <= f.streamScans; i++ {
+			f.respond(cmd, STATUS_SCAN, lines...)
+			if i == f.corrupt {
+				// Flip a bit of the last data line
+				corrupted := f.out.Bytes()
+				corrupted[len(corrupted)-4] ^= 1
+			}
+		}
+		f.corrupt = 0
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	// The examples of the SCIP 2.0 specification
+	if got := decode("CB"); got != 1234 {
+		t.Errorf("Got %d, wanted 1234", got)
+	}
+	if got := decode("1Dh"); got != 5432 {
+		t.Errorf("Got %d, wanted 5432", got)
+	}
+	if got := encode(5432, 3); got != "1Dh" {
+		t.Errorf("Got %s, wanted 1Dh", got)
+	}
+	if checksum("00") != 'P' || checksum("99") != 'b' || checksum("DMIN:20") != '4' {
+		t.Error("Wrong checksums")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	f := makeFakeUrg()
+	u := MakeUrgWithOpener(f.open, 240, 1, 3, false)
+	if err := u.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Disconnect()
+
+	if got, want := f.getCommands(), []string{"QT", "SCIP2.0", "VV", "PP", "II", "BM"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got commands %v, wanted %v", got, want)
+	}
+	if got := u.GetVersion()["PROD"]; got != "SOKUIKI Sensor URG-04LX" {
+		t.Errorf("Got product %s", got)
+	}
+	if got := u.GetInfo()["STAT"]; got != "Stable 000 no error." {
+		t.Errorf("Got state %s", got)
+	}
+	want := Parameters{MinDistance: 20, MaxDistance: 5600, Resolution: 1024, FirstStep: 44, LastStep: 725, FrontStep: 384, RPM: 600}
+	if got := u.GetParameters(); got != want {
+		t.Errorf("Got parameters %+v, wanted %+v", got, want)
+	}
+
+	// 240 degrees are more than the sensor covers
+	if start, end := u.GetSteps(); start != 44 || end != 725 {
+		t.Errorf("Got steps %d to %d", start, end)
+	}
+}
+
+func TestGetScan(t *testing.T) {
+	tests := []struct {
+		encoding  int
+		intensity bool
+		command   string
+	}{
+		{3, false, "GD0214055403"},
+		{2, false, "GS0214055403"},
+		{3, true, "GE0214055403"},
+	}
+
+	for _, test := range tests {
+		f := makeFakeUrg()
+		u := MakeUrgWithOpener(f.open, 120, 3, test.encoding, test.intensity)
+		if err := u.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		scan, err := u.ReceiveScan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.getCommands(); got[len(got)-1] != test.command {
+			t.Errorf("Got command %s, wanted %s", got[len(got)-1], test.command)
+		}
+
+		if len(scan.Distances) != 114 {
+			t.Fatalf("Got %d distances, wanted 114", len(scan.Distances))
+		}
+		if scan.Distances[0] != 0 || scan.Distances[1] != 1001 || scan.Distances[113] != 1113 {
+			t.Errorf("Got distances %v", scan.Distances)
+		}
+		if test.intensity && (len(scan.Intensities) != 114 || scan.Intensities[113] != 613) {
+			t.Errorf("Got intensities %v", scan.Intensities)
+		}
+		if scan.RPM != 600 || scan.Timestamp.IsZero() {
+			t.Errorf("Got %f RPM and timestamp %v", scan.RPM, scan.Timestamp)
+		}
+
+		u.Disconnect()
+	}
+}
+
+func TestStream(t *testing.T) {
+	f := makeFakeUrg()
+	f.corrupt = 2
+	u := MakeUrgWithOpener(f.open, 240, 1, 3, false)
+	if err := u.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Disconnect()
+
+	if err := u.RequestInfiniteData(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.ReceiveData(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The corrupt scan fails, and the stream is restarted, skipping the
+	// scan left in it
+	if _, err := u.ReceiveData(); err != errChecksum {
+		t.Errorf("Got %v, wanted a checksum mismatch", err)
+	}
+	distances, err := u.ReceiveData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(distances) != 682 || distances[681] != 1681 {
+		t.Errorf("Got %d distances", len(distances))
+	}
+
+	got := f.getCommands()[6:]
+	want := []string{"MD0044072501000", "QT", "MD0044072501000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got commands %v, wanted %v", got, want)
+	}
+}
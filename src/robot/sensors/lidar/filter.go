@@ -0,0 +1,299 @@
+package lidar
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"robot/config"
+)
+
+// Names of the filters, for the lidar_filters option
+const (
+	RANGE_FILTER     = "range"
+	MASK_FILTER      = "mask"
+	MEDIAN_FILTER    = "median"
+	TEMPORAL_FILTER  = "temporal"
+	SHADOW_FILTER    = "shadow"
+	INTENSITY_FILTER = "intensity"
+)
+
+// A Filter cleans up the readings of a LIDAR before they're distributed.
+// Distances which are filtered out are set to 0, the same as beams without an
+// echo, so every reading keeps its number of distances.
+type Filter interface {
+	Filter(reading *LidarReading)
+}
+
+// Make the chain of filters of the LIDAR named name from the lidar_filter*
+// options, which may be overridden in the section of the LIDAR
+func MakeFilters(name string) ([]Filter, error) {
+	filters := make([]Filter, 0)
+
+	for _, filterName := range strings.Split(config.GetStringDefault(name, "lidar_filters", config.LIDAR_FILTERS), ",") {
+		var filter Filter
+
+		switch filterName = strings.TrimSpace(filterName); filterName {
+		case "":
+			continue
+		case RANGE_FILTER:
+			filter = &RangeFilter{
+				Min: config.GetFloat64Default(name, "lidar_filter_min_range", config.LIDAR_FILTER_MIN_RANGE),
+				Max: config.GetFloat64Default(name, "lidar_filter_max_range", config.LIDAR_FILTER_MAX_RANGE),
+			}
+		case MASK_FILTER:
+			masks, err := ParseMasks(config.GetStringDefault(name, "lidar_filter_masks", config.LIDAR_FILTER_MASKS))
+			if err != nil {
+				return nil, err
+			}
+			filter = &MaskFilter{Masks: masks}
+		case MEDIAN_FILTER:
+			filter = &MedianFilter{Window: config.GetIntDefault(name, "lidar_filter_median_window", config.LIDAR_FILTER_MEDIAN_WINDOW)}
+		case TEMPORAL_FILTER:
+			filter = MakeTemporalFilter(config.GetIntDefault(name, "lidar_filter_temporal_scans", config.LIDAR_FILTER_TEMPORAL_SCANS))
+		case SHADOW_FILTER:
+			filter = &ShadowFilter{
+				MinAngle:   config.GetFloat64Default(name, "lidar_filter_shadow_min_angle", config.LIDAR_FILTER_SHADOW_MIN_ANGLE),
+				MaxAngle:   config.GetFloat64Default(name, "lidar_filter_shadow_max_angle", config.LIDAR_FILTER_SHADOW_MAX_ANGLE),
+				Neighbours: config.GetIntDefault(name, "lidar_filter_shadow_neighbours", config.LIDAR_FILTER_SHADOW_NEIGHBOURS),
+			}
+		case INTENSITY_FILTER:
+			filter = &IntensityFilter{
+				Min: config.GetFloat64Default(name, "lidar_filter_min_intensity", config.LIDAR_FILTER_MIN_INTENSITY),
+				Max: config.GetFloat64Default(name, "lidar_filter_max_intensity", config.LIDAR_FILTER_MAX_INTENSITY),
+			}
+		default:
+			return nil, errors.New("Unknown LIDAR filter: " + filterName)
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// Parse masks from a comma separated list of start:end angles in degrees,
+// e.g. "-120:-116, 116:120"
+func ParseMasks(s string) ([][2]float64, error) {
+	masks := make([][2]float64, 0)
+
+	for _, mask := range strings.Split(s, ",") {
+		mask = strings.TrimSpace(mask)
+		if mask == "" {
+			continue
+		}
+
+		parts := strings.Split(mask, ":")
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid LIDAR mask, should be start:end: " + mask)
+		}
+		start, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return nil, err
+		}
+		end, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			start, end = end, start
+		}
+
+		masks = append(masks, [2]float64{start, end})
+	}
+
+	return masks, nil
+}
+
+// A RangeFilter removes distances shorter than Min, e.g. echoes from the
+// robot itself, and longer than Max, unless Max is 0. In mm.
+type RangeFilter struct {
+	Min, Max float64
+}
+
+func (f *RangeFilter) Filter(reading *LidarReading) {
+	for i, d := range reading.Distances {
+		if d < f.Min || (f.Max > 0 && d > f.Max) {
+			reading.Distances[i] = 0
+		}
+	}
+}
+
+// A MaskFilter removes the distances of beams within any of the Masks, ranges
+// of angles in degrees from start to end counter clockwise from the robot's
+// forward, in [-180, 180], e.g. where the robot's body blocks the view of the
+// LIDAR
+type MaskFilter struct {
+	Masks [][2]float64
+}
+
+func (f *MaskFilter) Filter(reading *LidarReading) {
+	for i := range reading.Distances {
+		angle := reading.BeamAngle(i) * 180 / math.Pi
+		for _, mask := range f.Masks {
+			if angle >= mask[0] && angle <= mask[1] {
+				reading.Distances[i] = 0
+				break
+			}
+		}
+	}
+}
+
+// A MedianFilter replaces each distance with the median of the distances in
+// a window of beams around it, removing single spikes while keeping edges.
+// Beams without a distance are neither filled in nor counted.
+type MedianFilter struct {
+	Window int
+}
+
+func (f *MedianFilter) Filter(reading *LidarReading) {
+	distances := make([]float64, len(reading.Distances))
+	copy(distances, reading.Distances)
+
+	half := f.Window / 2
+	window := make([]float64, 0, 2*half+1)
+	for i, d := range distances {
+		if d <= 0 {
+			continue
+		}
+
+		window = window[:0]
+		for j := i - half; j <= i+half; j++ {
+			if j >= 0 && j < len(distances) && distances[j] > 0 {
+				window = append(window, distances[j])
+			}
+		}
+		reading.Distances[i] = median(window)
+	}
+}
+
+// A TemporalFilter replaces each distance with the median of the distances of
+// the same beam in the last scans, removing distances which come and go, e.g.
+// from dust or moving legs, and filling in single dropouts. A beam is left
+// without a distance if most of the scans have none.
+type TemporalFilter struct {
+	Scans   int
+	history [][]float64
+}
+
+// Make a temporal filter taking the median of the last scans
+func MakeTemporalFilter(scans int) *TemporalFilter {
+	return &TemporalFilter{
+		Scans:   scans,
+		history: make([][]float64, 0, scans),
+	}
+}
+
+func (f *TemporalFilter) Filter(reading *LidarReading) {
+	// Start over if the number of beams changes
+	if len(f.history) > 0 && len(f.history[0]) != len(reading.Distances) {
+		f.history = f.history[:0]
+	}
+
+	distances := make([]float64, len(reading.Distances))
+	copy(distances, reading.Distances)
+	for len(f.history) > 0 && len(f.history) >= f.Scans {
+		f.history = append(f.history[:0], f.history[1:]...)
+	}
+	f.history = append(f.history, distances)
+
+	values := make([]float64, 0, len(f.history))
+	for i := range reading.Distances {
+		values = values[:0]
+		for _, scan := range f.history {
+			if scan[i] > 0 {
+				values = append(values, scan[i])
+			}
+		}
+
+		if 2*len(values) < len(f.history) {
+			reading.Distances[i] = 0
+		} else {
+			reading.Distances[i] = median(values)
+		}
+	}
+}
+
+// A ShadowFilter removes veiling points, the distances measured in between
+// the edge of an object and what's behind it when a beam hits both. For each
+// pair of beams at most Neighbours apart, the farther distance is removed if
+// the surface through the two points is at an angle to the beam of less than
+// MinAngle or more than MaxAngle degrees.
+type ShadowFilter struct {
+	MinAngle, MaxAngle float64
+	Neighbours         int
+}
+
+func (f *ShadowFilter) Filter(reading *LidarReading) {
+	distances := reading.Distances
+	if len(distances) < 2 {
+		return
+	}
+	step := math.Abs(reading.AngleIncrement())
+	minAngle, maxAngle := f.MinAngle*math.Pi/180, f.MaxAngle*math.Pi/180
+
+	// Decide on the unfiltered distances, removing afterwards
+	remove := make([]bool, len(distances))
+	for i := range distances {
+		if distances[i] <= 0 {
+			continue
+		}
+		for j := i + 1; j <= i+f.Neighbours && j < len(distances); j++ {
+			if distances[j] <= 0 {
+				continue
+			}
+
+			// Angle at point i between the beam and the line to point j
+			sin, cos := math.Sincos(float64(j-i) * step)
+			angle := math.Abs(math.Atan2(distances[j]*sin, distances[i]-distances[j]*cos))
+			if angle < minAngle || angle > maxAngle {
+				if distances[i] > distances[j] {
+					remove[i] = true
+				} else {
+					remove[j] = true
+				}
+			}
+		}
+	}
+
+	for i := range distances {
+		if remove[i] {
+			distances[i] = 0
+		}
+	}
+}
+
+// An IntensityFilter removes distances with a signal strength weaker than
+// Min, or stronger than Max unless Max is 0, e.g. reflections from shiny
+// surfaces. Readings without signal strengths are left as they are.
+type IntensityFilter struct {
+	Min, Max float64
+}
+
+func (f *IntensityFilter) Filter(reading *LidarReading) {
+	if len(reading.Intensities) != len(reading.Distances) {
+		return
+	}
+
+	for i, intensity := range reading.Intensities {
+		if intensity < f.Min || (f.Max > 0 && intensity > f.Max) {
+			reading.Distances[i] = 0
+		}
+	}
+}
+
+// Get the median of values, reordering them
+func median(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+	if n%2 == 0 {
+		return (values[n/2-1] + values[n/2]) / 2
+	}
+	return values[n/2]
+}
@@ -0,0 +1,251 @@
+package lidar
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Load the scans recorded in testdata/scans.log, see the file for what's in
+// them
+func loadScans(t *testing.T) []*LidarReading {
+	f, err := os.Open("testdata/scans.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	l := MakeLidar(NAME, nil, 240, 4000, 241)
+	scans := make([]*LidarReading, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Skip the sensor name and the timestamp
+		reading, err := MakeReadingFromRecordBody(l, strings.Split(line, ",")[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		scans = append(scans, reading)
+	}
+	if len(scans) != 5 || len(scans[0].Distances) != 241 {
+		t.Fatal("Unexpected recorded scans")
+	}
+
+	return scans
+}
+
+func copyDistances(reading *LidarReading) []float64 {
+	distances := make([]float64, len(reading.Distances))
+	copy(distances, reading.Distances)
+	return distances
+}
+
+func TestRangeFilter(t *testing.T) {
+	reading := loadScans(t)[0]
+	original := copyDistances(reading)
+
+	(&RangeFilter{Min: 100, Max: 3500}).Filter(reading)
+
+	for i, d := range reading.Distances {
+		switch {
+		case original[i] < 100 || original[i] > 3500:
+			if d != 0 {
+				t.Error("Distance out of range kept at beam", i, original[i])
+			}
+		case d != original[i]:
+			t.Error("Distance in range changed at beam", i, original[i], d)
+		}
+	}
+
+	// The robot's body at the ends of the span
+	for _, i := range []int{0, 4, 236, 240} {
+		if reading.Distances[i] != 0 {
+			t.Error("Robot body not removed at beam", i)
+		}
+	}
+}
+
+func TestMaskFilter(t *testing.T) {
+	masks, err := ParseMasks("-120:-116, 120:116")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masks) != 2 || masks[1] != [2]float64{116, 120} {
+		t.Fatal("Masks not parsed:", masks)
+	}
+	if _, err := ParseMasks("-120:-116, 116"); err == nil {
+		t.Error("No error for a mask without an end")
+	}
+
+	reading := loadScans(t)[0]
+	original := copyDistances(reading)
+
+	(&MaskFilter{Masks: masks}).Filter(reading)
+
+	for i, d := range reading.Distances {
+		masked := i <= 4 || i >= 236
+		if masked && d != 0 {
+			t.Error("Masked beam kept", i)
+		}
+		if !masked && d != original[i] {
+			t.Error("Beam outside the masks changed", i)
+		}
+	}
+}
+
+func TestMedianFilter(t *testing.T) {
+	scans := loadScans(t)
+	filter := &MedianFilter{Window: 5}
+
+	// The spikes are removed
+	for _, spike := range []struct{ scan, beam int }{{0, 90}, {2, 170}} {
+		reading := scans[spike.scan]
+		neighbours := (reading.Distances[spike.beam-1] + reading.Distances[spike.beam+1]) / 2
+
+		filter.Filter(reading)
+
+		if d := reading.Distances[spike.beam]; math.Abs(d-neighbours) > 100 {
+			t.Error("Spike kept at beam", spike.beam, d, neighbours)
+		}
+	}
+
+	// The edges of the pillar are kept, and the dropout isn't filled in
+	reading := scans[2]
+	if reading.Distances[122] < 2900 || reading.Distances[127] > 1300 ||
+		reading.Distances[138] > 1300 || reading.Distances[142] < 3000 {
+		t.Error("Edges of the pillar moved:", reading.Distances[120:144])
+	}
+	if reading.Distances[200] != 0 {
+		t.Error("Dropout filled in")
+	}
+}
+
+func TestTemporalFilter(t *testing.T) {
+	scans := loadScans(t)
+	original := make([][]float64, len(scans))
+	for i := range scans {
+		original[i] = copyDistances(scans[i])
+	}
+
+	filter := MakeTemporalFilter(3)
+	for _, reading := range scans {
+		filter.Filter(reading)
+	}
+
+	// The first scan is kept as it is
+	for i, d := range scans[0].Distances {
+		if d != original[0][i] {
+			t.Fatal("First scan changed at beam", i)
+		}
+	}
+
+	// The dropouts are filled in with the distances of the other scans
+	for _, dropout := range []struct{ scan, beam int }{{1, 60}, {2, 200}, {3, 30}, {3, 31}} {
+		d := scans[dropout.scan].Distances[dropout.beam]
+		if math.Abs(d-original[dropout.scan-1][dropout.beam]) > 50 {
+			t.Error("Dropout not filled in at scan", dropout.scan, "beam", dropout.beam, d)
+		}
+	}
+
+	// The spike of the third scan is removed
+	if d := scans[2].Distances[170]; math.Abs(d-original[1][170]) > 50 {
+		t.Error("Spike kept:", d)
+	}
+
+	// Starts over with another number of beams
+	reading := MakeLidarReading(MakeLidar(NAME, nil, 240, 4000, 10))
+	reading.Distances[3] = 1000
+	filter.Filter(reading)
+	if reading.Distances[3] != 1000 {
+		t.Error("Not started over for another number of beams")
+	}
+}
+
+func TestShadowFilter(t *testing.T) {
+	reading := loadScans(t)[0]
+	(&RangeFilter{Min: 100}).Filter(reading)
+	original := copyDistances(reading)
+
+	(&ShadowFilter{MinAngle: 10, MaxAngle: 170, Neighbours: 1}).Filter(reading)
+
+	// The veiling points at the edges of the pillar are removed
+	if reading.Distances[124] != 0 || reading.Distances[140] != 0 {
+		t.Error("Veiling points kept:", reading.Distances[120:144])
+	}
+
+	// Elsewhere only the points next to the spike at beam 90, which stands
+	// out like an edge, and at the edges of the pillar are removed
+	for i, d := range reading.Distances {
+		edge := i == 89 || i == 91 || (i >= 123 && i <= 125) || (i >= 139 && i <= 141)
+		if !edge && d != original[i] {
+			t.Error("Point removed at beam", i, original[i])
+		}
+	}
+}
+
+func TestIntensityFilter(t *testing.T) {
+	reading := loadScans(t)[0]
+	original := copyDistances(reading)
+
+	// Without signal strengths nothing is removed
+	filter := &IntensityFilter{Min: 20}
+	filter.Filter(reading)
+	for i, d := range reading.Distances {
+		if d != original[i] {
+			t.Fatal("Distance removed without signal strengths at beam", i)
+		}
+	}
+
+	// The veiling points are weak echoes
+	reading.Intensities = make([]float64, len(reading.Distances))
+	for i := range reading.Intensities {
+		reading.Intensities[i] = 200
+	}
+	reading.Intensities[124] = 5
+	reading.Intensities[140] = 12
+	reading.Intensities[60] = 900
+
+	filter.Filter(reading)
+	for i, d := range reading.Distances {
+		weak := i == 124 || i == 140
+		if weak && d != 0 {
+			t.Error("Weak echo kept at beam", i)
+		}
+		if !weak && d != original[i] {
+			t.Error("Strong echo removed at beam", i)
+		}
+	}
+
+	(&IntensityFilter{Max: 500}).Filter(reading)
+	if reading.Distances[60] != 0 {
+		t.Error("Too strong echo kept")
+	}
+}
+
+func TestMakeFilters(t *testing.T) {
+	filters, err := MakeFilters(NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 {
+		t.Fatal("Expected the range filter of the config file, got", filters)
+	}
+	if _, ok := filters[0].(*RangeFilter); !ok {
+		t.Error("Expected a range filter, got", filters[0])
+	}
+
+	// The filters are applied when distributing readings
+	l := MakeLidar(NAME, nil, 240, 4000, 241)
+	l.SetFilters(filters)
+	reading := loadScans(t)[0]
+	l.Distribute(reading)
+	if reading.Distances[0] != 0 || reading.Distances[100] == 0 {
+		t.Error("Reading not filtered when distributed")
+	}
+}
@@ -11,42 +11,72 @@ import (
 	"robot/fsm"
 	"robot/logging"
 	"robot/sensors/lidar/driver"
+	"robot/sensors/lidar/driver/neato"
+	"robot/sensors/lidar/driver/rplidar"
+	"robot/sensors/lidar/driver/urg"
 	"robot/sensors/sensor"
 )
 
 var logger *log.Logger
-var LidarSensor *Lidar
 
-const NAME = "LIDAR" // Used to identify the sensor for i.e. web gui/logs
+const NAME = "LIDAR" // Default name, used to identify the sensor for i.e. web gui/logs
+
+// Names of the drivers, for the lidar_driver option
+const (
+	NEATO   = "neato"
+	URG     = "urg"
+	RPLIDAR = "rplidar"
+)
 
 func init() {
-	LidarSensor = MakeDefaultLidar()
 	logger = logging.New()
 }
 
+// A Device is the piece of hardware (or something pretending to be it) which
+// the Lidar gets its distances from. The default device is the serial LIDAR
+// driver.
+type Device interface {
+	Connect() error
+	Disconnect()
+	RequestInfiniteData() error
+	ReceiveData() ([]float64, error)
+}
+
+// A ScanDevice is a Device which also gives the signal strengths, the speed of
+// the LIDAR and when each scan started, e.g. the Neato driver
+type ScanDevice interface {
+	Device
+	ReceiveScan() (*driver.Scan, error)
+}
+
 // A LIDAR implements a Sensor and holds additional LIDAR specific information,
 // such as the ranges and the number of distance readings it produces for every
 // reading.
 type Lidar struct {
 	sensor.BasicSensor
-	urg         *driver.Urg
+	device      Device
+	filters     []Filter
 	stopChan    chan bool
 	RadialSpan  float64
 	MaxDistance float64
 	Distances   int
+
+	// How the LIDAR is mounted on the robot, the robot's center facing
+	// forward by default
+	Mount Mount
 }
 
-// Make an arbitrary LIDAR
-func MakeLidar(radialSpan, maxDistance float64, distances int) *Lidar {
+// Make an arbitrary LIDAR, identified by name, reading from device
+func MakeLidar(name string, device Device, radialSpan, maxDistance float64, distances int) *Lidar {
 	l := &Lidar{
 		BasicSensor: sensor.BasicSensor{
 			FSM:  *fsm.MakeFSM(sensor.OFF),
-			Name: NAME,
+			Name: name,
 		},
 		RadialSpan:  radialSpan,
 		MaxDistance: maxDistance,
 		Distances:   distances,
-		urg:         driver.MakeDefaultUrg(),
+		device:      device,
 	}
 
 	l.stopChan = make(chan bool)
@@ -56,7 +86,73 @@ func MakeLidar(radialSpan, maxDistance float64, distances int) *Lidar {
 
 // Make LIDAR from default parameters and config file
 func MakeDefaultLidar() *Lidar {
-	return MakeLidar(config.LIDAR_RADIAL_SPAN, config.LIDAR_MAX_DISTANCE, config.LIDAR_NUM_DISTANCES)
+	device, err := MakeDevice(NAME)
+	if err != nil {
+		logger.Println(err)
+		device = neato.MakeNeato(config.LIDAR_COM_NAME, config.LIDAR_BAUD_RATE, config.LIDAR_START_ANGLE, int(config.LIDAR_RADIAL_SPAN)+1)
+	}
+	l := MakeLidar(NAME, device, config.LIDAR_RADIAL_SPAN, config.LIDAR_MAX_DISTANCE, config.LIDAR_NUM_DISTANCES)
+	l.Mount = MakeMount(NAME)
+
+	filters, err := MakeFilters(NAME)
+	if err != nil {
+		logger.Println(err)
+	} else {
+		l.SetFilters(filters)
+	}
+	return l
+}
+
+// Make the driver of the LIDAR named name from the lidar_* options, which may
+// be overridden in the section of the LIDAR. The Neato and the RPLIDAR give a
+// distance per degree of the radial span.
+func MakeDevice(name string) (Device, error) {
+	comName := config.GetStringDefault(name, "lidar_com_name", config.LIDAR_COM_NAME)
+	baudRate := config.GetIntDefault(name, "lidar_baud_rate", config.LIDAR_BAUD_RATE)
+	radialSpan := config.GetFloat64Default(name, "lidar_radial_span", config.LIDAR_RADIAL_SPAN)
+
+	switch driverName := config.GetStringDefault(name, "lidar_driver", config.LIDAR_DRIVER); driverName {
+	case NEATO:
+		startAngle := config.GetIntDefault(name, "lidar_start_angle", config.LIDAR_START_ANGLE)
+		return neato.MakeNeato(comName, baudRate, startAngle, int(radialSpan)+1), nil
+	case URG:
+		return urg.MakeUrg(comName, baudRate, radialSpan,
+			config.GetIntDefault(name, "urg_cluster", config.URG_CLUSTER),
+			config.GetIntDefault(name, "urg_encoding", config.URG_ENCODING),
+			config.GetBoolDefault(name, "urg_intensity", config.URG_INTENSITY)), nil
+	case RPLIDAR:
+		startAngle := config.GetIntDefault(name, "lidar_start_angle", config.LIDAR_START_ANGLE)
+		return rplidar.MakeRplidar(comName, baudRate, float64(startAngle), radialSpan, int(radialSpan)+1,
+			config.GetBoolDefault(name, "rplidar_express", config.RPLIDAR_EXPRESS),
+			config.GetIntDefault(name, "rplidar_motor_pwm", config.RPLIDAR_MOTOR_PWM)), nil
+	default:
+		return nil, errors.New("Unknown LIDAR driver: " + driverName)
+	}
+}
+
+// Replace the device the LIDAR reads from, e.g. with a simulated one. Must be
+// done while the LIDAR is disconnected.
+func (l *Lidar) SetDevice(device Device) {
+	l.device = device
+}
+
+// Set the filters applied in order to every reading before it's distributed,
+// replacing any set before. Must be done before readings are distributed.
+func (l *Lidar) SetFilters(filters []Filter) {
+	l.filters = filters
+}
+
+// Filter a reading and distribute it among the subscribers. Readings of
+// played logs are distributed this way too, so they're filtered the same as
+// the readings of the device.
+func (l *Lidar) Distribute(r sensor.SensorReading) {
+	if reading, ok := r.(*LidarReading); ok {
+		for _, filter := range l.filters {
+			filter.Filter(reading)
+		}
+	}
+
+	l.BasicSensor.Distribute(r)
 }
 
 // Return all parameters as a string-interface map
@@ -65,12 +161,17 @@ func (l Lidar) GetParameters() map[string]interface{} {
 		"Radial span":  l.RadialSpan,
 		"Max distance": l.MaxDistance,
 		"Distances":    l.Distances,
+		"Position x":   l.Mount.X,
+		"Position y":   l.Mount.Y,
+		"Yaw":          l.Mount.Yaw,
+		"Clockwise":    l.Mount.Clockwise,
+		"Start offset": l.Mount.StartOffset,
 	}
 }
 
 // Connect the LIDAR
 func (l *Lidar) Connect() error {
-	err := l.urg.Connect()
+	err := l.device.Connect()
 	if err != nil {
 		return err
 	}
@@ -85,7 +186,7 @@ func (l *Lidar) Connect() error {
 
 // Disconnect the LIDAR
 func (l *Lidar) Disconnect() {
-	l.urg.Disconnect()
+	l.device.Disconnect()
 
 	// Update the state
 	l.SetState(sensor.OFF)
@@ -103,7 +204,7 @@ func (l *Lidar) Start() error {
 	}
 
 	// Set the lidar to continuously take measurements
-	err = l.urg.RequestInfiniteData()
+	err = l.device.RequestInfiniteData()
 	if err != nil {
 		logger.Println(err)
 		return err
@@ -123,7 +224,7 @@ func (l *Lidar) Start() error {
 			default:
 			}
 
-			distances, err := l.urg.ReceiveData()
+			scan, err := l.receiveScan()
 			if err != nil {
 				// log the error but try to continue
 				logger.Println(err)
@@ -139,11 +240,14 @@ func (l *Lidar) Start() error {
 				BasicSensorReading: sensor.BasicSensorReading{
 					Sensor: l,
 				},
-				Distances:   distances,
-				Span:        config.LIDAR_RADIAL_SPAN,
+				Distances:   scan.Distances,
+				Intensities: scan.Intensities,
+				RPM:         scan.RPM,
+				Span:        l.RadialSpan,
 				MaxDistance: l.MaxDistance,
+				Mount:       l.Mount,
 			}
-			reading.SetTimestamp(time.Now())
+			reading.SetTimestamp(scan.Timestamp)
 
 			l.Distribute(reading)
 		}
@@ -152,6 +256,20 @@ func (l *Lidar) Start() error {
 	return nil
 }
 
+// Receive a scan from the device. Scans of devices which only give distances
+// are timestamped when received.
+func (l *Lidar) receiveScan() (*driver.Scan, error) {
+	if device, ok := l.device.(ScanDevice); ok {
+		return device.ReceiveScan()
+	}
+
+	distances, err := l.device.ReceiveData()
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Scan{Distances: distances, Timestamp: time.Now()}, nil
+}
+
 // Stop the running LIDAR. Note that this function does not alter the state
 // iteself. This function communicates the stopping wish to the running go
 // routine, which alters the state when it terminates.
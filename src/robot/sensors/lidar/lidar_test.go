@@ -8,7 +8,8 @@ import (
 )
 
 func TestLidarDistribution(t *testing.T) {
-	ch := LidarSensor.Subscribe()
+	l := MakeDefaultLidar()
+	ch := l.Subscribe()
 	
 	n := 5
 	
@@ -16,9 +17,9 @@ func TestLidarDistribution(t *testing.T) {
 	go func() {
 		for i := 0; i < n; i++ {
 			time.Sleep(100 * time.Millisecond)
-			reading := MakeLidarReading()
+			reading := MakeLidarReading(l)
 			reading.SetTimestamp(time.Now())
-			LidarSensor.Distribute(reading)
+			l.Distribute(reading)
 		}
 	}()
 	
@@ -31,7 +32,7 @@ func TestLidarDistribution(t *testing.T) {
 
 func TestTypeAssertion(t *testing.T) {
 	var sensorReading sensor.SensorReading
-	sensorReading = MakeLidarReading()
+	sensorReading = MakeLidarReading(MakeDefaultLidar())
 	t.Log(sensorReading)
 	
 	lidarReading := (sensorReading).(*LidarReading)
@@ -39,7 +40,8 @@ func TestTypeAssertion(t *testing.T) {
 }
 
 func TestActualLidar(t *testing.T) {
-	ch := LidarSensor.Subscribe()
+	l := MakeDefaultLidar()
+	ch := l.Subscribe()
 	
 	n := 2
 	
@@ -49,8 +51,8 @@ func TestActualLidar(t *testing.T) {
 			reading := <-ch
 			t.Log(reading.LogEntry())
 		}
-		LidarSensor.Stop()
+		l.Stop()
 	}()
 		
-	LidarSensor.Start()
+	l.Start()
 }
\ No newline at end of file
@@ -13,26 +13,35 @@ type LidarReading struct {
 	Distances []float64
 	Span float64
 	MaxDistance float64
+
+	// How the LIDAR is mounted, for the angles of the beams
+	Mount Mount
+
+	// Signal strengths of the distances and the rotation speed in RPM, for
+	// LIDARs which report them, otherwise nil and 0. They aren't logged.
+	Intensities []float64
+	RPM float64
 }
 
-// Construct a plain record
-func MakeLidarReading() *LidarReading {
+// Construct a plain record from the given LIDAR
+func MakeLidarReading(lidar *Lidar) *LidarReading {
 	l := &LidarReading {
 		BasicSensorReading: sensor.BasicSensorReading {
-			Sensor: LidarSensor,
+			Sensor: lidar,
 		},
-		Distances: make([]float64, LidarSensor.Distances),
-		Span: LidarSensor.RadialSpan,
-		MaxDistance: LidarSensor.MaxDistance,
+		Distances: make([]float64, lidar.Distances),
+		Span: lidar.RadialSpan,
+		MaxDistance: lidar.MaxDistance,
+		Mount: lidar.Mount,
 	}
 	
 	return l
 }
 
-// Given the data part of a log record, construct a reading with the data from
-// the record
-func MakeReadingFromRecordBody(recordData []string) (l *LidarReading, err error) {
-	l = MakeLidarReading()
+// Given the data part of a log record, construct a reading from the given LIDAR
+// with the data from the record
+func MakeReadingFromRecordBody(lidar *Lidar, recordData []string) (l *LidarReading, err error) {
+	l = MakeLidarReading(lidar)
 	
 	// The first value is the span of the lidar sweep
 	a, err := strconv.ParseFloat(recordData[0], 64)
@@ -43,7 +52,7 @@ func MakeReadingFromRecordBody(recordData []string) (l *LidarReading, err error)
 	
 	// The rest are distances
 	n := len(recordData) - 1
-	if len(l.Distances) < n {
+	if len(l.Distances) != n {
 		l.Distances = make([]float64, n)
 	}
 	
@@ -0,0 +1,80 @@
+package lidar
+
+import (
+	"math"
+
+	"robot/config"
+)
+
+// A Mount describes how a LIDAR is mounted on the robot: where it is, which
+// way it faces and which way it measures. Beam i of n over a span of degrees
+// is at StartOffset - span/2 + i*span/(n-1) degrees in the LIDAR's own frame,
+// which is mirrored if the LIDAR measures clockwise, e.g. when it's mounted
+// upside down, and turned Yaw degrees counter clockwise from the robot's
+// forward.
+type Mount struct {
+	// Position in meters, forward and to the left of the robot's center
+	X, Y float64
+
+	// Degrees counter clockwise from the robot's forward to the middle of
+	// the span
+	Yaw float64
+
+	// Set if the distances are ordered clockwise
+	Clockwise bool
+
+	// Degrees from the middle of the span to where the LIDAR's own frame has
+	// it, e.g. if the first distance isn't at -span/2
+	StartOffset float64
+}
+
+// Make the mount of the LIDAR named name from the lidar_position_* and
+// lidar_* options, which may be overridden in the section of the LIDAR
+func MakeMount(name string) Mount {
+	return Mount{
+		X:           config.GetFloat64Default(name, "lidar_position_x", config.LIDAR_POSITION_X),
+		Y:           config.GetFloat64Default(name, "lidar_position_y", config.LIDAR_POSITION_Y),
+		Yaw:         config.GetFloat64Default(name, "lidar_yaw", config.LIDAR_YAW),
+		Clockwise:   config.GetBoolDefault(name, "lidar_clockwise", config.LIDAR_CLOCKWISE),
+		StartOffset: config.GetFloat64Default(name, "lidar_start_offset", config.LIDAR_START_OFFSET),
+	}
+}
+
+// Get the angle of beam i of beams spread over span degrees, in radians
+// counter clockwise from the robot's forward, in [-pi, pi]
+func (m Mount) BeamAngle(i, beams int, span float64) float64 {
+	angle := m.StartOffset - span/2
+	if beams > 1 {
+		angle += float64(i) * span / float64(beams-1)
+	}
+	if m.Clockwise {
+		angle = -angle
+	}
+
+	return math.Remainder((m.Yaw+angle)*math.Pi/180, 2*math.Pi)
+}
+
+// Get the angle of beam i in radians, counter clockwise from the robot's
+// forward, in [-pi, pi]
+func (lr *LidarReading) BeamAngle(i int) float64 {
+	return lr.Mount.BeamAngle(i, len(lr.Distances), lr.Span)
+}
+
+// Get the angle in radians from one beam to the next, negative if the
+// distances are ordered clockwise
+func (lr *LidarReading) AngleIncrement() float64 {
+	if len(lr.Distances) < 2 {
+		return 0
+	}
+
+	increment := lr.Span / float64(len(lr.Distances)-1) * math.Pi / 180
+	if lr.Mount.Clockwise {
+		return -increment
+	}
+	return increment
+}
+
+// Get the position of the LIDAR in meters, in the robot's frame
+func (lr *LidarReading) Origin() [2]float64 {
+	return [2]float64{lr.Mount.X, lr.Mount.Y}
+}
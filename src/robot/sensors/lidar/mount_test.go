@@ -0,0 +1,61 @@
+package lidar
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBeamAngle(t *testing.T) {
+	deg := math.Pi / 180
+	tests := []struct {
+		mount Mount
+		beam  int
+		want  float64
+	}{
+		// Centered on the robot's forward, counter clockwise
+		{Mount{}, 0, -120 * deg},
+		{Mount{}, 120, 0},
+		{Mount{}, 240, 120 * deg},
+
+		// Turned to the left
+		{Mount{Yaw: 90}, 0, -30 * deg},
+		{Mount{Yaw: 90}, 240, -150 * deg},
+
+		// Upside down, the distances are clockwise
+		{Mount{Clockwise: true}, 0, 120 * deg},
+		{Mount{Clockwise: true}, 30, 90 * deg},
+
+		// The LIDAR's own frame has the middle of the span elsewhere
+		{Mount{StartOffset: 7}, 127, 14 * deg},
+		{Mount{StartOffset: 7, Clockwise: true}, 113, 0},
+		{Mount{Yaw: 180, StartOffset: 7}, 113, math.Pi},
+	}
+
+	for _, test := range tests {
+		got := test.mount.BeamAngle(test.beam, 241, 240)
+		if math.Abs(math.Remainder(got-test.want, 2*math.Pi)) > 1e-9 {
+			t.Errorf("Beam %d with %+v is at %f, expected %f", test.beam, test.mount, got, test.want)
+		}
+		if got < -math.Pi || got > math.Pi {
+			t.Errorf("Beam %d with %+v is at %f, outside [-pi, pi]", test.beam, test.mount, got)
+		}
+	}
+}
+
+func TestReadingMount(t *testing.T) {
+	l := MakeLidar(NAME, nil, 240, 4000, 241)
+	l.Mount = Mount{X: 0.1, Y: -0.05, Yaw: 180, Clockwise: true}
+	reading := MakeLidarReading(l)
+
+	// Beam 90 is 30 degrees before the middle, which faces backwards, and
+	// the beams go clockwise, so it's behind and to the right of the robot
+	if angle := reading.BeamAngle(90); math.Abs(angle+150*math.Pi/180) > 1e-9 {
+		t.Error("Beam 90 is at", angle)
+	}
+	if reading.Origin() != [2]float64{0.1, -0.05} {
+		t.Error("Origin is", reading.Origin())
+	}
+	if math.Abs(reading.AngleIncrement()+math.Pi/180) > 1e-12 {
+		t.Error("Angle increment is", reading.AngleIncrement())
+	}
+}
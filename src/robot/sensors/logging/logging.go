@@ -6,14 +6,26 @@ import (
 	"log"
 	
 	"robot/config"
+	robotlogging "robot/logging"
+	"robot/model"
+	"robot/sensors/binlog"
 	"robot/sensors/sensor"
 )
 
+var logger *log.Logger
+
 type SensorLogger struct {
 	*log.Logger
 	filename string
 	file *os.File
 	stopChans []chan bool
+	
+	// Writer of binary logs, nil for CSV logs
+	binary *binlog.Writer
+}
+
+func init() {
+	logger = robotlogging.New()
 }
 
 // Make a log of arbitrary filename
@@ -35,13 +47,54 @@ func MakeLog(filename string) (*SensorLogger, error) {
 	return l, nil
 }
 
+// Make a binary log of arbitrary filename, with a header describing the given
+// sensors and robot
+func MakeBinaryLog(filename string, sensors []sensor.Sensor, robot *model.DifferentialWheeledRobot, compress bool) (*SensorLogger, error) {
+	l, err := MakeLog(filename)
+	if err != nil {
+		return nil, err
+	}
+	
+	l.binary, err = binlog.MakeWriter(l.file, binlog.MakeHeader(sensors, robot, compress))
+	if err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	
+	return l, nil
+}
+
 // Make a log with timestamped filename
 func MakeTimestampedLog() (*SensorLogger, error) {
-	return MakeLog(time.Now().Format("01-02-2006.15-04-05"))
+	return MakeLog(timestamp())
+}
+
+// Make a binary log with timestamped filename
+func MakeTimestampedBinaryLog(sensors []sensor.Sensor, robot *model.DifferentialWheeledRobot, compress bool) (*SensorLogger, error) {
+	return MakeBinaryLog(timestamp(), sensors, robot, compress)
+}
+
+// Make a log with timestamped filename, in the format given in the config
+func MakeTimestampedConfigLog(sensors []sensor.Sensor) (*SensorLogger, error) {
+	if config.SENSORLOGS_FORMAT == "binary" {
+		return MakeTimestampedBinaryLog(sensors, model.MakeDefaultDifferentialWheeledRobot(), config.SENSORLOGS_COMPRESS)
+	}
+	return MakeTimestampedLog()
+}
+
+func timestamp() string {
+	return time.Now().Format("01-02-2006.15-04-05")
 }
 
 // Write a sensor reading to the log
 func (l *SensorLogger) writeReading(r sensor.SensorReading) {
+	if l.binary != nil {
+		err := l.binary.WriteReading(r)
+		if err != nil {
+			logger.Println(err)
+		}
+		return
+	}
 	l.Println(r.LogEntry())
 }
 
@@ -85,5 +138,18 @@ func (l *SensorLogger) Close() error {
 		l.stopChans[i] <- true
 	}
 	l.stopChans = make([]chan bool, 0)
+	
+	if l.binary != nil {
+		err := l.binary.Close()
+		if err != nil {
+			l.file.Close()
+			return err
+		}
+	}
 	return l.file.Close()
+}
+
+// Check if the log is binary
+func (l *SensorLogger) IsBinary() bool {
+	return l.binary != nil
 }
\ No newline at end of file
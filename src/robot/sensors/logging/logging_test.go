@@ -13,20 +13,21 @@ func TestLogData(t *testing.T) {
 		t.Error(err)
 	}
 	
+	l := lidar.MakeDefaultLidar()
 	n := 5
 	
 	// Distribute n readings
 	go func() {
 		for i := 0; i < n; i++ {
 			time.Sleep(100 * time.Millisecond)
-			reading := lidar.MakeLidarReading()
+			reading := lidar.MakeLidarReading(l)
 			reading.SetTimestamp(time.Now())
-			lidar.LidarSensor.Distribute(reading)
+			l.Distribute(reading)
 		}
-		lidar.LidarSensor.CloseSubscribtions()
+		l.CloseSubscribtions()
 	}()
 	
-	logger.LogSensor(lidar.LidarSensor)
+	logger.LogSensor(l)
 	
 }
 
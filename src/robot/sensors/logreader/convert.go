@@ -0,0 +1,66 @@
+package logreader
+
+import (
+	"fmt"
+	"io"
+
+	"robot/model"
+	"robot/sensors/binlog"
+)
+
+// Write the rest of the log to w as a binary log. The header of a binary log
+// is kept, while the header of a CSV log is made from the sensors of the
+// reader and the default robot.
+func (slr *SensorLogReader) ConvertToBinary(w io.Writer, compress bool) error {
+	var header binlog.Header
+	if slr.binary != nil {
+		header = *slr.binary.GetHeader()
+	} else {
+		header = binlog.MakeHeader(slr.GetSensors(), model.MakeDefaultDifferentialWheeledRobot(), false)
+	}
+
+	header.Compression = binlog.COMPRESSION_NONE
+	if compress {
+		header.Compression = binlog.COMPRESSION_FLATE
+	}
+
+	bw, err := binlog.MakeWriter(w, header)
+	if err != nil {
+		return err
+	}
+
+	for {
+		reading, err := slr.ReadSensorReading()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = bw.WriteReading(reading)
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Close()
+}
+
+// Write the rest of the log to w as a CSV log
+func (slr *SensorLogReader) ConvertToCSV(w io.Writer) error {
+	for {
+		reading, err := slr.ReadSensorReading()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, reading.LogEntry())
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package logreader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"robot/sensors/lidar"
+	"robot/sensors/odometry"
+)
+
+// Write a CSV log of LIDAR and odometry readings to a temporary file
+func writeCSVLog(t *testing.T, dir string, n int) (string, time.Time) {
+	l := lidar.MakeDefaultLidar()
+	e := odometry.MakeDefaultEncoder()
+
+	var buf bytes.Buffer
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		lr := lidar.MakeLidarReading(l)
+		for j := range lr.Distances {
+			lr.Distances[j] = float64(500 + i + j)
+		}
+		lr.SetTimestamp(start.Add(time.Duration(i) * 100 * time.Millisecond))
+		buf.WriteString(lr.LogEntry() + "\n")
+
+		or := odometry.MakeOdometryReading(e)
+		or.LeftPulses, or.RightPulses = 10*i, 11*i
+		or.SetTimestamp(lr.GetTimestamp().Add(50 * time.Millisecond))
+		buf.WriteString(or.LogEntry() + "\n")
+	}
+
+	fileName := filepath.Join(dir, "csv.log")
+	err := ioutil.WriteFile(fileName, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName, start
+}
+
+// Convert a CSV log to binary and back, and check that nothing is lost
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logreader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	csvName, start := writeCSVLog(t, dir, 20)
+
+	slr, err := MakeLogReaderFromFileName(csvName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slr.IsBinary() {
+		t.Fatal("CSV log detected as binary")
+	}
+
+	binName := filepath.Join(dir, "binary.log")
+	f, err := os.Create(binName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = slr.ConvertToBinary(f, true)
+	f.Close()
+	slr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slr, err = MakeLogReaderFromFileName(binName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slr.Close()
+	if !slr.IsBinary() || slr.GetLogHeader() == nil {
+		t.Fatal("Binary log not detected as binary")
+	}
+
+	// Read a record through the same API as for CSV logs
+	err = slr.Seek(start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reading, err := slr.ReadSensorReading()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, ok := reading.(*lidar.LidarReading)
+	if !ok || !lr.GetTimestamp().Equal(start.Add(time.Second)) || lr.Distances[0] != 510 {
+		t.Errorf("Read %s after seeking", reading)
+	}
+
+	err = slr.Seek(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var converted bytes.Buffer
+	err = slr.ConvertToCSV(&converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := ioutil.ReadFile(csvName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(converted.Bytes(), original) {
+		t.Error("Log converted to binary and back differs from the original")
+	}
+}
+
+func TestSeekCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logreader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	csvName, start := writeCSVLog(t, dir, 20)
+	slr, err := MakeLogReaderFromFileName(csvName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slr.Close()
+
+	err = slr.Seek(start.Add(520 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reading, err := slr.ReadSensorReading()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(550 * time.Millisecond); !reading.GetTimestamp().Equal(want) {
+		t.Errorf("First reading after seeking is at %s, want %s", reading.GetTimestamp(), want)
+	}
+}
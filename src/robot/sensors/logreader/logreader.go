@@ -9,24 +9,33 @@ import (
 	"io"
 	"log"
 	"os"
-	"reflect"
 	"time"
 
 	"robot/config"
 	"robot/logging"
+	"robot/sensors/binlog"
 	"robot/sensors/lidar"
 	"robot/sensors/odometry"
 	"robot/sensors/sensor"
 )
 
 var logger *log.Logger
-var lidarReadingType = reflect.TypeOf(&lidar.LidarReading{})
-var odometryReadingType = reflect.TypeOf(&odometry.OdometryReading{})
 
 type SensorLogReader struct {
 	*csv.Reader
 	file     *os.File
 	fileName string
+
+	// Sensors by name, which readings are made from and distributed by
+	sensors map[string]sensor.Sensor
+
+	// Reader of binary logs, nil for CSV logs
+	binary *binlog.Reader
+
+	// Record read while seeking in a CSV log, and the number of the next
+	// record of a CSV log
+	pending  LogRecord
+	position int
 }
 
 type LogRecord []string
@@ -35,24 +44,89 @@ func init() {
 	logger = logging.New()
 }
 
-// Open log with filename
+// Open log with filename, in the sensor log folder
 func MakeLogReaderFromLogName(logName string) (*SensorLogReader, error) {
+	l, err := MakeLogReaderFromFileName(config.SENSORLOGS_ROOT + logName)
+	if err != nil {
+		return nil, err
+	}
+	l.fileName = logName
+
+	return l, nil
+}
+
+// Open log with the full path to the file
+func MakeLogReaderFromFileName(fileName string) (*SensorLogReader, error) {
 	l := new(SensorLogReader)
 
 	// Open the file
-	f, err := os.Open(config.SENSORLOGS_ROOT + logName)
+	f, err := os.Open(fileName)
 	if err != nil {
 		return nil, err
 	}
 	l.file = f
+	l.fileName = fileName
 
-	// Initiate the reader
-	l.Reader = csv.NewReader(f)
-	l.Reader.FieldsPerRecord = -1 // Don't check number of records per file
+	// Until told otherwise, readings from the default sensor names are made
+	// from sensors not used anywhere else.
+	l.sensors = map[string]sensor.Sensor{}
+	l.SetSensors([]sensor.Sensor{lidar.MakeDefaultLidar(), odometry.MakeDefaultEncoder()})
 
-	l.fileName = logName
+	binary, err := binlog.IsBinaryLog(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if binary {
+		l.binary, err = binlog.MakeReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		// The sensors in the log header replace the default ones
+		l.SetSensors(l.binary.GetHeader().MakeSensors())
+	} else {
+		l.initCSVReader()
+	}
 
-	return l, err
+	return l, nil
+}
+
+func (slr *SensorLogReader) initCSVReader() {
+	slr.Reader = csv.NewReader(slr.file)
+	slr.Reader.FieldsPerRecord = -1 // Don't check number of records per file
+}
+
+// Make readings for records with the name of one of the given sensors from
+// that sensor, so they are distributed to its subscribers.
+func (slr *SensorLogReader) SetSensors(sensors []sensor.Sensor) {
+	for _, s := range sensors {
+		slr.sensors[s.GetTypeName()] = s
+	}
+}
+
+// Get the sensors readings are made from
+func (slr *SensorLogReader) GetSensors() []sensor.Sensor {
+	sensors := make([]sensor.Sensor, 0, len(slr.sensors))
+	for _, s := range slr.sensors {
+		sensors = append(sensors, s)
+	}
+	return sensors
+}
+
+// Check if the log is binary
+func (slr *SensorLogReader) IsBinary() bool {
+	return slr.binary != nil
+}
+
+// Get the header of a binary log, or nil for a CSV log
+func (slr *SensorLogReader) GetLogHeader() *binlog.Header {
+	if slr.binary == nil {
+		return nil
+	}
+	return slr.binary.GetHeader()
 }
 
 // Close log reader
@@ -62,25 +136,125 @@ func (slr *SensorLogReader) Close() error {
 
 // Read a record from the log
 func (slr *SensorLogReader) ReadRecord() (LogRecord, error) {
+	if slr.binary != nil {
+		r, err := slr.binary.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		return LogRecord(r.Strings()), nil
+	}
+
+	if slr.pending != nil {
+		record := slr.pending
+		slr.pending = nil
+		slr.position++
+		return record, nil
+	}
+
 	strings, err := slr.Read()
 	if err != nil {
 		return nil, err
 	}
+	slr.position++
 	record := LogRecord(strings)
 	return record, nil
 }
 
+// Get the number of the next record, counting from 0
+func (slr *SensorLogReader) GetPosition() int {
+	if slr.binary != nil {
+		return slr.binary.GetPosition()
+	}
+	return slr.position
+}
+
+// Start reading a CSV log from the beginning
+func (slr *SensorLogReader) rewindCSV() error {
+	_, err := slr.file.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	slr.initCSVReader()
+	slr.pending = nil
+	slr.position = 0
+	return nil
+}
+
+// Continue reading from the first record at or after the given time. Binary
+// logs are seeked with their index, while CSV logs are read from the start.
+func (slr *SensorLogReader) Seek(t time.Time) error {
+	if slr.binary != nil {
+		return slr.binary.Seek(t)
+	}
+
+	err := slr.rewindCSV()
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, err := slr.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, timestamp, err := record.GetHeader()
+		if err == nil && !timestamp.Before(t) {
+			slr.pending = record
+			slr.position--
+			return nil
+		}
+	}
+}
+
+// Continue reading from the record with the given number, counting from 0
+func (slr *SensorLogReader) SeekRecord(n int) error {
+	if slr.binary != nil {
+		return slr.binary.SeekRecord(n)
+	}
+
+	err := slr.rewindCSV()
+	if err != nil {
+		return err
+	}
+
+	for slr.position < n {
+		_, err := slr.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Get the file name
 func (slr *SensorLogReader) GetFileName() string {
 	return slr.fileName
 }
 
 func (slr *SensorLogReader) ReadSensorReading() (sensorReading sensor.SensorReading, err error) {
+	// Binary records are made into readings directly, without going through
+	// strings
+	if slr.binary != nil {
+		r, err := slr.binary.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		return r.MakeReading(slr.sensors[r.Sensor])
+	}
+
 	record, err := slr.ReadRecord()
 	if err != nil {
 		return
 	}
-	return record.GetReading()
+	return slr.GetReading(record)
 }
 
 // Read all records
@@ -113,7 +287,7 @@ func (slr *SensorLogReader) ReadAllSensorReadings() (sensorReadings []sensor.Sen
 
 	sensorReadings = make([]sensor.SensorReading, len(records))
 	for i := range records {
-		sensorReadings[i], err = records[i].GetReading()
+		sensorReadings[i], err = slr.GetReading(records[i])
 		if err != nil {
 			return
 		}
@@ -189,16 +363,13 @@ func (slr *SensorLogReader) RealTimeReadingDistribution(newTimestamp bool) (stop
 	return
 }
 
-// Distribute a reading from the correct sensor
+// Distribute a reading from the sensor it was made from
 func (slr *SensorLogReader) Distribute(sr sensor.SensorReading) (err error) {
-	switch reflect.TypeOf(sr) {
-	case lidarReadingType:
-		lidar.LidarSensor.Distribute(sr)
-	case odometryReadingType:
-		odometry.OdometrySensor.Distribute(sr)
-	default:
-		err = errors.New(fmt.Sprintf("Invalid reading type %s", reflect.TypeOf(sr)))
+	s := sr.GetSensor()
+	if s == nil {
+		return errors.New(fmt.Sprintf("Reading has no sensor: %s", sr.LogEntry()))
 	}
+	s.Distribute(sr)
 	return
 }
 
@@ -234,8 +405,8 @@ func (r LogRecord) GetBody() (body []string, err error) {
 	return
 }
 
-// SensorReading from record
-func (r LogRecord) GetReading() (reading sensor.SensorReading, err error) {
+// SensorReading from record, made from the sensor with the name in the record
+func (slr *SensorLogReader) GetReading(r LogRecord) (reading sensor.SensorReading, err error) {
 	sensorType, timestamp, err := r.GetHeader()
 	if err != nil {
 		return
@@ -246,11 +417,11 @@ func (r LogRecord) GetReading() (reading sensor.SensorReading, err error) {
 		return
 	}
 
-	switch sensorType {
-	case lidar.LidarSensor.GetTypeName():
-		reading, err = lidar.MakeReadingFromRecordBody(body)
-	case odometry.OdometrySensor.GetTypeName():
-		reading, err = odometry.MakeReadingFromRecordBody(body)
+	switch s := slr.sensors[sensorType].(type) {
+	case *lidar.Lidar:
+		reading, err = lidar.MakeReadingFromRecordBody(s, body)
+	case *odometry.Encoder:
+		reading, err = odometry.MakeReadingFromRecordBody(s, body)
 	default:
 		err = errors.New("Invalid sensor type")
 	}
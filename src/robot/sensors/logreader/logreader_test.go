@@ -6,6 +6,7 @@ import (
     "fmt"
     
     "robot/sensors/lidar"
+    "robot/sensors/sensor"
 )
 
 // Test if we can make a logreader from the testlog
@@ -71,19 +72,21 @@ func TestRealTimeReadingDistribution(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	l := lidar.MakeDefaultLidar()
+	logReader.SetSensors([]sensor.Sensor{l})
 	
 	// Distribute the readings from the log
 	go func() {
-		err = logReader.RealTimeReadingDistribution(true)
+		_, err = logReader.RealTimeReadingDistribution(true)
 		if err != nil {
 			t.Fatal(err)
 		}
-		lidar.LidarSensor.CloseSubscribtions()
+		l.CloseSubscribtions()
 	}()
 	
 	// Receive the sensor distributions from LIDAR
 	var firstTimestamp time.Time
-	ch := lidar.LidarSensor.Subscribe()
+	ch := l.Subscribe()
 		
 	count := 0
 	for {
@@ -112,18 +115,20 @@ func TestRealTimeReadingDistributionLongDataSet(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	l := lidar.MakeDefaultLidar()
+	logReader.SetSensors([]sensor.Sensor{l})
 	
 	// Distribute the readings from the log
 	go func() {
-		err = logReader.RealTimeReadingDistribution(true)
+		_, err = logReader.RealTimeReadingDistribution(true)
 		if err != nil {
 			t.Error(err)
 		}
-		lidar.LidarSensor.CloseSubscribtions()
+		l.CloseSubscribtions()
 	}()
 	
 	// Receive the sensor distributions from LIDAR
-	ch := lidar.LidarSensor.Subscribe()
+	ch := l.Subscribe()
 	count := 0
 	for {
 		reading, ok := <-ch
@@ -0,0 +1,385 @@
+package logreader
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"robot/sensors/sensor"
+)
+
+// Playback states
+const (
+	PLAYING  = "PLAYING"
+	PAUSED   = "PAUSED"
+	FINISHED = "FINISHED"
+	STOPPED  = "STOPPED"
+)
+
+// Limits of the playback rate
+const (
+	MIN_RATE = 0.1
+	MAX_RATE = 20.0
+)
+
+// Playback is finished when this many records in a row can't be read, as the
+// log is unreadable from there on
+const MAX_SKIPPED_RECORDS = 100
+
+var errPlayerStopped = errors.New("Log replay is stopped.")
+
+// Where a replay is in the log
+type PlayerStatus struct {
+	LogName string  `json:"logName"`
+	State   string  `json:"state"`
+	Rate    float64 `json:"rate"`
+
+	// Number of records played, which is the number of the next record
+	Position int `json:"position"`
+
+	// Log time of the last reading played
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// A Player distributes the readings of a log at the pace they were logged, or
+// a multiple of it. Playback can be paused, stepped through reading by reading
+// and moved to another time or record of the log.
+type Player struct {
+	slr           *SensorLogReader
+	newTimestamps bool
+
+	// Commands are run by the playback goroutine
+	commands chan func()
+	done     chan bool
+
+	// Reading which has been read but not played yet
+	next sensor.SensorReading
+
+	// Number of records in a row which couldn't be read
+	skipped int
+
+	// Log time and wall time playback at the current rate started from
+	startLog, startWall time.Time
+
+	status      PlayerStatus
+	subscribers []chan PlayerStatus
+	lock        *sync.Mutex
+}
+
+// Make a paused player for the log. The flag newTimestamps decides if the
+// distributed readings should be given a new timestamp.
+func MakePlayer(slr *SensorLogReader, newTimestamps bool) *Player {
+	p := &Player{
+		slr:           slr,
+		newTimestamps: newTimestamps,
+		commands:      make(chan func()),
+		done:          make(chan bool),
+		status: PlayerStatus{
+			LogName:  slr.GetFileName(),
+			State:    PAUSED,
+			Rate:     1,
+			Position: slr.GetPosition(),
+		},
+		subscribers: make([]chan PlayerStatus, 0),
+		lock:        new(sync.Mutex),
+	}
+
+	go p.run()
+
+	return p
+}
+
+func (p *Player) run() {
+	for {
+		if p.status.State != PLAYING {
+			cmd := <-p.commands
+			cmd()
+			if p.status.State == STOPPED {
+				close(p.done)
+				return
+			}
+			continue
+		}
+
+		err := p.readNext()
+		if err != nil {
+			continue
+		}
+
+		if p.startLog.IsZero() {
+			p.startLog, p.startWall = p.next.GetTimestamp(), time.Now()
+		}
+		wait := time.Duration(float64(p.next.GetTimestamp().Sub(p.startLog))/p.status.Rate) - time.Since(p.startWall)
+
+		select {
+		case cmd := <-p.commands:
+			cmd()
+			if p.status.State == STOPPED {
+				close(p.done)
+				return
+			}
+		case <-time.After(wait):
+			p.playNext()
+			p.publish()
+		}
+	}
+}
+
+// Read the next reading, unless it has been read already. At the end of the
+// log, or after MAX_SKIPPED_RECORDS records in a row which can't be read,
+// playback is finished.
+func (p *Player) readNext() error {
+	if p.next != nil {
+		return nil
+	}
+
+	reading, err := p.slr.ReadSensorReading()
+	if err == io.EOF {
+		p.setState(FINISHED)
+		p.publish()
+		return err
+	}
+	if err != nil {
+		// Skip records which can't be read
+		logger.Println(err)
+		p.skipped++
+		if p.skipped >= MAX_SKIPPED_RECORDS {
+			logger.Println("Too many records in a row can't be read, finishing log replay.")
+			p.setState(FINISHED)
+			p.publish()
+		}
+		return err
+	}
+
+	p.skipped = 0
+	p.next = reading
+	return nil
+}
+
+// Distribute the next reading
+func (p *Player) playNext() {
+	reading := p.next
+	p.next = nil
+
+	p.lock.Lock()
+	p.status.Timestamp = reading.GetTimestamp()
+	p.status.Position = p.slr.GetPosition()
+	p.lock.Unlock()
+
+	if p.newTimestamps {
+		reading.SetTimestamp(time.Now())
+	}
+
+	err := p.slr.Distribute(reading)
+	if err != nil {
+		logger.Println(err)
+	}
+}
+
+// Let the playback goroutine run a command, and wait for it to finish
+func (p *Player) do(f func() error) error {
+	result := make(chan error, 1)
+	cmd := func() {
+		result <- f()
+	}
+
+	select {
+	case p.commands <- cmd:
+	case <-p.done:
+		return errPlayerStopped
+	}
+
+	err := <-result
+	p.publish()
+	return err
+}
+
+func (p *Player) setState(state string) {
+	p.lock.Lock()
+	p.status.State = state
+	p.lock.Unlock()
+
+	if state != PLAYING {
+		p.startLog = time.Time{}
+	}
+}
+
+// Start or continue playback
+func (p *Player) Play() error {
+	return p.do(func() error {
+		if p.status.State == FINISHED {
+			return errors.New("End of log.")
+		}
+		p.startLog = time.Time{}
+		p.setState(PLAYING)
+		return nil
+	})
+}
+
+// Pause playback
+func (p *Player) Pause() error {
+	return p.do(func() error {
+		if p.status.State == PLAYING {
+			p.setState(PAUSED)
+		}
+		return nil
+	})
+}
+
+// Set the playback rate, where 1 is the pace the log was made at
+func (p *Player) SetRate(rate float64) error {
+	if rate < MIN_RATE || rate > MAX_RATE {
+		return errors.New("Playback rate must be between 0.1 and 20.")
+	}
+
+	return p.do(func() error {
+		p.lock.Lock()
+		p.status.Rate = rate
+		p.lock.Unlock()
+
+		// Continue at the new rate from the next reading
+		p.startLog = time.Time{}
+		return nil
+	})
+}
+
+// Continue playback from the first reading at or after the given log time
+func (p *Player) SeekTime(t time.Time) error {
+	return p.do(func() error {
+		return p.seek(func() error {
+			return p.slr.Seek(t)
+		})
+	})
+}
+
+// Continue playback from the record with the given number, counting from 0
+func (p *Player) SeekRecord(n int) error {
+	if n < 0 {
+		return errors.New("Invalid record number.")
+	}
+
+	return p.do(func() error {
+		return p.seek(func() error {
+			return p.slr.SeekRecord(n)
+		})
+	})
+}
+
+func (p *Player) seek(seekFunc func() error) error {
+	p.next = nil
+	p.skipped = 0
+	p.startLog = time.Time{}
+
+	err := seekFunc()
+
+	p.lock.Lock()
+	p.status.Position = p.slr.GetPosition()
+	if p.status.State == FINISHED {
+		p.status.State = PAUSED
+	}
+	p.lock.Unlock()
+
+	return err
+}
+
+// Pause and play the next reading. If a sensor name is given, readings are
+// played until one from that sensor has been played.
+func (p *Player) Step(sensorName string) error {
+	return p.do(func() error {
+		if p.status.State == PLAYING {
+			p.setState(PAUSED)
+		}
+
+		for {
+			err := p.readNext()
+			if err == io.EOF {
+				return errors.New("End of log.")
+			}
+			if err != nil && p.status.State == FINISHED {
+				return err
+			}
+			if err != nil {
+				continue
+			}
+
+			name := p.next.GetSensor().GetTypeName()
+			p.playNext()
+			if sensorName == "" || name == sensorName {
+				return nil
+			}
+		}
+	})
+}
+
+// Stop playback for good, and close the status subscriptions
+func (p *Player) Stop() {
+	err := p.do(func() error {
+		p.setState(STOPPED)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	p.lock.Lock()
+	for _, ch := range p.subscribers {
+		close(ch)
+	}
+	p.subscribers = nil
+	p.lock.Unlock()
+}
+
+// Get the current status
+func (p *Player) GetStatus() PlayerStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.status
+}
+
+// Get a channel with status updates. Only the latest status is kept, so a
+// slow subscriber doesn't hold up playback.
+func (p *Player) Subscribe() chan PlayerStatus {
+	ch := make(chan PlayerStatus, 1)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.status.State == STOPPED {
+		close(ch)
+		return ch
+	}
+
+	ch <- p.status
+	p.subscribers = append(p.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe from status updates
+func (p *Player) Unsubscribe(ch chan PlayerStatus) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i := range p.subscribers {
+		if ch == p.subscribers[i] {
+			p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Send the status to the subscribers, replacing any status they haven't
+// received yet
+func (p *Player) publish() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, ch := range p.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- p.status
+	}
+}
@@ -0,0 +1,174 @@
+package logreader
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"robot/sensors/lidar"
+	"robot/sensors/odometry"
+	"robot/sensors/sensor"
+)
+
+// Make a player for a CSV log of n LIDAR and n odometry readings, and a
+// channel with the readings it plays
+func makeTestPlayer(t *testing.T, dir string, n int) (*Player, chan sensor.SensorReading, time.Time) {
+	fileName, start := writeCSVLog(t, dir, n)
+	slr, err := MakeLogReaderFromFileName(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := lidar.MakeDefaultLidar()
+	e := odometry.MakeDefaultEncoder()
+	slr.SetSensors([]sensor.Sensor{l, e})
+
+	played := make(chan sensor.SensorReading, 2*n)
+	for _, s := range []sensor.Sensor{l, e} {
+		go func(ch chan sensor.SensorReading) {
+			for reading := range ch {
+				played <- reading
+			}
+		}(s.Subscribe())
+	}
+
+	// Sensors discard readings when no one is receiving, so let the
+	// subscribers start
+	time.Sleep(10 * time.Millisecond)
+
+	return MakePlayer(slr, false), played, start
+}
+
+// Playback finishes when the log can't be read any more
+func TestPlayerUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logreader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, _, _ := makeTestPlayer(t, dir, 10)
+	defer p.Stop()
+	p.slr.file.Close()
+
+	if err := p.Step(""); err == nil {
+		t.Error("Stepped through an unreadable log")
+	}
+	if state := p.GetStatus().State; state != FINISHED {
+		t.Errorf("Player is %s after stepping, want %s", state, FINISHED)
+	}
+
+	// Seeking lets playback try again
+	p.SeekRecord(0)
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; p.GetStatus().State != FINISHED; i++ {
+		if i == 100 {
+			t.Fatalf("Player is %s while playing an unreadable log", p.GetStatus().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPlayerStep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logreader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, played, start := makeTestPlayer(t, dir, 10)
+	defer p.Stop()
+
+	// One reading
+	if err := p.Step(""); err != nil {
+		t.Fatal(err)
+	}
+	if reading := <-played; !reading.GetTimestamp().Equal(start) {
+		t.Errorf("First step played reading at %s, want %s", reading.GetTimestamp(), start)
+	}
+
+	// The odometry reading, then the next LIDAR reading
+	if err := p.Step(lidar.NAME); err != nil {
+		t.Fatal(err)
+	}
+	<-played
+	if reading := <-played; reading.GetSensor().GetTypeName() != lidar.NAME {
+		t.Errorf("Stepping to the next scan ended with %s", reading)
+	}
+
+	status := p.GetStatus()
+	if status.State != PAUSED || status.Position != 3 {
+		t.Errorf("Status after stepping is %+v, want paused at record 3", status)
+	}
+
+	// Seek and step
+	if err := p.SeekRecord(10); err != nil {
+		t.Fatal(err)
+	}
+	p.Step("")
+	if reading := <-played; !reading.GetTimestamp().Equal(start.Add(500 * time.Millisecond)) {
+		t.Errorf("Record 10 is at %s", reading.GetTimestamp())
+	}
+
+	if err := p.SeekTime(start.Add(720 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	p.Step("")
+	if reading := <-played; !reading.GetTimestamp().Equal(start.Add(750 * time.Millisecond)) {
+		t.Errorf("First reading after seeking is at %s", reading.GetTimestamp())
+	}
+}
+
+func TestPlayerPlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logreader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The log is 2 seconds long
+	p, _, _ := makeTestPlayer(t, dir, 20)
+	defer p.Stop()
+
+	if err := p.SetRate(100); err == nil {
+		t.Error("Rate above the maximum was accepted")
+	}
+	if err := p.SetRate(MAX_RATE); err != nil {
+		t.Fatal(err)
+	}
+
+	status := p.Subscribe()
+	begin := time.Now()
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	var last PlayerStatus
+	for last.State != FINISHED {
+		select {
+		case last = <-status:
+		case <-timeout:
+			t.Fatal("Playback didn't finish")
+		}
+	}
+
+	if elapsed := time.Since(begin); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Playing 2 s at %.0fx took %s", MAX_RATE, elapsed)
+	}
+	if last.Position != 40 {
+		t.Errorf("Finished at record %d, want 40", last.Position)
+	}
+
+	if err := p.Play(); err == nil {
+		t.Error("Playing a finished log succeeded")
+	}
+
+	p.Stop()
+	if err := p.Pause(); err != errPlayerStopped {
+		t.Errorf("Pausing a stopped player gave %v", err)
+	}
+}
@@ -18,9 +18,7 @@ import (
 	"robot/sensors/sensor"
 )
 
-const NAME = "ODOMETRY"
-
-var OdometrySensor *Encoder
+const NAME = "ODOMETRY" // Default name
 
 // Pattern for reading from encoder card
 const patternStr = `H:(-?\d+) V:(-?\d+);`
@@ -30,15 +28,22 @@ var pattern *regexp.Regexp
 var logger *log.Logger
 
 func init() {
-	OdometrySensor = MakeDefaultEncoder()
-
 	pattern = regexp.MustCompile(patternStr)
 
 	logger = logging.New()
 }
 
+// A Device can stand in for the encoder card, e.g. a simulator. It returns
+// the pulses counted on the left and right wheel since the previous read.
+type Device interface {
+	Connect() error
+	Disconnect()
+	ReadPulses() (left, right int, err error)
+}
+
 type Encoder struct {
 	sensor.BasicSensor
+	device   Device
 	config   *serial.Config
 	port     io.ReadWriteCloser
 	stopChan chan bool
@@ -46,12 +51,12 @@ type Encoder struct {
 	robot    *model.DifferentialWheeledRobot
 }
 
-// Make an arbitrary encoder
-func MakeEncoder(config *serial.Config) *Encoder {
+// Make an arbitrary encoder, identified by name
+func MakeEncoder(name string, config *serial.Config) *Encoder {
 	e := &Encoder{
 		BasicSensor: sensor.BasicSensor{
 			FSM:  *fsm.MakeFSM(sensor.OFF),
-			Name: NAME,
+			Name: name,
 		},
 		config: config,
 		robot:  model.MakeDefaultDifferentialWheeledRobot(),
@@ -66,15 +71,38 @@ func MakeDefaultEncoder() *Encoder {
 		Name: config.ODOMETRY_COM_NAME,
 		Baud: config.ODOMETRY_BAUD_RATE,
 	}
-	return MakeEncoder(conf)
+	return MakeEncoder(NAME, conf)
+}
+
+// Read from the given device instead of the serial encoder card. Setting a nil
+// device switches back to the serial port. Must be done while disconnected.
+func (e *Encoder) SetDevice(device Device) {
+	e.device = device
 }
 
 // Return all parameters as a string-interface map
 func (e Encoder) GetParameters() map[string]interface{} {
-	return map[string]interface{}{}
+	if e.config == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"Port":      e.config.Name,
+		"Baud rate": e.config.Baud,
+	}
 }
 
 func (e *Encoder) Connect() error {
+	if e.device != nil {
+		err := e.device.Connect()
+		if err != nil {
+			return err
+		}
+
+		logger.Println("Encoder connected.")
+		e.SetState(sensor.CONNECTED)
+		return nil
+	}
+
 	s, err := serial.OpenPort(e.config)
 	if err != nil {
 		return err
@@ -87,6 +115,14 @@ func (e *Encoder) Connect() error {
 }
 
 func (e *Encoder) Disconnect() {
+	if e.device != nil {
+		e.device.Disconnect()
+
+		logger.Println("Encoder disconnected.")
+		e.SetState(sensor.OFF)
+		return
+	}
+
 	if e.port == nil {
 		return
 	}
@@ -153,6 +189,14 @@ func (e *Encoder) Stop() {
 // The answer is encoded as H:([-\d]+) V:([-\d]+);
 func (e *Encoder) GetData() (*OdometryReading, error) {
 
+	if e.device != nil {
+		leftPulses, rightPulses, err := e.device.ReadPulses()
+		if err != nil {
+			return nil, err
+		}
+		return e.makeReading(leftPulses, rightPulses), nil
+	}
+
 	// Send command to encoder, telling it to return a reading
 	err := e.write("l")
 	if err != nil {
@@ -174,13 +218,17 @@ func (e *Encoder) GetData() (*OdometryReading, error) {
 		return nil, err
 	}
 
-	// Wrap in an OdometryReading
-	or := MakeOdometryReading()
+	return e.makeReading(leftPulses, rightPulses), nil
+}
+
+// Wrap pulse counts in a timestamped OdometryReading
+func (e *Encoder) makeReading(leftPulses, rightPulses int) *OdometryReading {
+	or := MakeOdometryReading(e)
 	or.LeftPulses = leftPulses
 	or.RightPulses = rightPulses
 	or.SetTimestamp(time.Now())
 
-	return or, nil
+	return or
 }
 
 // Write string to encoder card
@@ -15,21 +15,21 @@ type OdometryReading struct {
 	RightPulses int
 }
 
-// Construct a plain record
-func MakeOdometryReading() *OdometryReading {
+// Construct a plain record from the given encoder
+func MakeOdometryReading(encoder *Encoder) *OdometryReading {
 	or := &OdometryReading{
 		BasicSensorReading: sensor.BasicSensorReading{
-			Sensor: OdometrySensor,
+			Sensor: encoder,
 		},
 	}
 
 	return or
 }
 
-// Given the data part of a log record, construct a reading with the data from
-// the record.
-func MakeReadingFromRecordBody(recordData []string) (or *OdometryReading, err error) {
-	or = MakeOdometryReading()
+// Given the data part of a log record, construct a reading from the given
+// encoder with the data from the record.
+func MakeReadingFromRecordBody(encoder *Encoder, recordData []string) (or *OdometryReading, err error) {
+	or = MakeOdometryReading(encoder)
 
 	left, err := strconv.ParseInt(recordData[0], 10, 64)
 	if err != nil {
@@ -0,0 +1,29 @@
+package sensors
+
+import (
+	"errors"
+)
+
+// A replayedDevice stands in for the device of a sensor whose readings only
+// come from a log player. It can't be connected.
+type replayedDevice struct {
+	name string
+}
+
+func (d *replayedDevice) Connect() error {
+	return errors.New("Readings of " + d.name + " come from a replayed sensor log.")
+}
+
+func (d *replayedDevice) Disconnect() {}
+
+func (d *replayedDevice) RequestInfiniteData() error {
+	return d.Connect()
+}
+
+func (d *replayedDevice) ReceiveData() ([]float64, error) {
+	return nil, d.Connect()
+}
+
+func (d *replayedDevice) ReadPulses() (left, right int, err error) {
+	return 0, 0, d.Connect()
+}
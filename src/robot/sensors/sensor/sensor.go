@@ -25,6 +25,7 @@ func init() {
 type Sensor interface {
 	Subscribe() chan SensorReading
 	Unsubscribe(chan SensorReading)
+	Distribute(SensorReading)
 	GetTypeName() string
 	GetParameters() map[string]interface{}
 	Connect() error
@@ -2,6 +2,9 @@ package sensors
 
 import (
 	"errors"
+	"strings"
+
+	serial "github.com/tarm/goserial"
 
 	"robot/config"
 	"robot/fsm"
@@ -10,6 +13,7 @@ import (
 	"robot/sensors/logreader"
 	"robot/sensors/odometry"
 	"robot/sensors/sensor"
+	"robot/simulator"
 )
 
 const (
@@ -17,37 +21,122 @@ const (
 	LOGREAD = "LOGREAD"
 )
 
+// Sensor types. Simulated sensors read from the simulator's default world,
+// replayed sensors have no device and only get readings from a log player.
+// Each type is a kind of LIDAR or ODOMETRY.
+const (
+	LIDAR              = "lidar"
+	ODOMETRY           = "odometry"
+	SIMULATED_LIDAR    = "simulated-lidar"
+	SIMULATED_ODOMETRY = "simulated-odometry"
+	REPLAYED_LIDAR     = "replayed-lidar"
+	REPLAYED_ODOMETRY  = "replayed-odometry"
+)
+
+// A SensorFactory makes a sensor of some type with the given name. Settings
+// for the sensor may be read from a config section with the same name.
+type SensorFactory func(name string) (sensor.Sensor, error)
+
+type sensorType struct {
+	kind    string
+	factory SensorFactory
+}
+
+// The sensor types which can be used in the config file
+var sensorTypes = map[string]sensorType{
+	LIDAR:              {LIDAR, makeLidar},
+	ODOMETRY:           {ODOMETRY, makeOdometry},
+	SIMULATED_LIDAR:    {LIDAR, makeSimulatedLidar},
+	SIMULATED_ODOMETRY: {ODOMETRY, makeSimulatedOdometry},
+	REPLAYED_LIDAR:     {LIDAR, makeReplayedLidar},
+	REPLAYED_ODOMETRY:  {ODOMETRY, makeReplayedOdometry},
+}
+
+// Make a new type of sensor available. Its kind is the type which
+// GetSensorOfType finds it as, e.g. LIDAR.
+func RegisterSensorType(typeName, kind string, factory SensorFactory) {
+	sensorTypes[typeName] = sensorType{kind, factory}
+}
+
 // A SensorController provides a unified interface to all the sensors on the
-// robot, plus logs and log reading utilities.
+// robot, plus logs and log reading utilities. It owns the sensors, which are
+// identified by their names.
 type SensorController struct {
 	fsm.FSM
-	Sensors           []sensor.Sensor
-	Logger            *logging.SensorLogger
-	LogReader         *logreader.SensorLogReader
-	logReaderStopChan chan bool
+	Sensors   []sensor.Sensor
+	Logger    *logging.SensorLogger
+	LogReader *logreader.SensorLogReader
+	LogPlayer *logreader.Player
+
+	// Kind of each sensor, by name
+	kinds map[string]string
 }
 
-// Make the default sensor controller
-func MakeDefaultSensorController() *SensorController {
+// Make a sensor controller with sensors from a comma separated list of
+// NAME:type pairs, e.g. "LIDAR:lidar, ODOMETRY:odometry".
+func MakeSensorController(sensorList string) (*SensorController, error) {
 	sc := &SensorController{
 		Sensors: make([]sensor.Sensor, 0),
 		FSM:     *fsm.MakeFSM(SENSE),
+		kinds:   map[string]string{},
 	}
 
-	if config.USE_LIDAR {
-		sc.Sensors = append(sc.Sensors, lidar.LidarSensor)
+	for _, pair := range strings.Split(sensorList, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid sensor, should be NAME:type: " + pair)
+		}
+
+		_, err := sc.AddSensor(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if config.USE_ODOMETRY {
-		sc.Sensors = append(sc.Sensors, odometry.OdometrySensor)
+	return sc, nil
+}
+
+// Make the default sensor controller, with the sensors from the config file
+func MakeDefaultSensorController() *SensorController {
+	sc, err := MakeSensorController(config.SENSORS)
+	if err != nil {
+		panic(err)
 	}
 
 	return sc
 }
 
-func (sc *SensorController) getSensorFromTypeName(typeName string) (sensor.Sensor, error) {
+// Make a sensor of a registered type and add it to the controller
+func (sc *SensorController) AddSensor(name, typeName string) (sensor.Sensor, error) {
+	if _, exists := sc.kinds[name]; exists {
+		return nil, errors.New("Sensor already exists: " + name)
+	}
+
+	t, ok := sensorTypes[typeName]
+	if !ok {
+		return nil, errors.New("No such sensor type: " + typeName)
+	}
+
+	s, err := t.factory(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.Sensors = append(sc.Sensors, s)
+	sc.kinds[name] = t.kind
+
+	return s, nil
+}
+
+// Get a sensor by its name
+func (sc *SensorController) GetSensor(name string) (sensor.Sensor, error) {
 	for i := range sc.Sensors {
-		if sc.Sensors[i].GetTypeName() == typeName {
+		if sc.Sensors[i].GetTypeName() == name {
 			return sc.Sensors[i], nil
 		}
 	}
@@ -55,6 +144,105 @@ func (sc *SensorController) getSensorFromTypeName(typeName string) (sensor.Senso
 	return nil, errors.New("No such sensor.")
 }
 
+// Get the first sensor of a kind, e.g. LIDAR, or nil if there is none
+func (sc *SensorController) GetSensorOfType(kind string) sensor.Sensor {
+	for i := range sc.Sensors {
+		if sc.kinds[sc.Sensors[i].GetTypeName()] == kind {
+			return sc.Sensors[i]
+		}
+	}
+
+	return nil
+}
+
+// Get all sensors of a kind
+func (sc *SensorController) GetSensorsOfType(kind string) []sensor.Sensor {
+	sensors := make([]sensor.Sensor, 0)
+	for i := range sc.Sensors {
+		if sc.kinds[sc.Sensors[i].GetTypeName()] == kind {
+			sensors = append(sensors, sc.Sensors[i])
+		}
+	}
+
+	return sensors
+}
+
+// Make a LIDAR, with its mount and chain of filters, from the lidar_* options, which may
+// be overridden in the sensor's own section.
+func makeLidar(name string) (sensor.Sensor, error) {
+	device, err := lidar.MakeDevice(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return makeLidarWithDevice(name, device)
+}
+
+// Make a LIDAR which scans the simulator's default world
+func makeSimulatedLidar(name string) (sensor.Sensor, error) {
+	world, err := simulator.GetDefaultWorld()
+	if err != nil {
+		return nil, err
+	}
+
+	return makeLidarWithDevice(name, simulator.MakeLidarDevice(world))
+}
+
+// Make a LIDAR which only gets readings from a log player
+func makeReplayedLidar(name string) (sensor.Sensor, error) {
+	return makeLidarWithDevice(name, &replayedDevice{name})
+}
+
+// Make a LIDAR with its mount and filters, which reads from the device
+func makeLidarWithDevice(name string, device lidar.Device) (sensor.Sensor, error) {
+	filters, err := lidar.MakeFilters(name)
+	if err != nil {
+		return nil, err
+	}
+
+	l := lidar.MakeLidar(name, device,
+		config.GetFloat64Default(name, "lidar_radial_span", config.LIDAR_RADIAL_SPAN),
+		config.GetFloat64Default(name, "lidar_max_distance", config.LIDAR_MAX_DISTANCE),
+		config.GetIntDefault(name, "lidar_num_distances", config.LIDAR_NUM_DISTANCES))
+	l.Mount = lidar.MakeMount(name)
+	l.SetFilters(filters)
+
+	return l, nil
+}
+
+// Make an encoder from the odometry_* options, which may be overridden in the
+// sensor's own section.
+func makeOdometry(name string) (sensor.Sensor, error) {
+	conf := &serial.Config{
+		Name: config.GetStringDefault(name, "odometry_com_name", config.ODOMETRY_COM_NAME),
+		Baud: config.GetIntDefault(name, "odometry_baud_rate", config.ODOMETRY_BAUD_RATE),
+	}
+
+	return odometry.MakeEncoder(name, conf), nil
+}
+
+// Make an encoder which counts the wheel pulses in the simulator's default
+// world
+func makeSimulatedOdometry(name string) (sensor.Sensor, error) {
+	world, err := simulator.GetDefaultWorld()
+	if err != nil {
+		return nil, err
+	}
+
+	e := odometry.MakeEncoder(name, nil)
+	e.SetDevice(simulator.MakeOdometryDevice(world))
+
+	return e, nil
+}
+
+// Make an encoder which only gets readings from a log player
+func makeReplayedOdometry(name string) (sensor.Sensor, error) {
+	e := odometry.MakeEncoder(name, nil)
+	e.SetDevice(&replayedDevice{name})
+
+	return e, nil
+}
+
 // Check if any sensor is connected
 func (sc *SensorController) AnySensorConnected() bool {
 	for i := range sc.Sensors {
@@ -71,14 +259,14 @@ func (sc *SensorController) ConnectSensor(typeName string) error {
 		return errors.New("Can't connect sensor while in logreading mode")
 	}
 
-	sensor, err := sc.getSensorFromTypeName(typeName)
+	sensor, err := sc.GetSensor(typeName)
 	if err != nil {
 		return err
 	}
 
 	err = sensor.Connect()
 	if sc.Logger == nil {
-		sc.Logger, _ = logging.MakeTimestampedLog() // Disregard errors
+		sc.Logger, _ = logging.MakeTimestampedConfigLog(sc.Sensors) // Disregard errors
 	}
 	sc.Logger.LogSensor(sensor)
 
@@ -87,7 +275,7 @@ func (sc *SensorController) ConnectSensor(typeName string) error {
 
 // Disconnect a specific sensor, identified by its type name
 func (sc *SensorController) DisconnectSensor(typeName string) error {
-	sensor, err := sc.getSensorFromTypeName(typeName)
+	sensor, err := sc.GetSensor(typeName)
 	if err != nil {
 		return err
 	}
@@ -113,28 +301,39 @@ func (sc *SensorController) StartLogReadRealtime(logName string) error {
 	if err != nil {
 		return err
 	}
+	sc.LogReader.SetSensors(sc.Sensors)
 
 	sc.SetState(LOGREAD)
-	sc.logReaderStopChan, err = sc.LogReader.RealTimeReadingDistribution(true)
+	sc.LogPlayer = logreader.MakePlayer(sc.LogReader, true)
 
-	return err
+	return sc.LogPlayer.Play()
 }
 
 func (sc *SensorController) StopLogReadRealtime() {
-	// Stop the routine, but if it's closed, continue
-	select {
-	case sc.logReaderStopChan <- true:
-	default:
+	if sc.LogPlayer != nil {
+		sc.LogPlayer.Stop()
+		sc.LogPlayer = nil
 	}
 
-	sc.LogReader.Close()
+	if sc.LogReader != nil {
+		sc.LogReader.Close()
+		sc.LogReader = nil
+	}
 
 	sc.SetState(SENSE)
 }
 
+// Get the player of the log being read, or an error if no log is read
+func (sc *SensorController) GetLogPlayer() (*logreader.Player, error) {
+	if sc.LogPlayer == nil {
+		return nil, errors.New("No log is being read.")
+	}
+	return sc.LogPlayer, nil
+}
+
 // Start a specific sensor, identified by its type name
 func (sc *SensorController) StartSensor(typeName string) error {
-	sensor, err := sc.getSensorFromTypeName(typeName)
+	sensor, err := sc.GetSensor(typeName)
 	if err != nil {
 		return err
 	}
@@ -144,7 +343,7 @@ func (sc *SensorController) StartSensor(typeName string) error {
 
 // Stop a specific sensor, identified by its type name
 func (sc *SensorController) StopSensor(typeName string) error {
-	sensor, err := sc.getSensorFromTypeName(typeName)
+	sensor, err := sc.GetSensor(typeName)
 	if err != nil {
 		return err
 	}
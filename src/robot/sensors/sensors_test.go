@@ -0,0 +1,66 @@
+package sensors
+
+import (
+	"testing"
+)
+
+func TestMakeSensorController(t *testing.T) {
+	sc, err := MakeSensorController("LIDAR:lidar, LIDAR2:lidar, ODOMETRY:odometry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sc.Sensors) != 3 {
+		t.Fatalf("Got %d sensors, want 3", len(sc.Sensors))
+	}
+
+	if s := sc.GetSensorOfType(LIDAR); s == nil || s.GetTypeName() != "LIDAR" {
+		t.Errorf("First LIDAR should be LIDAR, got %v", s)
+	}
+
+	if n := len(sc.GetSensorsOfType(LIDAR)); n != 2 {
+		t.Errorf("Got %d LIDARs, want 2", n)
+	}
+
+	if _, err := sc.GetSensor("ODOMETRY"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMakeSensorControllerInvalid(t *testing.T) {
+	for _, list := range []string{"LIDAR", "LIDAR:nosuchtype", "LIDAR:lidar, LIDAR:odometry"} {
+		if _, err := MakeSensorController(list); err == nil {
+			t.Errorf("Expected error for %q", list)
+		}
+	}
+
+	sc, err := MakeSensorController("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.GetSensorOfType(LIDAR) != nil {
+		t.Error("Expected no LIDAR")
+	}
+}
+
+func TestReplayedSensors(t *testing.T) {
+	sc, err := MakeSensorController("LIDAR:replayed-lidar, ODOMETRY:replayed-odometry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed sensors are found as the kind of sensor they replay
+	if s := sc.GetSensorOfType(LIDAR); s == nil || s.GetTypeName() != "LIDAR" {
+		t.Errorf("First LIDAR should be LIDAR, got %v", s)
+	}
+	if s := sc.GetSensorOfType(ODOMETRY); s == nil || s.GetTypeName() != "ODOMETRY" {
+		t.Errorf("First odometry should be ODOMETRY, got %v", s)
+	}
+
+	// and can only get readings from a log player
+	for _, s := range sc.Sensors {
+		if err := s.Connect(); err == nil {
+			t.Errorf("Expected error connecting %s", s.GetTypeName())
+		}
+	}
+}
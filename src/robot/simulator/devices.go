@@ -0,0 +1,135 @@
+package simulator
+
+import (
+	"sync"
+	"time"
+)
+
+// Motor implements motor.MotorDriver by setting the wheel speeds of the robot
+// in the simulated world.
+type Motor struct {
+	world     *World
+	connected bool
+}
+
+func MakeMotor(world *World) *Motor {
+	return &Motor{world: world}
+}
+
+func (m *Motor) Connect() error {
+	m.connected = true
+	return nil
+}
+
+// Disconnecting stops the robot
+func (m *Motor) Disconnect() error {
+	m.world.setWheelSpeeds(0, 0)
+	m.connected = false
+	return nil
+}
+
+func (m *Motor) IsConnected() bool {
+	return m.connected
+}
+
+// Set speeds in the interval [-1, 1], which are scaled by the world's maximum
+// wheel speed. Values outside the interval are clamped.
+func (m *Motor) SetSpeeds(left, right float64) error {
+	if !m.connected {
+		return errNotConnected
+	}
+
+	m.world.setWheelSpeeds(clamp(left)*m.world.MaxWheelSpeed, clamp(right)*m.world.MaxWheelSpeed)
+	return nil
+}
+
+func clamp(speed float64) float64 {
+	if speed > 1 {
+		return 1
+	}
+	if speed < -1 {
+		return -1
+	}
+	return speed
+}
+
+// LidarDevice implements lidar.Device by ray casting in the simulated world.
+// Scans are delivered at the world's scan period.
+type LidarDevice struct {
+	world     *World
+	connected bool
+	lastScan  time.Time
+}
+
+func MakeLidarDevice(world *World) *LidarDevice {
+	return &LidarDevice{world: world}
+}
+
+func (l *LidarDevice) Connect() error {
+	l.connected = true
+	return nil
+}
+
+func (l *LidarDevice) Disconnect() {
+	l.connected = false
+}
+
+func (l *LidarDevice) RequestInfiniteData() error {
+	if !l.connected {
+		return errNotConnected
+	}
+	return nil
+}
+
+// Wait for the next scan period, then scan
+func (l *LidarDevice) ReceiveData() ([]float64, error) {
+	if !l.connected {
+		return nil, errNotConnected
+	}
+
+	time.Sleep(l.world.ScanPeriod - time.Since(l.lastScan))
+	l.lastScan = time.Now()
+
+	return l.world.Scan(), nil
+}
+
+// OdometryDevice implements odometry.Device by counting the pulses from the
+// wheels in the simulated world.
+type OdometryDevice struct {
+	world     *World
+	connected bool
+	lock      sync.Mutex
+}
+
+func MakeOdometryDevice(world *World) *OdometryDevice {
+	return &OdometryDevice{world: world}
+}
+
+// Connecting resets the pulse count
+func (o *OdometryDevice) Connect() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.world.readPulses()
+	o.connected = true
+	return nil
+}
+
+func (o *OdometryDevice) Disconnect() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.connected = false
+}
+
+func (o *OdometryDevice) ReadPulses() (left, right int, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if !o.connected {
+		return 0, 0, errNotConnected
+	}
+
+	left, right = o.world.readPulses()
+	return left, right, nil
+}
@@ -0,0 +1,393 @@
+// Package simulator implements a simulated robot, which makes it possible to
+// run the controller, SLAM, path planning and the web interface without the
+// physical robot.
+//
+// The simulated world is a ground truth occupancy grid map. The robot moves in
+// the map according to the speeds set on the simulated motor, integrated
+// through the robot model. The simulated LIDAR ray-casts in the ground truth
+// map and the simulated encoder counts pulses from the distance the wheels
+// have rolled. Both add configurable noise to their readings.
+//
+// The devices plug into the existing sensors and motor controller, so readings
+// are distributed just like readings from the physical sensors.
+package simulator
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	_ "image/png"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"hectormapping/map/gridmap"
+	"hectormapping/map/gridmap/logoddsmap"
+
+	"robot/config"
+	"robot/logging"
+	"robot/mapstorage"
+	"robot/model"
+	"robot/sensors/lidar"
+)
+
+// Log odds values given to cells when making a map from an image
+const (
+	OCCUPIED_VALUE = 2.0
+	FREE_VALUE     = -2.0
+)
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+// A World holds the ground truth map and the true state of the robot in it.
+type World struct {
+	truth gridmap.OccGridMap
+	robot *model.DifferentialWheeledRobot
+	pose  model.Position
+
+	// Wheel speeds in m/s
+	leftSpeed, rightSpeed float64
+
+	// Distances rolled by the wheels since the last odometry read
+	leftDist, rightDist float64
+
+	lastUpdate time.Time
+	rand       *rand.Rand
+	lock       *sync.Mutex
+
+	// Wheel speed in m/s when the motor speed is 1.0
+	MaxWheelSpeed float64
+
+	// LIDAR properties, span in degrees and distances in mm
+	LidarBeams       int
+	LidarSpan        float64
+	LidarMaxDistance float64
+
+	// How the LIDAR is mounted on the robot, which decides where the beams
+	// go and in which order the distances are
+	LidarMount lidar.Mount
+
+	// Standard deviation of LIDAR distances in mm, and of odometry pulses as
+	// a fraction of the pulses
+	LidarNoise    float64
+	OdometryNoise float64
+
+	// Time between LIDAR scans
+	ScanPeriod time.Duration
+}
+
+// Make a world with the robot placed at start in the ground truth map.
+// Properties not given are taken from the config file, including the seed of
+// the noise.
+func MakeWorld(truth gridmap.OccGridMap, robot *model.DifferentialWheeledRobot, start model.Position) *World {
+	seed := int64(config.SIMULATOR_SEED)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &World{
+		truth:            truth,
+		robot:            robot,
+		pose:             start,
+		lastUpdate:       time.Now(),
+		rand:             rand.New(rand.NewSource(seed)),
+		lock:             new(sync.Mutex),
+		MaxWheelSpeed:    config.SIMULATOR_MAX_WHEEL_SPEED,
+		LidarBeams:       config.SIMULATOR_LIDAR_BEAMS,
+		LidarSpan:        config.LIDAR_RADIAL_SPAN,
+		LidarMaxDistance: config.LIDAR_MAX_DISTANCE,
+		LidarMount:       lidar.MakeMount(lidar.NAME),
+		LidarNoise:       config.SIMULATOR_LIDAR_NOISE,
+		OdometryNoise:    config.SIMULATOR_ODOMETRY_NOISE,
+		ScanPeriod:       time.Duration(config.SIMULATOR_SCAN_PERIOD) * time.Millisecond,
+	}
+}
+
+// Make a world from the map and start position in the config file
+func MakeDefaultWorld() (*World, error) {
+	truth, err := LoadTruthMap(config.SIMULATOR_MAP)
+	if err != nil {
+		return nil, err
+	}
+
+	start := model.Position{
+		X:     config.SIMULATOR_START_X,
+		Y:     config.SIMULATOR_START_Y,
+		Theta: config.SIMULATOR_START_THETA,
+	}
+
+	return MakeWorld(truth, model.MakeDefaultDifferentialWheeledRobot(), start), nil
+}
+
+// The default world shared by the simulated sensors and motors
+var defaultWorld struct {
+	sync.Mutex
+	world *World
+}
+
+// Get the default world, which is made from the config file the first time
+// it's needed and then shared by all simulated devices.
+func GetDefaultWorld() (*World, error) {
+	defaultWorld.Lock()
+	defer defaultWorld.Unlock()
+
+	if defaultWorld.world == nil {
+		world, err := MakeDefaultWorld()
+		if err != nil {
+			return nil, err
+		}
+		defaultWorld.world = world
+	}
+
+	return defaultWorld.world, nil
+}
+
+// Get the default world if any simulated device uses it, or nil
+func GetDefaultWorldIfMade() *World {
+	defaultWorld.Lock()
+	defer defaultWorld.Unlock()
+
+	return defaultWorld.world
+}
+
+// Load a ground truth map. PNG images are read as dark pixels being walls,
+// bright pixels being free space and anything in between unknown, with the
+// origin in the center of the image. Anything else is loaded from the map
+// storage.
+func LoadTruthMap(filename string) (gridmap.OccGridMap, error) {
+	if strings.ToLower(path.Ext(filename)) != ".png" {
+		m, err := mapstorage.Load(filename)
+		if err != nil {
+			return nil, err
+		}
+		return m.MapRep.GetGridMap(0), nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		f, err = os.Open(config.MAP_STORAGE_ROOT + filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return MakeTruthMapFromImage(img, config.SIMULATOR_PNG_RESOLUTION), nil
+}
+
+// Make a ground truth map from an image, where each pixel is a cell with the
+// given side length in meters.
+func MakeTruthMapFromImage(img image.Image, resolution float64) gridmap.OccGridMap {
+	bounds := img.Bounds()
+	sizeX, sizeY := bounds.Dx(), bounds.Dy()
+
+	offset := [2]float64{
+		float64(sizeX) * resolution / 2,
+		float64(sizeY) * resolution / 2,
+	}
+	truth := logoddsmap.MakeOccGridMapLogOdds(resolution, [2]int{sizeX, sizeY}, offset)
+
+	for j := 0; j < sizeY; j++ {
+		for i := 0; i < sizeX; i++ {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+i, bounds.Min.Y+j)).(color.Gray)
+
+			// Image rows go downwards, map rows upwards
+			cell := truth.GetCell(i, sizeY-1-j)
+			switch {
+			case gray.Y < 85:
+				cell.Set(OCCUPIED_VALUE)
+			case gray.Y > 170:
+				cell.Set(FREE_VALUE)
+			}
+		}
+	}
+
+	return truth
+}
+
+// Seed the noise of the LIDAR and odometry, so the same seed and motions
+// give the same readings
+func (w *World) SetSeed(seed int64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.rand = rand.New(rand.NewSource(seed))
+}
+
+// Get the true position of the robot
+func (w *World) GetPose() model.Position {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.advance(time.Now())
+	return w.pose
+}
+
+// Place the robot somewhere else in the world
+func (w *World) SetPose(pose model.Position) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.advance(time.Now())
+	w.pose = pose
+}
+
+// Get the ground truth map
+func (w *World) GetTruthMap() gridmap.OccGridMap {
+	return w.truth
+}
+
+// Set the speeds of the wheels in m/s
+func (w *World) setWheelSpeeds(left, right float64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.advance(time.Now())
+	w.leftSpeed, w.rightSpeed = left, right
+}
+
+// Move the robot according to the wheel speeds up until the given time. The
+// movement is done in steps of at most half a cell, and the robot stops in
+// front of any wall it would otherwise drive into. Must be called with the
+// lock held.
+func (w *World) advance(now time.Time) {
+	dt := now.Sub(w.lastUpdate).Seconds()
+	w.lastUpdate = now
+	if dt <= 0 {
+		return
+	}
+
+	left, right := w.leftSpeed*dt, w.rightSpeed*dt
+	if left == 0 && right == 0 {
+		return
+	}
+
+	steps := math.Ceil(math.Max(math.Abs(left), math.Abs(right)) / (w.truth.GetCellLength() / 2))
+	left, right = left/steps, right/steps
+
+	for i := 0; i < int(steps); i++ {
+		pose := w.robot.RollPosition(left, right, w.pose)
+		if w.occupied(pose.X, pose.Y) {
+			return
+		}
+
+		w.pose = pose
+		w.leftDist += left
+		w.rightDist += right
+	}
+}
+
+// Determine if a point in world coordinates is inside a wall in the ground
+// truth map. Points outside the map are considered walls.
+func (w *World) occupied(x, y float64) bool {
+	mx, my := w.toMap(x, y)
+	ix, iy := int(math.Floor(mx)), int(math.Floor(my))
+	if ix < 0 || iy < 0 || ix >= w.truth.GetSizeX() || iy >= w.truth.GetSizeY() {
+		return true
+	}
+	return w.truth.IsOccupied(ix, iy)
+}
+
+// Convert world coordinates to map coordinates
+func (w *World) toMap(x, y float64) (float64, float64) {
+	offset := w.truth.GetMapDimProperties().GetTopLeftOffset()
+	cl := w.truth.GetCellLength()
+	return (x + offset[0]) / cl, (y + offset[1]) / cl
+}
+
+// Take a LIDAR scan from the current position. Distances are in mm, ordered
+// the way the LIDAR delivers them as it's mounted, counter clockwise from
+// -span/2 to span/2 with 0 being straight ahead by default. Beams which don't
+// hit anything within the maximum distance are 0.
+func (w *World) Scan() []float64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.advance(time.Now())
+
+	distances := make([]float64, w.LidarBeams)
+	if w.LidarBeams == 0 {
+		return distances
+	}
+
+	// Position of the LIDAR in the world
+	sin, cos := math.Sincos(w.pose.Theta)
+	x := w.pose.X + cos*w.LidarMount.X - sin*w.LidarMount.Y
+	y := w.pose.Y + sin*w.LidarMount.X + cos*w.LidarMount.Y
+
+	for i := range distances {
+		angle := w.LidarMount.BeamAngle(i, w.LidarBeams, w.LidarSpan)
+		d := w.castRay(x, y, w.pose.Theta+angle)
+		if d > 0 {
+			d += w.rand.NormFloat64() * w.LidarNoise
+			if d <= 0 {
+				d = 1
+			}
+		}
+		distances[i] = d
+	}
+
+	return distances
+}
+
+// Find the distance in mm from a point in world coordinates to the nearest
+// wall in the given direction. Returns 0 if there's no wall within the LIDAR's
+// range.
+func (w *World) castRay(x, y, angle float64) float64 {
+	cl := w.truth.GetCellLength()
+	mx, my := w.toMap(x, y)
+	dx, dy := math.Cos(angle), math.Sin(angle)
+	maxCells := w.LidarMaxDistance / 1000 / cl
+	sizeX, sizeY := w.truth.GetSizeX(), w.truth.GetSizeY()
+
+	// Step half a cell at a time
+	for t := 0.0; t <= maxCells; t += 0.5 {
+		ix := int(math.Floor(mx + t*dx))
+		iy := int(math.Floor(my + t*dy))
+		if ix < 0 || iy < 0 || ix >= sizeX || iy >= sizeY {
+			return 0
+		}
+		if w.truth.IsOccupied(ix, iy) {
+			return t * cl * 1000
+		}
+	}
+
+	return 0
+}
+
+// Get the number of encoder pulses counted on each wheel since the last time
+// the pulses were read.
+func (w *World) readPulses() (left, right int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.advance(time.Now())
+
+	distancePerPulse := 2 * w.robot.WheelRadius * math.Pi / float64(w.robot.OdometryPPR)
+	left = w.pulses(w.leftDist / distancePerPulse)
+	right = w.pulses(w.rightDist / distancePerPulse)
+	w.leftDist, w.rightDist = 0, 0
+
+	return left, right
+}
+
+// Add noise to an exact pulse count
+func (w *World) pulses(exact float64) int {
+	return int(math.Floor(exact*(1+w.rand.NormFloat64()*w.OdometryNoise) + 0.5))
+}
+
+var errNotConnected = errors.New("Simulated device not connected.")
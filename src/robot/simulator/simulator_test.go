@@ -0,0 +1,86 @@
+package simulator
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+	"time"
+
+	"robot/model"
+	"robot/sensors/lidar"
+)
+
+// A 5x5 m world, origin in the center, with a wall 1.5 m in front of the origin
+func makeTestWorld() *World {
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	for j := 0; j < 100; j++ {
+		for i := 0; i < 100; i++ {
+			img.SetGray(i, j, color.Gray{255})
+		}
+		img.SetGray(80, j, color.Gray{0})
+	}
+
+	w := MakeWorld(MakeTruthMapFromImage(img, 0.05), model.MakeDefaultDifferentialWheeledRobot(), model.Position{})
+	w.LidarMount = lidar.Mount{}
+	w.LidarNoise = 0
+	w.OdometryNoise = 0
+	w.LidarBeams = 241
+	w.LidarSpan = 240
+	w.LidarMaxDistance = 4000
+
+	return w
+}
+
+func TestScan(t *testing.T) {
+	w := makeTestWorld()
+
+	distances := w.Scan()
+	if len(distances) != 241 {
+		t.Fatalf("Got %d distances, want 241", len(distances))
+	}
+
+	// Straight ahead
+	if d := distances[120]; math.Abs(d-1500) > 50 {
+		t.Errorf("Distance straight ahead is %f mm, want 1500 mm", d)
+	}
+
+	// Straight to the left there's nothing within range
+	if d := distances[210]; d != 0 {
+		t.Errorf("Distance to the left is %f mm, want 0", d)
+	}
+}
+
+func TestDriveStraight(t *testing.T) {
+	w := makeTestWorld()
+
+	w.leftSpeed, w.rightSpeed = 0.2, 0.2
+	w.advance(w.lastUpdate.Add(time.Second))
+
+	if math.Abs(w.pose.X-0.2) > 1e-9 || w.pose.Y != 0 || w.pose.Theta != 0 {
+		t.Errorf("Pose after driving is %v, want X: 0.2", w.pose)
+	}
+
+	left, right := w.readPulses()
+	want := int(0.2/(2*w.robot.WheelRadius*math.Pi)*float64(w.robot.OdometryPPR) + 0.5)
+	if left != want || right != want {
+		t.Errorf("Got pulses %d, %d, want %d", left, right, want)
+	}
+
+	// Pulses are counted since the last read
+	left, right = w.readPulses()
+	if left != 0 || right != 0 {
+		t.Errorf("Got pulses %d, %d after reset, want 0", left, right)
+	}
+}
+
+func TestWallStopsRobot(t *testing.T) {
+	w := makeTestWorld()
+
+	w.leftSpeed, w.rightSpeed = 1, 1
+	w.advance(w.lastUpdate.Add(2 * time.Second))
+
+	if w.pose.X > 1.5 || w.pose.X < 1.4 {
+		t.Errorf("Robot should stop in front of the wall, but is at %v", w.pose)
+	}
+}
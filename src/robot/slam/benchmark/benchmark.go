@@ -0,0 +1,260 @@
+// Package benchmark measures how accurately SLAM tracks the robot and maps its
+// surroundings, so changes to the scan matcher, the odometry filter or the
+// map update factors can be compared.
+//
+// HectorSLAM is run on the sensor log of a dataset with the offline processor,
+// and the estimated trajectory is compared with the ground truth:
+//
+//   - Absolute trajectory error (ATE) is the distance between the estimated
+//     and true positions, after aligning the trajectories.
+//   - Relative pose error (RPE) is the error of the estimated motion over a
+//     fixed time, which measures drift.
+//
+// If the dataset has a reference map, the map is compared with it as well.
+// The results are written as a plain text report, which only depends on the
+// dataset and the SLAM code, so reports from two commits can be diffed.
+package benchmark
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"hectormapping"
+
+	"robot/config"
+	"robot/logging"
+	"robot/model"
+	"robot/slam/hector"
+	"robot/slam/offline"
+	"robot/slam/trajectory"
+)
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+// A Benchmark runs HectorSLAM on datasets and measures the results
+type Benchmark struct {
+	// Processor settings
+	UseOdometry bool
+	LoopClosure bool
+
+	// Largest time difference between an estimated and a true pose for them
+	// to be compared
+	MaxTimeDifference time.Duration
+
+	// Time over which the relative pose error is measured
+	RPEDelta time.Duration
+
+	// Distance in meters within which a wall counts as found
+	MapTolerance float64
+}
+
+// The results of running a benchmark on a dataset
+type Result struct {
+	Dataset   string
+	Algorithm string
+
+	// Number of estimated poses, and how many were compared with ground truth
+	Poses, Matched int
+
+	ATE                         ErrorStats
+	RPETranslation, RPERotation ErrorStats
+	RPEDelta                    time.Duration
+
+	// Rotation and translation from the SLAM coordinate system to the one of
+	// the ground truth
+	Alignment model.Position
+
+	// Map comparison, nil if the dataset has no reference map
+	Map *MapStats
+
+	// Processing statistics. Timing varies between runs, so it is not part
+	// of the report.
+	Stats offline.Stats
+
+	Trajectory []trajectory.Pose
+}
+
+// Make a benchmark, with the processor set up from the config file
+func MakeBenchmark() *Benchmark {
+	return &Benchmark{
+		UseOdometry:       config.HECTORSLAM_USE_ODOMETRY,
+		LoopClosure:       config.HECTORSLAM_LOOP_CLOSURE,
+		MaxTimeDifference: 20 * time.Millisecond,
+		RPEDelta:          time.Second,
+		MapTolerance:      0.1,
+	}
+}
+
+// Get the name of the SLAM setup, as given in the report
+func (b *Benchmark) GetAlgorithm() string {
+	name := hector.TYPE_NAME
+	if b.UseOdometry {
+		name += "+odometry"
+	}
+	if b.LoopClosure {
+		name += "+loopclosure"
+	}
+	return name
+}
+
+// Run SLAM on the dataset and compare the result with the ground truth
+func (b *Benchmark) Run(d *Dataset) (*Result, error) {
+	truth, err := d.LoadGroundTruth()
+	if err != nil {
+		return nil, err
+	}
+	reference, err := d.LoadReferenceMap()
+	if err != nil {
+		return nil, err
+	}
+
+	slr, err := d.OpenLog()
+	if err != nil {
+		return nil, err
+	}
+	defer slr.Close()
+
+	robot := model.MakeDefaultDifferentialWheeledRobot()
+	if header := slr.GetLogHeader(); header != nil {
+		robot = &header.Robot
+	}
+
+	p := offline.MakeProcessor(d.makeSlamProcessor(), robot)
+	p.UseOdometry = b.UseOdometry
+	if b.LoopClosure {
+		p.EnableLoopClosure()
+	}
+
+	logger.Printf("Running %s on dataset %s", b.GetAlgorithm(), d.Name)
+	err = p.ProcessLog(slr)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := Associate(p.Trajectory, truth, b.MaxTimeDifference)
+	alignment := Align(pairs)
+
+	r := &Result{
+		Dataset:    d.Name,
+		Algorithm:  b.GetAlgorithm(),
+		Poses:      len(p.Trajectory),
+		Matched:    len(pairs),
+		ATE:        AbsoluteTrajectoryError(pairs, alignment),
+		RPEDelta:   b.RPEDelta,
+		Alignment:  alignment,
+		Stats:      p.Stats,
+		Trajectory: p.Trajectory,
+	}
+	r.RPETranslation, r.RPERotation = RelativePoseError(pairs, b.RPEDelta)
+
+	if reference != nil {
+		stats := CompareMaps(p.GetMapRepresentation().GetGridMap(0), reference, alignment, b.MapTolerance)
+		r.Map = &stats
+	}
+
+	return r, nil
+}
+
+// Make a slam processor with the map settings of the dataset
+func (d *Dataset) makeSlamProcessor() *hectormapping.HectorSlamProcessor {
+	resolution := config.HECTORSLAM_GRIDMAP_RESOLUTION
+	if d.MapResolution > 0 {
+		resolution = d.MapResolution
+	}
+	sizeX, sizeY := config.HECTORSLAM_GRIDMAP_SIZE_X, config.HECTORSLAM_GRIDMAP_SIZE_Y
+	if d.MapSize > 0 {
+		sizeX, sizeY = d.MapSize, d.MapSize
+	}
+	start := [2]float64{config.HECTORSLAM_GRIDMAP_START_X, config.HECTORSLAM_GRIDMAP_START_Y}
+	if d.MapStart != nil {
+		start = *d.MapStart
+	}
+	levels := config.HECTORSLAM_LEVELS
+	if d.MapLevels > 0 {
+		levels = d.MapLevels
+	}
+
+	hsp := hectormapping.MakeHectorSlamProcessor(resolution, sizeX, sizeY, start, levels)
+	hector.ConfigureSlamProcessor(hsp)
+	return hsp
+}
+
+// Check the result against the limits, and describe the limits exceeded
+func (r *Result) Check(limits Limits) []string {
+	failures := make([]string, 0)
+	above := func(name string, value, limit float64) {
+		if limit > 0 && value > limit {
+			failures = append(failures, fmt.Sprintf("%s %.4f is above %.4f", name, value, limit))
+		}
+	}
+	below := func(name string, value, limit float64) {
+		if limit > 0 && value < limit {
+			failures = append(failures, fmt.Sprintf("%s %.4f is below %.4f", name, value, limit))
+		}
+	}
+
+	above("ATE", r.ATE.RMSE, limits.ATE)
+	above("RPE translation", r.RPETranslation.RMSE, limits.RPETranslation)
+	above("RPE rotation", r.RPERotation.RMSE, limits.RPERotation)
+	if r.Map != nil {
+		above("Obstacle distance", r.Map.ObstacleDistance, limits.ObstacleDistance)
+		below("Map precision", r.Map.Precision, limits.MinPrecision)
+		below("Map recall", r.Map.Recall, limits.MinRecall)
+	}
+
+	return failures
+}
+
+// Format the result as a report. Numbers are rounded, so tiny floating point
+// differences don't show up when reports are diffed.
+func (r *Result) String() string {
+	lines := []string{
+		fmt.Sprintf("Dataset: %s", r.Dataset),
+		fmt.Sprintf("Algorithm: %s", r.Algorithm),
+		fmt.Sprintf("Poses: %d estimated, %d matched", r.Poses, r.Matched),
+		fmt.Sprintf("Alignment: x %.3f m, y %.3f m, theta %.2f deg",
+			r.Alignment.X, r.Alignment.Y, r.Alignment.Theta*180/math.Pi),
+		"ATE (m): " + r.ATE.format(4),
+		fmt.Sprintf("RPE translation over %s (m): %s", r.RPEDelta, r.RPETranslation.format(4)),
+		fmt.Sprintf("RPE rotation over %s (deg): %s", r.RPEDelta, r.RPERotation.format(3)),
+	}
+	if r.Map != nil {
+		lines = append(lines,
+			fmt.Sprintf("Map: %d occupied cells, precision %.3f, recall %.3f, obstacle distance %.4f m",
+				r.Map.OccupiedCells, r.Map.Precision, r.Map.Recall, r.Map.ObstacleDistance))
+	} else {
+		lines = append(lines, "Map: no reference map")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (s ErrorStats) format(decimals int) string {
+	return fmt.Sprintf("rmse %.*f, mean %.*f, median %.*f, max %.*f (%d)",
+		decimals, s.RMSE, decimals, s.Mean, decimals, s.Median, decimals, s.Max, s.Count)
+}
+
+// Write the results as a report, separated by blank lines
+func WriteReport(w io.Writer, results []*Result) error {
+	for i, r := range results {
+		if i > 0 {
+			_, err := io.WriteString(w, "\n")
+			if err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, r.String()+"\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
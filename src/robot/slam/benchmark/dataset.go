@@ -0,0 +1,165 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"hectormapping/map/gridmap"
+
+	"robot/config"
+	"robot/sensors/logreader"
+	"robot/simulator"
+	"robot/slam/trajectory"
+)
+
+// Files of a dataset
+const (
+	DATASET_FILE_NAME      = "dataset.json"
+	SENSORLOG_FILE_NAME    = "sensors.log"
+	GROUND_TRUTH_FILE_NAME = "groundtruth.txt"
+	REFERENCE_FILE_NAME    = "reference.png"
+)
+
+// A Dataset is a sensor log with the true trajectory of the robot, and
+// optionally a reference map, kept together in a folder:
+//
+//	dataset.json     description, map settings and regression limits
+//	sensors.log      sensor log, CSV or binary
+//	groundtruth.txt  true poses in the TUM format
+//	reference.png    reference map, dark pixels are walls (optional)
+//
+// The reference map image is read like a simulator map, with the origin in
+// the center of the image.
+type Dataset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// Map to build, as the HECTORSLAM_GRIDMAP settings. Values not given are
+	// taken from the config file.
+	MapResolution float64     `json:"mapResolution"`
+	MapSize       int         `json:"mapSize"`
+	MapStart      *[2]float64 `json:"mapStart"`
+	MapLevels     int         `json:"mapLevels"`
+
+	// Side length in meters of the reference map pixels
+	ReferenceResolution float64 `json:"referenceResolution"`
+
+	// Limits checked by the regression tests. Zero means no limit.
+	Limits Limits `json:"limits"`
+
+	dir string
+}
+
+// Limits for the metrics of a run. The errors are RMSE in meters and degrees.
+type Limits struct {
+	ATE              float64 `json:"ate"`
+	RPETranslation   float64 `json:"rpeTranslation"`
+	RPERotation      float64 `json:"rpeRotation"`
+	ObstacleDistance float64 `json:"obstacleDistance"`
+	MinPrecision     float64 `json:"minPrecision"`
+	MinRecall        float64 `json:"minRecall"`
+}
+
+// Load a dataset from the dataset folder, or from the given path
+func LoadDataset(name string) (*Dataset, error) {
+	dir := name
+	if filepath.Base(name) == name {
+		dir = config.DATASETS_ROOT + name
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, DATASET_FILE_NAME))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dataset{}
+	err = json.Unmarshal(data, d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Name == "" {
+		d.Name = filepath.Base(dir)
+	}
+	d.dir = dir
+
+	return d, nil
+}
+
+// Get the names of the datasets in the dataset folder
+func GetDatasets() ([]string, error) {
+	files, err := ioutil.ReadDir(config.DATASETS_ROOT)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(config.DATASETS_ROOT+f.Name(), DATASET_FILE_NAME))
+		if err == nil {
+			names = append(names, f.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Get the folder of the dataset
+func (d *Dataset) GetDir() string {
+	return d.dir
+}
+
+// Open the sensor log
+func (d *Dataset) OpenLog() (*logreader.SensorLogReader, error) {
+	return logreader.MakeLogReaderFromFileName(filepath.Join(d.dir, SENSORLOG_FILE_NAME))
+}
+
+// Read the true trajectory
+func (d *Dataset) LoadGroundTruth() ([]trajectory.Pose, error) {
+	f, err := os.Open(filepath.Join(d.dir, GROUND_TRUTH_FILE_NAME))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	poses, err := trajectory.ReadTUM(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(poses) == 0 {
+		return nil, errors.New("Ground truth trajectory is empty.")
+	}
+
+	return poses, nil
+}
+
+// Load the reference map. Returns nil if the dataset has none.
+func (d *Dataset) LoadReferenceMap() (gridmap.OccGridMap, error) {
+	f, err := os.Open(filepath.Join(d.dir, REFERENCE_FILE_NAME))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	resolution := d.ReferenceResolution
+	if resolution == 0 {
+		resolution = config.SIMULATOR_PNG_RESOLUTION
+	}
+
+	return simulator.MakeTruthMapFromImage(img, resolution), nil
+}
@@ -0,0 +1,144 @@
+package benchmark
+
+import (
+	"math"
+
+	"hectormapping/map/gridmap"
+
+	"robot/model"
+)
+
+// How well a map fits a reference map
+type MapStats struct {
+	// Fraction of the occupied cells of the map which are within the
+	// tolerance of a wall in the reference map
+	Precision float64
+
+	// Fraction of the walls in the reference map which are within the
+	// tolerance of an occupied cell of the map. Walls which were never seen
+	// count as missed.
+	Recall float64
+
+	// Mean distance in meters from the occupied cells of the map inside the
+	// reference map to the nearest wall in it
+	ObstacleDistance float64
+
+	OccupiedCells int
+}
+
+// Compare a map with a reference map. The alignment moves the map into the
+// coordinate system of the reference, and tolerance is in meters.
+func CompareMaps(estimate, reference gridmap.OccGridMap, alignment model.Position, tolerance float64) MapStats {
+	stats := MapStats{}
+
+	refSizeX, refSizeY := reference.GetSizeX(), reference.GetSizeY()
+	refCellLength := reference.GetCellLength()
+	refDistance := distanceTransform(refSizeX, refSizeY, func(x, y int) bool {
+		return reference.IsOccupied(x, y)
+	})
+
+	// Occupied cells of the map, in reference map cells
+	seen := make([]bool, refSizeX*refSizeY)
+
+	precise, inside := 0, 0
+	distanceSum := 0.0
+	for y := 0; y < estimate.GetSizeY(); y++ {
+		for x := 0; x < estimate.GetSizeX(); x++ {
+			if !estimate.IsOccupied(x, y) {
+				continue
+			}
+			stats.OccupiedCells++
+
+			world := estimate.GetWorldCoords([2]float64{float64(x) + 0.5, float64(y) + 0.5})
+			aligned := compose(alignment, model.Position{X: world[0], Y: world[1]})
+			cell := reference.GetMapCoords([2]float64{aligned.X, aligned.Y})
+			rx, ry := int(math.Floor(cell[0])), int(math.Floor(cell[1]))
+			if rx < 0 || ry < 0 || rx >= refSizeX || ry >= refSizeY {
+				continue
+			}
+			seen[ry*refSizeX+rx] = true
+			inside++
+
+			distance := refDistance[ry*refSizeX+rx] * refCellLength
+			distanceSum += distance
+			if distance <= tolerance {
+				precise++
+			}
+		}
+	}
+
+	if stats.OccupiedCells > 0 {
+		stats.Precision = float64(precise) / float64(stats.OccupiedCells)
+	}
+	if inside > 0 {
+		stats.ObstacleDistance = distanceSum / float64(inside)
+	}
+
+	seenDistance := distanceTransform(refSizeX, refSizeY, func(x, y int) bool {
+		return seen[y*refSizeX+x]
+	})
+	walls, found := 0, 0
+	for y := 0; y < refSizeY; y++ {
+		for x := 0; x < refSizeX; x++ {
+			if !reference.IsOccupied(x, y) {
+				continue
+			}
+			walls++
+			if seenDistance[y*refSizeX+x]*refCellLength <= tolerance {
+				found++
+			}
+		}
+	}
+	if walls > 0 {
+		stats.Recall = float64(found) / float64(walls)
+	}
+
+	return stats
+}
+
+// Get the distance in cells from each cell of a grid to the nearest cell where
+// set is true, approximated by steps to the 8 neighbours. Cells with nothing
+// set in the grid get a distance of +Inf.
+func distanceTransform(sizeX, sizeY int, set func(x, y int) bool) []float64 {
+	distance := make([]float64, sizeX*sizeY)
+	for y := 0; y < sizeY; y++ {
+		for x := 0; x < sizeX; x++ {
+			if set(x, y) {
+				distance[y*sizeX+x] = 0
+			} else {
+				distance[y*sizeX+x] = math.Inf(1)
+			}
+		}
+	}
+
+	update := func(x, y, dx, dy int, step float64) {
+		nx, ny := x+dx, y+dy
+		if nx < 0 || ny < 0 || nx >= sizeX || ny >= sizeY {
+			return
+		}
+		if d := distance[ny*sizeX+nx] + step; d < distance[y*sizeX+x] {
+			distance[y*sizeX+x] = d
+		}
+	}
+
+	// Forward pass from the neighbours above and to the left, then backward
+	// from below and to the right
+	for y := 0; y < sizeY; y++ {
+		for x := 0; x < sizeX; x++ {
+			update(x, y, -1, 0, 1)
+			update(x, y, 0, -1, 1)
+			update(x, y, -1, -1, math.Sqrt2)
+			update(x, y, 1, -1, math.Sqrt2)
+		}
+	}
+	for y := sizeY - 1; y >= 0; y-- {
+		for x := sizeX - 1; x >= 0; x-- {
+			update(x, y, 1, 0, 1)
+			update(x, y, 0, 1, 1)
+			update(x, y, 1, 1, math.Sqrt2)
+			update(x, y, -1, 1, math.Sqrt2)
+		}
+	}
+
+	return distance
+}
@@ -0,0 +1,203 @@
+package benchmark
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"robot/model"
+	"robot/slam/trajectory"
+)
+
+// Summary of a set of errors
+type ErrorStats struct {
+	RMSE   float64
+	Mean   float64
+	Median float64
+	Max    float64
+	Count  int
+}
+
+func makeErrorStats(values []float64) ErrorStats {
+	s := ErrorStats{Count: len(values)}
+	if len(values) == 0 {
+		return s
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	sum, sumSquares := 0.0, 0.0
+	for _, e := range sorted {
+		sum += e
+		sumSquares += e * e
+	}
+
+	s.RMSE = math.Sqrt(sumSquares / float64(len(sorted)))
+	s.Mean = sum / float64(len(sorted))
+	s.Max = sorted[len(sorted)-1]
+	if n := len(sorted); n%2 == 1 {
+		s.Median = sorted[n/2]
+	} else {
+		s.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	return s
+}
+
+// An estimated pose with the true pose at the same time
+type PosePair struct {
+	Estimate, Truth model.Position
+	Timestamp       time.Time
+}
+
+// Pair each estimated pose with the true pose closest in time, if it is within
+// maxDiff. Both trajectories must be in timestamp order.
+func Associate(estimate, truth []trajectory.Pose, maxDiff time.Duration) []PosePair {
+	pairs := make([]PosePair, 0, len(estimate))
+	for _, e := range estimate {
+		i := sort.Search(len(truth), func(i int) bool {
+			return !truth[i].Timestamp.Before(e.Timestamp)
+		})
+
+		// The closest of the poses before and after
+		best, bestDiff := -1, maxDiff+1
+		for _, j := range []int{i - 1, i} {
+			if j < 0 || j >= len(truth) {
+				continue
+			}
+			diff := truth[j].Timestamp.Sub(e.Timestamp)
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff < bestDiff {
+				best, bestDiff = j, diff
+			}
+		}
+
+		if best >= 0 {
+			pairs = append(pairs, PosePair{
+				Estimate:  e.Position,
+				Truth:     truth[best].Position,
+				Timestamp: e.Timestamp,
+			})
+		}
+	}
+	return pairs
+}
+
+// Find the rotation and translation which moves the estimated positions
+// closest to the true positions, in the least squares sense. The estimate
+// starts in its own coordinate system, so it has to be aligned with the ground
+// truth before it is compared.
+func Align(pairs []PosePair) model.Position {
+	if len(pairs) == 0 {
+		return model.Position{}
+	}
+
+	// Centroids
+	var ex, ey, tx, ty float64
+	for _, p := range pairs {
+		ex += p.Estimate.X
+		ey += p.Estimate.Y
+		tx += p.Truth.X
+		ty += p.Truth.Y
+	}
+	n := float64(len(pairs))
+	ex, ey, tx, ty = ex/n, ey/n, tx/n, ty/n
+
+	// The rotation angle maximizing the correlation of the centered points
+	var dot, cross float64
+	for _, p := range pairs {
+		x1, y1 := p.Estimate.X-ex, p.Estimate.Y-ey
+		x2, y2 := p.Truth.X-tx, p.Truth.Y-ty
+		dot += x1*x2 + y1*y2
+		cross += x1*y2 - y1*x2
+	}
+	theta := math.Atan2(cross, dot)
+
+	// With too little movement to find the rotation, align the headings
+	if math.Abs(dot)+math.Abs(cross) < 1e-9 {
+		var sin, cos float64
+		for _, p := range pairs {
+			d := p.Truth.Theta - p.Estimate.Theta
+			sin += math.Sin(d)
+			cos += math.Cos(d)
+		}
+		theta = math.Atan2(sin, cos)
+	}
+
+	sin, cos := math.Sincos(theta)
+	return model.Position{
+		X:     tx - (cos*ex - sin*ey),
+		Y:     ty - (sin*ex + cos*ey),
+		Theta: theta,
+	}
+}
+
+// Compose two poses, giving b in the coordinate system a is in, when b is
+// relative to a
+func compose(a, b model.Position) model.Position {
+	sin, cos := math.Sincos(a.Theta)
+	return model.Position{
+		X:     a.X + cos*b.X - sin*b.Y,
+		Y:     a.Y + sin*b.X + cos*b.Y,
+		Theta: normalizeAngle(a.Theta + b.Theta),
+	}
+}
+
+// Get b relative to a
+func relative(a, b model.Position) model.Position {
+	sin, cos := math.Sincos(a.Theta)
+	dx, dy := b.X-a.X, b.Y-a.Y
+	return model.Position{
+		X:     cos*dx + sin*dy,
+		Y:     -sin*dx + cos*dy,
+		Theta: normalizeAngle(b.Theta - a.Theta),
+	}
+}
+
+// Get an angle between -pi and pi
+func normalizeAngle(angle float64) float64 {
+	return math.Remainder(angle, 2*math.Pi)
+}
+
+// Absolute trajectory error: The distance between each aligned estimated
+// position and the true position, in meters.
+func AbsoluteTrajectoryError(pairs []PosePair, alignment model.Position) ErrorStats {
+	distances := make([]float64, len(pairs))
+	for i, p := range pairs {
+		aligned := compose(alignment, p.Estimate)
+		distances[i] = math.Hypot(aligned.X-p.Truth.X, aligned.Y-p.Truth.Y)
+	}
+	return makeErrorStats(distances)
+}
+
+// Relative pose error: How much the estimated motion over the given time
+// differs from the true motion, as translation in meters and rotation in
+// degrees. It measures drift, independently of the alignment.
+func RelativePoseError(pairs []PosePair, delta time.Duration) (translation, rotation ErrorStats) {
+	translations := make([]float64, 0)
+	rotations := make([]float64, 0)
+
+	j := 0
+	for i := range pairs {
+		// The first pose at least delta later
+		for j < len(pairs) && pairs[j].Timestamp.Sub(pairs[i].Timestamp) < delta {
+			j++
+		}
+		if j >= len(pairs) {
+			break
+		}
+
+		estimated := relative(pairs[i].Estimate, pairs[j].Estimate)
+		truth := relative(pairs[i].Truth, pairs[j].Truth)
+		e := relative(truth, estimated)
+
+		translations = append(translations, math.Hypot(e.X, e.Y))
+		rotations = append(rotations, math.Abs(e.Theta)*180/math.Pi)
+	}
+
+	return makeErrorStats(translations), makeErrorStats(rotations)
+}
@@ -0,0 +1,113 @@
+package benchmark
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"hectormapping/map/gridmap/logoddsmap"
+
+	"robot/model"
+	"robot/slam/trajectory"
+)
+
+// A true trajectory driving a quarter circle, and an estimate of it in a
+// coordinate system rotated and moved by the given transform
+func makeTestTrajectories(transform model.Position) (estimate, truth []trajectory.Pose) {
+	start := time.Unix(1400000000, 0)
+	for i := 0; i < 50; i++ {
+		angle := float64(i) / 49 * math.Pi / 2
+		t := model.Position{X: 2 * math.Sin(angle), Y: 2 - 2*math.Cos(angle), Theta: angle}
+		e := relative(transform, t)
+
+		timestamp := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		truth = append(truth, trajectory.Pose{Timestamp: timestamp, Position: t})
+		estimate = append(estimate, trajectory.Pose{Timestamp: timestamp.Add(3 * time.Millisecond), Position: e})
+	}
+	return estimate, truth
+}
+
+func TestAlign(t *testing.T) {
+	transform := model.Position{X: 1.5, Y: -0.5, Theta: 0.7}
+	estimate, truth := makeTestTrajectories(transform)
+
+	pairs := Associate(estimate, truth, 20*time.Millisecond)
+	if len(pairs) != 50 {
+		t.Fatalf("Associated %d poses, want 50", len(pairs))
+	}
+	if none := Associate(estimate, truth, time.Millisecond); len(none) != 0 {
+		t.Errorf("Associated %d poses further apart than allowed", len(none))
+	}
+
+	alignment := Align(pairs)
+	if math.Abs(alignment.X-transform.X) > 1e-9 || math.Abs(alignment.Y-transform.Y) > 1e-9 ||
+		math.Abs(alignment.Theta-transform.Theta) > 1e-9 {
+		t.Errorf("Alignment is %v, want %v", alignment, transform)
+	}
+
+	if ate := AbsoluteTrajectoryError(pairs, alignment); ate.RMSE > 1e-9 || ate.Count != 50 {
+		t.Errorf("ATE of an exact estimate is %+v", ate)
+	}
+}
+
+func TestRelativePoseError(t *testing.T) {
+	estimate, truth := makeTestTrajectories(model.Position{})
+
+	// The estimate drifts 1 cm and 0.1 degrees to the left per pose
+	for i := range estimate {
+		drift := model.Position{X: 0, Y: 0.01 * float64(i), Theta: 0.1 * math.Pi / 180 * float64(i)}
+		estimate[i].Position = compose(drift, estimate[i].Position)
+	}
+
+	pairs := Associate(estimate, truth, 20*time.Millisecond)
+	translation, rotation := RelativePoseError(pairs, time.Second)
+
+	// Poses one second, 10 poses, apart
+	if translation.Count != 40 {
+		t.Errorf("Got %d relative poses, want 40", translation.Count)
+	}
+	if math.Abs(rotation.Mean-1) > 1e-6 || math.Abs(rotation.Max-1) > 1e-6 {
+		t.Errorf("Rotation error is %+v, want 1 degree", rotation)
+	}
+	if translation.Mean < 0.05 {
+		t.Errorf("Translation error is %+v, want more than the 10 cm of lateral drift", translation)
+	}
+}
+
+func TestCompareMaps(t *testing.T) {
+	reference := logoddsmap.MakeOccGridMapLogOdds(0.05, [2]int{100, 100}, [2]float64{2.5, 2.5})
+	estimate := logoddsmap.MakeOccGridMapLogOdds(0.025, [2]int{200, 200}, [2]float64{1, 1})
+
+	// A wall along x = 1 in the reference, and along y = 0 in the estimate,
+	// which is rotated 90 degrees and moved 1 m
+	for i := 0; i < 60; i++ {
+		reference.GetCell(70, 40+i).Set(2)
+	}
+	for i := 0; i < 80; i++ {
+		estimate.GetCell(40+i, 40).Set(2)
+	}
+	alignment := model.Position{X: 1, Y: 0, Theta: math.Pi / 2}
+
+	stats := CompareMaps(estimate, reference, alignment, 0.1)
+	if stats.OccupiedCells != 80 || stats.Precision != 1 || stats.ObstacleDistance > 0.06 {
+		t.Errorf("Map stats are %+v, want all 80 cells on the wall", stats)
+	}
+	if stats.Recall < 0.6 || stats.Recall > 0.8 {
+		t.Errorf("Recall is %f, want 2 of the 3 m wall", stats.Recall)
+	}
+
+	stats = CompareMaps(estimate, reference, model.Position{}, 0.1)
+	if stats.Precision > 0.2 {
+		t.Errorf("Precision of an unaligned map is %f", stats.Precision)
+	}
+}
+
+func TestDistanceTransform(t *testing.T) {
+	distance := distanceTransform(5, 5, func(x, y int) bool {
+		return x == 0 && y == 0
+	})
+
+	if distance[0] != 0 || distance[4] != 4 || math.Abs(distance[24]-4*math.Sqrt2) > 1e-9 {
+		t.Errorf("Distances are %v", distance)
+	}
+}
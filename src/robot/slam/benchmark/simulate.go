@@ -0,0 +1,202 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"hectormapping/map/gridmap"
+
+	"robot/model"
+	"robot/sensors/binlog"
+	"robot/sensors/lidar"
+	"robot/sensors/odometry"
+	"robot/sensors/sensor"
+	"robot/simulator"
+	"robot/slam/trajectory"
+)
+
+// A step of a simulated run: the distances in meters the wheels roll before
+// the next scan
+type Step struct {
+	Left, Right float64
+}
+
+// Make the steps of driving the given distance straight ahead, in steps of at
+// most stepLength
+func Straight(distance, stepLength float64) []Step {
+	n := int(math.Ceil(math.Abs(distance) / stepLength))
+	steps := make([]Step, n)
+	for i := range steps {
+		steps[i] = Step{distance / float64(n), distance / float64(n)}
+	}
+	return steps
+}
+
+// Make the steps of turning on the spot by the given angle in radians, in
+// steps of at most stepAngle
+func Turn(robot *model.DifferentialWheeledRobot, angle, stepAngle float64) []Step {
+	n := int(math.Ceil(math.Abs(angle) / stepAngle))
+	steps := make([]Step, n)
+	for i := range steps {
+		d := angle / float64(n) * robot.BaseWidth / 2
+		steps[i] = Step{-d, d}
+	}
+	return steps
+}
+
+// Make a dataset by driving a simulated robot through the steps, scanning
+// after each step. The ground truth map of the world is saved as the
+// reference map, so it must have its origin in the center, as maps made from
+// images do. The noise of the world is seeded with seed, so the same world,
+// steps and seed always give the same dataset. The dataset is saved to dir,
+// which is created if needed.
+func Simulate(dir string, world *simulator.World, robot *model.DifferentialWheeledRobot, steps []Step, seed int64) (*Dataset, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	truth := world.GetTruthMap()
+	offset := truth.GetMapDimProperties().GetTopLeftOffset()
+	size := truth.GetMapDimensions()
+	cl := truth.GetCellLength()
+	if math.Abs(offset[0]-float64(size[0])*cl/2) > cl || math.Abs(offset[1]-float64(size[1])*cl/2) > cl {
+		return nil, errors.New("The ground truth map must have its origin in the center.")
+	}
+
+	d := &Dataset{
+		Name:                filepath.Base(dir),
+		Description:         fmt.Sprintf("Simulated run, seed %d", seed),
+		ReferenceResolution: cl,
+		dir:                 dir,
+	}
+
+	world.SetSeed(seed)
+	err = d.writeSensorLog(world, robot, steps)
+	if err != nil {
+		return nil, err
+	}
+	err = writeReferenceMap(filepath.Join(dir, REFERENCE_FILE_NAME), truth)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, DATASET_FILE_NAME), data, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Drive through the steps, writing the readings to the sensor log and the true
+// poses to the ground truth
+func (d *Dataset) writeSensorLog(world *simulator.World, robot *model.DifferentialWheeledRobot, steps []Step) error {
+	// The logged LIDAR is the simulated one, which may have other settings
+	// than the real one
+	l := lidar.MakeLidar(lidar.NAME, simulator.MakeLidarDevice(world), world.LidarSpan, world.LidarMaxDistance, world.LidarBeams)
+	l.Mount = world.LidarMount
+	e := odometry.MakeDefaultEncoder()
+
+	f, err := os.Create(filepath.Join(d.dir, SENSORLOG_FILE_NAME))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw, err := binlog.MakeWriter(f, binlog.MakeHeader([]sensor.Sensor{l, e}, robot, true))
+	if err != nil {
+		return err
+	}
+
+	// Timestamps are counted from a fixed time, so the same steps always
+	// give the same log
+	t := time.Unix(1400000000, 0)
+	pose := world.GetPose()
+	truth := make([]trajectory.Pose, 0, len(steps)+1)
+	distancePerPulse := 2 * robot.WheelRadius * math.Pi / float64(robot.OdometryPPR)
+	var left, right float64
+
+	for i := 0; i <= len(steps); i++ {
+		if i > 0 {
+			step := steps[i-1]
+			pose = robot.RollPosition(step.Left, step.Right, pose)
+			world.SetPose(pose)
+
+			// Count whole pulses, keeping the rest for the next step
+			left += step.Left / distancePerPulse
+			right += step.Right / distancePerPulse
+			or := odometry.MakeOdometryReading(e)
+			or.LeftPulses, or.RightPulses = int(left), int(right)
+			left, right = left-float64(or.LeftPulses), right-float64(or.RightPulses)
+			or.SetTimestamp(t.Add(-world.ScanPeriod / 2))
+			err = bw.WriteReading(or)
+			if err != nil {
+				return err
+			}
+		}
+
+		lr := lidar.MakeLidarReading(l)
+		lr.Distances = world.Scan()
+		lr.Span = world.LidarSpan
+		lr.SetTimestamp(t)
+		err = bw.WriteReading(lr)
+		if err != nil {
+			return err
+		}
+
+		truth = append(truth, trajectory.Pose{Timestamp: t, Position: pose})
+		t = t.Add(world.ScanPeriod)
+	}
+
+	err = bw.Close()
+	if err != nil {
+		return err
+	}
+
+	g, err := os.Create(filepath.Join(d.dir, GROUND_TRUTH_FILE_NAME))
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+
+	return trajectory.WriteTUM(g, truth)
+}
+
+// Save a map as an image, the way simulator maps are read
+func writeReferenceMap(filename string, m gridmap.OccGridMap) error {
+	sizeX, sizeY := m.GetSizeX(), m.GetSizeY()
+	img := image.NewGray(image.Rect(0, 0, sizeX, sizeY))
+	for j := 0; j < sizeY; j++ {
+		for i := 0; i < sizeX; i++ {
+			c := color.Gray{127}
+			if m.IsOccupied(i, sizeY-1-j) {
+				c = color.Gray{0}
+			} else if m.IsFree(i, sizeY-1-j) {
+				c = color.Gray{255}
+			}
+			img.SetGray(i, j, c)
+		}
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, img)
+}
@@ -60,6 +60,14 @@ func (o *OdomSlamEKF) Stop() {
 	o.mX = matrix.Zeros(5, 1)
 }
 
+// Move the estimated pose, keeping the speeds, e.g. when loop closure has
+// corrected it
+func (o *OdomSlamEKF) SetPose(x, y, theta float64) {
+	o.mX.Set(0, 0, x)
+	o.mX.Set(1, 0, y)
+	o.mX.Set(2, 0, theta)
+}
+
 func (o *OdomSlamEKF) States() []float64 {
 	return o.mX.Array()
 }
@@ -245,3 +253,14 @@ func (o *OdomSlamEKF) Estimate() []float64 {
 
 // 	return [3]float64{}
 // }
+
+// Get the covariance of x, y and theta at the last update
+func (o *OdomSlamEKF) Covariance() [3][3]float64 {
+	covariance := [3][3]float64{}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			covariance[i][j] = o.mP.Get(i, j)
+		}
+	}
+	return covariance
+}
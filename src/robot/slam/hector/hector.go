@@ -10,6 +10,7 @@ import (
 	"hectormapping/datacontainer"
 	"hectormapping/map/mapimages"
 	"hectormapping/map/maprep"
+	"hectormapping/posegraph"
 
 	"robot/config"
 	"robot/logging"
@@ -17,6 +18,8 @@ import (
 	"robot/sensors/lidar"
 	"robot/sensors/odometry"
 	"robot/sensors/sensor"
+	"robot/slam/trajectory"
+	"robot/telemetry"
 )
 
 const TYPE_NAME = "hectorslam"
@@ -31,6 +34,8 @@ func init() {
 type HectorSlam struct {
 	hsp         *hectormapping.HectorSlamProcessor
 	stopChan    chan bool
+	lidar       sensor.Sensor
+	odometry    sensor.Sensor
 	lidarChan   chan sensor.SensorReading
 	encoderChan chan sensor.SensorReading
 	robot       *model.DifferentialWheeledRobot
@@ -38,14 +43,29 @@ type HectorSlam struct {
 	// rightPulses int
 	filter            *OdomSlamEKF
 	lastMapUpdatePose [3]float64
+	trajectory        *trajectory.Trajectory
+	tiles             *mapimages.TileCache
+
+	// Pose graph for loop closure, nil if it's disabled, and the keyframe
+	// each pose of the trajectory was recorded relative to
+	poseGraph *posegraph.Processor
+	anchors   []posegraph.Anchor
 }
 
-func MakeHectorSlam() *HectorSlam {
+// Make a slam processor with an empty map, set up from the config file
+func MakeDefaultHectorSlamProcessor() *hectormapping.HectorSlamProcessor {
 	slamProcessor := hectormapping.MakeHectorSlamProcessor(config.HECTORSLAM_GRIDMAP_RESOLUTION,
 		config.HECTORSLAM_GRIDMAP_SIZE_X, config.HECTORSLAM_GRIDMAP_SIZE_Y,
 		[2]float64{config.HECTORSLAM_GRIDMAP_START_X, config.HECTORSLAM_GRIDMAP_START_Y},
 		config.HECTORSLAM_LEVELS)
 
+	ConfigureSlamProcessor(slamProcessor)
+
+	return slamProcessor
+}
+
+// Set update factors and map update limits from the config file
+func ConfigureSlamProcessor(slamProcessor *hectormapping.HectorSlamProcessor) {
 	// Set update factors
 	slamProcessor.SetUpdateFactorFree(config.HECTORSLAM_UPDATE_FACTOR_FREE)
 	slamProcessor.SetUpdateFactorOccupied(config.HECTORSLAM_UPDATE_FACTOR_OCCUPIED)
@@ -53,39 +73,60 @@ func MakeHectorSlam() *HectorSlam {
 	// Set minimum distance and angle for map update
 	slamProcessor.SetMapUpdateMinDistDiff(config.HECTORSLAM_MAP_UPDATE_MIN_DIST_DIFF)
 	slamProcessor.SetMapUpdateMinAngleDiff(config.HECTORSLAM_MAP_UPDATE_MIN_ANGLE_DIFF)
+}
+
+// Make HectorSLAM using readings from the given sensors. Odometry may be nil.
+func MakeHectorSlam(lidar, odometry sensor.Sensor) *HectorSlam {
+	slamProcessor := MakeDefaultHectorSlamProcessor()
 
 	return &HectorSlam{
-		hsp:      slamProcessor,
-		stopChan: make(chan bool),
-		robot:    model.MakeDefaultDifferentialWheeledRobot(),
+		hsp:        slamProcessor,
+		poseGraph:  makePoseGraph(slamProcessor),
+		stopChan:   make(chan bool),
+		lidar:      lidar,
+		odometry:   odometry,
+		robot:      model.MakeDefaultDifferentialWheeledRobot(),
+		trajectory: trajectory.MakeTrajectory(),
+		tiles:      mapimages.MakeTileCache(slamProcessor.GetMapRepresentation()),
 	}
 }
 
-func MakeHectorSlamFromMapRep(mapRep maprep.MapRepresentation) *HectorSlam {
+func MakeHectorSlamFromMapRep(mapRep maprep.MapRepresentation, lidar, odometry sensor.Sensor) *HectorSlam {
 	slamProcessor := hectormapping.MakeHectorSlamProcessorFromMapRep(mapRep)
-
-	// Set update factors
-	slamProcessor.SetUpdateFactorFree(config.HECTORSLAM_UPDATE_FACTOR_FREE)
-	slamProcessor.SetUpdateFactorOccupied(config.HECTORSLAM_UPDATE_FACTOR_OCCUPIED)
-
-	// Set minimum distance and angle for map update
-	slamProcessor.SetMapUpdateMinDistDiff(config.HECTORSLAM_MAP_UPDATE_MIN_DIST_DIFF)
-	slamProcessor.SetMapUpdateMinAngleDiff(config.HECTORSLAM_MAP_UPDATE_MIN_ANGLE_DIFF)
+	ConfigureSlamProcessor(slamProcessor)
 
 	return &HectorSlam{
-		hsp:      slamProcessor,
-		stopChan: make(chan bool),
-		robot:    model.MakeDefaultDifferentialWheeledRobot(),
+		hsp:        slamProcessor,
+		poseGraph:  makePoseGraph(slamProcessor),
+		stopChan:   make(chan bool),
+		lidar:      lidar,
+		odometry:   odometry,
+		robot:      model.MakeDefaultDifferentialWheeledRobot(),
+		trajectory: trajectory.MakeTrajectory(),
+		tiles:      mapimages.MakeTileCache(mapRep),
+	}
+}
+
+// Make a pose graph for the slam processor if loop closure is enabled in the
+// config file
+func makePoseGraph(slamProcessor *hectormapping.HectorSlamProcessor) *posegraph.Processor {
+	if !config.HECTORSLAM_LOOP_CLOSURE {
+		return nil
 	}
+	return posegraph.MakeProcessor(slamProcessor)
 }
 
 func (hs *HectorSlam) Start() {
 
 	// Start LIDAR sensor subscription
-	hs.lidarChan = lidar.LidarSensor.Subscribe()
+	if hs.lidar != nil {
+		hs.lidarChan = hs.lidar.Subscribe()
+	}
 
 	// Start Odometry sensor subcription
-	hs.encoderChan = odometry.OdometrySensor.Subscribe()
+	if hs.odometry != nil {
+		hs.encoderChan = hs.odometry.Subscribe()
+	}
 
 	// Start filter
 	hs.filter = MakeOdomSlamEKF(hs.robot)
@@ -138,24 +179,65 @@ func (hs *HectorSlam) run() {
 			hs.LidarReadingToDataContainer(lidarReading, dataContainer, hs.hsp.GetScaleToMap())
 
 			// Update SLAM
-			hs.hsp.Update(dataContainer, [3]float64{state[0], state[1], state[2]})
+			hint := [3]float64{state[0], state[1], state[2]}
+			closed := false
+			if hs.poseGraph != nil {
+				loopClosures := hs.poseGraph.GetLoopClosures()
+				hs.poseGraph.Update(dataContainer, hint)
+				closed = hs.poseGraph.GetLoopClosures() != loopClosures
+			} else {
+				hs.hsp.Update(dataContainer, hint)
+			}
 
 			// Obtain position from SLAM
 			matchedPos := hs.hsp.GetLastScanMatchPose()
 			// matchedPos := hs.hsp.GetMapRepresentation().MatchData([3]float64{state[0], state[1], state[2]}, dataContainer, hs.hsp.GetLastScanMatchCovariance())
 
-			// Update filter
-			hs.filter.SLAMUpdate(matchedPos[0], matchedPos[1], matchedPos[2], lidarReading)
+			// Update filter. A loop closure moves the pose rather than
+			// measuring a motion.
+			if closed {
+				hs.filter.SetPose(matchedPos[0], matchedPos[1], matchedPos[2])
+			} else {
+				hs.filter.SLAMUpdate(matchedPos[0], matchedPos[1], matchedPos[2], lidarReading)
+			}
+
+			// Record the filtered pose
+			states := hs.filter.States()
+			pose := hs.trajectory.Add(lidarReading.GetTimestamp(),
+				model.Position{X: states[0], Y: states[1], Theta: states[2]},
+				hs.filter.Covariance())
+			telemetry.Publish(telemetry.POSE, pose)
+
+			if hs.poseGraph != nil {
+				hs.anchors = append(hs.anchors, hs.poseGraph.MakeAnchor([3]float64{states[0], states[1], states[2]}))
+				if closed {
+					hs.correctTrajectory()
+				}
+			}
 
 		}
 	}
 
 }
 
+// Move the poses of the trajectory with the keyframes they are anchored to,
+// after loop closure has optimised the pose graph
+func (hs *HectorSlam) correctTrajectory() {
+	for i, a := range hs.anchors {
+		if pose, ok := hs.poseGraph.AnchoredPose(a); ok {
+			hs.trajectory.SetPosition(i, model.Position{X: pose[0], Y: pose[1], Theta: pose[2]})
+		}
+	}
+}
+
 func (hs *HectorSlam) Stop() {
 	// Stop LIDAR sensor subscription
-	lidar.LidarSensor.Unsubscribe(hs.lidarChan)
-	odometry.OdometrySensor.Unsubscribe(hs.encoderChan)
+	if hs.lidar != nil {
+		hs.lidar.Unsubscribe(hs.lidarChan)
+	}
+	if hs.odometry != nil {
+		hs.odometry.Unsubscribe(hs.encoderChan)
+	}
 
 	// Stop the running loop
 	hs.stopChan <- true
@@ -175,7 +257,11 @@ func (hs *HectorSlam) GetPosition() model.Position {
 }
 
 func (hs *HectorSlam) GetPositionHistory() []model.Position {
-	return make([]model.Position, 0)
+	return hs.trajectory.GetPositions()
+}
+
+func (hs *HectorSlam) GetTrajectory() *trajectory.Trajectory {
+	return hs.trajectory
 }
 
 func (hs *HectorSlam) GetTypeName() string {
@@ -198,7 +284,15 @@ func (hs *HectorSlam) GetMapImage() (image.Image, error) {
 }
 
 func (hs *HectorSlam) GetMapTile(zoomLevel uint, tileX, tileY int) (image.Image, error) {
-	return mapimages.GetMapTile(hs.hsp.GetMapRepresentation(), zoomLevel, tileX, tileY)
+	tile, err := hs.tiles.GetTile(zoomLevel, tileX, tileY)
+	if err != nil {
+		return nil, err
+	}
+	return tile.Image, nil
+}
+
+func (hs *HectorSlam) GetTileCache() *mapimages.TileCache {
+	return hs.tiles
 }
 
 func (hs *HectorSlam) GetOffsetX() float64 {
@@ -215,10 +309,10 @@ func (hs *HectorSlam) GetMapRepresentation() maprep.MapRepresentation {
 
 // Hector Mapping has it's own ideas of how the LIDAR data should be held. This
 // function transforms a LidarReading into Hector Mapping's DataContainer,
-// which means setting the data container's origo to (0, 0) and filling in
-// values converted from the LidarReading's polar coordinate system (distances
-// from the LIDAR with implicit angle) into a cartesian system, with (x, y)
-// distance components from the origo. The function also does basic filtering,
+// which means setting the data container's origo to the position of the LIDAR
+// and filling in values converted from the LidarReading's polar coordinate
+// system (distances from the LIDAR at the angles of its beams) into the
+// robot's cartesian system. The function also does basic filtering,
 // leaving out beams which should not be considered by the SLAM process.
 func (hs *HectorSlam) LidarReadingToDataContainer(lidarReading *lidar.LidarReading,
 	dataContainer *datacontainer.DataContainer, scaleToMap float64) {
@@ -229,14 +323,16 @@ func (hs *HectorSlam) LidarReadingToDataContainer(lidarReading *lidar.LidarReadi
 
 	N := len(lidarReading.Distances)
 
-	// Alpha is the angle of the first beam
-	alpha := -lidarReading.Span / 2 * math.Pi / 180.0
-	deltaAngle := lidarReading.Span / float64(N - 1) * math.Pi / 180
-	angle := alpha
+	// The LIDAR is at origin in the robot's frame, and angle is the angle of
+	// the first beam
+	origin := lidarReading.Origin()
+	angle := lidarReading.BeamAngle(0)
+	deltaAngle := lidarReading.AngleIncrement()
 
-	// Configure dataContainer
+	// Configure dataContainer, with the origo scaled to the map like the
+	// points
 	dataContainer.Clear()
-	dataContainer.SetOrigo([2]float64{config.LIDAR_POSITION_X, config.LIDAR_POSITION_Y})
+	dataContainer.SetOrigo([2]float64{origin[0] * scaleToMap, origin[1] * scaleToMap})
 
 	// Get the estimated wheel speeds of the robot
 	states := hs.filter.States()
@@ -252,18 +348,19 @@ func (hs *HectorSlam) LidarReadingToDataContainer(lidarReading *lidar.LidarReadi
 	// Loop through all measurements, insert them into dataContainer
 	for i := 0; i < N; i, angle = i + 1, angle + deltaAngle {
 
-		// If distance is < 0.1 meters, disregard
-		if lidarReading.Distances[i] < 100 {
+		// Disregard beams without an echo, or filtered out by the LIDAR's
+		// filters, which remove distances which are too short
+		if lidarReading.Distances[i] <= 0 {
 			continue
 		}
 
 		// Distance in map
 		dist := lidarReading.Distances[i] / 1000.0 * scaleToMap
 
-		// Calculate naive distances, i.e. not taking delay between time of
+		// Calculate naive points, i.e. not taking delay between time of
 		// measurement for the beam and time of scan into account.
-		x_p := dist * math.Cos(angle)
-		y_p := dist * math.Sin(angle)
+		x_p := origin[0] * scaleToMap + dist * math.Cos(angle)
+		y_p := origin[1] * scaleToMap + dist * math.Sin(angle)
 
 		// Calculate the (negative) time delay
 		d_i := - float64(N - i) / float64(N) * T_SPAN
@@ -289,25 +386,56 @@ func (hs *HectorSlam) LidarReadingToDataContainer(lidarReading *lidar.LidarReadi
 
 }
 
-// func LidarReadingToDataContainer(lidarReading *lidar.LidarReading,
-// 	dataContainer *datacontainer.DataContainer, scaleToMap float64) {
+// Transform a LidarReading into a DataContainer like the method with the same
+// name, but without correcting for the movement of the robot during the sweep.
+// Used where there's no filter, e.g. when processing logs offline.
+func LidarReadingToDataContainer(lidarReading *lidar.LidarReading,
+	dataContainer *datacontainer.DataContainer, scaleToMap float64) {
+
+	size := len(lidarReading.Distances)
+	origin := lidarReading.Origin()
+	angle := lidarReading.BeamAngle(0)
+	deltaAngle := lidarReading.AngleIncrement()
+
+	dataContainer.Clear()
+	dataContainer.SetOrigo([2]float64{origin[0] * scaleToMap, origin[1] * scaleToMap})
+
+	for i := 0; i < size; i++ {
+		dist := lidarReading.Distances[i] / 1000.0
+		if dist > 0 {
+			dist *= scaleToMap
+			meas := [2]float64{origin[0]*scaleToMap + math.Cos(angle)*dist, origin[1]*scaleToMap + math.Sin(angle)*dist}
+			dataContainer.Add(meas)
+		}
+
+		angle += deltaAngle
 
-// 	size := len(lidarReading.Distances)
-// 	angle := -lidarReading.Span / 2 * math.Pi / 180.0
-// 	deltaAngle := lidarReading.Span / float64(size-1) * math.Pi / 180.0
+	}
+}
 
-// 	dataContainer.Clear()
-// 	dataContainer.SetOrigo([2]float64{config.LIDAR_POSITION_X, config.LIDAR_POSITION_Y})
+// Get the covariance of the last scan match in meters and radians. The scan
+// matcher gives the Hessian of the match in map cells, the inverse of which
+// is the covariance. Zero if there is no match, or the Hessian is singular.
+func ScanMatchCovariance(hsp *hectormapping.HectorSlamProcessor) [3][3]float64 {
+	covariance := [3][3]float64{}
 
-// 	for i := 0; i < size; i++ {
-// 		dist := lidarReading.Distances[i] / 1000.0
-// 		if dist > 0.1 {
-// 			dist *= scaleToMap
-// 			meas := [2]float64{math.Cos(angle) * dist, math.Sin(angle) * dist}
-// 			dataContainer.Add(meas)
-// 		}
+	H := hsp.GetLastScanMatchCovariance()
+	if H == nil {
+		return covariance
+	}
+	inverse, err := H.Inverse()
+	if err != nil {
+		return covariance
+	}
 
-// 		angle += deltaAngle
+	// Scale x and y from cells to meters
+	cellLength := 1 / hsp.GetScaleToMap()
+	scale := [3]float64{cellLength, cellLength, 1}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			covariance[i][j] = inverse.Get(i, j) * scale[i] * scale[j]
+		}
+	}
 
-// 	}
-// }
+	return covariance
+}
@@ -0,0 +1,111 @@
+package hector
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/skelterjohn/go.matrix"
+
+	"hectormapping/datacontainer"
+	"hectormapping/map/maprep"
+	"hectormapping/posegraph"
+
+	"robot/model"
+	"robot/sensors/lidar"
+	"robot/slam/trajectory"
+)
+
+func TestLidarReadingToDataContainerMount(t *testing.T) {
+	mapRep := maprep.MakeMapRepMultiMap(0.05, 256, 256, 3, [2]float64{0.5, 0.5})
+
+	// A LIDAR 1 m ahead of and 0.2 m to the right of the robot's center,
+	// turned to the left, seeing something 1 m to its left
+	l := lidar.MakeLidar(lidar.NAME, nil, 240, 4000, 241)
+	reading := lidar.MakeLidarReading(l)
+	reading.Mount = lidar.Mount{X: 1, Y: -0.2, Yaw: 90}
+	reading.Distances[120] = 1000
+
+	dataContainer := datacontainer.MakeDataContainer(0)
+	LidarReadingToDataContainer(reading, dataContainer, mapRep.GetScaleToMap())
+
+	// The origo and the points are in the robot's frame, scaled to the map
+	if dataContainer.GetSize() != 1 {
+		t.Fatal("Expected one point, got", dataContainer.GetSize())
+	}
+	origo, point := dataContainer.GetOrigo(), dataContainer.GetVecEntry(0)
+	if math.Abs(origo[0]-20) > 1e-9 || math.Abs(origo[1]+4) > 1e-9 {
+		t.Error("Wrong origo:", origo)
+	}
+	if math.Abs(point[0]-20) > 1e-9 || math.Abs(point[1]-16) > 1e-9 {
+		t.Error("Wrong point:", point)
+	}
+
+	// With the robot at (1, 0.5) in the world, the LIDAR is at (2, 0.3) and
+	// the point at (2, 1.3). On every level of the map the point is occupied
+	// and the beam from the LIDAR to it is free.
+	mapRep.UpdateByScan(dataContainer, [3]float64{1, 0.5, 0})
+	for i := 0; i < mapRep.GetMapLevels(); i++ {
+		gridMap := mapRep.GetGridMap(i)
+
+		end := gridMap.GetMapCoords([2]float64{2, 1.3})
+		if !gridMap.IsOccupied(int(end[0]+0.5), int(end[1]+0.5)) {
+			t.Error("End of the beam not occupied on level", i)
+		}
+
+		beam := gridMap.GetMapCoords([2]float64{2, 0.5})
+		if !gridMap.IsFree(int(beam[0]+0.5), int(beam[1]+0.5)) {
+			t.Error("Beam not free on level", i)
+		}
+	}
+}
+
+func TestLidarReadingToDataContainerDistances(t *testing.T) {
+	l := lidar.MakeLidar(lidar.NAME, nil, 240, 4000, 241)
+	reading := lidar.MakeLidarReading(l)
+
+	// Beams without an echo are left out, short distances are left to the
+	// range filter of the LIDAR
+	reading.Distances[10] = 0
+	reading.Distances[20] = 50
+	reading.Distances[30] = 1000
+
+	dataContainer := datacontainer.MakeDataContainer(0)
+	LidarReadingToDataContainer(reading, dataContainer, 20)
+	if dataContainer.GetSize() != 2 {
+		t.Error("Expected two points, got", dataContainer.GetSize())
+	}
+}
+
+func TestCorrectTrajectory(t *testing.T) {
+	hsp := MakeDefaultHectorSlamProcessor()
+	hs := &HectorSlam{
+		hsp:        hsp,
+		poseGraph:  posegraph.MakeProcessor(hsp),
+		trajectory: trajectory.MakeTrajectory(),
+	}
+	graph := hs.poseGraph.GetGraph()
+	scan := datacontainer.MakeDataContainer(0)
+
+	// A pose recorded after each of two keyframes
+	graph.AddKeyframe([3]float64{0, 0, 0}, matrix.Eye(3), scan)
+	hs.anchors = append(hs.anchors, hs.poseGraph.MakeAnchor([3]float64{0.5, 0, 0}))
+	hs.trajectory.Add(time.Time{}, model.Position{X: 0.5}, [3][3]float64{})
+	graph.AddKeyframe([3]float64{1, 0, math.Pi / 2}, matrix.Eye(3), scan)
+	hs.anchors = append(hs.anchors, hs.poseGraph.MakeAnchor([3]float64{1, 0.5, math.Pi / 2}))
+	hs.trajectory.Add(time.Time{}, model.Position{X: 1, Y: 0.5, Theta: math.Pi / 2}, [3][3]float64{})
+
+	// Loop closure moved the second keyframe
+	graph.Keyframes[1].Pose = [3]float64{1, 0.2, math.Pi/2 + 0.1}
+	hs.correctTrajectory()
+
+	want := []model.Position{
+		{X: 0.5, Y: 0, Theta: 0},
+		{X: 1 - 0.5*math.Sin(0.1), Y: 0.2 + 0.5*math.Cos(0.1), Theta: math.Pi/2 + 0.1},
+	}
+	for i, got := range hs.trajectory.GetPositions() {
+		if math.Abs(got.X-want[i].X) > 1e-9 || math.Abs(got.Y-want[i].Y) > 1e-9 || math.Abs(got.Theta-want[i].Theta) > 1e-9 {
+			t.Errorf("Pose %d is %v, want %v", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package mcl
+
+import (
+	"math"
+
+	"hectormapping/map/gridmap"
+	"hectormapping/map/gridmap/logoddsmap"
+)
+
+// Probabilities in the likelihood field far from walls and right on them
+const (
+	FIELD_MIN = 0.1
+	FIELD_MAX = 0.9
+)
+
+// Make a map with the same dimensions as gridMap, where the probability falls
+// off from the occupied cells like a Gaussian with the given standard
+// deviation in meters. Matching against the thin walls of the map directly
+// only gives a high likelihood very close to the right pose, so the filter
+// would need far more particles to find it.
+func makeLikelihoodField(gridMap gridmap.OccGridMap, stdDev float64) gridmap.OccGridMap {
+	dims := gridMap.GetMapDimProperties()
+	field := logoddsmap.MakeOccGridMapLogOdds(dims.GetCellLength(), gridMap.GetMapDimensions(), dims.GetTopLeftOffset())
+
+	sizeX, sizeY := gridMap.GetSizeX(), gridMap.GetSizeY()
+	sigma := stdDev / dims.GetCellLength()
+	radius := int(math.Ceil(3 * sigma))
+
+	prob := make([]float64, sizeX*sizeY)
+	for i := range prob {
+		prob[i] = FIELD_MIN
+	}
+
+	for y := 0; y < sizeY; y++ {
+		for x := 0; x < sizeX; x++ {
+			if !gridMap.IsOccupied(x, y) {
+				continue
+			}
+
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if x+dx < 0 || y+dy < 0 || x+dx >= sizeX || y+dy >= sizeY {
+						continue
+					}
+
+					p := FIELD_MAX
+					if sigma > 0 {
+						d2 := float64(dx*dx + dy*dy)
+						p = FIELD_MIN + (FIELD_MAX-FIELD_MIN)*math.Exp(-d2/(2*sigma*sigma))
+					} else if dx != 0 || dy != 0 {
+						continue
+					}
+
+					index := (y+dy)*sizeX + x + dx
+					if p > prob[index] {
+						prob[index] = p
+					}
+				}
+			}
+		}
+	}
+
+	for i, p := range prob {
+		field.GetCellByIndex(i).Set(math.Log(p / (1 - p)))
+	}
+
+	return field
+}
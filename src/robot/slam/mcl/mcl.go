@@ -0,0 +1,642 @@
+// Package mcl implements localization in a stored map with Monte Carlo
+// localization, an adaptive particle filter.
+//
+// Unlike the SLAM algorithms, the map is never updated. Each particle is a
+// guess at the pose of the robot. Odometry readings move the particles, and
+// LIDAR readings weight them by how well the scan fits the map from their
+// pose, using the interpolated values of OccGridMapUtil on a likelihood field
+// made from the map. The number of particles adapts to how spread out they are (KLD
+// sampling), and random particles are added when the scans suddenly fit the
+// map worse, so the robot can be found again after being moved.
+package mcl
+
+import (
+	"image"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"hectormapping/datacontainer"
+	"hectormapping/map/cache"
+	"hectormapping/map/gridmap"
+	"hectormapping/map/gridmap/occbase"
+	"hectormapping/map/mapimages"
+	"hectormapping/map/maprep"
+
+	"robot/config"
+	"robot/logging"
+	"robot/model"
+	"robot/sensors/lidar"
+	"robot/sensors/odometry"
+	"robot/sensors/sensor"
+	"robot/slam/hector"
+	"robot/slam/trajectory"
+	"robot/telemetry"
+)
+
+const TYPE_NAME = "mcl"
+
+var logger *log.Logger
+
+func init() {
+	logger = logging.New()
+}
+
+// A Particle is a guess at the pose of the robot
+type Particle struct {
+	model.Position
+	Weight float64
+}
+
+// MCL implements the Slam interface, but only localizes the robot
+type MCL struct {
+	mapRep    maprep.MapRepresentation
+	tiles     *mapimages.TileCache
+	gridMap   gridmap.OccGridMap
+	field     gridmap.OccGridMap
+	util      *occbase.OccGridMapUtil
+	freeCells []int
+
+	robot       *model.DifferentialWheeledRobot
+	lidar       sensor.Sensor
+	odometry    sensor.Sensor
+	lidarChan   chan sensor.SensorReading
+	encoderChan chan sensor.SensorReading
+	stopChan    chan bool
+
+	dataContainer *datacontainer.DataContainer
+	beams         *datacontainer.DataContainer
+
+	particles  []Particle
+	pose       model.Position
+	covariance [3][3]float64
+	confidence float64
+	trajectory *trajectory.Trajectory
+
+	// Short and long term averages of the scan likelihood
+	wSlow, wFast float64
+
+	rand *rand.Rand
+	lock *sync.RWMutex
+
+	// Limits for the number of particles
+	MinParticles, MaxParticles int
+
+	// Number of LIDAR beams used to weight the particles
+	Beams int
+
+	// Standard deviation of the motion, per meter driven and per radian
+	// turned
+	AlphaTranslation, AlphaRotation float64
+
+	// Standard deviation of the noise added to the particles for each scan,
+	// when there is no odometry
+	DiffusionXY, DiffusionTheta float64
+
+	// How much a worse fit of the scan lowers the weight of a particle. The
+	// weight is exp((likelihood - 1) / LikelihoodSigma).
+	LikelihoodSigma float64
+
+	// KLD sampling: The number of particles is chosen so that the error of
+	// the distribution is below KLDError with the quantile KLDZ, counting
+	// particles in bins of the given size.
+	KLDError, KLDZ          float64
+	BinSizeXY, BinSizeTheta float64
+
+	// Decay rates of the slow and fast likelihood averages
+	AlphaSlow, AlphaFast float64
+
+	// Particles within this distance in meters of the estimated pose count
+	// towards the confidence
+	ConvergenceRadius float64
+}
+
+// Make MCL in the given map, set up from the config file. The particles start
+// around the origin of the map. Odometry may be nil.
+func MakeMCL(mapRep maprep.MapRepresentation, lidar, odometry sensor.Sensor) *MCL {
+	level := config.MCL_MAP_LEVEL
+	if level >= mapRep.GetMapLevels() {
+		level = mapRep.GetMapLevels() - 1
+	}
+	if level < 0 {
+		level = 0
+	}
+
+	m := makeMCL(mapRep.GetGridMap(level))
+	m.mapRep = mapRep
+	m.tiles = mapimages.MakeTileCache(mapRep)
+	m.lidar = lidar
+	m.odometry = odometry
+
+	m.SetInitialPose(model.Position{}, config.MCL_INITIAL_STD_DEV_XY, config.MCL_INITIAL_STD_DEV_THETA)
+
+	return m
+}
+
+// Make MCL localizing in a grid map, without any particles
+func makeMCL(gridMap gridmap.OccGridMap) *MCL {
+	m := &MCL{
+		gridMap:           gridMap,
+		robot:             model.MakeDefaultDifferentialWheeledRobot(),
+		stopChan:          make(chan bool),
+		dataContainer:     datacontainer.MakeDataContainer(config.LIDAR_NUM_DISTANCES),
+		beams:             datacontainer.MakeDataContainer(0),
+		particles:         make([]Particle, 0),
+		rand:              rand.New(rand.NewSource(time.Now().UnixNano())),
+		lock:              new(sync.RWMutex),
+		trajectory:        trajectory.MakeTrajectory(),
+		MinParticles:      config.MCL_MIN_PARTICLES,
+		MaxParticles:      config.MCL_MAX_PARTICLES,
+		Beams:             config.MCL_BEAMS,
+		AlphaTranslation:  0.2,
+		AlphaRotation:     0.2,
+		DiffusionXY:       0.05,
+		DiffusionTheta:    0.05,
+		LikelihoodSigma:   0.05,
+		KLDError:          0.05,
+		KLDZ:              2.33,
+		BinSizeXY:         0.25,
+		BinSizeTheta:      10 * math.Pi / 180,
+		AlphaSlow:         0.001,
+		AlphaFast:         0.1,
+		ConvergenceRadius: 0.5,
+	}
+
+	m.field, m.util, m.freeCells = m.deriveFromMap()
+
+	return m
+}
+
+// Make the likelihood field of the map, and find the free cells, which are
+// where particles are placed for global localization
+func (m *MCL) deriveFromMap() (gridmap.OccGridMap, *occbase.OccGridMapUtil, []int) {
+	field := makeLikelihoodField(m.gridMap, config.MCL_FIELD_STD_DEV)
+
+	freeCells := make([]int, 0)
+	for y := 0; y < m.gridMap.GetSizeY(); y++ {
+		for x := 0; x < m.gridMap.GetSizeX(); x++ {
+			if m.gridMap.IsFree(x, y) {
+				freeCells = append(freeCells, y*m.gridMap.GetSizeX()+x)
+			}
+		}
+	}
+
+	return field, occbase.MakeOccGridMapUtil(field, cache.MakeGridMapCacheArray()), freeCells
+}
+
+// Update the likelihood field and free cells after the map has been edited,
+// e.g. painted
+func (m *MCL) OnMapEdited() {
+	field, util, freeCells := m.deriveFromMap()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.field, m.util, m.freeCells = field, util, freeCells
+}
+
+// Place the particles around a pose, with the given standard deviations
+func (m *MCL) SetInitialPose(pose model.Position, stdDevXY, stdDevTheta float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.particles = make([]Particle, m.MaxParticles)
+	for i := range m.particles {
+		m.particles[i] = Particle{
+			Position: model.Position{
+				X:     pose.X + m.rand.NormFloat64()*stdDevXY,
+				Y:     pose.Y + m.rand.NormFloat64()*stdDevXY,
+				Theta: normalizeAngle(pose.Theta + m.rand.NormFloat64()*stdDevTheta),
+			},
+			Weight: 1 / float64(m.MaxParticles),
+		}
+	}
+
+	m.wSlow, m.wFast = 0, 0
+	m.updateEstimate()
+}
+
+// Spread the particles over all free space in the map, for when the pose of
+// the robot is unknown
+func (m *MCL) GlobalLocalization() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.particles = make([]Particle, m.MaxParticles)
+	for i := range m.particles {
+		m.particles[i] = Particle{
+			Position: m.randomPose(),
+			Weight:   1 / float64(m.MaxParticles),
+		}
+	}
+
+	m.wSlow, m.wFast = 0, 0
+	m.updateEstimate()
+}
+
+// A random pose in free space
+func (m *MCL) randomPose() model.Position {
+	if len(m.freeCells) == 0 {
+		return model.Position{Theta: (m.rand.Float64()*2 - 1) * math.Pi}
+	}
+
+	index := m.freeCells[m.rand.Intn(len(m.freeCells))]
+	sizeX := m.gridMap.GetSizeX()
+	world := m.gridMap.GetWorldCoords([2]float64{
+		float64(index%sizeX) + m.rand.Float64() - 0.5,
+		float64(index/sizeX) + m.rand.Float64() - 0.5,
+	})
+
+	return model.Position{X: world[0], Y: world[1], Theta: (m.rand.Float64()*2 - 1) * math.Pi}
+}
+
+func (m *MCL) Start() {
+	if m.lidar != nil {
+		m.lidarChan = m.lidar.Subscribe()
+	}
+	if m.odometry != nil {
+		m.encoderChan = m.odometry.Subscribe()
+	}
+
+	go m.run()
+}
+
+func (m *MCL) run() {
+	logger.Println("MCL now running")
+
+	for {
+		select {
+		case <-m.stopChan:
+			return
+
+		case sensorReading := <-m.encoderChan:
+			odometryReading, ok := sensorReading.(*odometry.OdometryReading)
+			if !ok {
+				logger.Println("Received invalid reading from encoder")
+				continue
+			}
+			m.motionUpdate(odometryReading.LeftPulses, odometryReading.RightPulses)
+
+		case sensorReading := <-m.lidarChan:
+			lidarReading, ok := sensorReading.(*lidar.LidarReading)
+			if !ok {
+				logger.Println("Received invalid reading from LIDAR")
+				continue
+			}
+
+			hector.LidarReadingToDataContainer(lidarReading, m.dataContainer, m.gridMap.GetScaleToMap())
+			m.measurementUpdate(m.dataContainer)
+
+			m.lock.RLock()
+			pose := m.trajectory.Add(lidarReading.GetTimestamp(), m.pose, m.covariance)
+			m.lock.RUnlock()
+			telemetry.Publish(telemetry.POSE, pose)
+		}
+	}
+}
+
+func (m *MCL) Stop() {
+	if m.lidar != nil {
+		m.lidar.Unsubscribe(m.lidarChan)
+	}
+	if m.odometry != nil {
+		m.odometry.Unsubscribe(m.encoderChan)
+	}
+
+	m.stopChan <- true
+
+	logger.Println("MCL stopped.")
+}
+
+// Move the particles by the distance the wheels have rolled, with noise
+// growing with the distance
+func (m *MCL) motionUpdate(leftPulses, rightPulses int) {
+	if leftPulses == 0 && rightPulses == 0 {
+		return
+	}
+
+	delta := m.robot.OdometryPosition(leftPulses, rightPulses, model.Position{})
+	translation := math.Hypot(delta.X, delta.Y)
+	rotation := math.Abs(delta.Theta)
+
+	stdDevXY := m.AlphaTranslation*translation + m.AlphaRotation*rotation/10
+	stdDevTheta := m.AlphaRotation*rotation + m.AlphaTranslation*translation/10
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.particles {
+		m.particles[i].Position = m.move(m.particles[i].Position, delta, stdDevXY, stdDevTheta)
+	}
+	m.updateEstimate()
+}
+
+// Move a pose by delta, given in the coordinate system of the pose, with
+// noise of the given standard deviations
+func (m *MCL) move(pose, delta model.Position, stdDevXY, stdDevTheta float64) model.Position {
+	dx := delta.X + m.rand.NormFloat64()*stdDevXY
+	dy := delta.Y + m.rand.NormFloat64()*stdDevXY
+	sin, cos := math.Sincos(pose.Theta)
+
+	return model.Position{
+		X:     pose.X + cos*dx - sin*dy,
+		Y:     pose.Y + sin*dx + cos*dy,
+		Theta: normalizeAngle(pose.Theta + delta.Theta + m.rand.NormFloat64()*stdDevTheta),
+	}
+}
+
+// Weight the particles by how well the scan fits the map from their pose, and
+// resample when the weights have become too uneven. The scan must be scaled to
+// the map MCL localizes in.
+func (m *MCL) measurementUpdate(scan *datacontainer.DataContainer) {
+	m.selectBeams(scan)
+	if m.beams.GetSize() == 0 {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.odometry == nil {
+		for i := range m.particles {
+			m.particles[i].Position = m.move(m.particles[i].Position, model.Position{}, m.DiffusionXY, m.DiffusionTheta)
+		}
+	}
+
+	total, average := 0.0, 0.0
+	for i := range m.particles {
+		w := math.Exp((m.likelihood(m.particles[i].Position) - 1) / m.LikelihoodSigma)
+		average += m.particles[i].Weight * w
+		m.particles[i].Weight *= w
+		total += m.particles[i].Weight
+	}
+
+	if total == 0 || math.IsNaN(total) {
+		for i := range m.particles {
+			m.particles[i].Weight = 1 / float64(len(m.particles))
+		}
+	} else {
+		for i := range m.particles {
+			m.particles[i].Weight /= total
+		}
+	}
+
+	// The prior weights sum to one, so average is the mean scan likelihood
+	if m.wSlow == 0 {
+		m.wSlow, m.wFast = average, average
+	} else {
+		m.wSlow += m.AlphaSlow * (average - m.wSlow)
+		m.wFast += m.AlphaFast * (average - m.wFast)
+	}
+
+	if m.effectiveParticles() < float64(len(m.particles))/2 || m.randomProbability() > 0 {
+		m.resample()
+	}
+
+	m.updateEstimate()
+}
+
+// Pick evenly spaced beams from the scan
+func (m *MCL) selectBeams(scan *datacontainer.DataContainer) {
+	m.beams.Clear()
+	m.beams.SetOrigo(scan.GetOrigo())
+
+	size := scan.GetSize()
+	if size == 0 {
+		return
+	}
+
+	step := 1.0
+	if m.Beams > 0 && size > m.Beams {
+		step = float64(size) / float64(m.Beams)
+	}
+	for i := 0.0; int(i) < size; i += step {
+		m.beams.Add(scan.GetVecEntry(int(i)))
+	}
+}
+
+// The mean of the likelihood field at the scan endpoints, from FIELD_MIN to
+// FIELD_MAX, when the scan is taken from the given pose
+func (m *MCL) likelihood(pose model.Position) float64 {
+	mapPose := m.gridMap.GetMapCoordsPose([3]float64{pose.X, pose.Y, pose.Theta})
+	sin, cos := math.Sincos(mapPose[2])
+
+	sum := 0.0
+	for i := 0; i < m.beams.GetSize(); i++ {
+		point := m.beams.GetVecEntry(i)
+		sum += m.util.InterpMapValue([2]float64{
+			mapPose[0] + cos*point[0] - sin*point[1],
+			mapPose[1] + sin*point[0] + cos*point[1],
+		})
+	}
+
+	return sum / float64(m.beams.GetSize())
+}
+
+func (m *MCL) effectiveParticles() float64 {
+	sum := 0.0
+	for _, p := range m.particles {
+		sum += p.Weight * p.Weight
+	}
+	return 1 / sum
+}
+
+// Draw a new set of particles from the current one. The number of particles
+// is chosen by KLD sampling. If the scans have recently started to fit the map
+// worse than usual, some particles are placed randomly in free space instead.
+func (m *MCL) resample() {
+	cumulative := make([]float64, len(m.particles))
+	sum := 0.0
+	for i, p := range m.particles {
+		sum += p.Weight
+		cumulative[i] = sum
+	}
+
+	randomProbability := m.randomProbability()
+
+	particles := make([]Particle, 0, len(m.particles))
+	bins := make(map[[3]int]bool)
+
+	for len(particles) < m.MaxParticles {
+		var pose model.Position
+		if m.rand.Float64() < randomProbability {
+			pose = m.randomPose()
+		} else {
+			i := sort.SearchFloat64s(cumulative, m.rand.Float64()*sum)
+			if i >= len(m.particles) {
+				i = len(m.particles) - 1
+			}
+			pose = m.particles[i].Position
+		}
+		particles = append(particles, Particle{Position: pose})
+
+		bins[[3]int{
+			int(math.Floor(pose.X / m.BinSizeXY)),
+			int(math.Floor(pose.Y / m.BinSizeXY)),
+			int(math.Floor(pose.Theta / m.BinSizeTheta)),
+		}] = true
+
+		if len(particles) >= m.MinParticles && len(particles) >= m.kldLimit(len(bins)) {
+			break
+		}
+	}
+
+	for i := range particles {
+		particles[i].Weight = 1 / float64(len(particles))
+	}
+	m.particles = particles
+}
+
+// The probability of placing a particle randomly when resampling, which grows
+// when the short term likelihood average falls below the long term one
+func (m *MCL) randomProbability() float64 {
+	if m.wSlow == 0 {
+		return 0
+	}
+	return math.Max(0, 1-m.wFast/m.wSlow)
+}
+
+// The number of particles needed when they are spread over k bins
+func (m *MCL) kldLimit(k int) int {
+	if k <= 1 {
+		return 1
+	}
+
+	a := 2 / (9 * float64(k-1))
+	b := 1 - a + math.Sqrt(a)*m.KLDZ
+	return int(math.Ceil(float64(k-1) / (2 * m.KLDError) * b * b * b))
+}
+
+// Compute the estimated pose as the weighted mean of the particles, with the
+// weighted covariance of the particles, and the confidence as the weight of
+// the particles close to it. Must be called with the lock held.
+func (m *MCL) updateEstimate() {
+	var x, y, sin, cos, total float64
+	for _, p := range m.particles {
+		x += p.Weight * p.X
+		y += p.Weight * p.Y
+		sin += p.Weight * math.Sin(p.Theta)
+		cos += p.Weight * math.Cos(p.Theta)
+		total += p.Weight
+	}
+	if total == 0 {
+		return
+	}
+
+	m.pose = model.Position{X: x / total, Y: y / total, Theta: math.Atan2(sin, cos)}
+
+	m.covariance = [3][3]float64{}
+	close := 0.0
+	for _, p := range m.particles {
+		d := [3]float64{p.X - m.pose.X, p.Y - m.pose.Y, math.Remainder(p.Theta-m.pose.Theta, 2*math.Pi)}
+		for i := range d {
+			for j := range d {
+				m.covariance[i][j] += p.Weight * d[i] * d[j] / total
+			}
+		}
+
+		if math.Hypot(d[0], d[1]) <= m.ConvergenceRadius {
+			close += p.Weight
+		}
+	}
+	m.confidence = close / total
+}
+
+func (m *MCL) GetPosition() model.Position {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.pose
+}
+
+func (m *MCL) GetPositionHistory() []model.Position {
+	return m.trajectory.GetPositions()
+}
+
+func (m *MCL) GetTrajectory() *trajectory.Trajectory {
+	return m.trajectory
+}
+
+// Get how much of the particle weight is close to the estimated pose, from 0
+// to 1
+func (m *MCL) GetConfidence() float64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.confidence
+}
+
+func (m *MCL) GetNumParticles() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.particles)
+}
+
+// Get the poses of at most max particles, evenly picked from all of them
+func (m *MCL) GetParticles(max int) []model.Position {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	step := 1.0
+	if max > 0 && len(m.particles) > max {
+		step = float64(len(m.particles)) / float64(max)
+	}
+
+	poses := make([]model.Position, 0)
+	for i := 0.0; int(i) < len(m.particles); i += step {
+		poses = append(poses, m.particles[int(i)].Position)
+	}
+	return poses
+}
+
+func (m *MCL) GetTypeName() string {
+	return TYPE_NAME
+}
+
+// Warning: Assumes the map is quadratic
+func (m *MCL) GetMapSizeMeters() float64 {
+	mapDims := m.mapRep.GetGridMap(0).GetMapDimProperties()
+	return mapDims.GetCellLength() * float64(mapDims.GetSizeX())
+}
+
+// Warning: Assumes the map is quadratic
+func (m *MCL) GetMapSize() int {
+	return m.mapRep.GetGridMap(0).GetMapDimProperties().GetSizeX()
+}
+
+func (m *MCL) GetMapImage() (image.Image, error) {
+	return mapimages.GetMapImage(m.mapRep)
+}
+
+func (m *MCL) GetMapTile(zoomLevel uint, tileX, tileY int) (image.Image, error) {
+	tile, err := m.tiles.GetTile(zoomLevel, tileX, tileY)
+	if err != nil {
+		return nil, err
+	}
+	return tile.Image, nil
+}
+
+func (m *MCL) GetTileCache() *mapimages.TileCache {
+	return m.tiles
+}
+
+func (m *MCL) GetOffsetX() float64 {
+	return m.mapRep.GetGridMap(0).GetMapDimProperties().GetTopLeftOffset()[0]
+}
+
+func (m *MCL) GetOffsetY() float64 {
+	return m.mapRep.GetGridMap(0).GetMapDimProperties().GetTopLeftOffset()[1]
+}
+
+func (m *MCL) GetMapRepresentation() maprep.MapRepresentation {
+	return m.mapRep
+}
+
+// Normalize an angle to [-pi, pi]
+func normalizeAngle(angle float64) float64 {
+	return math.Atan2(math.Sin(angle), math.Cos(angle))
+}
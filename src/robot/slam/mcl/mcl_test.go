@@ -0,0 +1,166 @@
+package mcl
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"hectormapping/datacontainer"
+
+	"robot/model"
+	"robot/sensors/lidar"
+	"robot/simulator"
+	"robot/slam/hector"
+)
+
+// A 4x3 m room with a box in one corner, so there is only one pose which
+// fits a scan
+func makeTestWorld(pose model.Position) *simulator.World {
+	img := image.NewGray(image.Rect(0, 0, 80, 60))
+	for j := 0; j < 60; j++ {
+		for i := 0; i < 80; i++ {
+			c := color.Gray{255}
+			if i == 0 || j == 0 || i == 79 || j == 59 || (i > 55 && j < 15) {
+				c = color.Gray{0}
+			}
+			img.SetGray(i, j, c)
+		}
+	}
+
+	w := simulator.MakeWorld(simulator.MakeTruthMapFromImage(img, 0.05),
+		model.MakeDefaultDifferentialWheeledRobot(), pose)
+	w.LidarNoise = 0
+	w.LidarMount = lidar.Mount{}
+	w.LidarSpan = 360
+	w.LidarBeams = 360
+
+	return w
+}
+
+func makeTestMCL(w *simulator.World) *MCL {
+	m := makeMCL(w.GetTruthMap())
+	m.rand = rand.New(rand.NewSource(1))
+	m.MinParticles = 100
+	m.MaxParticles = 5000
+	m.Beams = 60
+	return m
+}
+
+func scan(w *simulator.World, m *MCL) *datacontainer.DataContainer {
+	reading := lidar.MakeLidarReading(lidar.MakeDefaultLidar())
+	reading.Distances = w.Scan()
+	reading.Span = w.LidarSpan
+
+	dc := datacontainer.MakeDataContainer(0)
+	hector.LidarReadingToDataContainer(reading, dc, m.gridMap.GetScaleToMap())
+	return dc
+}
+
+func closeTo(a, b model.Position, dist, angle float64) bool {
+	return math.Hypot(a.X-b.X, a.Y-b.Y) <= dist && math.Abs(normalizeAngle(a.Theta-b.Theta)) <= angle
+}
+
+func TestInitialPose(t *testing.T) {
+	truth := model.Position{X: -0.5, Y: 0.3, Theta: 0.4}
+	w := makeTestWorld(truth)
+	m := makeTestMCL(w)
+
+	m.SetInitialPose(model.Position{X: -0.3, Y: 0.2, Theta: 0.3}, 0.3, 0.2)
+
+	dc := scan(w, m)
+	for i := 0; i < 10; i++ {
+		m.measurementUpdate(dc)
+	}
+
+	if pose := m.GetPosition(); !closeTo(pose, truth, 0.1, 0.1) {
+		t.Errorf("Estimated pose is %v, want %v", pose, truth)
+	}
+	if m.GetNumParticles() >= 5000 {
+		t.Errorf("Number of particles didn't adapt, %d particles", m.GetNumParticles())
+	}
+}
+
+func TestGlobalLocalization(t *testing.T) {
+	truth := model.Position{X: 0.8, Y: -0.4, Theta: 2}
+	w := makeTestWorld(truth)
+	m := makeTestMCL(w)
+
+	m.GlobalLocalization()
+	if m.GetConfidence() > 0.5 {
+		t.Errorf("Confidence is %f right after spreading the particles", m.GetConfidence())
+	}
+
+	dc := scan(w, m)
+	for i := 0; i < 20; i++ {
+		m.measurementUpdate(dc)
+	}
+
+	if pose := m.GetPosition(); !closeTo(pose, truth, 0.15, 0.15) {
+		t.Errorf("Estimated pose is %v, want %v", pose, truth)
+	}
+	if m.GetConfidence() < 0.9 {
+		t.Errorf("Confidence is %f after localizing", m.GetConfidence())
+	}
+}
+
+func TestMotionUpdate(t *testing.T) {
+	w := makeTestWorld(model.Position{})
+	m := makeTestMCL(w)
+	m.SetInitialPose(model.Position{}, 0, 0)
+
+	// Drive 0.5 m straight ahead
+	pulses := int(0.5 / (2 * math.Pi * m.robot.WheelRadius) * float64(m.robot.OdometryPPR))
+	m.motionUpdate(pulses, pulses)
+
+	if pose := m.GetPosition(); !closeTo(pose, model.Position{X: 0.5}, 0.05, 0.05) {
+		t.Errorf("Pose after driving is %v, want X: 0.5", pose)
+	}
+}
+
+func TestKidnappedRobot(t *testing.T) {
+	start := model.Position{X: -0.5, Y: 0.3, Theta: 0.4}
+	w := makeTestWorld(start)
+	m := makeTestMCL(w)
+
+	m.SetInitialPose(start, 0.1, 0.1)
+	dc := scan(w, m)
+	for i := 0; i < 10; i++ {
+		m.measurementUpdate(dc)
+	}
+
+	// Move the robot without telling the filter
+	truth := model.Position{X: 1, Y: -0.8, Theta: -2}
+	w.SetPose(truth)
+	dc = scan(w, m)
+	for i := 0; i < 100; i++ {
+		m.measurementUpdate(dc)
+	}
+
+	if pose := m.GetPosition(); !closeTo(pose, truth, 0.15, 0.15) {
+		t.Errorf("Estimated pose is %v, want %v", pose, truth)
+	}
+}
+
+func TestMapEdited(t *testing.T) {
+	w := makeTestWorld(model.Position{})
+	m := makeTestMCL(w)
+	freeCells := len(m.freeCells)
+
+	// Paint a wall in the middle of the room
+	cell := m.gridMap.GetMapCoords([2]float64{0, 0})
+	x, y := int(cell[0]+0.5), int(cell[1]+0.5)
+	if m.field.GetCell(x, y).IsOccupied() {
+		t.Fatal("Likelihood field is high in the middle of the room")
+	}
+	m.gridMap.GetCell(x, y).Set(2)
+
+	m.OnMapEdited()
+	if !m.field.GetCell(x, y).IsOccupied() {
+		t.Error("Likelihood field isn't high at the painted wall")
+	}
+	if len(m.freeCells) != freeCells-1 {
+		t.Errorf("Got %d free cells, want %d", len(m.freeCells), freeCells-1)
+	}
+}
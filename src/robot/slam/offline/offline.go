@@ -0,0 +1,266 @@
+// Package offline runs HectorSLAM on a recorded sensor log. The readings are
+// processed in timestamp order as fast as possible, instead of being replayed
+// in real time, so a map can be made from a long log in a fraction of the
+// time it took to record it.
+//
+// Since the odometry filter in the hector package depends on the wall clock,
+// the processor uses the slam processor directly, with the last scan match
+// pose (optionally moved by the odometry since) as the pose hint.
+package offline
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"hectormapping"
+	"hectormapping/datacontainer"
+	"hectormapping/map/maprep"
+	"hectormapping/posegraph"
+
+	"robot/config"
+	"robot/mapstorage"
+	"robot/model"
+	"robot/sensors/lidar"
+	"robot/sensors/logreader"
+	"robot/sensors/odometry"
+	"robot/sensors/sensor"
+	"robot/slam/hector"
+	"robot/slam/trajectory"
+)
+
+// Statistics from processing a log
+type Stats struct {
+	LidarReadings    int
+	OdometryReadings int
+	SkippedRecords   int
+
+	// Time between the first and the last reading in the log
+	LogDuration time.Duration
+
+	// Time spent processing, in total and for the slowest scan
+	ProcessingTime time.Duration
+	MaxScanTime    time.Duration
+}
+
+// Mean time spent per LIDAR reading
+func (s Stats) MeanScanTime() time.Duration {
+	if s.LidarReadings == 0 {
+		return 0
+	}
+	return s.ProcessingTime / time.Duration(s.LidarReadings)
+}
+
+// How many times faster than real time the log was processed
+func (s Stats) Speedup() float64 {
+	if s.ProcessingTime == 0 {
+		return 0
+	}
+	return s.LogDuration.Seconds() / s.ProcessingTime.Seconds()
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d LIDAR readings, %d odometry readings, %d skipped records\n"+
+		"Log duration %s, processed in %s (%.1fx real time)\n"+
+		"Scan processing time mean %s, max %s",
+		s.LidarReadings, s.OdometryReadings, s.SkippedRecords,
+		s.LogDuration, s.ProcessingTime, s.Speedup(),
+		s.MeanScanTime(), s.MaxScanTime)
+}
+
+// A Processor builds a map from sensor readings, and keeps the trajectory and
+// statistics.
+type Processor struct {
+	hsp           *hectormapping.HectorSlamProcessor
+	poseGraph     *posegraph.Processor
+	robot         *model.DifferentialWheeledRobot
+	dataContainer *datacontainer.DataContainer
+	pose          model.Position
+
+	// Move the pose hint by the odometry readings between LIDAR readings
+	UseOdometry bool
+
+	// The pose of the robot at each LIDAR reading
+	Trajectory []trajectory.Pose
+	Stats      Stats
+
+	// The keyframe each pose of the trajectory was recorded relative to,
+	// when loop closure is enabled
+	anchors []posegraph.Anchor
+}
+
+// Make a processor which updates the given slam processor
+func MakeProcessor(hsp *hectormapping.HectorSlamProcessor, robot *model.DifferentialWheeledRobot) *Processor {
+	return &Processor{
+		hsp:           hsp,
+		robot:         robot,
+		dataContainer: datacontainer.MakeDataContainer(config.LIDAR_NUM_DISTANCES),
+		Trajectory:    make([]trajectory.Pose, 0),
+	}
+}
+
+// Make a processor with an empty map, set up from the config file
+func MakeDefaultProcessor() *Processor {
+	p := MakeProcessor(hector.MakeDefaultHectorSlamProcessor(), model.MakeDefaultDifferentialWheeledRobot())
+	p.UseOdometry = config.HECTORSLAM_USE_ODOMETRY
+	if config.HECTORSLAM_LOOP_CLOSURE {
+		p.EnableLoopClosure()
+	}
+	return p
+}
+
+// Keep a pose graph of the scans, and correct the map and the trajectory
+// recorded so far when a loop closure is found
+func (p *Processor) EnableLoopClosure() {
+	if p.poseGraph == nil {
+		p.poseGraph = posegraph.MakeProcessor(p.hsp)
+	}
+}
+
+// Get the pose graph, or nil if loop closure is not enabled
+func (p *Processor) GetPoseGraph() *posegraph.Processor {
+	return p.poseGraph
+}
+
+// Read all readings from the log and process them in timestamp order. Records
+// which can't be read are skipped.
+func (p *Processor) ProcessLog(slr *logreader.SensorLogReader) error {
+	readings := make([]sensor.SensorReading, 0)
+
+	for {
+		record, err := slr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				p.Stats.SkippedRecords++
+				continue
+			}
+			return err
+		}
+
+		reading, err := slr.GetReading(record)
+		if err != nil {
+			p.Stats.SkippedRecords++
+			continue
+		}
+
+		readings = append(readings, reading)
+	}
+
+	p.ProcessReadings(readings)
+
+	return nil
+}
+
+// Sort readings by timestamp and process them
+func (p *Processor) ProcessReadings(readings []sensor.SensorReading) {
+	sort.Stable(byTimestamp(readings))
+
+	if len(readings) > 0 {
+		p.Stats.LogDuration += readings[len(readings)-1].GetTimestamp().Sub(readings[0].GetTimestamp())
+	}
+
+	for _, reading := range readings {
+		p.ProcessReading(reading)
+	}
+}
+
+// Process a single reading. LIDAR readings update the map and the trajectory,
+// odometry readings move the pose hint if UseOdometry is set.
+func (p *Processor) ProcessReading(reading sensor.SensorReading) {
+	switch r := reading.(type) {
+	case *lidar.LidarReading:
+		start := time.Now()
+
+		hector.LidarReadingToDataContainer(r, p.dataContainer, p.hsp.GetScaleToMap())
+		hint := [3]float64{p.pose.X, p.pose.Y, p.pose.Theta}
+		loopClosures := 0
+		if p.poseGraph != nil {
+			loopClosures = p.poseGraph.GetLoopClosures()
+			p.poseGraph.Update(p.dataContainer, hint)
+		} else {
+			p.hsp.Update(p.dataContainer, hint)
+		}
+
+		pose := p.hsp.GetLastScanMatchPose()
+		p.pose = model.Position{X: pose[0], Y: pose[1], Theta: pose[2]}
+		p.Trajectory = append(p.Trajectory, trajectory.Pose{
+			Timestamp:  r.GetTimestamp(),
+			Position:   p.pose,
+			Covariance: hector.ScanMatchCovariance(p.hsp),
+		})
+
+		if p.poseGraph != nil {
+			p.anchors = append(p.anchors, p.poseGraph.MakeAnchor(pose))
+			if p.poseGraph.GetLoopClosures() != loopClosures {
+				p.correctTrajectory()
+			}
+		}
+
+		elapsed := time.Since(start)
+		p.Stats.ProcessingTime += elapsed
+		if elapsed > p.Stats.MaxScanTime {
+			p.Stats.MaxScanTime = elapsed
+		}
+		p.Stats.LidarReadings++
+
+	case *odometry.OdometryReading:
+		if p.UseOdometry {
+			p.pose = p.robot.OdometryPosition(r.LeftPulses, r.RightPulses, p.pose)
+		}
+		p.Stats.OdometryReadings++
+
+	default:
+		p.Stats.SkippedRecords++
+	}
+}
+
+// Move the poses of the trajectory with the keyframes they are anchored to,
+// after the pose graph has been optimised
+func (p *Processor) correctTrajectory() {
+	for i, a := range p.anchors {
+		if pose, ok := p.poseGraph.AnchoredPose(a); ok {
+			p.Trajectory[i].Position = model.Position{X: pose[0], Y: pose[1], Theta: pose[2]}
+		}
+	}
+}
+
+// Get the current pose estimate
+func (p *Processor) GetPosition() model.Position {
+	return p.pose
+}
+
+func (p *Processor) GetMapRepresentation() maprep.MapRepresentation {
+	return p.hsp.GetMapRepresentation()
+}
+
+// Save the map to the map storage
+func (p *Processor) SaveMap(filename, name, description string) error {
+	m := &mapstorage.Map{
+		Meta: &mapstorage.MapMetaData{
+			Name:        name,
+			Description: description,
+			MapType:     "logodds",
+		},
+		MapRep:     p.GetMapRepresentation(),
+		Trajectory: p.Trajectory,
+	}
+
+	return m.Save(filename)
+}
+
+// Write the trajectory as CSV, with the timestamp in the sensor log format
+func (p *Processor) WriteTrajectory(w io.Writer) error {
+	return trajectory.WriteCSV(w, p.Trajectory)
+}
+
+// Sorts readings by timestamp
+type byTimestamp []sensor.SensorReading
+
+func (b byTimestamp) Len() int           { return len(b) }
+func (b byTimestamp) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTimestamp) Less(i, j int) bool { return b[i].GetTimestamp().Before(b[j].GetTimestamp()) }
@@ -0,0 +1,294 @@
+package offline
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skelterjohn/go.matrix"
+
+	"hectormapping/datacontainer"
+	"hectormapping/posegraph"
+
+	"robot/model"
+	"robot/sensors/lidar"
+	"robot/sensors/odometry"
+	"robot/sensors/sensor"
+	"robot/simulator"
+	"robot/slam/hector"
+	"robot/slam/trajectory"
+)
+
+// Make scans from a robot standing still in a 4x4 m room, with the readings
+// in reverse order.
+func makeTestReadings(n int) []sensor.SensorReading {
+	img := image.NewGray(image.Rect(0, 0, 80, 80))
+	for j := 0; j < 80; j++ {
+		for i := 0; i < 80; i++ {
+			c := color.Gray{255}
+			if i == 0 || j == 0 || i == 79 || j == 79 || (i > 50 && j > 50) {
+				c = color.Gray{0}
+			}
+			img.SetGray(i, j, c)
+		}
+	}
+
+	world := simulator.MakeWorld(simulator.MakeTruthMapFromImage(img, 0.05),
+		model.MakeDefaultDifferentialWheeledRobot(), model.Position{})
+	world.LidarNoise = 0
+	world.LidarMount = lidar.Mount{}
+
+	l := lidar.MakeDefaultLidar()
+	start := time.Now()
+	readings := make([]sensor.SensorReading, 0, n+1)
+	for i := n - 1; i >= 0; i-- {
+		reading := lidar.MakeLidarReading(l)
+		reading.Distances = world.Scan()
+		reading.Span = world.LidarSpan
+		reading.SetTimestamp(start.Add(time.Duration(i) * 100 * time.Millisecond))
+		readings = append(readings, reading)
+	}
+
+	o := odometry.MakeOdometryReading(odometry.MakeDefaultEncoder())
+	o.SetTimestamp(start.Add(50 * time.Millisecond))
+	readings = append(readings, o)
+
+	return readings
+}
+
+// Make scans from a robot driving a lap and a quarter around a block in a
+// 10x8 m room. There are bumps along the walls, except for a 5 m stretch where
+// the scan matcher slips. Returns the readings and the true poses relative to
+// the start.
+func makeLoopReadings() ([]sensor.SensorReading, []model.Position) {
+	img := image.NewGray(image.Rect(0, 0, 200, 160))
+	for j := 0; j < 160; j++ {
+		for i := 0; i < 200; i++ {
+			c := color.Gray{255}
+			wall := i == 0 || j == 0 || i == 199 || j == 159
+			block := i >= 50 && i < 150 && j >= 50 && j < 110
+			nearWall := i < 8 || j < 8 || i >= 192 || j >= 152 || (i >= 44 && i < 156 && j >= 44 && j < 116)
+			bump := nearWall && (i*7+j*13)%37 < 6 && ((i/6+j/6)*5)%7 < 3 && !(i >= 50 && i < 150 && j < 60)
+			if wall || block || bump {
+				c = color.Gray{0}
+			}
+			img.SetGray(i, j, c)
+		}
+	}
+
+	world := simulator.MakeWorld(simulator.MakeTruthMapFromImage(img, 0.05),
+		model.MakeDefaultDifferentialWheeledRobot(), model.Position{})
+	world.LidarNoise = 0
+	world.LidarMount = lidar.Mount{}
+
+	// Drive counter clockwise around the block, turning slowly in the corners
+	start := model.Position{X: -3.75, Y: -2.75}
+	poses := make([]model.Position, 0)
+	pose := start
+	for leg, length := range []float64{7.5, 5.5, 7.5, 5.5, 7.5} {
+		for d := 0.0; d < length-1e-9; d += 0.1 {
+			poses = append(poses, pose)
+			pose.X += 0.1 * math.Cos(pose.Theta)
+			pose.Y += 0.1 * math.Sin(pose.Theta)
+		}
+		for a := 0.0; leg < 4 && a < math.Pi/2-1e-9; a += math.Pi / 48 {
+			poses = append(poses, pose)
+			pose.Theta += math.Pi / 48
+		}
+	}
+
+	l := lidar.MakeDefaultLidar()
+	now := time.Now()
+	readings := make([]sensor.SensorReading, len(poses))
+	for i := range poses {
+		world.SetPose(poses[i])
+		reading := lidar.MakeLidarReading(l)
+		reading.Distances = world.Scan()
+		reading.Span = world.LidarSpan
+		reading.SetTimestamp(now.Add(time.Duration(i) * 100 * time.Millisecond))
+		readings[i] = reading
+
+		poses[i].X -= start.X
+		poses[i].Y -= start.Y
+	}
+
+	return readings, poses
+}
+
+// Distances in meters from the poses of the trajectory to the true poses
+func trajectoryErrors(poses []trajectory.Pose, truth []model.Position) []float64 {
+	errors := make([]float64, len(poses))
+	for i := range poses {
+		errors[i] = math.Hypot(poses[i].Position.X-truth[i].X, poses[i].Position.Y-truth[i].Y)
+	}
+	return errors
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func TestProcessReadings(t *testing.T) {
+	hsp := hector.MakeDefaultHectorSlamProcessor()
+	p := MakeProcessor(hsp, model.MakeDefaultDifferentialWheeledRobot())
+
+	p.ProcessReadings(makeTestReadings(5))
+
+	if p.Stats.LidarReadings != 5 || p.Stats.OdometryReadings != 1 {
+		t.Errorf("Got %d LIDAR and %d odometry readings, want 5 and 1",
+			p.Stats.LidarReadings, p.Stats.OdometryReadings)
+	}
+
+	if p.Stats.LogDuration != 400*time.Millisecond {
+		t.Errorf("Log duration is %s, want 400ms", p.Stats.LogDuration)
+	}
+
+	if len(p.Trajectory) != 5 {
+		t.Fatalf("Trajectory has %d poses, want 5", len(p.Trajectory))
+	}
+
+	for i := 1; i < len(p.Trajectory); i++ {
+		if !p.Trajectory[i].Timestamp.After(p.Trajectory[i-1].Timestamp) {
+			t.Errorf("Trajectory not in timestamp order at %d", i)
+		}
+	}
+
+	// The robot didn't move. The scan matcher drifts a few mm even on
+	// identical scans, but no more than that.
+	if first := p.Trajectory[0].Position; first != (model.Position{}) {
+		t.Errorf("First pose is %v, want the origin", first)
+	}
+	pose := p.GetPosition()
+	if math.Hypot(pose.X, pose.Y) > 0.03 || math.Abs(pose.Theta) > 0.02 {
+		t.Errorf("Robot standing still ended up at %v", pose)
+	}
+
+	var buf bytes.Buffer
+	err := p.WriteTrajectory(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 6 {
+		t.Errorf("Trajectory CSV has %d lines, want 6", lines)
+	}
+}
+
+func TestLoopClosureKeyframes(t *testing.T) {
+	hsp := hector.MakeDefaultHectorSlamProcessor()
+	p := MakeProcessor(hsp, model.MakeDefaultDifferentialWheeledRobot())
+	p.EnableLoopClosure()
+
+	p.ProcessReadings(makeTestReadings(5))
+
+	// The first scan updates the map, and becomes the first keyframe
+	graph := p.GetPoseGraph().GetGraph()
+	if len(graph.Keyframes) == 0 {
+		t.Fatal("No keyframes were added")
+	}
+	if graph.Keyframes[0].Pose != [3]float64{} {
+		t.Errorf("First keyframe is at %v, want the origin", graph.Keyframes[0].Pose)
+	}
+	if len(graph.Edges) != len(graph.Keyframes)-1 {
+		t.Errorf("Got %d edges for %d keyframes", len(graph.Edges), len(graph.Keyframes))
+	}
+}
+
+func TestLoopClosure(t *testing.T) {
+	readings, truth := makeLoopReadings()
+
+	plain := MakeProcessor(hector.MakeDefaultHectorSlamProcessor(), model.MakeDefaultDifferentialWheeledRobot())
+	plain.ProcessReadings(readings)
+
+	p := MakeProcessor(hector.MakeDefaultHectorSlamProcessor(), model.MakeDefaultDifferentialWheeledRobot())
+	p.EnableLoopClosure()
+	closed := -1
+	for i, reading := range readings {
+		p.ProcessReading(reading)
+		if closed < 0 && p.GetPoseGraph().GetLoopClosures() > 0 {
+			closed = i
+		}
+	}
+	if closed < 0 {
+		t.Fatal("No loop closure found")
+	}
+
+	plainErrors := trajectoryErrors(plain.Trajectory, truth)
+	errors := trajectoryErrors(p.Trajectory, truth)
+	t.Logf("Loop closed at pose %d, %.3f m off without loop closure, %.3f m with",
+		closed, plainErrors[closed], errors[closed])
+
+	// Without loop closure the slip is still there when back at the start
+	if plainErrors[closed] < 0.5 {
+		t.Fatalf("The scan matcher didn't slip, only %.3f m off at the loop closure", plainErrors[closed])
+	}
+
+	// With loop closure the poses from there on are corrected
+	for i := closed; i < len(errors); i++ {
+		if errors[i] > 0.15 {
+			t.Errorf("Pose %d is %.3f m off after the loop closure", i, errors[i])
+		}
+	}
+
+	// and so are the poses recorded before it, rewritten from the keyframes
+	if before, plainBefore := mean(errors[:closed]), mean(plainErrors[:closed]); before > 0.8*plainBefore {
+		t.Errorf("Poses before the loop closure are %.3f m off on average, %.3f m without loop closure",
+			before, plainBefore)
+	}
+
+	// The keyframe closing the loop was moved to where the robot was
+	graph := p.GetPoseGraph().GetGraph()
+	for _, e := range graph.Edges {
+		if !e.LoopClosure {
+			continue
+		}
+		pose := graph.Keyframes[e.To].Pose
+		if math.Hypot(pose[0]-truth[closed].X, pose[1]-truth[closed].Y) > 0.05 {
+			t.Errorf("Keyframe %d closing the loop is at %v, want %v", e.To, pose, truth[closed])
+		}
+		break
+	}
+}
+
+func TestCorrectTrajectory(t *testing.T) {
+	p := MakeProcessor(hector.MakeDefaultHectorSlamProcessor(), model.MakeDefaultDifferentialWheeledRobot())
+	p.EnableLoopClosure()
+	graph := p.GetPoseGraph().GetGraph()
+	scan := datacontainer.MakeDataContainer(0)
+
+	// Two poses recorded after each of two keyframes
+	graph.AddKeyframe([3]float64{0, 0, 0}, matrix.Eye(3), scan)
+	graph.AddKeyframe([3]float64{1, 0, math.Pi / 2}, matrix.Eye(3), scan)
+	for _, pose := range [][3]float64{{0, 0, 0}, {0.5, 0, 0}, {1, 0, math.Pi / 2}, {1, 0.5, math.Pi / 2}} {
+		if pose[0] < 1 {
+			p.anchors = append(p.anchors, posegraph.Anchor{Keyframe: 0, Relative: posegraph.RelativePose(graph.Keyframes[0].Pose, pose)})
+		} else {
+			p.anchors = append(p.anchors, posegraph.Anchor{Keyframe: 1, Relative: posegraph.RelativePose(graph.Keyframes[1].Pose, pose)})
+		}
+		p.Trajectory = append(p.Trajectory, trajectory.Pose{Position: model.Position{X: pose[0], Y: pose[1], Theta: pose[2]}})
+	}
+
+	// Optimising moved the second keyframe
+	graph.Keyframes[1].Pose = [3]float64{1, 0.2, math.Pi/2 + 0.1}
+	p.correctTrajectory()
+
+	want := []model.Position{
+		{X: 0, Y: 0, Theta: 0},
+		{X: 0.5, Y: 0, Theta: 0},
+		{X: 1, Y: 0.2, Theta: math.Pi/2 + 0.1},
+		{X: 1 - 0.5*math.Sin(0.1), Y: 0.2 + 0.5*math.Cos(0.1), Theta: math.Pi/2 + 0.1},
+	}
+	for i, pose := range p.Trajectory {
+		got := pose.Position
+		if math.Abs(got.X-want[i].X) > 1e-9 || math.Abs(got.Y-want[i].Y) > 1e-9 || math.Abs(got.Theta-want[i].Theta) > 1e-9 {
+			t.Errorf("Pose %d is %v, want %v", i, got, want[i])
+		}
+	}
+}
@@ -9,12 +9,18 @@ import (
 	"image"
 	"runtime"
 
+	"hectormapping/map/mapimages"
 	"hectormapping/map/maprep"
 
 	"robot/fsm"
+	"robot/maplayers"
 	"robot/mapstorage"
 	"robot/model"
+	"robot/sensors/sensor"
 	"robot/slam/hector"
+	"robot/slam/mcl"
+	"robot/slam/trajectory"
+	"robot/waypoints"
 	// "robot/slam/tinyslam"
 )
 
@@ -28,9 +34,11 @@ type Slam interface {
 	Start()
 	Stop()
 	GetPosition() model.Position
-	// GetPositionHistory() []model.Position
+	GetPositionHistory() []model.Position
+	GetTrajectory() *trajectory.Trajectory
 	GetMapImage() (image.Image, error)
 	GetMapTile(zoomLevel uint, tileX, tileY int) (image.Image, error)
+	GetTileCache() *mapimages.TileCache
 	GetTypeName() string
 	GetMapSizeMeters() float64
 	GetMapSize() int
@@ -39,14 +47,43 @@ type Slam interface {
 	GetMapRepresentation() maprep.MapRepresentation
 }
 
+// A Localizer only estimates the position of the robot in a stored map,
+// without updating the map.
+type Localizer interface {
+	Slam
+	SetInitialPose(pose model.Position, stdDevXY, stdDevTheta float64)
+	GlobalLocalization()
+	GetParticles(max int) []model.Position
+	GetNumParticles() int
+	GetConfidence() float64
+}
+
+// A MapEditListener is told when the map has been edited, so it can update
+// what it made from the map
+type MapEditListener interface {
+	OnMapEdited()
+}
+
 type SlamController struct {
 	fsm.FSM
 	slam Slam
+
+	// Edits of the map, kept apart from the SLAM map
+	layers *maplayers.Layers
+
+	// Waypoints in the map
+	waypoints *waypoints.Waypoints
+
+	// Sensors given to the SLAM algorithms. Odometry may be nil.
+	lidar    sensor.Sensor
+	odometry sensor.Sensor
 }
 
-func MakeSlamController() *SlamController {
+func MakeSlamController(lidar, odometry sensor.Sensor) *SlamController {
 	return &SlamController{
-		FSM: *fsm.MakeFSM(OFF),
+		FSM:      *fsm.MakeFSM(OFF),
+		lidar:    lidar,
+		odometry: odometry,
 	}
 }
 
@@ -58,13 +95,17 @@ func (sc *SlamController) InitializeSlam(algorithm string, robot model.Robot) er
 
 	switch algorithm {
 	// case "tinyslam":
-	// 	sc.slam = tinyslam.MakeTinySlam(robot)
+	// 	sc.slam = tinyslam.MakeTinySlam(robot, sc.lidar.(*lidar.Lidar))
 	case "hectorslam":
-		sc.slam = hector.MakeHectorSlam()
+		sc.slam = hector.MakeHectorSlam(sc.lidar, sc.odometry)
+	case "mcl":
+		return errors.New("MCL needs a stored map.")
 	default:
 		return errors.New("No such SLAM algorithm.")
 	}
 
+	sc.layers = maplayers.MakeLayers()
+	sc.waypoints = waypoints.MakeWaypoints()
 	sc.SetState(STOPPED)
 
 	return nil
@@ -86,11 +127,21 @@ func (sc *SlamController) InitializeSlamFromStoredMap(filename, algorithm string
 	case "tinyslam":
 		return errors.New("Initialize from stored map not implemented for TinySLAM.")
 	case "hectorslam":
-		sc.slam = hector.MakeHectorSlamFromMapRep(mapdata.MapRep)
+		sc.slam = hector.MakeHectorSlamFromMapRep(mapdata.MapRep, sc.lidar, sc.odometry)
+	case "mcl":
+		sc.slam = mcl.MakeMCL(mapdata.MapRep, sc.lidar, sc.odometry)
 	default:
 		return errors.New("No such SLAM algorithm.")
 	}
 
+	sc.layers = mapdata.Layers
+	if sc.layers == nil {
+		sc.layers = maplayers.MakeLayers()
+	}
+	sc.waypoints = mapdata.Waypoints
+	if sc.waypoints == nil {
+		sc.waypoints = waypoints.MakeWaypoints()
+	}
 	sc.SetState(STOPPED)
 	//Debug: fmt.Println("Got thru this function") seems to work to here
 	return nil
@@ -99,6 +150,8 @@ func (sc *SlamController) InitializeSlamFromStoredMap(filename, algorithm string
 // Terminate the slam algorithm, put the controller in the OFF state
 func (sc *SlamController) TerminateSlam() {
 	sc.slam = nil
+	sc.layers = nil
+	sc.waypoints = nil
 	sc.SetState(OFF)
 
 	// Run a garbage collection
@@ -134,7 +187,79 @@ func (sc *SlamController) GetSlam() Slam {
 	return sc.slam
 }
 
+// Get the SLAM algorithm as a Localizer, if it is one
+func (sc *SlamController) getLocalizer() (Localizer, error) {
+	if sc.slam == nil {
+		return nil, errors.New("No SLAM algorithm initialized")
+	}
+
+	localizer, ok := sc.slam.(Localizer)
+	if !ok {
+		return nil, errors.New("SLAM algorithm is not a localizer")
+	}
+
+	return localizer, nil
+}
+
+// Tell a localizer where the robot is, with the given uncertainty
+func (sc *SlamController) SetInitialPose(pose model.Position, stdDevXY, stdDevTheta float64) error {
+	localizer, err := sc.getLocalizer()
+	if err != nil {
+		return err
+	}
+
+	localizer.SetInitialPose(pose, stdDevXY, stdDevTheta)
+	return nil
+}
+
+// Make a localizer search the whole map for the robot
+func (sc *SlamController) StartGlobalLocalization() error {
+	localizer, err := sc.getLocalizer()
+	if err != nil {
+		return err
+	}
+
+	localizer.GlobalLocalization()
+	return nil
+}
+
 // Get the map representation
 func (sc *SlamController) GetMapRepresentation() maprep.MapRepresentation {
 	return sc.slam.GetMapRepresentation()
 }
+
+// Paint the cells of a shape in the map with a value, as maplayers.Paint, and
+// update what the SLAM algorithm made from the map
+func (sc *SlamController) Paint(shape maplayers.Shape, value string) error {
+	if sc.slam == nil {
+		return errors.New("SLAM not initialized.")
+	}
+
+	err := maplayers.Paint(sc.slam.GetMapRepresentation(), shape, value)
+	if err != nil {
+		return err
+	}
+	sc.slam.GetTileCache().Invalidate()
+
+	if listener, ok := sc.slam.(MapEditListener); ok {
+		listener.OnMapEdited()
+	}
+
+	return nil
+}
+
+// Get the edits of the map. Without SLAM there are none.
+func (sc *SlamController) GetLayers() *maplayers.Layers {
+	if sc.layers == nil {
+		return maplayers.MakeLayers()
+	}
+	return sc.layers
+}
+
+// Get the waypoints in the map. Without SLAM there are none.
+func (sc *SlamController) GetWaypoints() *waypoints.Waypoints {
+	if sc.waypoints == nil {
+		return waypoints.MakeWaypoints()
+	}
+	return sc.waypoints
+}
@@ -23,6 +23,7 @@ import (
 	"robot/sensors/odometry"
 	"robot/sensors/sensor"
 	"robot/slam/tinyslam/gridmap"
+	"robot/slam/trajectory"
 	"robot/tools/intmath"
 )
 
@@ -55,8 +56,8 @@ type TinySlam struct {
 	robot    model.Robot
 
 	// The instantaneous position, and log
-	position        model.Position
-	positionHistory []model.Position
+	position   model.Position
+	trajectory *trajectory.Trajectory
 
 	// Sensors
 	lidar        *lidar.Lidar
@@ -93,7 +94,7 @@ type cartesianLidarReading struct {
 }
 
 // Make a TinySLAM object
-func MakeTinySlam(robot model.Robot) *TinySlam {
+func MakeTinySlam(robot model.Robot, lidar *lidar.Lidar) *TinySlam {
 	// Initialize gridmap
 	gridMap := &SlamSimpleMap{
 		*gridmap.MakeSimpleMap(config.TINYSLAM_GRIDMAP_SIZE, config.TINYSLAM_GRIDMAP_RESOLUTION),
@@ -112,12 +113,11 @@ func MakeTinySlam(robot model.Robot) *TinySlam {
 		gridMap:  gridMap,
 		robot:    robot,
 
-		// Make space for many positions
-		position:        position,
-		positionHistory: make([]model.Position, 0, 10000),
+		position:   position,
+		trajectory: trajectory.MakeTrajectory(),
 
 		// Sensors
-		lidar: lidar.LidarSensor,
+		lidar: lidar,
 
 		// Config parameters
 		sigmaXY:              config.TINYSLAM_SIGMA_XY,
@@ -176,7 +176,13 @@ func (ts *TinySlam) Stop() {
 
 // Return the history of positions
 func (ts *TinySlam) GetPositionHistory() []model.Position {
-	return ts.positionHistory
+	return ts.trajectory.GetPositions()
+}
+
+// Get the recorded poses. TinySLAM doesn't estimate the uncertainty, so the
+// covariances are zero.
+func (ts *TinySlam) GetTrajectory() *trajectory.Trajectory {
+	return ts.trajectory
 }
 
 // Return current position
@@ -215,9 +221,8 @@ func makeCartesianLidarReading(lidarReading lidar.LidarReading) *cartesianLidarR
 		value:        make([]gridmap.SimpleCell, numDistances),
 	}
 
-	startAngle := -lidarReading.Span / 2 * math.Pi / 180.0
-	deltaAngle := lidarReading.Span / float64(numDistances-1) * math.Pi / 180.0
-	for i, angle := 0, startAngle; i < numDistances; i++ {
+	for i := 0; i < numDistances; i++ {
+		angle := lidarReading.BeamAngle(i)
 		if lidarReading.Distances[i] == 0 {
 			r.x[i] = lidarReading.MaxDistance * math.Cos(angle)
 			// ... (missing) correcting for speed
@@ -229,8 +234,6 @@ func makeCartesianLidarReading(lidarReading lidar.LidarReading) *cartesianLidarR
 			r.y[i] = float64(lidarReading.Distances[i]) / 1000 * math.Sin(angle)
 			r.value[i] = OBSTACLE
 		}
-
-		angle += deltaAngle
 	}
 
 	return r
@@ -553,4 +556,5 @@ func (ts *TinySlam) LidarMapBuilding(lidarReading *lidar.LidarReading, estPositi
 
 	// Update slam position
 	ts.position = correctedPosition
+	ts.trajectory.Add(ts.timestamp, correctedPosition, [3][3]float64{})
 }
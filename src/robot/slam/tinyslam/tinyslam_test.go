@@ -13,7 +13,7 @@ import (
 )
 
 func TestMapLaserRay(t *testing.T) {
-	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot())
+	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot(), lidar.MakeDefaultLidar())
 	
 	for i := 0; i < 1; i++ {
 		ts.gridMap.MapLaserRay(200, 200, 600, 200, 580, 200, 100, OBSTACLE)
@@ -30,7 +30,7 @@ func TestMapLaserRay(t *testing.T) {
 }
 
 func TestMapUpdate(t *testing.T) {
-	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot())
+	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot(), lidar.MakeDefaultLidar())
 	ts.position = model.Position{5, 17, 0}
 	
 	log, err := logreader.MakeLogReaderFromLogName("intel")
@@ -65,7 +65,7 @@ func TestMapUpdate(t *testing.T) {
 }
 
 func TestDistanceScanToMap(t *testing.T) {
-	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot())
+	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot(), lidar.MakeDefaultLidar())
 	ts.position = model.Position{5, 17, 0}
 	
 	// Obtain a reading
@@ -92,7 +92,7 @@ func TestDistanceScanToMap(t *testing.T) {
 }
 
 func TestMonteCarloSearch(t *testing.T) {
-	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot())
+	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot(), lidar.MakeDefaultLidar())
 	ts.position = model.Position{5, 17, 0}
 	
 	// Obtain a reading
@@ -141,7 +141,7 @@ func saveImage(filename string, ts *TinySlam) {
 }
 
 func TestLidarOnlyTinySlam(t *testing.T) {
-	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot())
+	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot(), lidar.MakeDefaultLidar())
 	ts.position = model.Position{25, 37, 0}
 	
 	// Start a logreader
@@ -184,7 +184,7 @@ func TestLidarOnlyTinySlam(t *testing.T) {
 
 func BenchmarkMapLaserRay(b *testing.B) {
 	b.StopTimer()
-	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot())
+	ts := MakeTinySlam(model.MakeDefaultDifferentialWheeledRobot(), lidar.MakeDefaultLidar())
 	b.StartTimer()
 	
 	for i := 0; i < b.N; i++ {
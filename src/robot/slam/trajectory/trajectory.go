@@ -0,0 +1,265 @@
+// Package trajectory records the poses estimated by a SLAM algorithm over
+// time, and writes them in the TUM and KITTI trajectory formats used by
+// common evaluation tools.
+package trajectory
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"robot/config"
+	"robot/model"
+)
+
+// Text formats the poses can be written in
+const (
+	FORMAT_CSV   = "csv"
+	FORMAT_TUM   = "tum"
+	FORMAT_KITTI = "kitti"
+)
+
+// A pose of the robot at a point in time. The covariance is of x, y and theta,
+// in meters and radians, and is zero if unknown.
+type Pose struct {
+	Timestamp  time.Time      `json:"timestamp"`
+	Position   model.Position `json:"position"`
+	Covariance [3][3]float64  `json:"covariance"`
+}
+
+// A Trajectory is the poses of the robot in the order they were estimated. It
+// is safe for concurrent use.
+type Trajectory struct {
+	poses []Pose
+	lock  *sync.RWMutex
+}
+
+// Make an empty trajectory
+func MakeTrajectory() *Trajectory {
+	return &Trajectory{
+		poses: make([]Pose, 0),
+		lock:  new(sync.RWMutex),
+	}
+}
+
+// Make a trajectory of the given poses
+func MakeTrajectoryFromPoses(poses []Pose) *Trajectory {
+	t := MakeTrajectory()
+	t.poses = append(t.poses, poses...)
+	return t
+}
+
+// Add a pose to the end of the trajectory, and return it
+func (t *Trajectory) Add(timestamp time.Time, position model.Position, covariance [3][3]float64) Pose {
+	pose := Pose{
+		Timestamp:  timestamp,
+		Position:   position,
+		Covariance: covariance,
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.poses = append(t.poses, pose)
+	return pose
+}
+
+// Move the pose with index i, e.g. when loop closure has corrected it
+func (t *Trajectory) SetPosition(i int, position model.Position) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.poses[i].Position = position
+}
+
+// Get the number of poses
+func (t *Trajectory) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return len(t.poses)
+}
+
+// Get a copy of all the poses
+func (t *Trajectory) GetPoses() []Pose {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	poses := make([]Pose, len(t.poses))
+	copy(poses, t.poses)
+	return poses
+}
+
+// Get the positions only
+func (t *Trajectory) GetPositions() []model.Position {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	positions := make([]model.Position, len(t.poses))
+	for i, pose := range t.poses {
+		positions[i] = pose.Position
+	}
+	return positions
+}
+
+// Get the poses from the given time up to and including the given time,
+// keeping every n-th pose. A zero time leaves that end of the range open, and
+// n below 1 keeps every pose.
+func (t *Trajectory) GetRange(from, to time.Time, n int) []Pose {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if n < 1 {
+		n = 1
+	}
+
+	// Poses are added as they are estimated, so they are in timestamp order
+	first := 0
+	if !from.IsZero() {
+		first = sort.Search(len(t.poses), func(i int) bool {
+			return !t.poses[i].Timestamp.Before(from)
+		})
+	}
+	last := len(t.poses)
+	if !to.IsZero() {
+		last = sort.Search(len(t.poses), func(i int) bool {
+			return t.poses[i].Timestamp.After(to)
+		})
+	}
+
+	poses := make([]Pose, 0)
+	for i := first; i < last; i += n {
+		poses = append(poses, t.poses[i])
+	}
+	return poses
+}
+
+// Remove all poses
+func (t *Trajectory) Clear() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.poses = make([]Pose, 0)
+}
+
+// Write poses in the given format
+func Write(w io.Writer, poses []Pose, format string) error {
+	switch format {
+	case FORMAT_CSV:
+		return WriteCSV(w, poses)
+	case FORMAT_TUM:
+		return WriteTUM(w, poses)
+	case FORMAT_KITTI:
+		return WriteKITTI(w, poses)
+	}
+	return errors.New("Unknown trajectory format.")
+}
+
+// Write poses in the TUM format, one pose per line as
+//
+//	timestamp x y z qx qy qz qw
+//
+// with the timestamp in seconds and the orientation as a unit quaternion.
+func WriteTUM(w io.Writer, poses []Pose) error {
+	for _, pose := range poses {
+		p := pose.Position
+		_, err := fmt.Fprintf(w, "%.9f %f %f %f %f %f %f %f\n",
+			float64(pose.Timestamp.UnixNano())/1e9,
+			p.X, p.Y, 0.0,
+			0.0, 0.0, math.Sin(p.Theta/2), math.Cos(p.Theta/2))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read poses in the TUM format. Lines starting with # are comments. Only the
+// rotation about the z axis is kept.
+func ReadTUM(r io.Reader) ([]Pose, error) {
+	poses := make([]Pose, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 8 {
+			return nil, errors.New("Invalid TUM trajectory line.")
+		}
+		values := make([]float64, len(fields))
+		for i, field := range fields {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+
+		// Yaw of the quaternion qx qy qz qw
+		qx, qy, qz, qw := values[4], values[5], values[6], values[7]
+		theta := math.Atan2(2*(qw*qz+qx*qy), 1-2*(qy*qy+qz*qz))
+
+		seconds, fraction := math.Modf(values[0])
+		poses = append(poses, Pose{
+			Timestamp: time.Unix(int64(seconds), int64(math.Floor(fraction*1e9+0.5))),
+			Position:  model.Position{X: values[1], Y: values[2], Theta: theta},
+		})
+	}
+
+	return poses, scanner.Err()
+}
+
+// Write poses in the KITTI format, one pose per line as the first three rows
+// of the homogeneous transformation matrix, row by row. The format has no
+// timestamps.
+func WriteKITTI(w io.Writer, poses []Pose) error {
+	for _, pose := range poses {
+		p := pose.Position
+		cos, sin := math.Cos(p.Theta), math.Sin(p.Theta)
+		_, err := fmt.Fprintf(w, "%e %e %e %e %e %e %e %e %e %e %e %e\n",
+			cos, -sin, 0.0, p.X,
+			sin, cos, 0.0, p.Y,
+			0.0, 0.0, 1.0, 0.0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write poses as CSV, with a header line
+func WriteCSV(w io.Writer, poses []Pose) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"timestamp", "x", "y", "theta"})
+	if err != nil {
+		return err
+	}
+
+	for _, pose := range poses {
+		err = writer.Write([]string{
+			pose.Timestamp.Format(config.SENSORLOGS_TIME_FORMAT),
+			fmt.Sprintf("%f", pose.Position.X),
+			fmt.Sprintf("%f", pose.Position.Y),
+			fmt.Sprintf("%f", pose.Position.Theta),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
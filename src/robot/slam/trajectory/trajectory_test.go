@@ -0,0 +1,131 @@
+package trajectory
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"robot/model"
+)
+
+// Make a trajectory of n poses 100 ms apart, driving along x
+func makeTestTrajectory(n int) (*Trajectory, time.Time) {
+	t := MakeTrajectory()
+	start := time.Unix(1400000000, 0)
+	for i := 0; i < n; i++ {
+		t.Add(start.Add(time.Duration(i)*100*time.Millisecond),
+			model.Position{X: float64(i), Y: 0, Theta: math.Pi / 2},
+			[3][3]float64{{0.01, 0, 0}, {0, 0.01, 0}, {0, 0, 0.001}})
+	}
+	return t, start
+}
+
+func TestGetRange(t *testing.T) {
+	traj, start := makeTestTrajectory(20)
+
+	if poses := traj.GetRange(time.Time{}, time.Time{}, 0); len(poses) != 20 {
+		t.Errorf("Got %d poses without limits, want 20", len(poses))
+	}
+
+	poses := traj.GetRange(start.Add(500*time.Millisecond), start.Add(time.Second), 1)
+	if len(poses) != 6 || poses[0].Position.X != 5 || poses[5].Position.X != 10 {
+		t.Errorf("Got poses %v for 0.5 to 1 s, want 5 to 10", poses)
+	}
+
+	poses = traj.GetRange(start.Add(time.Second), time.Time{}, 5)
+	if len(poses) != 2 || poses[0].Position.X != 10 || poses[1].Position.X != 15 {
+		t.Errorf("Got poses %v from 1 s, every 5th, want 10 and 15", poses)
+	}
+}
+
+func TestWriteTUM(t *testing.T) {
+	traj, _ := makeTestTrajectory(3)
+
+	var buf bytes.Buffer
+	err := WriteTUM(&buf, traj.GetPoses())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Wrote %d lines, want 3", len(lines))
+	}
+
+	var ts, x, y, z, qx, qy, qz, qw float64
+	_, err = fmt.Sscan(lines[1], &ts, &x, &y, &z, &qx, &qy, &qz, &qw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(ts-1400000000.1) > 1e-6 || x != 1 || y != 0 || z != 0 {
+		t.Errorf("Pose 1 written as %q", lines[1])
+	}
+	if math.Abs(qz-math.Sqrt(0.5)) > 1e-6 || math.Abs(qw-math.Sqrt(0.5)) > 1e-6 || qx != 0 || qy != 0 {
+		t.Errorf("Rotation of 90 degrees written as %f %f %f %f", qx, qy, qz, qw)
+	}
+}
+
+func TestReadTUM(t *testing.T) {
+	traj, start := makeTestTrajectory(3)
+
+	var buf bytes.Buffer
+	buf.WriteString("# timestamp x y z qx qy qz qw\n")
+	err := WriteTUM(&buf, traj.GetPoses())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poses, err := ReadTUM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(poses) != 3 {
+		t.Fatalf("Read %d poses, want 3", len(poses))
+	}
+
+	p := poses[2]
+	if d := p.Timestamp.Sub(start.Add(200 * time.Millisecond)); d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("Pose 2 is at %s", p.Timestamp)
+	}
+	if p.Position.X != 2 || math.Abs(p.Position.Theta-math.Pi/2) > 1e-6 {
+		t.Errorf("Pose 2 read as %v", p.Position)
+	}
+
+	if _, err := ReadTUM(strings.NewReader("1 2 3\n")); err == nil {
+		t.Error("Line with too few values accepted")
+	}
+}
+
+func TestWriteKITTI(t *testing.T) {
+	traj, _ := makeTestTrajectory(3)
+
+	var buf bytes.Buffer
+	err := Write(&buf, traj.GetPoses(), FORMAT_KITTI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	values := make([]float64, 12)
+	for i := range values {
+		_, err = fmt.Sscan(strings.Fields(lines[2])[i], &values[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []float64{0, -1, 0, 2, 1, 0, 0, 0, 0, 0, 1, 0}
+	for i := range want {
+		if math.Abs(values[i]-want[i]) > 1e-6 {
+			t.Errorf("Pose 2 written as %q, want %v", lines[2], want)
+			break
+		}
+	}
+
+	if err := Write(&buf, nil, "bag"); err == nil {
+		t.Error("Unknown format accepted")
+	}
+}
@@ -0,0 +1,113 @@
+package telemetry
+
+import (
+	"math"
+
+	"robot/sensors/lidar"
+	"robot/sensors/sensor"
+)
+
+// A LIDAR scan. Angles are in degrees, counter clockwise from straight
+// ahead, and distances in mm, 0 where nothing was hit. The position of the
+// LIDAR is in meters from the robot's center.
+type Scan struct {
+	Sensor         string     `json:"sensor"`
+	Position       [2]float64 `json:"position"`
+	StartAngle     float64    `json:"startAngle"`
+	AngleIncrement float64    `json:"angleIncrement"`
+	Distances      []float64  `json:"distances"`
+}
+
+// The speeds sent to the motors, in [-1, 1]
+type MotorCommand struct {
+	Left  float64 `json:"left"`
+	Right float64 `json:"right"`
+}
+
+// The point the path follower steers towards, on the path segment from one
+// path pose to the next
+type Target struct {
+	Segment int        `json:"segment"`
+	From    [2]float64 `json:"from"`
+	To      [2]float64 `json:"to"`
+	Point   [2]float64 `json:"point"`
+}
+
+// The state of the collision detector. Obstacle is set while the robot is
+// stopped by an obstacle.
+type Collision struct {
+	Active   bool `json:"active"`
+	Obstacle bool `json:"obstacle"`
+}
+
+// A data log series
+type Data struct {
+	Key    string    `json:"key"`
+	Values []float64 `json:"values"`
+}
+
+// Make a scan from a LIDAR reading
+func MakeScan(reading *lidar.LidarReading) Scan {
+	n := len(reading.Distances)
+	scan := Scan{
+		Position:   reading.Origin(),
+		StartAngle: reading.BeamAngle(0) * 180 / math.Pi,
+		Distances:  make([]float64, n),
+	}
+	if reading.GetSensor() != nil {
+		scan.Sensor = reading.GetSensor().GetTypeName()
+	}
+	if n > 1 {
+		scan.AngleIncrement = reading.AngleIncrement() * 180 / math.Pi
+	}
+	copy(scan.Distances, reading.Distances)
+	return scan
+}
+
+// Get a scan with only every n-th distance
+func (s Scan) Decimate(n int) Scan {
+	if n <= 1 {
+		return s
+	}
+
+	decimated := Scan{
+		Sensor:         s.Sensor,
+		Position:       s.Position,
+		StartAngle:     s.StartAngle,
+		AngleIncrement: s.AngleIncrement * float64(n),
+		Distances:      make([]float64, 0, len(s.Distances)/n+1),
+	}
+	for i := 0; i < len(s.Distances); i += n {
+		decimated.Distances = append(decimated.Distances, s.Distances[i])
+	}
+	return decimated
+}
+
+// Publish the readings of a LIDAR on the scan channel of the default hub, for
+// as long as the LIDAR distributes readings
+func ForwardScans(l sensor.Sensor) {
+	ch := l.Subscribe()
+
+	go func() {
+		for r := range ch {
+			reading, ok := r.(*lidar.LidarReading)
+			if !ok {
+				logger.Println("Telemetry got a reading which isn't a LIDAR reading.")
+				continue
+			}
+			if HasSubscribers(SCAN) {
+				Publish(SCAN, MakeScan(reading))
+			}
+		}
+	}()
+}
+
+// DataWriter publishes data log series on the data channel of the default
+// hub. It implements datalog.Writer.
+type DataWriter struct{}
+
+func (DataWriter) Write(key string, values ...float64) {
+	data := Data{Key: key, Values: make([]float64, len(values))}
+	copy(data.Values, values)
+	Publish(DATA, data)
+}
@@ -0,0 +1,275 @@
+// Package telemetry streams the live state of the robot to subscribers, e.g.
+// the web client or a headless recorder.
+//
+// The modules driving the robot publish messages on named channels: the SLAM
+// pose, LIDAR scans, the planned path, the path following target, motor
+// commands, the collision detector state and data log series. A subscriber
+// picks the channels it wants, each with its own rate limit. Messages are
+// never queued up for slow subscribers: a message which can't be delivered
+// right away is dropped. Of the messages which come too soon after the last
+// one on their channel only the latest is kept, and sent when the rate allows,
+// so the subscriber always ends up with the current state.
+package telemetry
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"robot/logging"
+)
+
+// Channels
+const (
+	POSE      = "pose"
+	SCAN      = "scan"
+	PATH      = "path"
+	TARGET    = "target"
+	MOTOR     = "motor"
+	COLLISION = "collision"
+	DATA      = "data"
+)
+
+var CHANNELS = []string{POSE, SCAN, PATH, TARGET, MOTOR, COLLISION, DATA}
+
+// Number of messages buffered for each subscriber
+const BUFFER_SIZE = 32
+
+var logger *log.Logger
+
+// The hub used by the package level functions
+var hub *Hub
+
+func init() {
+	logger = logging.New()
+	hub = MakeHub()
+}
+
+// A Message is published on a channel, with data of the type given for the
+// channel
+type Message struct {
+	Channel   string      `json:"channel"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// A Hub passes published messages on to the subscribers. It keeps the last
+// message of each channel, so new subscribers get the current state.
+type Hub struct {
+	lock          sync.Mutex
+	subscriptions []*Subscription
+	last          map[string]Message
+}
+
+// A Subscription receives the messages of the channels it has subscribed to
+// on C, until it is closed
+type Subscription struct {
+	C chan Message
+
+	hub      *Hub
+	channels map[string]*channelState
+	closed   bool
+}
+
+type channelState struct {
+	interval time.Duration
+	lastSent time.Time
+
+	// The latest message which came too soon after the last one sent, and
+	// the timer which sends it when the interval has passed
+	pending *Message
+	timer   *time.Timer
+}
+
+func MakeHub() *Hub {
+	return &Hub{
+		subscriptions: make([]*Subscription, 0),
+		last:          make(map[string]Message),
+	}
+}
+
+// Get the hub the package level functions use
+func GetHub() *Hub {
+	return hub
+}
+
+// Publish data on a channel of the default hub
+func Publish(channel string, data interface{}) {
+	hub.Publish(channel, data)
+}
+
+// Determine if anyone listens to a channel of the default hub, so data which
+// is costly to make can be skipped
+func HasSubscribers(channel string) bool {
+	return hub.HasSubscribers(channel)
+}
+
+// Subscribe to the default hub
+func Subscribe() *Subscription {
+	return hub.Subscribe()
+}
+
+// Publish data on a channel
+func (h *Hub) Publish(channel string, data interface{}) {
+	message := Message{
+		Channel:   channel,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.last[channel] = message
+	for _, s := range h.subscriptions {
+		if state, ok := s.channels[channel]; ok {
+			s.send(message, state)
+		}
+	}
+}
+
+// Determine if any subscription has subscribed to the channel
+func (h *Hub) HasSubscribers(channel string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, s := range h.subscriptions {
+		if _, ok := s.channels[channel]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the last message published on a channel
+func (h *Hub) GetLast(channel string) (Message, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	message, ok := h.last[channel]
+	return message, ok
+}
+
+// Make a subscription without any channels
+func (h *Hub) Subscribe() *Subscription {
+	s := &Subscription{
+		C:        make(chan Message, BUFFER_SIZE),
+		hub:      h,
+		channels: make(map[string]*channelState),
+	}
+
+	h.lock.Lock()
+	h.subscriptions = append(h.subscriptions, s)
+	h.lock.Unlock()
+
+	return s
+}
+
+// Subscribe to a channel, getting at most rate messages per second. A rate of
+// 0 or less gives all messages. The last message published on the channel is
+// sent right away. Subscribing again changes the rate.
+func (s *Subscription) Add(channel string, rate float64) {
+	state := &channelState{}
+	if rate > 0 {
+		state.interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	s.hub.lock.Lock()
+	defer s.hub.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.channels[channel] = state
+	if message, ok := s.hub.last[channel]; ok {
+		s.send(message, state)
+	}
+}
+
+// Unsubscribe from a channel
+func (s *Subscription) Remove(channel string) {
+	s.hub.lock.Lock()
+	defer s.hub.lock.Unlock()
+
+	delete(s.channels, channel)
+}
+
+// Get the channels subscribed to
+func (s *Subscription) GetChannels() []string {
+	s.hub.lock.Lock()
+	defer s.hub.lock.Unlock()
+
+	channels := make([]string, 0, len(s.channels))
+	for _, channel := range CHANNELS {
+		if _, ok := s.channels[channel]; ok {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// Stop the subscription and close C
+func (s *Subscription) Close() {
+	h := s.hub
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	for i := range h.subscriptions {
+		if s == h.subscriptions[i] {
+			h.subscriptions = append(h.subscriptions[:i], h.subscriptions[i+1:]...)
+			break
+		}
+	}
+	close(s.C)
+}
+
+// Send a message unless the subscriber's buffer is full. A message which comes
+// too soon after the last one is held back until the interval has passed,
+// replacing any held back before. Must be called with the hub locked.
+func (s *Subscription) send(message Message, state *channelState) {
+	if wait := state.interval - message.Timestamp.Sub(state.lastSent); wait > 0 {
+		state.pending = &message
+		if state.timer == nil {
+			state.timer = time.AfterFunc(wait, func() { s.flush(state) })
+		}
+		return
+	}
+
+	state.pending = nil
+	s.deliver(message, state, message.Timestamp)
+}
+
+// Send the message held back on a channel, now that its interval has passed
+func (s *Subscription) flush(state *channelState) {
+	s.hub.lock.Lock()
+	defer s.hub.lock.Unlock()
+
+	state.timer = nil
+	message := state.pending
+	state.pending = nil
+
+	// The subscription may have been closed, or the channel removed or added
+	// again, since the message was held back
+	if message == nil || s.closed || s.channels[message.Channel] != state {
+		return
+	}
+
+	s.deliver(*message, state, time.Now())
+}
+
+// Put a message on C, or drop it if the buffer is full. Must be called with
+// the hub locked.
+func (s *Subscription) deliver(message Message, state *channelState, now time.Time) {
+	select {
+	case s.C <- message:
+		state.lastSent = now
+	default:
+		// Dropped, the subscriber isn't keeping up
+	}
+}
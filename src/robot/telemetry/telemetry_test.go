@@ -0,0 +1,133 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+)
+
+// Get the messages waiting on a subscription
+func received(s *Subscription) []Message {
+	messages := make([]Message, 0)
+	for {
+		select {
+		case m := <-s.C:
+			messages = append(messages, m)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	h := MakeHub()
+	h.Publish(PATH, "old path")
+
+	s := h.Subscribe()
+	if h.HasSubscribers(POSE) {
+		t.Error("Subscription without channels listens to poses")
+	}
+
+	// The last path is sent on subscribing
+	s.Add(PATH, 0)
+	s.Add(MOTOR, 0)
+	h.Publish(MOTOR, MotorCommand{Left: 0.5, Right: 0.4})
+	h.Publish(POSE, "not subscribed")
+
+	messages := received(s)
+	if len(messages) != 2 || messages[0].Data != "old path" || messages[1].Channel != MOTOR {
+		t.Errorf("Got messages %+v", messages)
+	}
+
+	s.Remove(MOTOR)
+	h.Publish(MOTOR, MotorCommand{})
+	if messages := received(s); len(messages) != 0 {
+		t.Errorf("Got %d messages after unsubscribing", len(messages))
+	}
+
+	s.Close()
+	if _, ok := <-s.C; ok {
+		t.Error("Channel is open after closing the subscription")
+	}
+	if h.HasSubscribers(PATH) {
+		t.Error("Closed subscription still listens to paths")
+	}
+	h.Publish(PATH, "new path")
+}
+
+func TestRateLimit(t *testing.T) {
+	h := MakeHub()
+	s := h.Subscribe()
+	defer s.Close()
+
+	s.Add(POSE, 20)
+	for i := 0; i < 10; i++ {
+		h.Publish(POSE, i)
+	}
+	if messages := received(s); len(messages) != 1 || messages[0].Data != 0 {
+		t.Errorf("Got messages %+v, want only the first", messages)
+	}
+
+	// The last of the messages held back is sent when the interval has
+	// passed
+	time.Sleep(60 * time.Millisecond)
+	if messages := received(s); len(messages) != 1 || messages[0].Data != 9 {
+		t.Errorf("Got messages %+v after waiting, want only the last", messages)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	h.Publish(POSE, 10)
+	if messages := received(s); len(messages) != 1 || messages[0].Data != 10 {
+		t.Errorf("Got messages %+v after waiting again, want 1", messages)
+	}
+}
+
+func TestRateLimitClose(t *testing.T) {
+	h := MakeHub()
+	s := h.Subscribe()
+
+	// A message held back isn't sent after closing
+	s.Add(PATH, 20)
+	h.Publish(PATH, 0)
+	h.Publish(PATH, 1)
+	s.Close()
+	time.Sleep(60 * time.Millisecond)
+
+	messages := make([]Message, 0)
+	for m := range s.C {
+		messages = append(messages, m)
+	}
+	if len(messages) != 1 || messages[0].Data != 0 {
+		t.Errorf("Got messages %+v, want only the first", messages)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	h := MakeHub()
+	s := h.Subscribe()
+	defer s.Close()
+
+	// Publishing never blocks, the messages which don't fit are dropped
+	s.Add(DATA, 0)
+	for i := 0; i < 2*BUFFER_SIZE; i++ {
+		h.Publish(DATA, i)
+	}
+	if messages := received(s); len(messages) != BUFFER_SIZE {
+		t.Errorf("Got %d messages, want %d", len(messages), BUFFER_SIZE)
+	}
+}
+
+func TestDecimate(t *testing.T) {
+	scan := Scan{StartAngle: -120, AngleIncrement: 1, Distances: make([]float64, 241)}
+	for i := range scan.Distances {
+		scan.Distances[i] = float64(i)
+	}
+
+	decimated := scan.Decimate(4)
+	if len(decimated.Distances) != 61 || decimated.AngleIncrement != 4 || decimated.StartAngle != -120 {
+		t.Errorf("Decimated scan has %d distances, starting at %f in steps of %f",
+			len(decimated.Distances), decimated.StartAngle, decimated.AngleIncrement)
+	}
+	if decimated.Distances[60] != 240 {
+		t.Errorf("Last distance is %f, want 240", decimated.Distances[60])
+	}
+}
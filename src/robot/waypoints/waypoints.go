@@ -0,0 +1,148 @@
+// Package waypoints keeps named poses in a map, such as a docking station or
+// the rooms the robot is sent to, so navigation targets survive map reloads.
+package waypoints
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Waypoint is a named pose in world coordinates
+type Waypoint struct {
+	Name        string   `json:"name"`
+	X           float64  `json:"x"`
+	Y           float64  `json:"y"`
+	Theta       float64  `json:"theta"`
+	Tags        []string `json:"tags"`
+	Description string   `json:"description"`
+}
+
+// Get the pose of the waypoint
+func (w Waypoint) GetPose() [3]float64 {
+	return [3]float64{w.X, w.Y, w.Theta}
+}
+
+// Determine if the waypoint has a tag
+func (w Waypoint) HasTag(tag string) bool {
+	for _, t := range w.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Waypoints are the waypoints of a map, by name
+type Waypoints struct {
+	lock sync.Mutex
+
+	Waypoints map[string]Waypoint
+}
+
+func MakeWaypoints() *Waypoints {
+	return &Waypoints{
+		Waypoints: make(map[string]Waypoint),
+	}
+}
+
+// Check that a waypoint has a name which can be used in URLs and file names
+func validate(w Waypoint) error {
+	if w.Name == "" {
+		return errors.New("A waypoint needs a name.")
+	}
+	if strings.ContainsAny(w.Name, "/?&#") {
+		return errors.New("Waypoint names can't contain /, ?, & or #.")
+	}
+	return nil
+}
+
+// Add a new waypoint
+func (ws *Waypoints) Add(w Waypoint) error {
+	if err := validate(w); err != nil {
+		return err
+	}
+
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	if _, ok := ws.Waypoints[w.Name]; ok {
+		return errors.New("Waypoint " + w.Name + " already exists.")
+	}
+	ws.Waypoints[w.Name] = w
+
+	return nil
+}
+
+// Replace the waypoint with the given name. The name of the new waypoint may
+// differ, to rename it.
+func (ws *Waypoints) Update(name string, w Waypoint) error {
+	if err := validate(w); err != nil {
+		return err
+	}
+
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	if _, ok := ws.Waypoints[name]; !ok {
+		return errors.New("No such waypoint.")
+	}
+	if _, ok := ws.Waypoints[w.Name]; ok && w.Name != name {
+		return errors.New("Waypoint " + w.Name + " already exists.")
+	}
+
+	delete(ws.Waypoints, name)
+	ws.Waypoints[w.Name] = w
+
+	return nil
+}
+
+// Remove a waypoint
+func (ws *Waypoints) Remove(name string) error {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	if _, ok := ws.Waypoints[name]; !ok {
+		return errors.New("No such waypoint.")
+	}
+	delete(ws.Waypoints, name)
+
+	return nil
+}
+
+// Get a waypoint by name
+func (ws *Waypoints) Get(name string) (Waypoint, error) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	w, ok := ws.Waypoints[name]
+	if !ok {
+		return w, errors.New("No such waypoint.")
+	}
+	return w, nil
+}
+
+// Get the waypoints with a tag, or all waypoints if the tag is empty, sorted
+// by name
+func (ws *Waypoints) GetAll(tag string) []Waypoint {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	all := make([]Waypoint, 0, len(ws.Waypoints))
+	for _, w := range ws.Waypoints {
+		if tag == "" || w.HasTag(tag) {
+			all = append(all, w)
+		}
+	}
+	sort.Sort(byName(all))
+
+	return all
+}
+
+// Sorts waypoints by name
+type byName []Waypoint
+
+func (w byName) Len() int           { return len(w) }
+func (w byName) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
+func (w byName) Less(i, j int) bool { return w[i].Name < w[j].Name }
@@ -0,0 +1,55 @@
+package waypoints
+
+import (
+	"testing"
+)
+
+func TestWaypoints(t *testing.T) {
+	ws := MakeWaypoints()
+
+	dock := Waypoint{Name: "dock", X: 1, Y: 2, Theta: 0.5, Tags: []string{"charging"}}
+	if err := ws.Add(dock); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Add(Waypoint{Name: "kitchen", X: -3, Y: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Add(dock); err == nil {
+		t.Error("Waypoint was added twice")
+	}
+	if err := ws.Add(Waypoint{Name: "a/b"}); err == nil {
+		t.Error("Waypoint with / in its name was added")
+	}
+
+	if w, err := ws.Get("dock"); err != nil || w.GetPose() != [3]float64{1, 2, 0.5} {
+		t.Errorf("Got dock %+v, %v", w, err)
+	}
+
+	all := ws.GetAll("")
+	if len(all) != 2 || all[0].Name != "dock" || all[1].Name != "kitchen" {
+		t.Errorf("Got waypoints %+v", all)
+	}
+	if tagged := ws.GetAll("charging"); len(tagged) != 1 || tagged[0].Name != "dock" {
+		t.Errorf("Got waypoints %+v tagged charging", tagged)
+	}
+
+	// Renaming
+	dock.Name = "station"
+	if err := ws.Update("dock", dock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Get("dock"); err == nil {
+		t.Error("Renamed waypoint is still there")
+	}
+	dock.Name = "kitchen"
+	if err := ws.Update("station", dock); err == nil {
+		t.Error("Waypoint was renamed to the name of another")
+	}
+
+	if err := ws.Remove("kitchen"); err != nil {
+		t.Error(err)
+	}
+	if err := ws.Remove("kitchen"); err == nil {
+		t.Error("Waypoint was removed twice")
+	}
+}
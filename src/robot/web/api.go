@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,17 +10,31 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"robot/config"
 	"robot/controller"
+	"robot/maplayers"
 	"robot/mapstorage"
-	// "robot/slam"
+	"robot/mission"
+	"robot/model"
+	"robot/pathplanning"
+	"robot/slam"
+	"robot/slam/trajectory"
+	"robot/waypoints"
+
+	"hectormapping/map/mapimages"
+	"hectormapping/map/maprep"
 
 	auth "github.com/abbot/go-http-auth"
 )
 
+// Maximum number of particles sent with the SLAM stats
+const MAX_STATS_PARTICLES = 500
+
 var API_FUNCMAP = map[string]func(http.ResponseWriter, *controller.Controller, url.Values) ([]byte, error){
 	// "get/log": getLog,
 
@@ -30,13 +45,23 @@ var API_FUNCMAP = map[string]func(http.ResponseWriter, *controller.Controller, u
 	"set/slam/terminate":                  setSlamTerminate,
 	"set/slam/save":                       setSlamSave,
 	"get/slam/image/full":                 getSlamImageFull,
-	"get/slam/image/tile":                 getSlamImageTile,
+	"get/slam/image/changed-tiles":        getSlamImageChangedTiles,
+	"get/slam/image/costmap-tile":         getSlamImageCostmapTile,
 	"get/slam/stats":                      getSlamStats,
-
-	"get/mapstorage/package":   getMapstoragePackage,
-	"get/mapstorage/metadata":  getMapstorageMetadata,
-	"get/mapstorage/thumbnail": getMapstorageThumbnail,
-	"set/mapstorage/mapname":   setMapstorageMapname,
+	"set/slam/initial-pose":               setSlamInitialPose,
+	"set/slam/global-localization":        setSlamGlobalLocalization,
+	"get/slam/trajectory":                 getSlamTrajectory,
+	"get/slam/layers":                     getSlamLayers,
+	"set/slam/keepout/add":                setSlamKeepoutAdd,
+	"set/slam/keepout/remove":             setSlamKeepoutRemove,
+	"set/slam/paint":                      setSlamPaint,
+
+	"get/mapstorage/package":    getMapstoragePackage,
+	"get/mapstorage/metadata":   getMapstorageMetadata,
+	"get/mapstorage/thumbnail":  getMapstorageThumbnail,
+	"set/mapstorage/mapname":    setMapstorageMapname,
+	"get/mapstorage/ros":        getMapstorageROS,
+	"set/mapstorage/import-ros": setMapstorageImportROS,
 
 	"set/sensors/connect":    SetSensorsConnect,
 	"set/sensors/disconnect": SetSensorsDisconnect,
@@ -47,6 +72,12 @@ var API_FUNCMAP = map[string]func(http.ResponseWriter, *controller.Controller, u
 	"set/sensorlogs/rename":                 SetSensorlogsRename,
 	"set/sensorlogs/start-logread-realtime": SetSensorlogsStartlogreadrealtime,
 	"set/sensorlogs/stop-logread-realtime":  SetSensorlogsStoplogreadrealtime,
+	"set/sensorlogs/play":                   SetSensorlogsPlay,
+	"set/sensorlogs/pause":                  SetSensorlogsPause,
+	"set/sensorlogs/rate":                   SetSensorlogsRate,
+	"set/sensorlogs/seek":                   SetSensorlogsSeek,
+	"set/sensorlogs/step":                   SetSensorlogsStep,
+	"get/sensorlogs/replay-status":          GetSensorlogsReplayStatus,
 
 	"set/motor/disconnect":        SetMotorDisconnect,
 	"set/motor/speeds":            SetMotorSpeeds,
@@ -56,6 +87,24 @@ var API_FUNCMAP = map[string]func(http.ResponseWriter, *controller.Controller, u
 	"set/motor/stoppathfollowing": SetMotorStopPathFollowing,
 	"set/motor/deletepath":        SetMotorDeletePath,
 	"set/motor/goto":              SetMotorGoTo,
+
+	"get/waypoints":        GetWaypoints,
+	"set/waypoints/add":    SetWaypointsAdd,
+	"set/waypoints/update": SetWaypointsUpdate,
+	"set/waypoints/delete": SetWaypointsDelete,
+
+	"get/mission/status": GetMissionStatus,
+	"set/mission/add":    SetMissionAdd,
+	"set/mission/pause":  SetMissionPause,
+	"set/mission/resume": SetMissionResume,
+	"set/mission/skip":   SetMissionSkip,
+	"set/mission/abort":  SetMissionAbort,
+	"set/mission/clear":  SetMissionClear,
+}
+
+// API functions which need the request, e.g. for its headers
+var API_REQUEST_FUNCMAP = map[string]func(http.ResponseWriter, *http.Request, *controller.Controller, url.Values) ([]byte, error){
+	"get/slam/image/tile": getSlamImageTile,
 }
 
 func (ws *WebServer) getAPIAction(url string) string {
@@ -76,13 +125,24 @@ func (ws *WebServer) apiHandler(w http.ResponseWriter, r *auth.AuthenticatedRequ
 		return
 	}
 
-	apiFunc, ok := API_FUNCMAP[action]
-	if !ok {
+	var resp []byte
+	var err error
+	if apiFunc, ok := API_FUNCMAP[action]; ok {
+		resp, err = apiFunc(w, ws.controller, data)
+	} else if apiFunc, ok := API_REQUEST_FUNCMAP[action]; ok {
+		resp, err = apiFunc(w, &r.Request, ws.controller, data)
+	} else {
 		http.Error(w, "Unrecognized API action:"+action, http.StatusBadRequest)
 		return
 	}
+	if planningErr, ok := err.(*pathplanning.PlanningError); ok {
 
-	resp, err := apiFunc(w, ws.controller, data)
+		// Tell clients why no path was found
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusExpectationFailed)
+		json.NewEncoder(w).Encode(planningErr)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusExpectationFailed)
 		return
@@ -180,7 +240,10 @@ func setSlamSave(w http.ResponseWriter, ctrl *controller.Controller, data url.Va
 			Name:        mapName,
 			Description: mapDescription,
 		},
-		MapRep: ctrl.SlamController.GetMapRepresentation(),
+		MapRep:     ctrl.SlamController.GetMapRepresentation(),
+		Trajectory: ctrl.SlamController.GetSlam().GetTrajectory().GetPoses(),
+		Layers:     ctrl.SlamController.GetLayers(),
+		Waypoints:  ctrl.SlamController.GetWaypoints(),
 	}
 	//Debug: fmt.Printf("url.Values = %+v\n", data)
 	//Debug: fmt.Println("mapName = ", mapName)
@@ -217,7 +280,9 @@ func getSlamImageFull(w http.ResponseWriter, ctrl *controller.Controller, data u
 	return nil, nil
 }
 
-func getSlamImageTile(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+// Get a tile of the map. The tile's ETag changes when the map changes where
+// the tile is, so the client can ask with If-None-Match whether it has changed.
+func getSlamImageTile(w http.ResponseWriter, r *http.Request, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 	//Debug: fmt.Println("getSlamImageTile")
 	if ctrl.SlamController.GetSlam() == nil {
 		return nil, errors.New("SLAM not initialized.")
@@ -236,18 +301,186 @@ func getSlamImageTile(w http.ResponseWriter, ctrl *controller.Controller, data u
 		return nil, err
 	}
 
-	img, err := ctrl.SlamController.GetSlam().GetMapTile(uint(zoomLevel), tileX, tileY)
+	cache := ctrl.SlamController.GetSlam().GetTileCache()
+	tile, err := cache.GetTile(uint(zoomLevel), tileX, tileY)
 	if err != nil {
 		return nil, err
 	}
 
-	png.Encode(w, img)
+	etag := fmt.Sprintf("\"%d-%d\"", cache.GetID(), tile.UpdateIndex)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil, nil
+	}
+
+	w.Header().Add("Content-Type", "image/png")
+	png.Encode(w, tile.Image)
+
+	return nil, nil
+}
+
+// Get a debug image of the costs the path planners give the cells of a map
+// tile, to be drawn on top of the tile
+func getSlamImageCostmapTile(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	zoomLevel, err := strconv.Atoi(data.Get("zoomLevel"))
+	if err != nil {
+		return nil, err
+	}
+	if zoomLevel < 0 {
+		return nil, errors.New("Invalid zoom level.")
+	}
+	tileX, err := strconv.Atoi(data.Get("tileX"))
+	if err != nil {
+		return nil, err
+	}
+	tileY, err := strconv.Atoi(data.Get("tileY"))
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ctrl.GetCostmap()
+	if err != nil {
+		return nil, err
+	}
 
 	w.Header().Add("Content-Type", "image/png")
+	png.Encode(w, c.GetTile(uint(zoomLevel), tileX, tileY))
 
 	return nil, nil
 }
 
+// Get the tiles of a zoom level which have changed since the map had the
+// update index given by since. The update index of the map is returned, to be
+// used as since in the next request. If all is set, all tiles may have
+// changed, e.g. because a new map has been loaded.
+func getSlamImageChangedTiles(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	zoomLevel, err := strconv.Atoi(data.Get("zoomLevel"))
+	if err != nil {
+		return nil, err
+	}
+	if zoomLevel < 0 {
+		return nil, errors.New("Invalid zoom level.")
+	}
+
+	cache := ctrl.SlamController.GetSlam().GetTileCache()
+
+	// Without a valid index, or with the index of another map, all tiles
+	// have changed
+	since, err := strconv.Atoi(data.Get("since"))
+	if err != nil || data.Get("mapId") != strconv.FormatInt(cache.GetID(), 10) {
+		since = -2
+	}
+
+	updateIndex, tiles, all := cache.GetChangedTiles(uint(zoomLevel), since)
+
+	resp := struct {
+		MapID       int64               `json:"mapId,string"`
+		UpdateIndex int                 `json:"updateIndex"`
+		All         bool                `json:"all"`
+		Tiles       []mapimages.TileKey `json:"tiles"`
+	}{cache.GetID(), updateIndex, all, tiles}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal(resp)
+}
+
+// Get the edits of the map
+func getSlamLayers(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	resp := struct {
+		KeepOut []maplayers.Shape `json:"keepOut"`
+	}{ctrl.SlamController.GetLayers().GetKeepOut()}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal(resp)
+}
+
+// Add a polygon or polyline the robot must keep out of. Returns the id of the
+// shape.
+func setSlamKeepoutAdd(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	shape, err := parseShape(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := ctrl.SlamController.GetLayers().AddKeepOut(shape)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal(id)
+}
+
+func setSlamKeepoutRemove(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	id, err := strconv.Atoi(data.Get("id"))
+	if err != nil {
+		return nil, errors.New("Invalid id")
+	}
+
+	err = ctrl.SlamController.GetLayers().RemoveKeepOut(id)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Paint the cells of a polygon or polyline occupied, free or unknown in the
+// SLAM map
+func setSlamPaint(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	shape, err := parseShape(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctrl.SlamController.Paint(shape, data.Get("value"))
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Get a shape from the parameters type, points, e.g. [[0, 0], [1.5, 0]], and
+// width, which is optional
+func parseShape(data url.Values) (maplayers.Shape, error) {
+	shape := maplayers.Shape{Type: data.Get("type")}
+
+	err := json.Unmarshal([]byte(data.Get("points")), &shape.Points)
+	if err != nil {
+		return shape, errors.New("Invalid points")
+	}
+
+	if data.Get("width") != "" {
+		shape.Width, err = strconv.ParseFloat(data.Get("width"), 64)
+		if err != nil {
+			return shape, errors.New("Invalid width")
+		}
+	}
+
+	return shape, shape.Validate()
+}
+
 func getMapstoragePackage(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 	//Debug: fmt.Println("getMapstoragePackage")
 	filename := data.Get("filename")
@@ -316,6 +549,66 @@ func setMapstorageMapname(w http.ResponseWriter, ctrl *controller.Controller, da
 	return json.Marshal("ok")
 }
 
+// Export a stored map, or the current SLAM map if no filename is given, to a
+// ZIP archive holding a ROS map_server image and YAML file
+func getMapstorageROS(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+
+	filename := data.Get("filename")
+
+	var mapRep maprep.MapRepresentation
+	name := "map"
+	if filename == "" {
+		if ctrl.SlamController.GetSlam() == nil {
+			return nil, errors.New("SLAM not initialized.")
+		}
+		mapRep = ctrl.SlamController.GetMapRepresentation()
+	} else {
+		filename = path.Base(filename)
+		m, err := mapstorage.Load(filename)
+		if err != nil {
+			return nil, err
+		}
+		mapRep = m.MapRep
+		name = strings.TrimSuffix(filename, path.Ext(filename))
+	}
+
+	buf := new(bytes.Buffer)
+	err := mapstorage.ExportROSArchive(buf, mapRep, name)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/zip")
+	w.Header().Add("Content-Disposition", "attachment; filename=\""+name+".zip\"")
+	return buf.Bytes(), nil
+}
+
+// Import a ROS map_server YAML file and its image from the map storage root,
+// and store it as a map named by name
+func setMapstorageImportROS(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+
+	filename := data.Get("filename")
+	name := data.Get("name")
+
+	m, err := mapstorage.ImportROS(path.Base(filename))
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		m.Meta.Name = name
+	} else {
+		name = m.Meta.Name
+	}
+
+	err = m.Save(path.Base(name) + mapstorage.MAP_FILE_EXTENSION)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
 // Returns stats relevant for the SLAM page -- extracted from SLAM and motor controllers
 func getSlamStats(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 
@@ -336,10 +629,109 @@ func getSlamStats(w http.ResponseWriter, ctrl *controller.Controller, data url.V
 		stats["motorPathID"] = path.ID
 	}
 
+	// Particle cloud and confidence from localization
+	if localizer, ok := ctrl.SlamController.GetSlam().(slam.Localizer); ok {
+		stats["particles"] = localizer.GetParticles(MAX_STATS_PARTICLES)
+		stats["numParticles"] = localizer.GetNumParticles()
+		stats["confidence"] = localizer.GetConfidence()
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	return json.Marshal(stats)
 }
 
+// Tell the localizer where the robot is. The standard deviations stdxy and
+// stdtheta are optional.
+func setSlamInitialPose(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	var pose model.Position
+	var err error
+
+	if pose.X, err = strconv.ParseFloat(data.Get("x"), 64); err != nil {
+		return nil, err
+	}
+	if pose.Y, err = strconv.ParseFloat(data.Get("y"), 64); err != nil {
+		return nil, err
+	}
+	if pose.Theta, err = strconv.ParseFloat(data.Get("theta"), 64); err != nil {
+		return nil, err
+	}
+
+	stdDevXY, stdDevTheta := config.MCL_INITIAL_STD_DEV_XY, config.MCL_INITIAL_STD_DEV_THETA
+	if s := data.Get("stdxy"); s != "" {
+		if stdDevXY, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, err
+		}
+	}
+	if s := data.Get("stdtheta"); s != "" {
+		if stdDevTheta, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	err = ctrl.SlamController.SetInitialPose(pose, stdDevXY, stdDevTheta)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Get the trajectory of the robot. The optional from and to limit the poses to
+// a time range, every keeps every n-th pose, and format gives the poses in the
+// TUM or KITTI text formats instead of JSON.
+func getSlamTrajectory(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	var from, to time.Time
+	var err error
+	if s := data.Get("from"); s != "" {
+		if from, err = parseTime(s); err != nil {
+			return nil, errors.New("Invalid data")
+		}
+	}
+	if s := data.Get("to"); s != "" {
+		if to, err = parseTime(s); err != nil {
+			return nil, errors.New("Invalid data")
+		}
+	}
+	every := 1
+	if s := data.Get("every"); s != "" {
+		if every, err = strconv.Atoi(s); err != nil || every < 1 {
+			return nil, errors.New("Invalid data")
+		}
+	}
+
+	poses := ctrl.SlamController.GetSlam().GetTrajectory().GetRange(from, to, every)
+
+	format := data.Get("format")
+	if format == "" || format == "json" {
+		w.Header().Add("Content-Type", "application/json")
+		return json.Marshal(poses)
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	var buf bytes.Buffer
+	err = trajectory.Write(&buf, poses, format)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Make the localizer search the whole map for the robot
+func setSlamGlobalLocalization(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	err := ctrl.SlamController.StartGlobalLocalization()
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
 func SetSensorsConnect(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 
 	sensor := data.Get("sensor")
@@ -439,6 +831,116 @@ func SetSensorlogsStoplogreadrealtime(w http.ResponseWriter, ctrl *controller.Co
 	return json.Marshal("ok")
 }
 
+func SetSensorlogsPlay(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	player, err := ctrl.SensorController.GetLogPlayer()
+	if err != nil {
+		return nil, err
+	}
+
+	err = player.Play()
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+func SetSensorlogsPause(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	player, err := ctrl.SensorController.GetLogPlayer()
+	if err != nil {
+		return nil, err
+	}
+
+	err = player.Pause()
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Set the playback rate, from 0.1 to 20 times the pace the log was made at
+func SetSensorlogsRate(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	player, err := ctrl.SensorController.GetLogPlayer()
+	if err != nil {
+		return nil, err
+	}
+
+	rate, err := strconv.ParseFloat(data.Get("rate"), 64)
+	if err != nil {
+		return nil, errors.New("Invalid data")
+	}
+
+	err = player.SetRate(rate)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Seek to a log time, given as time in RFC 3339 or the sensor log time format,
+// or to a record number, given as record.
+func SetSensorlogsSeek(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	player, err := ctrl.SensorController.GetLogPlayer()
+	if err != nil {
+		return nil, err
+	}
+
+	if s := data.Get("record"); s != "" {
+		record, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New("Invalid data")
+		}
+		err = player.SeekRecord(record)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		t, err := parseTime(data.Get("time"))
+		if err != nil {
+			return nil, errors.New("Invalid data")
+		}
+		err = player.SeekTime(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Pause and play the next reading, or the readings up to and including the
+// next one from the sensor given as sensor
+func SetSensorlogsStep(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	player, err := ctrl.SensorController.GetLogPlayer()
+	if err != nil {
+		return nil, err
+	}
+
+	err = player.Step(data.Get("sensor"))
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+func GetSensorlogsReplayStatus(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	player, err := ctrl.SensorController.GetLogPlayer()
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal(player.GetStatus())
+}
+
 func SetMotorDisconnect(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 
 	err := ctrl.MotorController.Disconnect()
@@ -471,19 +973,17 @@ func SetMotorSpeeds(w http.ResponseWriter, ctrl *controller.Controller, data url
 	return json.Marshal("ok")
 }
 
+// Plan a path to x and y, or to a waypoint. See parseGoal for the heading. The
+// path is planned by the planner named by planner, by default
+// config.PATH_PLANNER.
 func SetMotorPlanPath(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 
-	x, err := strconv.ParseFloat(data.Get("x"), 64)
-	if err != nil {
-		return nil, errors.New("Invalid data")
-	}
-
-	y, err := strconv.ParseFloat(data.Get("y"), 64)
+	goal, tolerance, err := parseGoal(ctrl, data)
 	if err != nil {
-		return nil, errors.New("Invalid data")
+		return nil, err
 	}
 
-	err = ctrl.PlanPath([3]float64{x, y, 0})
+	err = ctrl.PlanPathWithHeading(goal, tolerance, data.Get("planner"))
 	if err != nil {
 		return nil, err
 	}
@@ -500,9 +1000,11 @@ func GetMotorPath(w http.ResponseWriter, ctrl *controller.Controller, data url.V
 	return json.Marshal(path)
 }
 
+// Follow the planned path with the path follower named by follower, by default
+// config.PATH_FOLLOWER
 func SetMotorFollowPath(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 
-	err := ctrl.FollowPath()
+	err := ctrl.FollowPath(data.Get("follower"))
 	if err != nil {
 		return nil, err
 	}
@@ -528,32 +1030,274 @@ func SetMotorDeletePath(w http.ResponseWriter, ctrl *controller.Controller, data
 	return json.Marshal("ok")
 }
 
+// Go to x and y, or to a waypoint. See parseGoal for the heading. The path is
+// planned by the planner named by planner, by default config.PATH_PLANNER,
+// and followed by the follower named by follower, by default
+// config.PATH_FOLLOWER.
 func SetMotorGoTo(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
 	fmt.Println("")
 	// logger.Println("SetMotorGoTo initiated!")
 
-	x, err := strconv.ParseFloat(data.Get("x"), 64)
+	goal, tolerance, err := parseGoal(ctrl, data)
+	if err != nil {
+		return nil, err
+	}
+	if name := data.Get("waypoint"); name != "" {
+		logger.Printf("Target waypoint: %s\n", name)
+	}
+	logger.Printf("Target location X: %.2f\n", goal[0])
+	logger.Printf("Target location Y: %.2f\n", goal[1])
+	if tolerance > 0 {
+		logger.Printf("Target heading: %.2f\n", goal[2])
+	}
+
+	err = ctrl.PlanPathWithHeading(goal, tolerance, data.Get("planner"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctrl.FollowPath(data.Get("follower"))
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Get the goal of a path from the data: x and y, or the name of a waypoint. The
+// robot turns to the heading given by theta at the end of the path, or to the
+// heading of the waypoint, until within tolerance, by default
+// config.ROTATE_TOLERANCE. Without either the heading is ignored, and the
+// tolerance returned is 0.
+func parseGoal(ctrl *controller.Controller, data url.Values) (goal [3]float64, tolerance float64, err error) {
+	if name := data.Get("waypoint"); name != "" {
+		waypoint, err := ctrl.SlamController.GetWaypoints().Get(name)
+		if err != nil {
+			return goal, 0, err
+		}
+		goal = waypoint.GetPose()
+		tolerance = config.ROTATE_TOLERANCE
+	} else {
+		if goal[0], err = strconv.ParseFloat(data.Get("x"), 64); err != nil {
+			return goal, 0, errors.New("Invalid X data")
+		}
+		if goal[1], err = strconv.ParseFloat(data.Get("y"), 64); err != nil {
+			return goal, 0, errors.New("Invalid Y data")
+		}
+	}
+
+	if s := data.Get("theta"); s != "" {
+		if goal[2], err = strconv.ParseFloat(s, 64); err != nil {
+			return goal, 0, errors.New("Invalid theta")
+		}
+		tolerance = config.ROTATE_TOLERANCE
+	}
+
+	if s := data.Get("tolerance"); s != "" && tolerance > 0 {
+		if tolerance, err = strconv.ParseFloat(s, 64); err != nil || tolerance <= 0 {
+			return goal, 0, errors.New("Invalid tolerance")
+		}
+	}
+
+	return goal, tolerance, nil
+}
+
+// Get the waypoints of the map, those with the given tag if any
+func GetWaypoints(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	all := ctrl.SlamController.GetWaypoints().GetAll(data.Get("tag"))
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal(all)
+}
+
+// Add a waypoint named by name, at x, y and theta, or at the current position
+// of the robot if they are left out. Tags are separated by commas.
+func SetWaypointsAdd(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	pos := ctrl.SlamController.GetSlam().GetPosition()
+	waypoint := waypoints.Waypoint{
+		Name:  data.Get("name"),
+		X:     pos.X,
+		Y:     pos.Y,
+		Theta: pos.Theta,
+		Tags:  []string{},
+	}
+
+	err := parseWaypoint(&waypoint, data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctrl.SlamController.GetWaypoints().Add(waypoint)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Change the waypoint named by name. The values given replace those of the
+// waypoint, and newname renames it.
+func SetWaypointsUpdate(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
+	}
+
+	name := data.Get("name")
+	waypoint, err := ctrl.SlamController.GetWaypoints().Get(name)
 	if err != nil {
-		return nil, errors.New("Invalid X data")
+		return nil, err
 	}
-	logger.Printf("Target location X: %.2f\n", x)
 
-	y, err := strconv.ParseFloat(data.Get("y"), 64)
+	if newName := data.Get("newname"); newName != "" {
+		waypoint.Name = newName
+	}
+	err = parseWaypoint(&waypoint, data)
 	if err != nil {
-		return nil, errors.New("Invalid Y data")
+		return nil, err
 	}
-	logger.Printf("Target location Y: %.2f\n", y)
 
-	err = ctrl.PlanPath([3]float64{x, y, 0})
+	err = ctrl.SlamController.GetWaypoints().Update(name, waypoint)
 	if err != nil {
-		return nil, errors.New("Path Planning failed!")
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+func SetWaypointsDelete(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if ctrl.SlamController.GetSlam() == nil {
+		return nil, errors.New("SLAM not initialized.")
 	}
 
-	err = ctrl.FollowPath()
+	err := ctrl.SlamController.GetWaypoints().Remove(data.Get("name"))
 	if err != nil {
-		return nil, errors.New("Path Following failed!")
+		return nil, err
 	}
 
-	w.Header().Add("Content-type", "application/json")
+	w.Header().Add("Content-Type", "application/json")
 	return json.Marshal("ok")
 }
+
+// Set the values of a waypoint given in the data: x, y, theta, tags and
+// description
+func parseWaypoint(waypoint *waypoints.Waypoint, data url.Values) error {
+	var err error
+	for key, value := range map[string]*float64{"x": &waypoint.X, "y": &waypoint.Y, "theta": &waypoint.Theta} {
+		if s := data.Get(key); s != "" {
+			if *value, err = strconv.ParseFloat(s, 64); err != nil {
+				return errors.New("Invalid " + key)
+			}
+		}
+	}
+
+	if _, ok := data["tags"]; ok {
+		waypoint.Tags = []string{}
+		for _, tag := range strings.Split(data.Get("tags"), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				waypoint.Tags = append(waypoint.Tags, tag)
+			}
+		}
+	}
+	if _, ok := data["description"]; ok {
+		waypoint.Description = data.Get("description")
+	}
+
+	return nil
+}
+
+// Get the state of the mission executor and the tasks in its queue
+func GetMissionStatus(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	status := struct {
+		State string         `json:"state"`
+		Tasks []mission.Task `json:"tasks"`
+	}{
+		State: ctrl.MissionExecutor.GetStatus(),
+		Tasks: ctrl.MissionExecutor.GetTasks(),
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal(status)
+}
+
+// Add the steps given as a JSON array in steps to the mission queue, and
+// return the ids of their tasks
+func SetMissionAdd(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	var steps []mission.Step
+	if err := json.Unmarshal([]byte(data.Get("steps")), &steps); err != nil {
+		return nil, errors.New("Invalid steps.")
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("No steps given.")
+	}
+
+	ids, err := ctrl.MissionExecutor.Add(steps)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal(ids)
+}
+
+func SetMissionPause(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if err := ctrl.MissionExecutor.Pause(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+func SetMissionResume(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if err := ctrl.MissionExecutor.Resume(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Skip the running task, or the next one if the mission is paused
+func SetMissionSkip(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	if err := ctrl.MissionExecutor.Skip(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+func SetMissionAbort(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	ctrl.MissionExecutor.Abort()
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Remove the finished tasks from the mission queue
+func SetMissionClear(w http.ResponseWriter, ctrl *controller.Controller, data url.Values) ([]byte, error) {
+	ctrl.MissionExecutor.Clear()
+
+	w.Header().Add("Content-Type", "application/json")
+	return json.Marshal("ok")
+}
+
+// Parse a time given in RFC 3339 or the sensor log format
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t, err = time.Parse(config.SENSORLOGS_TIME_FORMAT, s)
+	}
+	return t, err
+}
@@ -4,7 +4,13 @@ import (
 	//	"os"
 	//	"time"
 	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"robot/config"
 	"robot/logging"
+	"robot/telemetry"
 	//	"code.google.com/p/go.net/websocket"
 	"golang.org/x/net/websocket"
 )
@@ -48,3 +54,171 @@ func (ws *WebServer) logStreamingServer(conn *websocket.Conn) {
 		}
 	}
 }
+
+// Stream the status of the log replay, until the replay is stopped
+func (ws *WebServer) replayStreamingServer(conn *websocket.Conn) {
+	defer conn.Close()
+
+	player, err := ws.controller.SensorController.GetLogPlayer()
+	if err != nil {
+		return
+	}
+
+	ch := player.Subscribe()
+	defer player.Unsubscribe(ch)
+
+	for status := range ch {
+		json_txt, err := json.Marshal(map[string]interface{}{
+			"type": "replayStatus",
+			"data": status,
+		})
+		if err != nil {
+			logger.Println(err.Error())
+			continue
+		}
+
+		_, err = conn.Write(json_txt)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// A telemetry client subscribes to channels by sending requests like
+//
+//	{"subscribe": ["pose", "scan"], "rate": 5, "decimate": 4}
+//	{"unsubscribe": ["scan"]}
+//
+// The rate is the max number of messages per second on each of the channels,
+// 0 for all messages, and defaults to the telemetry_max_rate option. Decimate
+// sends every n-th distance of the scans. The first request can also be given
+// in the query of the URL, as ?channels=pose,scan&rate=5&decimate=4.
+type telemetryRequest struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+	Rate        *float64 `json:"rate"`
+	Decimate    int      `json:"decimate"`
+}
+
+// Stream telemetry messages on the channels the client subscribes to
+func (ws *WebServer) telemetryStreamingServer(conn *websocket.Conn) {
+	defer conn.Close()
+
+	sub := telemetry.Subscribe()
+	defer sub.Close()
+
+	decimation := config.TELEMETRY_SCAN_DECIMATION
+
+	// Apply a request, sending an error message for unknown channels
+	apply := func(req telemetryRequest) error {
+		rate := config.TELEMETRY_MAX_RATE
+		if req.Rate != nil {
+			rate = *req.Rate
+		}
+		if req.Decimate > 0 {
+			decimation = req.Decimate
+		}
+
+		for _, channel := range req.Unsubscribe {
+			sub.Remove(channel)
+		}
+		for _, channel := range req.Subscribe {
+			if !isTelemetryChannel(channel) {
+				err := websocket.JSON.Send(conn, map[string]string{
+					"channel": "error",
+					"data":    "Unknown telemetry channel: " + channel + ".",
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			sub.Add(channel, rate)
+		}
+		return nil
+	}
+
+	req, err := parseTelemetryQuery(conn.Request().URL.Query())
+	if err == nil {
+		err = apply(req)
+	}
+	if err != nil {
+		logger.Println(err)
+		return
+	}
+
+	// Read requests until the client goes away
+	requests := make(chan telemetryRequest)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		defer close(requests)
+		for {
+			var req telemetryRequest
+			err := websocket.JSON.Receive(conn, &req)
+			switch err.(type) {
+			case nil:
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				// Ignore malformed requests
+				continue
+			default:
+				return
+			}
+
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			if apply(req) != nil {
+				return
+			}
+
+		case message := <-sub.C:
+			if scan, ok := message.Data.(telemetry.Scan); ok {
+				message.Data = scan.Decimate(decimation)
+			}
+
+			err := websocket.JSON.Send(conn, message)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Get the subscription request in the query of a telemetry URL
+func parseTelemetryQuery(query url.Values) (req telemetryRequest, err error) {
+	if channels := query.Get("channels"); channels != "" {
+		req.Subscribe = strings.Split(channels, ",")
+	}
+	if rate := query.Get("rate"); rate != "" {
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return req, err
+		}
+		req.Rate = &r
+	}
+	if decimate := query.Get("decimate"); decimate != "" {
+		req.Decimate, err = strconv.Atoi(decimate)
+	}
+	return req, err
+}
+
+func isTelemetryChannel(channel string) bool {
+	for _, c := range telemetry.CHANNELS {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
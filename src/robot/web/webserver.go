@@ -69,6 +69,8 @@ func (ws *WebServer) Serve() {
 		ws.staticHandler(w, r)
 	})
 	http.Handle("/api/streaming/log/", websocket.Handler(func(w *websocket.Conn) { ws.logStreamingServer(w) }))
+	http.Handle("/api/streaming/sensorlogs/", websocket.Handler(func(w *websocket.Conn) { ws.replayStreamingServer(w) }))
+	http.Handle("/api/streaming/telemetry/", websocket.Handler(func(w *websocket.Conn) { ws.telemetryStreamingServer(w) }))
 	http.HandleFunc(ws.apiURL, authenticator.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
 		ws.apiHandler(w, r)
 	}))